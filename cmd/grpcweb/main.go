@@ -0,0 +1,99 @@
+// Command grpcweb is a command line companion of the grpcweb client.
+//
+// Usage:
+//
+//	grpcweb replay [-target url] [-timeout d] capture.har
+//
+// The replay command sends the unary and server streaming calls of a HAR
+// capture recorded with the har package again, to the recorded URLs or to
+// target, and reports the responses which differ from the recorded ones. It
+// exits with status 1 if any call differs or fails.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/har"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "usage: grpcweb <command> [arguments]\n\ncommands:\n  replay  replay the calls of a HAR capture")
+		return 2
+	}
+	switch args[0] {
+	case "replay":
+		return replay(args[1:], stdout, stderr)
+	default:
+		fmt.Fprintf(stderr, "grpcweb: unknown command %q\n", args[0])
+		return 2
+	}
+}
+
+func replay(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	target := fs.String("target", "", "base URL replacing the scheme and host of the recorded calls, such as https://staging.example.com")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout of each call")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: grpcweb replay [-target url] [-timeout d] capture.har")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(stderr, "grpcweb: %s\n", err)
+		return 1
+	}
+	log, err := har.ReadLog(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(stderr, "grpcweb: %s\n", err)
+		return 1
+	}
+
+	r := &har.Replayer{Target: *target}
+	failed := 0
+	for i := range log.Entries {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		res := r.ReplayEntry(ctx, &log.Entries[i])
+		cancel()
+
+		switch {
+		case res.Skipped != "":
+			fmt.Fprintf(stdout, "SKIP %s: %s\n", res.URL, res.Skipped)
+		case res.Err != nil:
+			failed++
+			fmt.Fprintf(stdout, "FAIL %s: %s\n", res.URL, res.Err)
+		case len(res.Diffs) > 0:
+			failed++
+			fmt.Fprintf(stdout, "DIFF %s\n", res.URL)
+			for _, d := range res.Diffs {
+				fmt.Fprintf(stdout, "     %s\n", d)
+			}
+		default:
+			fmt.Fprintf(stdout, "OK   %s\n", res.URL)
+		}
+	}
+	fmt.Fprintf(stdout, "%d calls, %d differing or failing\n", len(log.Entries), failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,108 @@
+package grpcweb
+
+import (
+	"hash/fnv"
+	"net/http"
+	"sync"
+)
+
+// AffinityKey pins the calls made with the same key to the same address of
+// the resolver set with WithResolver, as long as it is in the rotation. The
+// addresses are chosen by rendezvous hashing, so that a change of the
+// addresses only moves the keys of the addresses which came or went. It has no
+// effect without a resolver.
+func AffinityKey(key string) CallOption {
+	return newCallOption(func(opt *callOptions) {
+		opt.affinityKey = key
+	})
+}
+
+// WithAffinityHeader echoes the last value of the given response header
+// received by a unary call or server stream in the requests of the next calls
+// and streams, so that gateways routing on it keep sending them to the same
+// backend.
+func WithAffinityHeader(name string) DialOption {
+	return func(opt *dialOptions) {
+		opt.affinity = &affinity{name: http.CanonicalHeaderKey(name)}
+	}
+}
+
+// WithAffinityCookie is like WithAffinityHeader for the cookie with the given
+// name. The cookie is forgotten when the gateway deletes it.
+func WithAffinityCookie(name string) DialOption {
+	return func(opt *dialOptions) {
+		opt.affinity = &affinity{name: name, cookie: true}
+	}
+}
+
+// affinity is the affinity token learned from the responses.
+type affinity struct {
+	name   string
+	cookie bool
+
+	mu    sync.Mutex
+	value string
+}
+
+// learn records the token of a response header.
+func (a *affinity) learn(h http.Header) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.cookie {
+		if v := h.Get(a.name); v != "" {
+			a.value = v
+		}
+		return
+	}
+	for _, c := range (&http.Response{Header: h}).Cookies() {
+		if c.Name != a.name {
+			continue
+		}
+		if c.MaxAge < 0 {
+			a.value = ""
+		} else {
+			a.value = c.Value
+		}
+	}
+}
+
+// set adds the token, if any, to a request header.
+func (a *affinity) set(h http.Header) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	v := a.value
+	a.mu.Unlock()
+	if v == "" {
+		return
+	}
+	if !a.cookie {
+		h.Set(a.name, v)
+		return
+	}
+	c := (&http.Cookie{Name: a.name, Value: v}).String()
+	if prev := h.Get("Cookie"); prev != "" {
+		c = prev + "; " + c
+	}
+	h.Set("Cookie", c)
+}
+
+// rendezvous returns the index of the address of key among addrs, the one
+// with the highest hash of the key and the address.
+func rendezvous(key string, addrs []string) int {
+	best, bestHash := 0, uint64(0)
+	for i, a := range addrs {
+		f := fnv.New64a()
+		f.Write([]byte(key))
+		f.Write([]byte{0})
+		f.Write([]byte(a))
+		if h := f.Sum64(); i == 0 || h > bestHash {
+			best, bestHash = i, h
+		}
+	}
+	return best
+}
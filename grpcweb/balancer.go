@@ -0,0 +1,115 @@
+package grpcweb
+
+import (
+	"net"
+	"sync"
+
+	"go.uber.org/atomic"
+)
+
+// LoadBalancingPolicy picks the address of every call among the addresses
+// returned by the resolver. The policies of this package are safe for
+// concurrent use and may be shared by several clients.
+type LoadBalancingPolicy interface {
+	// Pick returns the index in addrs of the address of the next call.
+	// pending holds the number of calls in flight to each address. addrs is
+	// never empty.
+	Pick(addrs []string, pending []int) int
+}
+
+// WithLoadBalancingPolicy sets the policy spreading the calls over the
+// addresses of the resolver set with WithResolver. The default is
+// RoundRobin.
+func WithLoadBalancingPolicy(p LoadBalancingPolicy) DialOption {
+	return func(opt *dialOptions) {
+		opt.balancer = p
+	}
+}
+
+// RoundRobin returns a policy sending the calls to each address in turn.
+func RoundRobin() LoadBalancingPolicy {
+	return &roundRobin{}
+}
+
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+func (p *roundRobin) Pick(addrs []string, _ []int) int {
+	return int((p.next.Inc() - 1) % uint64(len(addrs)))
+}
+
+// Weighted returns a policy sending the calls to the addresses in proportion
+// to their weights, interleaving them evenly. The weights are looked up by
+// address, then by host, and default to 1. Addresses with a weight below 1
+// are only picked if no other address is left.
+func Weighted(weights map[string]int) LoadBalancingPolicy {
+	return &weighted{weights: weights, current: make(map[string]int)}
+}
+
+type weighted struct {
+	weights map[string]int
+
+	mu sync.Mutex
+	// current holds the weights of the smooth weighted round robin, see
+	// https://github.com/phusion/nginx/commit/27e94984486058d73157038f7950a0a36ecc6e35.
+	current map[string]int
+}
+
+func (p *weighted) weight(addr string) int {
+	if w, ok := p.weights[addr]; ok {
+		return w
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		if w, ok := p.weights[host]; ok {
+			return w
+		}
+	}
+	return 1
+}
+
+func (p *weighted) Pick(addrs []string, _ []int) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	best, total := -1, 0
+	for i, a := range addrs {
+		w := p.weight(a)
+		if w < 1 {
+			continue
+		}
+		total += w
+		p.current[a] += w
+		if best == -1 || p.current[a] > p.current[addrs[best]] {
+			best = i
+		}
+	}
+	if best == -1 {
+		return 0
+	}
+	p.current[addrs[best]] -= total
+	return best
+}
+
+// LeastPending returns a policy sending the calls to the address with the
+// fewest calls in flight, in turn among the ties. Calls in flight include
+// open streams.
+func LeastPending() LoadBalancingPolicy {
+	return &leastPending{}
+}
+
+type leastPending struct {
+	next atomic.Uint64
+}
+
+func (p *leastPending) Pick(addrs []string, pending []int) int {
+	start := int(p.next.Inc() % uint64(len(addrs)))
+	best := start
+	for i := range addrs {
+		j := (start + i) % len(addrs)
+		if pending[j] < pending[best] {
+			best = j
+		}
+	}
+	return best
+}
@@ -0,0 +1,35 @@
+package grpcweb
+
+import (
+	"context"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/transport"
+)
+
+// WithBlock makes NewClient and DialContext set up a connection to the target
+// before returning, instead of on the first call, and fail if they can't.
+// The failure is a *transport.DialError telling whether the DNS resolution,
+// the TCP connection, the TLS handshake or the proxy failed, and wrapping the
+// cause for errors.As. The connection is kept for the unary calls.
+func WithBlock() DialOption {
+	return func(opt *dialOptions) {
+		opt.block = true
+	}
+}
+
+// connect eagerly sets up a connection to the target, picked by the resolver
+// if one is set.
+func (c *ClientConn) connect(ctx context.Context) error {
+	callOptions := c.applyCallOptions(nil)
+	target, use, err := c.pickTarget(ctx, callOptions)
+	if err != nil {
+		return err
+	}
+	if err := transport.Dial(ctx, target, c.targetConnectOptions("", callOptions, use)...); err != nil {
+		c.connectionFailed(use, target, err)
+		use.end(err)
+		return err
+	}
+	use.release()
+	return nil
+}
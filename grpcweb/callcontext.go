@@ -0,0 +1,158 @@
+package grpcweb
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// TransportKind identifies the transport carrying a call.
+type TransportKind int
+
+const (
+	// TransportHTTP carries unary calls and server streams over plain HTTP
+	// requests.
+	TransportHTTP TransportKind = iota + 1
+	// TransportWebSocket carries client and bidirectional streams over
+	// websockets.
+	TransportWebSocket
+)
+
+func (k TransportKind) String() string {
+	switch k {
+	case TransportHTTP:
+		return "http"
+	case TransportWebSocket:
+		return "websocket"
+	default:
+		return "unknown"
+	}
+}
+
+// CallInfo describes a call or stream. It is stored in the context of the
+// call before the interceptors run, so that interceptors and the hooks given
+// the context can look it up with CallInfoFromContext instead of parsing the
+// method.
+type CallInfo struct {
+	// FullMethod is the full name of the method, such as
+	// "/package.Service/Method".
+	FullMethod string
+	// Service and Method are the parts of FullMethod, such as
+	// "package.Service" and "Method".
+	Service string
+	Method  string
+	// StreamDesc describes the stream. It is nil for unary calls.
+	StreamDesc *grpc.StreamDesc
+	// Transport is the kind of transport carrying the call.
+	Transport TransportKind
+	// Attempt is the attempt number of the call, starting at 1.
+	Attempt int
+	// StartTime is the time the call was started.
+	StartTime time.Time
+}
+
+// CallInfoFromContext returns the description of the call or stream ctx
+// belongs to. The returned CallInfo must not be modified.
+func CallInfoFromContext(ctx context.Context) (*CallInfo, bool) {
+	c, ok := ctx.Value(callContextKey{}).(*CallInfo)
+	return c, ok
+}
+
+type callContextKey struct{}
+
+type attemptKey struct{}
+
+// newCallContext returns ctx carrying the description of a call of method,
+// desc being nil for unary calls. The attempt number is 1 unless set with
+// withAttempt.
+func (o *dialOptions) newCallContext(ctx context.Context, method string, desc *grpc.StreamDesc) context.Context {
+	attempt, ok := ctx.Value(attemptKey{}).(int)
+	if ok {
+		// The attempt number only applies to this call, not to the calls
+		// nested in it.
+		ctx = context.WithValue(ctx, attemptKey{}, nil)
+	} else {
+		attempt = 1
+	}
+	kind := TransportHTTP
+	if desc != nil && desc.ClientStreams {
+		kind = TransportWebSocket
+	}
+	service, name := splitMethod(method)
+	return context.WithValue(ctx, callContextKey{}, &CallInfo{
+		FullMethod: method,
+		Service:    service,
+		Method:     name,
+		StreamDesc: desc,
+		Transport:  kind,
+		Attempt:    attempt,
+		StartTime:  o.clock.Now(),
+	})
+}
+
+// splitMethod splits a full method name into its service and method names.
+func splitMethod(method string) (string, string) {
+	method = strings.TrimPrefix(method, "/")
+	if i := strings.LastIndex(method, "/"); i != -1 {
+		return method[:i], method[i+1:]
+	}
+	return "", method
+}
+
+// withAttempt sets the attempt number of the calls made with ctx.
+func withAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, attemptKey{}, attempt)
+}
+
+// withCallAttempt returns ctx with the attempt number of its call set to n.
+func withCallAttempt(ctx context.Context, n int) context.Context {
+	c, ok := CallInfoFromContext(ctx)
+	if !ok {
+		return ctx
+	}
+	attempt := *c
+	attempt.Attempt = n
+	return context.WithValue(ctx, callContextKey{}, &attempt)
+}
+
+// MethodFromContext returns the full method name of the call or stream ctx
+// belongs to.
+func MethodFromContext(ctx context.Context) (string, bool) {
+	c, ok := CallInfoFromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	return c.FullMethod, true
+}
+
+// AttemptFromContext returns the attempt number of the call or stream ctx
+// belongs to, starting at 1. It returns 0 if ctx doesn't belong to a call.
+func AttemptFromContext(ctx context.Context) int {
+	c, ok := CallInfoFromContext(ctx)
+	if !ok {
+		return 0
+	}
+	return c.Attempt
+}
+
+// TransportKindFromContext returns the kind of transport carrying the call or
+// stream ctx belongs to.
+func TransportKindFromContext(ctx context.Context) (TransportKind, bool) {
+	c, ok := CallInfoFromContext(ctx)
+	if !ok {
+		return 0, false
+	}
+	return c.Transport, true
+}
+
+// StartTimeFromContext returns the time the call or stream ctx belongs to
+// was started.
+func StartTimeFromContext(ctx context.Context) (time.Time, bool) {
+	c, ok := CallInfoFromContext(ctx)
+	if !ok {
+		return time.Time{}, false
+	}
+	return c.StartTime, true
+}
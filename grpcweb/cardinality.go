@@ -0,0 +1,33 @@
+package grpcweb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/errs"
+)
+
+// The violations of the cardinality of a stream, as declared by its
+// grpc.StreamDesc. They catch the bugs of the servers and gateways early.
+var (
+	errFramesAfterTrailer = errs.WithCode(codes.Internal, nil, "cardinality violation: received frames after the trailer of a server stream")
+	errMultipleResponses  = errs.WithCode(codes.Internal, nil, "cardinality violation: received several response messages on a stream with a single response")
+	errServerStreamResend = errs.WithCode(codes.Internal, nil, "cardinality violation: SendMsg called more than once on a server stream")
+)
+
+// checkEnd returns errFramesAfterTrailer unless the response body ends after
+// the trailer, heartbeats aside.
+func (s *serverStream) checkEnd() error {
+	h, err := s.dialOptions.readFrameHeader(s.endpoint, s.resStream, s.callOptions)
+	switch {
+	case errors.Is(err, io.EOF):
+		return nil
+	case err != nil:
+		return errs.Wrap(err, "failed to read the end of the response")
+	default:
+		return fmt.Errorf("%w: frame with flag 0x%02x", errFramesAfterTrailer, h.Flag())
+	}
+}
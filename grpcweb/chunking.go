@@ -0,0 +1,289 @@
+package grpcweb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/errs"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/transport"
+)
+
+// chunkingHeader negotiates the chunking: the requests announce the chunk
+// size the client wants, and the responses of the servers which support the
+// chunking carry it back.
+const chunkingHeader = "Grpc-Web-Chunking"
+
+// chunkMore is the flag of the chunk header telling that more chunks of the
+// message follow.
+const chunkMore = 0x01
+
+// WithMessageChunking splits the messages into chunks, for the gateways with
+// a hard limit on the size of the frames. The requests carry a
+// grpc-web-chunking header with size, and a server supporting the extension
+// answers with the header too, after which:
+//
+//   - It splits its response messages into chunks of at most size bytes,
+//     which the client reassembles.
+//   - The client splits its request messages likewise, once a response of the
+//     ClientConn advertised the extension.
+//
+// A chunk is a message frame whose payload starts with a chunk header, a byte
+// whose bit 0x01 tells that more chunks of the message follow, and whose
+// payload, the chunk header included, is at most size bytes long. The chunks
+// keep the compression flag of their message. Every message frame carries a
+// chunk header once the extension is in use, the empty heartbeat frames
+// aside. The transport wrappers and the HAR recorder see the reassembled
+// messages.
+func WithMessageChunking(size int) DialOption {
+	return func(opt *dialOptions) {
+		opt.chunking = &chunking{size: max(size, 2)}
+	}
+}
+
+type chunking struct {
+	size int
+	// supported is set once a response advertised the chunking.
+	supported atomic.Bool
+}
+
+// chunkFrames splits the message frames of body, a request body, into
+// chunks.
+func (c *chunking) chunkFrames(body io.Reader) (io.Reader, error) {
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return nil, errs.Wrap(err, "failed to read the request body")
+	}
+	var chunked bytes.Buffer
+	for len(b) >= headerLen {
+		n := headerLen + int(binary.BigEndian.Uint32(b[1:headerLen]))
+		if n > len(b) {
+			break
+		}
+		flag, payload := b[0], b[headerLen:n]
+		if flag&^compressedFlag != 0 || len(payload) == 0 {
+			chunked.Write(b[:n])
+			b = b[n:]
+			continue
+		}
+		b = b[n:]
+		for {
+			chunk := payload[:min(len(payload), c.size-1)]
+			payload = payload[len(chunk):]
+			var h [headerLen + 1]byte
+			h[0] = flag
+			binary.BigEndian.PutUint32(h[1:headerLen], uint32(len(chunk)+1))
+			if len(payload) > 0 {
+				h[headerLen] = chunkMore
+			}
+			chunked.Write(h[:])
+			chunked.Write(chunk)
+			if len(payload) == 0 {
+				break
+			}
+		}
+	}
+	// A trailing incomplete frame is left for the server to reject.
+	chunked.Write(b)
+	return &chunked, nil
+}
+
+// readUnchunked reads the frames of r up to a whole message or another frame,
+// and returns it with the chunk headers stripped. The messages are limited to
+// maxSize bytes if it is positive.
+func readUnchunked(r io.Reader, maxSize int) ([]byte, error) {
+	var (
+		msg     bytes.Buffer
+		flag    byte
+		pending bool
+	)
+	for {
+		var h [headerLen]byte
+		if n, err := io.ReadFull(r, h[:]); err != nil {
+			if (n > 0 || pending) && errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		length := int64(binary.BigEndian.Uint32(h[1:]))
+		if h[0]&^compressedFlag != 0 || length == 0 {
+			if pending {
+				return nil, errs.WithCode(codes.Internal, nil, "chunked message interrupted by another frame")
+			}
+			frame := bytes.NewBuffer(h[:])
+			// The frame isn't allocated upfront, its length may be bogus.
+			if _, err := io.CopyN(frame, r, length); err != nil {
+				return nil, errs.Wrap(io.ErrUnexpectedEOF, "truncated frame")
+			}
+			return frame.Bytes(), nil
+		}
+
+		var chunkHeader [1]byte
+		if _, err := io.ReadFull(r, chunkHeader[:]); err != nil {
+			return nil, errs.Wrap(io.ErrUnexpectedEOF, "truncated chunk")
+		}
+		if !pending {
+			flag = h[0]
+			msg.Write(make([]byte, headerLen))
+		}
+		if size := int64(msg.Len()-headerLen) + length - 1; maxSize > 0 && size > int64(maxSize) {
+			return nil, messageTooLarge(size, maxSize)
+		}
+		if _, err := io.CopyN(&msg, r, length-1); err != nil {
+			return nil, errs.Wrap(io.ErrUnexpectedEOF, "truncated chunk")
+		}
+		if pending = chunkHeader[0]&chunkMore != 0; pending {
+			continue
+		}
+
+		frame := msg.Bytes()
+		frame[0] = flag
+		binary.BigEndian.PutUint32(frame[1:headerLen], uint32(len(frame)-headerLen))
+		return frame, nil
+	}
+}
+
+// unchunkedBody reassembles the chunked messages of a response body.
+type unchunkedBody struct {
+	io.ReadCloser
+	maxSize int
+	frame   []byte
+}
+
+func (b *unchunkedBody) Read(p []byte) (int, error) {
+	if len(b.frame) == 0 {
+		frame, err := readUnchunked(b.ReadCloser, b.maxSize)
+		if err != nil {
+			return 0, err
+		}
+		b.frame = frame
+	}
+	n := copy(p, b.frame)
+	b.frame = b.frame[n:]
+	return n, nil
+}
+
+// learn reports whether the response headers h advertise the chunking, and
+// records it.
+func (c *chunking) learn(h http.Header) bool {
+	if h.Get(chunkingHeader) == "" {
+		return false
+	}
+	c.supported.Store(true)
+	return true
+}
+
+// wrapChunking chunks the messages of tr, if the chunking is enabled.
+func (o *dialOptions) wrapChunking(tr transport.UnaryTransport) transport.UnaryTransport {
+	if o.chunking == nil {
+		return tr
+	}
+	return &chunkingTransport{UnaryTransport: tr, opts: o}
+}
+
+type chunkingTransport struct {
+	transport.UnaryTransport
+	opts *dialOptions
+}
+
+func (t *chunkingTransport) Send(ctx context.Context, endpoint, contentType string, body io.Reader) (http.Header, io.ReadCloser, error) {
+	c := t.opts.chunking
+	t.Header().Set(chunkingHeader, strconv.Itoa(c.size))
+	if c.supported.Load() {
+		var err error
+		if body, err = c.chunkFrames(body); err != nil {
+			return nil, nil, err
+		}
+	}
+	h, rawBody, err := t.UnaryTransport.Send(ctx, endpoint, contentType, body)
+	if err != nil || !c.learn(h) {
+		return h, rawBody, err
+	}
+	return h, &unchunkedBody{ReadCloser: rawBody, maxSize: t.opts.maxBufferSize}, nil
+}
+
+// wrapChunkingStream chunks the messages of tr, if the chunking is enabled.
+func (o *dialOptions) wrapChunkingStream(tr transport.ClientStreamTransport) transport.ClientStreamTransport {
+	if o.chunking == nil {
+		return tr
+	}
+	return &chunkingStreamTransport{ClientStreamTransport: tr, opts: o}
+}
+
+type chunkingStreamTransport struct {
+	transport.ClientStreamTransport
+	opts *dialOptions
+}
+
+func (t *chunkingStreamTransport) SetRequestHeader(h http.Header) {
+	h = h.Clone()
+	h.Set(chunkingHeader, strconv.Itoa(t.opts.chunking.size))
+	t.ClientStreamTransport.SetRequestHeader(h)
+}
+
+func (t *chunkingStreamTransport) Send(ctx context.Context, body io.Reader) error {
+	if c := t.opts.chunking; c.supported.Load() {
+		var err error
+		if body, err = c.chunkFrames(body); err != nil {
+			return err
+		}
+	}
+	return t.ClientStreamTransport.Send(ctx, body)
+}
+
+// Receive returns the next response frame, reassembled from the frames
+// received one by one if it is chunked.
+func (t *chunkingStreamTransport) Receive(ctx context.Context) (io.ReadCloser, error) {
+	h, err := t.ClientStreamTransport.Header()
+	if err != nil || !t.opts.chunking.learn(h) {
+		return t.ClientStreamTransport.Receive(ctx)
+	}
+	r := &receivedFrames{ctx: ctx, tr: t.ClientStreamTransport}
+	defer r.close()
+	frame, err := readUnchunked(r, t.opts.maxBufferSize)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(frame)), nil
+}
+
+// receivedFrames reads the frames received by a stream transport in a row.
+type receivedFrames struct {
+	ctx context.Context
+	tr  transport.ClientStreamTransport
+	cur io.ReadCloser
+}
+
+func (r *receivedFrames) Read(p []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			cur, err := r.tr.Receive(r.ctx)
+			if err != nil {
+				return 0, err
+			}
+			r.cur = cur
+		}
+		n, err := r.cur.Read(p)
+		if errors.Is(err, io.EOF) {
+			r.close()
+			err = nil
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+func (r *receivedFrames) close() {
+	if r.cur != nil {
+		r.cur.Close()
+		r.cur = nil
+	}
+}
@@ -0,0 +1,116 @@
+// Package compress provides zstd and snappy compressors for use with
+// grpcweb.UseCompressor, in addition to the gzip one of grpc-go. They are not
+// registered by importing the package, call RegisterZstd or RegisterSnappy:
+//
+//	compress.RegisterZstd()
+//	err := client.Invoke(ctx, method, req, res, grpcweb.UseCompressor(compress.Zstd))
+//
+// Registered compressors also decompress the responses of the gateways which
+// use them.
+package compress
+
+import (
+	"io"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc/encoding"
+)
+
+// Names of the compressors, as sent in the grpc-encoding header.
+const (
+	Zstd   = "zstd"
+	Snappy = "snappy"
+)
+
+// RegisterZstd registers the zstd compressor with encoding.RegisterCompressor.
+func RegisterZstd() {
+	encoding.RegisterCompressor(&zstdCompressor{})
+}
+
+// RegisterSnappy registers the snappy compressor, using the framing format,
+// with encoding.RegisterCompressor.
+func RegisterSnappy() {
+	encoding.RegisterCompressor(&snappyCompressor{})
+}
+
+type zstdCompressor struct {
+	encoders, decoders sync.Pool
+}
+
+func (c *zstdCompressor) Name() string {
+	return Zstd
+}
+
+func (c *zstdCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	enc, ok := c.encoders.Get().(*zstd.Encoder)
+	if !ok {
+		var err error
+		if enc, err = zstd.NewWriter(w); err != nil {
+			return nil, err
+		}
+	} else {
+		enc.Reset(w)
+	}
+	return &zstdWriter{Encoder: enc, pool: &c.encoders}, nil
+}
+
+func (c *zstdCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	dec, ok := c.decoders.Get().(*zstd.Decoder)
+	if !ok {
+		// Decoders run synchronously so that they don't leak goroutines when
+		// they are not closed.
+		var err error
+		if dec, err = zstd.NewReader(r, zstd.WithDecoderConcurrency(1)); err != nil {
+			return nil, err
+		}
+	} else if err := dec.Reset(r); err != nil {
+		return nil, err
+	}
+	return &zstdReader{Decoder: dec, pool: &c.decoders}, nil
+}
+
+// zstdWriter returns its encoder to the pool once closed.
+type zstdWriter struct {
+	*zstd.Encoder
+	pool *sync.Pool
+}
+
+func (w *zstdWriter) Close() error {
+	err := w.Encoder.Close()
+	w.pool.Put(w.Encoder)
+	return err
+}
+
+// zstdReader returns its decoder to the pool once the content is fully read.
+type zstdReader struct {
+	*zstd.Decoder
+	pool *sync.Pool
+}
+
+func (r *zstdReader) Read(p []byte) (int, error) {
+	if r.Decoder == nil {
+		return 0, io.EOF
+	}
+	n, err := r.Decoder.Read(p)
+	if err == io.EOF {
+		r.pool.Put(r.Decoder)
+		r.Decoder = nil
+	}
+	return n, err
+}
+
+type snappyCompressor struct{}
+
+func (snappyCompressor) Name() string {
+	return Snappy
+}
+
+func (snappyCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return snappy.NewBufferedWriter(w), nil
+}
+
+func (snappyCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	return snappy.NewReader(r), nil
+}
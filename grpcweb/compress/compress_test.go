@@ -0,0 +1,55 @@
+package compress_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/encoding"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/compress"
+)
+
+func TestCompressors(t *testing.T) {
+	compress.RegisterZstd()
+	compress.RegisterSnappy()
+
+	payload := []byte(strings.Repeat("telemetry sample ", 1000))
+	for _, name := range []string{compress.Zstd, compress.Snappy} {
+		t.Run(name, func(t *testing.T) {
+			c := encoding.GetCompressor(name)
+			if c == nil {
+				t.Fatalf("expected %s to be registered", name)
+			}
+
+			// Run twice to exercise the reuse of pooled encoders and decoders.
+			for i := 0; i < 2; i++ {
+				var buf bytes.Buffer
+				w, err := c.Compress(&buf)
+				if err != nil {
+					t.Fatalf("Compress should not return an error, but got '%s'", err)
+				}
+				w.Write(payload)
+				if err := w.Close(); err != nil {
+					t.Fatalf("Close should not return an error, but got '%s'", err)
+				}
+				if buf.Len() >= len(payload) {
+					t.Errorf("expected the payload to shrink, but got %d bytes out of %d", buf.Len(), len(payload))
+				}
+
+				r, err := c.Decompress(&buf)
+				if err != nil {
+					t.Fatalf("Decompress should not return an error, but got '%s'", err)
+				}
+				b, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatalf("ReadAll should not return an error, but got '%s'", err)
+				}
+				if !bytes.Equal(b, payload) {
+					t.Errorf("the payload doesn't survive the round trip")
+				}
+			}
+		})
+	}
+}
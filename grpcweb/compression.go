@@ -0,0 +1,117 @@
+package grpcweb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/mem"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/errs"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/parser"
+)
+
+// compressedFlag is set in the flag byte of compressed message frames.
+const compressedFlag = 0x01
+
+// UseCompressor compresses the request messages with the compressor
+// registered under name with encoding.RegisterCompressor, such as gzip after
+// importing google.golang.org/grpc/encoding/gzip. Compressed responses are
+// decompressed with the registered compressors regardless of this option.
+func UseCompressor(name string) CallOption {
+	return newCallOption(func(opt *callOptions) {
+		opt.compressor = name
+	})
+}
+
+// WithCompressionThreshold only compresses the request messages larger than n
+// bytes, since small messages tend to grow when compressed. It is the default
+// of the calls and streams of the ClientConn, see CompressionThreshold.
+func WithCompressionThreshold(n int) DialOption {
+	return func(opt *dialOptions) {
+		opt.compressionThreshold = n
+	}
+}
+
+// CompressionThreshold overrides the threshold set with
+// WithCompressionThreshold for a call or stream.
+func CompressionThreshold(n int) CallOption {
+	return newCallOption(func(opt *callOptions) {
+		opt.compressionThreshold = n
+	})
+}
+
+// setCompressionHeader announces the compressor of the request messages, if
+// any.
+func (o *callOptions) setCompressionHeader(h http.Header) {
+	if o.compressor != "" {
+		h.Set("grpc-encoding", o.compressor)
+		h.Set("grpc-accept-encoding", o.compressor)
+	}
+}
+
+// compressRequestBody frames body as a compressed message.
+func (o *callOptions) compressRequestBody(body mem.BufferSlice) (*bytes.Buffer, error) {
+	c := encoding.GetCompressor(o.compressor)
+	if c == nil {
+		return nil, errs.WithCode(codes.Internal, nil, fmt.Sprintf("compressor %q is not registered", o.compressor))
+	}
+
+	buf := bytes.NewBuffer(make([]byte, headerLen, headerLen+body.Len()))
+	w, err := c.Compress(buf)
+	if err != nil {
+		return nil, errs.Wrap(err, "failed to compress the request body")
+	}
+	if _, err := io.Copy(w, body.Reader()); err != nil {
+		return nil, errs.Wrap(err, "failed to compress the request body")
+	}
+	if err := w.Close(); err != nil {
+		return nil, errs.Wrap(err, "failed to compress the request body")
+	}
+
+	b := buf.Bytes()
+	b[0] = compressedFlag
+	binary.BigEndian.PutUint32(b[1:headerLen], uint32(buf.Len()-headerLen))
+	return buf, nil
+}
+
+// decompressMessage returns the content of the message frame h, decompressing
+// msg with the compressor named by the grpc-encoding response header if the
+// frame is compressed. The decompressed content is subject to limit like the
+// frames are.
+func decompressMessage(h *parser.Header, md metadata.MD, msg []byte, limit int) ([]byte, error) {
+	if h.Flag()&compressedFlag == 0 {
+		return msg, nil
+	}
+
+	var name string
+	if v := md.Get("grpc-encoding"); len(v) > 0 {
+		name = v[0]
+	}
+	c := encoding.GetCompressor(name)
+	if c == nil {
+		return nil, errs.WithCode(codes.Internal, nil, fmt.Sprintf("received a message compressed with unsupported encoding %q", name))
+	}
+
+	r, err := c.Decompress(bytes.NewReader(msg))
+	if err != nil {
+		return nil, errs.WithCode(codes.Internal, err, "failed to decompress the response message")
+	}
+	if limit > 0 {
+		// Read one byte past the limit to detect oversized messages.
+		r = io.LimitReader(r, int64(limit)+1)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errs.WithCode(codes.Internal, err, "failed to decompress the response message")
+	}
+	if err := checkMessageSize(limit, uint32(len(b))); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,255 @@
+package grpcweb
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/errs"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/transport"
+)
+
+// ErrConcurrencyLimitExceeded is returned by the unary calls which exceed the
+// limit set with WithAdaptiveConcurrency.
+var ErrConcurrencyLimitExceeded = errs.WithCode(codes.ResourceExhausted, nil, "concurrency limit exceeded")
+
+// AdaptiveConcurrency configures the adaptive limit of the unary calls in
+// flight, like the AIMD limit of netflix/concurrency-limits. The limit grows
+// additively, by about one per limit's worth of successful calls made while
+// at least half of it is in use. It shrinks multiplicatively when a call
+// fails with codes.ResourceExhausted, codes.Unavailable or
+// codes.DeadlineExceeded, or takes longer than the lowest latency observed
+// times LatencyTolerance, the sign of a queue building up in the gateway.
+// Every attempt of a call is accounted for separately, the canceled ones are
+// ignored.
+type AdaptiveConcurrency struct {
+	// InitialLimit is the limit to start with. It defaults to 20.
+	InitialLimit int
+	// MinLimit and MaxLimit bound the limit. They default to 1 and 1000.
+	MinLimit, MaxLimit int
+	// BackoffRatio is the factor in (0, 1) the limit is multiplied by when it
+	// shrinks. It defaults to 0.9.
+	BackoffRatio float64
+	// LatencyTolerance is the factor of the lowest latency observed above
+	// which a successful call shrinks the limit. It defaults to 2.
+	LatencyTolerance float64
+	// Window is the number of successful calls after which the lowest latency
+	// is measured anew, so that the limit follows a lasting change of the
+	// latency of the server. It defaults to 500.
+	Window int
+	// MaxWait is the time a call exceeding the limit waits for another one
+	// to end. It fails at once with ErrConcurrencyLimitExceeded if zero. The
+	// waiting calls are admitted by priority, see Priority.
+	MaxWait time.Duration
+}
+
+// PriorityClass is the priority of a call under the limit set with
+// WithAdaptiveConcurrency.
+type PriorityClass int
+
+const (
+	// PriorityLow calls are shed with ErrConcurrencyLimitExceeded as soon as
+	// the limit is reached, instead of waiting.
+	PriorityLow PriorityClass = -1
+	// PriorityNormal is the priority of the calls by default.
+	PriorityNormal PriorityClass = 0
+	// PriorityHigh calls are admitted before the other waiting ones.
+	PriorityHigh PriorityClass = 1
+)
+
+// Priority sets the priority class of the call, which orders the admission
+// of the calls exceeding the limit set with WithAdaptiveConcurrency. It has
+// no effect without it.
+func Priority(p PriorityClass) CallOption {
+	return newCallOption(func(opt *callOptions) {
+		opt.priority = p
+	})
+}
+
+// WithAdaptiveConcurrency limits the number of unary calls in flight with a
+// limit adjusted to the observed latency, which protects an overloaded
+// gateway better than a static cap. Streams aren't limited.
+func WithAdaptiveConcurrency(a AdaptiveConcurrency) DialOption {
+	return func(opt *dialOptions) {
+		opt.adaptiveConcurrency = &a
+	}
+}
+
+// ConcurrencyLimit returns the current limit set with WithAdaptiveConcurrency
+// and the number of unary calls in flight. The limit is zero if there is
+// none.
+func (c *ClientConn) ConcurrencyLimit() (limit, inFlight int) {
+	l := c.concurrency
+	if l == nil {
+		return 0, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit), l.inFlight
+}
+
+// concurrencyLimiter implements AdaptiveConcurrency.
+type concurrencyLimiter struct {
+	config AdaptiveConcurrency
+	clock  transport.Clock
+
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+	// minLatency is the lowest latency observed, windowMin the lowest one
+	// over the samples of the current window.
+	minLatency, windowMin time.Duration
+	samples               int
+	// waiters are the calls waiting for the limit, by decreasing priority
+	// then in order of arrival.
+	waiters []*concurrencyWaiter
+}
+
+type concurrencyWaiter struct {
+	priority PriorityClass
+	// admitted is closed once the call is admitted.
+	admitted chan struct{}
+}
+
+func newConcurrencyLimiter(a *AdaptiveConcurrency, clock transport.Clock) *concurrencyLimiter {
+	if a == nil {
+		return nil
+	}
+	config := *a
+	if config.MinLimit <= 0 {
+		config.MinLimit = 1
+	}
+	if config.MaxLimit <= 0 {
+		config.MaxLimit = max(1000, config.MinLimit)
+	}
+	if config.InitialLimit <= 0 {
+		config.InitialLimit = 20
+	}
+	config.InitialLimit = min(max(config.InitialLimit, config.MinLimit), config.MaxLimit)
+	if config.BackoffRatio <= 0 || config.BackoffRatio >= 1 {
+		config.BackoffRatio = 0.9
+	}
+	if config.LatencyTolerance <= 0 {
+		config.LatencyTolerance = 2
+	}
+	if config.Window <= 0 {
+		config.Window = 500
+	}
+	return &concurrencyLimiter{
+		config: config,
+		clock:  clock,
+		limit:  float64(config.InitialLimit),
+	}
+}
+
+// acquire waits for the call of priority p to fit in the limit, and returns
+// the function to call with its error once it ends.
+func (l *concurrencyLimiter) acquire(ctx context.Context, p PriorityClass) (func(err error), error) {
+	if l == nil {
+		return func(error) {}, nil
+	}
+	l.mu.Lock()
+	if l.inFlight < int(l.limit) {
+		l.inFlight++
+		l.mu.Unlock()
+		return l.start(), nil
+	}
+	if p <= PriorityLow || l.config.MaxWait <= 0 {
+		l.mu.Unlock()
+		return nil, ErrConcurrencyLimitExceeded
+	}
+	w := &concurrencyWaiter{priority: p, admitted: make(chan struct{})}
+	i := len(l.waiters)
+	for i > 0 && l.waiters[i-1].priority < p {
+		i--
+	}
+	l.waiters = slices.Insert(l.waiters, i, w)
+	l.mu.Unlock()
+
+	timer := l.clock.NewTimer(l.config.MaxWait)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-w.admitted:
+		return l.start(), nil
+	case <-ctx.Done():
+		err = errs.WithCode(codes.Canceled, ctx.Err(), "the call was canceled while waiting for the concurrency limit")
+	case <-timer.C():
+		err = ErrConcurrencyLimitExceeded
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-w.admitted:
+		// The call was admitted meanwhile, it hands its slot over.
+		l.inFlight--
+		l.admit()
+	default:
+		l.waiters = slices.DeleteFunc(l.waiters, func(v *concurrencyWaiter) bool { return v == w })
+	}
+	return nil, err
+}
+
+// start returns the function ending an admitted call.
+func (l *concurrencyLimiter) start() func(err error) {
+	start := l.clock.Now()
+	return func(err error) { l.release(l.clock.Now().Sub(start), err) }
+}
+
+// admit admits the waiting calls which fit in the limit.
+func (l *concurrencyLimiter) admit() {
+	for len(l.waiters) > 0 && l.inFlight < int(l.limit) {
+		l.inFlight++
+		close(l.waiters[0].admitted)
+		l.waiters = l.waiters[1:]
+	}
+}
+
+// release ends a call which took latency and failed with err, adjusts the
+// limit and admits the waiting calls.
+func (l *concurrencyLimiter) release(latency time.Duration, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	defer l.admit()
+	inFlight := l.inFlight
+	l.inFlight--
+
+	var overloaded bool
+	switch code := status.Code(err); {
+	case err == nil:
+		overloaded = l.minLatency > 0 && float64(latency) > float64(l.minLatency)*l.config.LatencyTolerance
+		l.observe(latency)
+	case code == codes.ResourceExhausted || code == codes.Unavailable || code == codes.DeadlineExceeded ||
+		errors.Is(err, context.DeadlineExceeded):
+		overloaded = true
+	default:
+		return
+	}
+
+	switch {
+	case overloaded:
+		l.limit = max(l.limit*l.config.BackoffRatio, float64(l.config.MinLimit))
+	case float64(inFlight)*2 >= l.limit:
+		l.limit = min(l.limit+1/l.limit, float64(l.config.MaxLimit))
+	}
+}
+
+// observe records the latency of a successful call.
+func (l *concurrencyLimiter) observe(latency time.Duration) {
+	if l.minLatency == 0 || latency < l.minLatency {
+		l.minLatency = latency
+	}
+	if l.windowMin == 0 || latency < l.windowMin {
+		l.windowMin = latency
+	}
+	l.samples++
+	if l.samples >= l.config.Window {
+		l.minLatency, l.windowMin, l.samples = l.windowMin, 0, 0
+	}
+}
@@ -0,0 +1,200 @@
+// Package dashboard provides an http.Handler rendering the health of a
+// ClientConn as JSON, meant to be mounted on an internal admin port:
+//
+//	d := dashboard.New()
+//	cc, err := grpcweb.NewClient(host, grpcweb.WithStatsHandler(d))
+//	d.SetClientConn(cc)
+//	adminMux.Handle("/debug/grpcweb", d)
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb"
+)
+
+const (
+	// maxRecentErrors is the number of errors kept for display.
+	maxRecentErrors = 20
+	// maxSamples is the number of latencies per method the percentiles are
+	// computed from.
+	maxSamples = 1024
+)
+
+// Dashboard collects the outcome of the calls made with the ClientConn it is
+// registered on with grpcweb.WithStatsHandler, and renders it along with the
+// state of the ClientConn.
+type Dashboard struct {
+	mu           sync.Mutex
+	cc           *grpcweb.ClientConn
+	errorsByCode map[string]int
+	recentErrors []Error
+	latencies    map[string]*samples
+}
+
+// New returns an empty Dashboard.
+func New() *Dashboard {
+	return &Dashboard{
+		errorsByCode: make(map[string]int),
+		latencies:    make(map[string]*samples),
+	}
+}
+
+// SetClientConn sets the ClientConn whose state is rendered.
+func (d *Dashboard) SetClientConn(cc *grpcweb.ClientConn) {
+	d.mu.Lock()
+	d.cc = cc
+	d.mu.Unlock()
+}
+
+// Snapshot is the content rendered by the Dashboard.
+type Snapshot struct {
+	Target        string             `json:"target,omitempty"`
+	ActiveStreams []Stream           `json:"active_streams"`
+	ErrorsByCode  map[string]int     `json:"errors_by_code"`
+	RecentErrors  []Error            `json:"recent_errors"`
+	Methods       map[string]Latency `json:"methods"`
+}
+
+// Stream describes an open stream.
+type Stream struct {
+	Method     string  `json:"method"`
+	AgeSeconds float64 `json:"age_seconds"`
+}
+
+// Error describes a failed call.
+type Error struct {
+	Time    time.Time `json:"time"`
+	Method  string    `json:"method"`
+	Code    string    `json:"code"`
+	Message string    `json:"message"`
+}
+
+// Latency summarizes the latencies of the recent calls of a method, in
+// milliseconds.
+type Latency struct {
+	Count int64   `json:"count"`
+	P50   float64 `json:"p50_ms"`
+	P90   float64 `json:"p90_ms"`
+	P99   float64 `json:"p99_ms"`
+}
+
+// samples is a ring of the most recent latencies of a method.
+type samples struct {
+	count int64
+	ring  []time.Duration
+}
+
+func (s *samples) add(d time.Duration) {
+	if len(s.ring) < maxSamples {
+		s.ring = append(s.ring, d)
+	} else {
+		s.ring[s.count%maxSamples] = d
+	}
+	s.count++
+}
+
+func (s *samples) latency() Latency {
+	sorted := append([]time.Duration(nil), s.ring...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	percentile := func(p float64) float64 {
+		if len(sorted) == 0 {
+			return 0
+		}
+		d := sorted[int(p*float64(len(sorted)-1))]
+		return float64(d) / float64(time.Millisecond)
+	}
+	return Latency{Count: s.count, P50: percentile(0.5), P90: percentile(0.9), P99: percentile(0.99)}
+}
+
+// Snapshot returns the current content of the Dashboard.
+func (d *Dashboard) Snapshot() Snapshot {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s := Snapshot{
+		ActiveStreams: []Stream{},
+		ErrorsByCode:  make(map[string]int, len(d.errorsByCode)),
+		RecentErrors:  append([]Error{}, d.recentErrors...),
+		Methods:       make(map[string]Latency, len(d.latencies)),
+	}
+	if d.cc != nil {
+		target := d.cc.Target()
+		// Don't expose the credentials of the target.
+		if i := strings.LastIndex(target, "@"); i != -1 {
+			target = target[i+1:]
+		}
+		s.Target = target
+		for _, info := range d.cc.ActiveStreams() {
+			s.ActiveStreams = append(s.ActiveStreams, Stream{Method: info.Method, AgeSeconds: info.Age().Seconds()})
+		}
+	}
+	for code, n := range d.errorsByCode {
+		s.ErrorsByCode[code] = n
+	}
+	for method, l := range d.latencies {
+		s.Methods[method] = l.latency()
+	}
+	return s
+}
+
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(d.Snapshot())
+}
+
+type methodKey struct{}
+
+func (d *Dashboard) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, methodKey{}, info.FullMethodName)
+}
+
+func (d *Dashboard) HandleRPC(ctx context.Context, rs stats.RPCStats) {
+	end, ok := rs.(*stats.End)
+	if !ok {
+		return
+	}
+	method, _ := ctx.Value(methodKey{}).(string)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	l, ok := d.latencies[method]
+	if !ok {
+		l = &samples{}
+		d.latencies[method] = l
+	}
+	l.add(end.EndTime.Sub(end.BeginTime))
+
+	if end.Error == nil {
+		return
+	}
+	st := status.Convert(end.Error)
+	d.errorsByCode[st.Code().String()]++
+	if len(d.recentErrors) == maxRecentErrors {
+		d.recentErrors = d.recentErrors[1:]
+	}
+	d.recentErrors = append(d.recentErrors, Error{
+		Time:    end.EndTime,
+		Method:  method,
+		Code:    st.Code().String(),
+		Message: st.Message(),
+	})
+}
+
+func (d *Dashboard) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (d *Dashboard) HandleConn(context.Context, stats.ConnStats) {}
@@ -0,0 +1,61 @@
+package dashboard_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/dashboard"
+)
+
+func TestDashboard(t *testing.T) {
+	cc, err := grpcweb.NewClient("user:secret@localhost:8080", grpcweb.WithInsecure())
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+	d := dashboard.New()
+	d.SetClientConn(cc)
+
+	begin := time.Now()
+	for i, err := range []error{nil, nil, status.Error(codes.Unavailable, "gateway down")} {
+		ctx := d.TagRPC(context.Background(), &stats.RPCTagInfo{FullMethodName: "/service/Method"})
+		d.HandleRPC(ctx, &stats.Begin{Client: true, BeginTime: begin})
+		d.HandleRPC(ctx, &stats.End{
+			Client:    true,
+			BeginTime: begin,
+			EndTime:   begin.Add(time.Duration(i+1) * time.Millisecond),
+			Error:     err,
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	d.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/grpcweb", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected content-type application/json, but got %q", ct)
+	}
+
+	var s dashboard.Snapshot
+	if err := json.Unmarshal(rec.Body.Bytes(), &s); err != nil {
+		t.Fatalf("the response should be a JSON snapshot, but got '%s'", err)
+	}
+	if s.Target != "localhost:8080" {
+		t.Errorf("expected the target without credentials, but got %q", s.Target)
+	}
+	if n := s.ErrorsByCode["Unavailable"]; n != 1 {
+		t.Errorf("expected 1 Unavailable error, but got %d", n)
+	}
+	if len(s.RecentErrors) != 1 || s.RecentErrors[0].Message != "gateway down" || s.RecentErrors[0].Method != "/service/Method" {
+		t.Errorf("unexpected recent errors: %+v", s.RecentErrors)
+	}
+	l := s.Methods["/service/Method"]
+	if l.Count != 3 || l.P50 != 2 || l.P99 != 2 {
+		t.Errorf("unexpected latencies: %+v", l)
+	}
+}
@@ -0,0 +1,113 @@
+package grpcweb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/errs"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/statusresolver"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/parser"
+)
+
+// deviation handles a response which doesn't follow the gRPC-Web protocol. In
+// strict mode it returns an Internal error, otherwise the deviation is logged
+// as a warning and nil is returned so that the caller can carry on.
+func (o *dialOptions) deviation(method, format string, args ...any) error {
+	detail := fmt.Sprintf(format, args...)
+	if o.strict {
+		return errs.WithCode(codes.Internal, nil, "protocol violation: "+detail)
+	}
+	if o.logger != nil {
+		o.logger.Warn("gRPC-Web protocol deviation", "method", method, "detail", detail)
+	}
+	return nil
+}
+
+// resolveEnd resolves the status of a response whose body ended without a
+// trailer frame, reporting the deviation unless it is a trailers-only one.
+func (o *dialOptions) resolveEnd(method string, res *statusresolver.Resolver) error {
+	st, deviation := res.OnEnd()
+	if deviation {
+		if err := o.deviation(method, "server closed the stream without sending trailers"); err != nil {
+			return err
+		}
+	}
+	return st.Err()
+}
+
+// checkContentType reports a deviation if the response isn't a gRPC-Web one.
+func (o *dialOptions) checkContentType(method string, h http.Header) error {
+	ct := h.Get("content-type")
+	if strings.HasPrefix(ct, "application/grpc-web") {
+		return nil
+	}
+	return o.deviation(method, "unexpected content-type %q", ct)
+}
+
+// errSkippedFrames is returned by readFrameHeader when r ends right after
+// skipped frames. Websocket streams receive the next message in that case.
+var errSkippedFrames = errs.Wrap(io.EOF, "no frame left after the skipped ones")
+
+// readFrameHeader reads the next frame header from r, and notifies the frame
+// handlers of co of it. Heartbeat frames are skipped and counted into the
+// stats of co. Frames which are neither messages nor trailers are handled as
+// configured by WithUnknownFrames, and skipped unless rejected.
+func (o *dialOptions) readFrameHeader(method string, r io.Reader, co *callOptions) (*parser.Header, error) {
+	skipped := false
+	for {
+		h, err := o.frameParser.ParseResponseHeader(r)
+		if err != nil {
+			if skipped && errors.Is(err, io.EOF) {
+				return nil, errSkippedFrames
+			}
+			return nil, err
+		}
+		co.frame(false, h.Flag(), h.ContentLength)
+		if h.IsHeartbeat() {
+			if co.stats != nil {
+				co.stats.Heartbeats++
+			}
+			skipped = true
+			continue
+		}
+		if isKnownFrame(h) {
+			return h, nil
+		}
+
+		if err := o.unknownFrame(method, r, h); err != nil {
+			return nil, err
+		}
+		skipped = true
+	}
+}
+
+// extraMessages consumes the superfluous message frames of a unary response,
+// starting with h, and returns an Internal error. The frames are exposed
+// through the logger at debug level.
+func (o *dialOptions) extraMessages(method string, r io.Reader, h *parser.Header, co *callOptions) error {
+	n := 1
+	for h != nil && h.IsMessageHeader() {
+		if err := checkMessageSize(o.maxBufferSize, h.ContentLength); err != nil {
+			break
+		}
+		msg, err := o.frameParser.ParseLengthPrefixedMessage(r, h.ContentLength)
+		if err != nil {
+			break
+		}
+		n++
+		if o.logger != nil {
+			o.logger.Debug("extra response message on unary call", "method", method, "index", n, "message", o.redact(method, msg))
+		}
+
+		h, err = o.readFrameHeader(method, r, co)
+		if err != nil {
+			break
+		}
+	}
+	return errs.WithCode(codes.Internal, nil, fmt.Sprintf("received %d response messages for a unary call, expected exactly one", n))
+}
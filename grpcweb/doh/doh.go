@@ -0,0 +1,196 @@
+// Package doh resolves the targets of a ClientConn with DNS over HTTPS
+// (RFC 8484), for the environments where the system DNS is unreliable or
+// monitored:
+//
+//	r := doh.NewResolver([]doh.Server{
+//		{URL: "https://dns.google/dns-query", Bootstrap: []string{"8.8.8.8", "8.8.4.4"}},
+//		{URL: "https://1.1.1.1/dns-query"},
+//	})
+//	client, err := grpcweb.NewClient(target, grpcweb.WithResolver(r, time.Minute))
+package doh
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"google.golang.org/grpc/codes"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/errs"
+)
+
+// ErrNotFound is returned when the host has no address.
+var ErrNotFound = errs.WithCode(codes.NotFound, nil, "no such host")
+
+// maxMessageSize bounds the DNS responses read, the largest DNS message.
+const maxMessageSize = 65535
+
+// Server is a DoH server.
+type Server struct {
+	// URL is the URL of the endpoint, such as
+	// "https://dns.google/dns-query".
+	URL string
+	// Bootstrap are the IP addresses the host of URL is connected to, tried
+	// in order, so that the host isn't resolved with the system DNS. The
+	// certificate is still verified against the host. The host is resolved
+	// by the system if Bootstrap is empty, unless it is an IP address.
+	Bootstrap []string
+}
+
+// Option configures a Resolver.
+type Option func(*options)
+
+type options struct {
+	tlsConf *tls.Config
+}
+
+// WithTLSConfig sets the TLS configuration of the connections to the servers.
+func WithTLSConfig(conf *tls.Config) Option {
+	return func(o *options) {
+		o.tlsConf = conf
+	}
+}
+
+// Resolver is a grpcweb.Resolver querying DoH servers. It is safe for
+// concurrent use.
+type Resolver struct {
+	servers []Server
+	client  *http.Client
+}
+
+// NewResolver returns a Resolver querying servers in order, falling back to
+// the next one when a server fails to answer.
+func NewResolver(servers []Server, opts ...Option) *Resolver {
+	o := new(options)
+	for _, f := range opts {
+		f(o)
+	}
+
+	bootstrap := make(map[string][]string)
+	for _, s := range servers {
+		if u, err := url.Parse(s.URL); err == nil && len(s.Bootstrap) > 0 {
+			bootstrap[u.Hostname()] = s.Bootstrap
+		}
+	}
+	d := new(net.Dialer)
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = o.tlsConf
+	tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || len(bootstrap[host]) == 0 {
+			return d.DialContext(ctx, network, addr)
+		}
+		var lastErr error
+		for _, ip := range bootstrap[host] {
+			conn, err := d.DialContext(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+
+	return &Resolver{servers: servers, client: &http.Client{Transport: tr}}
+}
+
+// Resolve returns the IPv4 and IPv6 addresses of host. An IP address is
+// returned as is.
+func (r *Resolver) Resolve(ctx context.Context, host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+	name, err := dnsmessage.NewName(strings.TrimSuffix(host, ".") + ".")
+	if err != nil {
+		return nil, errs.Wrapf(err, "invalid host %q", host)
+	}
+
+	if len(r.servers) == 0 {
+		return nil, errs.New("no DoH server")
+	}
+	var lastErr error
+	for _, s := range r.servers {
+		addrs, err := r.resolve(ctx, s.URL, name)
+		if err == nil || errors.Is(err, ErrNotFound) || ctx.Err() != nil {
+			return addrs, err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// resolve queries the A and AAAA records of name to server.
+func (r *Resolver) resolve(ctx context.Context, server string, name dnsmessage.Name) ([]string, error) {
+	var addrs []string
+	for _, t := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		res, err := r.query(ctx, server, dnsmessage.Question{Name: name, Type: t, Class: dnsmessage.ClassINET})
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range res.Answers {
+			switch b := a.Body.(type) {
+			case *dnsmessage.AResource:
+				addrs = append(addrs, net.IP(b.A[:]).String())
+			case *dnsmessage.AAAAResource:
+				addrs = append(addrs, net.IP(b.AAAA[:]).String())
+			}
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return addrs, nil
+}
+
+// query sends q to server. The ID of the query is 0, as recommended by RFC
+// 8484 for the responses to be cacheable.
+func (r *Resolver) query(ctx context.Context, server string, q dnsmessage.Question) (*dnsmessage.Message, error) {
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{q},
+	}
+	b, err := msg.Pack()
+	if err != nil {
+		return nil, errs.Wrap(err, "failed to build the query")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server, bytes.NewReader(b))
+	if err != nil {
+		return nil, errs.Wrap(err, "failed to build the request")
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		return nil, errs.Wrapf(err, "failed to query %s", server)
+	}
+	defer res.Body.Close()
+	b, err = io.ReadAll(io.LimitReader(res.Body, maxMessageSize))
+	if err != nil {
+		return nil, errs.Wrapf(err, "failed to read the response of %s", server)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, errs.Errorf("%s responded with %s", server, res.Status)
+	}
+
+	var answer dnsmessage.Message
+	if err := answer.Unpack(b); err != nil {
+		return nil, errs.Wrapf(err, "invalid response from %s", server)
+	}
+	switch answer.RCode {
+	case dnsmessage.RCodeSuccess:
+		return &answer, nil
+	case dnsmessage.RCodeNameError:
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, q.Name)
+	default:
+		return nil, errs.Errorf("%s responded with %s", server, answer.RCode)
+	}
+}
@@ -0,0 +1,111 @@
+package doh_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/doh"
+)
+
+func TestResolver(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		var q dnsmessage.Message
+		if err := q.Unpack(b); err != nil || len(q.Questions) != 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		question := q.Questions[0]
+		res := dnsmessage.Message{
+			Header:    dnsmessage.Header{Response: true, RecursionAvailable: true},
+			Questions: q.Questions,
+		}
+		h := dnsmessage.ResourceHeader{Name: question.Name, Type: question.Type, Class: dnsmessage.ClassINET, TTL: 60}
+		switch {
+		case question.Name.String() != "backend.example.com.":
+			res.RCode = dnsmessage.RCodeNameError
+		case question.Type == dnsmessage.TypeA:
+			res.Answers = []dnsmessage.Resource{{Header: h, Body: &dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}}}}
+		case question.Type == dnsmessage.TypeAAAA:
+			res.Answers = []dnsmessage.Resource{{Header: h, Body: &dnsmessage.AAAAResource{AAAA: [16]byte{15: 1}}}}
+		}
+		b, _ = res.Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(b)
+	}))
+	defer srv.Close()
+	failing := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	roots.AddCert(failing.Certificate())
+	// The test certificates are valid for *.example.com, whose names are
+	// only reachable through the bootstrap addresses.
+	server := func(s *httptest.Server) doh.Server {
+		port := s.URL[strings.LastIndex(s.URL, ":"):]
+		return doh.Server{URL: "https://dns.example.com" + port + "/dns-query", Bootstrap: []string{"127.0.0.1"}}
+	}
+
+	cases := map[string]struct {
+		servers       []doh.Server
+		host          string
+		expectedAddrs []string
+		expectedErr   error
+	}{
+		"resolved": {
+			servers:       []doh.Server{server(srv)},
+			host:          "backend.example.com",
+			expectedAddrs: []string{"10.0.0.1", "::1"},
+		},
+		"fallback": {
+			servers:       []doh.Server{server(failing), server(srv)},
+			host:          "backend.example.com",
+			expectedAddrs: []string{"10.0.0.1", "::1"},
+		},
+		"ip address": {
+			host:          "192.0.2.1",
+			expectedAddrs: []string{"192.0.2.1"},
+		},
+		"not found": {
+			servers:     []doh.Server{server(srv), server(failing)},
+			host:        "missing.example.com",
+			expectedErr: doh.ErrNotFound,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := doh.NewResolver(c.servers, doh.WithTLSConfig(&tls.Config{RootCAs: roots}))
+			addrs, err := r.Resolve(context.Background(), c.host)
+			if c.expectedErr != nil {
+				if !errors.Is(err, c.expectedErr) {
+					t.Errorf("expected '%v', but got '%v'", c.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve should not return an error, but got '%s'", err)
+			}
+			if diff := cmp.Diff(c.expectedAddrs, addrs); diff != "" {
+				t.Errorf("-want, +got\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package dynamic
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/errs"
+)
+
+// NewDescriptorSetSource returns a Source looking the methods up in set, so
+// that the dynamic calls don't need server reflection. The imports missing
+// from set must be well-known types, which are registered in
+// protoregistry.GlobalFiles.
+func NewDescriptorSetSource(set *descriptorpb.FileDescriptorSet) (Source, error) {
+	protos := make(map[string]*descriptorpb.FileDescriptorProto, len(set.GetFile()))
+	for _, fdp := range set.GetFile() {
+		protos[fdp.GetName()] = fdp
+	}
+	files := new(protoregistry.Files)
+	for _, fdp := range set.GetFile() {
+		if err := registerFile(files, protos, fdp.GetName()); err != nil {
+			return nil, errs.Wrap(err, "failed to load the descriptor set")
+		}
+	}
+	return NewFilesSource(files), nil
+}
+
+// LoadDescriptorSet returns a Source looking the methods up in the
+// FileDescriptorSet stored at path, such as the output of
+// protoc --descriptor_set_out --include_imports or a buf image. The set may
+// be gzipped, and is parsed as JSON if path ends with ".json".
+func LoadDescriptorSet(path string) (Source, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errs.Wrap(err, "failed to read the descriptor set")
+	}
+	if bytes.HasPrefix(b, []byte{0x1f, 0x8b}) {
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, errs.Wrap(err, "failed to decompress the descriptor set")
+		}
+		if b, err = io.ReadAll(r); err != nil {
+			return nil, errs.Wrap(err, "failed to decompress the descriptor set")
+		}
+		path = strings.TrimSuffix(path, ".gz")
+	}
+
+	// Buf images are descriptor sets with additional fields, which are
+	// discarded.
+	set := new(descriptorpb.FileDescriptorSet)
+	if filepath.Ext(path) == ".json" {
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(b, set)
+	} else {
+		err = proto.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(b, set)
+	}
+	if err != nil {
+		return nil, errs.Wrap(err, "failed to parse the descriptor set")
+	}
+	return NewDescriptorSetSource(set)
+}
@@ -0,0 +1,173 @@
+// Package dynamic calls methods whose message types are only known at run
+// time, with requests and responses in JSON. It is the building block of the
+// CLI and REPL tools layered on the client:
+//
+//	src := dynamic.NewReflectionSource(client)
+//	res, err := dynamic.InvokeJSON(ctx, client, src, "/pkg.Service/Method", []byte(`{"name": "nano"}`))
+//
+// The descriptors may also be loaded from a descriptor set file with
+// LoadDescriptorSet, for the gateways which don't expose server reflection.
+package dynamic
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/errs"
+)
+
+// Sentinel errors returned by the package. They resolve to gRPC codes.
+var (
+	ErrMethodNotFound  = errs.WithCode(codes.NotFound, nil, "method not found")
+	ErrStreamingMethod = errs.WithCode(codes.InvalidArgument, nil, "streaming method")
+	ErrInvalidRequest  = errs.WithCode(codes.InvalidArgument, nil, "invalid request")
+)
+
+// Source resolves the descriptors of the methods called dynamically.
+type Source interface {
+	// FindMethod returns the descriptor of method, a full method name such
+	// as "/pkg.Service/Method". It returns an error wrapping
+	// ErrMethodNotFound if the method doesn't exist.
+	FindMethod(ctx context.Context, method string) (protoreflect.MethodDescriptor, error)
+}
+
+// NewFilesSource returns a Source looking the methods up in files, such as
+// protoregistry.GlobalFiles.
+func NewFilesSource(files *protoregistry.Files) Source {
+	return filesSource{files: files}
+}
+
+type filesSource struct {
+	files *protoregistry.Files
+}
+
+func (s filesSource) FindMethod(_ context.Context, method string) (protoreflect.MethodDescriptor, error) {
+	return findMethod(s.files, method)
+}
+
+// InvokeJSON performs a unary call of method with the request given in JSON,
+// and returns the response in JSON. The message types are resolved by src.
+func InvokeJSON(ctx context.Context, cc grpcweb.ClientConnInterface, src Source, method string, request []byte, opts ...grpcweb.CallOption) ([]byte, error) {
+	md, err := src.FindMethod(ctx, method)
+	if err != nil {
+		return nil, err
+	}
+	if md.IsStreamingClient() || md.IsStreamingServer() {
+		return nil, fmt.Errorf("%w: %s", ErrStreamingMethod, md.FullName())
+	}
+
+	types := methodTypes(md)
+	req, err := parseRequest(md, types, request)
+	if err != nil {
+		return nil, err
+	}
+	res := dynamicpb.NewMessage(md.Output())
+	if err := cc.Invoke(ctx, fullMethod(md), req, res, opts...); err != nil {
+		return nil, err
+	}
+	b, err := (protojson.MarshalOptions{Resolver: types}).Marshal(res)
+	if err != nil {
+		return nil, errs.Wrap(err, "failed to marshal the response")
+	}
+	return b, nil
+}
+
+// parseRequest returns the request message of md given in JSON.
+func parseRequest(md protoreflect.MethodDescriptor, types *dynamicpb.Types, request []byte) (*dynamicpb.Message, error) {
+	req := dynamicpb.NewMessage(md.Input())
+	if err := (protojson.UnmarshalOptions{Resolver: types}).Unmarshal(request, req); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	return req, nil
+}
+
+// fullMethod returns the full method name of md, as sent on the wire.
+func fullMethod(md protoreflect.MethodDescriptor) string {
+	return "/" + string(md.Parent().FullName()) + "/" + string(md.Name())
+}
+
+// splitMethod splits a full method name into its service and method names.
+func splitMethod(method string) (protoreflect.FullName, protoreflect.Name, error) {
+	service, name, ok := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	if !ok || service == "" || name == "" {
+		return "", "", fmt.Errorf("%w: malformed method name %q", ErrMethodNotFound, method)
+	}
+	return protoreflect.FullName(service), protoreflect.Name(name), nil
+}
+
+func findMethod(files *protoregistry.Files, method string) (protoreflect.MethodDescriptor, error) {
+	service, name, err := splitMethod(method)
+	if err != nil {
+		return nil, err
+	}
+	d, err := files.FindDescriptorByName(service)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMethodNotFound, method)
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is not a service", ErrMethodNotFound, service)
+	}
+	md := sd.Methods().ByName(name)
+	if md == nil {
+		return nil, fmt.Errorf("%w: %s", ErrMethodNotFound, method)
+	}
+	return md, nil
+}
+
+// methodTypes returns the types of the file of md and its imports, which the
+// JSON of its messages may refer to in Any fields.
+func methodTypes(md protoreflect.MethodDescriptor) *dynamicpb.Types {
+	files := new(protoregistry.Files)
+	var register func(fd protoreflect.FileDescriptor)
+	register = func(fd protoreflect.FileDescriptor) {
+		if _, err := files.FindFileByPath(fd.Path()); err == nil {
+			return
+		}
+		// Conflicts are ignored, the first definition wins.
+		_ = files.RegisterFile(fd)
+		imports := fd.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			register(imports.Get(i).FileDescriptor)
+		}
+	}
+	register(md.ParentFile())
+	return dynamicpb.NewTypes(files)
+}
+
+// registerFile builds the file name from protos and registers it in files,
+// after its dependencies. The dependencies missing from protos and files are
+// looked up in protoregistry.GlobalFiles, which holds the well-known types.
+func registerFile(files *protoregistry.Files, protos map[string]*descriptorpb.FileDescriptorProto, name string) error {
+	if _, err := files.FindFileByPath(name); err == nil {
+		return nil
+	}
+	fdp, ok := protos[name]
+	if !ok {
+		fd, err := protoregistry.GlobalFiles.FindFileByPath(name)
+		if err != nil {
+			return errs.Wrapf(err, "missing file %q", name)
+		}
+		return files.RegisterFile(fd)
+	}
+	for _, dep := range fdp.GetDependency() {
+		if err := registerFile(files, protos, dep); err != nil {
+			return err
+		}
+	}
+	fd, err := protodesc.NewFile(fdp, files)
+	if err != nil {
+		return errs.Wrapf(err, "invalid file %q", name)
+	}
+	return files.RegisterFile(fd)
+}
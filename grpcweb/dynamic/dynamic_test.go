@@ -0,0 +1,321 @@
+package dynamic
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	pb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/grpcwebmock"
+)
+
+func healthConn(t *testing.T) *grpcwebmock.ClientConnInterfaceMock {
+	return &grpcwebmock.ClientConnInterfaceMock{
+		InvokeFunc: func(ctx context.Context, method string, args, reply any, opts ...grpcweb.CallOption) error {
+			if method != "/grpc.health.v1.Health/Check" {
+				t.Errorf("expected the method to be '/grpc.health.v1.Health/Check', but got '%s'", method)
+			}
+			b, err := protojson.Marshal(args.(proto.Message))
+			if err != nil {
+				t.Fatalf("Marshal should not return an error, but got '%s'", err)
+			}
+			var req healthpb.HealthCheckRequest
+			if err := protojson.Unmarshal(b, &req); err != nil {
+				t.Fatalf("Unmarshal should not return an error, but got '%s'", err)
+			}
+			if req.GetService() == "unknown" {
+				return status.Error(codes.NotFound, "unknown service")
+			}
+			return protojson.Unmarshal([]byte(`{"status": "SERVING"}`), reply.(proto.Message))
+		},
+	}
+}
+
+func TestInvokeJSON(t *testing.T) {
+	cases := map[string]struct {
+		method      string
+		request     string
+		expected    string
+		expectedErr error
+		code        codes.Code
+	}{
+		"ok":               {method: "/grpc.health.v1.Health/Check", request: `{"service": "api"}`, expected: `{"status":"SERVING"}`},
+		"without slash":    {method: "grpc.health.v1.Health/Check", request: `{}`, expected: `{"status":"SERVING"}`},
+		"unknown service":  {method: "/pkg.Service/Check", request: `{}`, expectedErr: ErrMethodNotFound, code: codes.NotFound},
+		"unknown method":   {method: "/grpc.health.v1.Health/Get", request: `{}`, expectedErr: ErrMethodNotFound, code: codes.NotFound},
+		"malformed method": {method: "Check", request: `{}`, expectedErr: ErrMethodNotFound, code: codes.NotFound},
+		"streaming":        {method: "/grpc.health.v1.Health/Watch", request: `{}`, expectedErr: ErrStreamingMethod, code: codes.InvalidArgument},
+		"invalid request":  {method: "/grpc.health.v1.Health/Check", request: `{"name": 1}`, expectedErr: ErrInvalidRequest, code: codes.InvalidArgument},
+		"call failure":     {method: "/grpc.health.v1.Health/Check", request: `{"service": "unknown"}`, code: codes.NotFound},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			res, err := InvokeJSON(context.Background(), healthConn(t), NewFilesSource(protoregistry.GlobalFiles), c.method, []byte(c.request))
+			if c.code != codes.OK {
+				if c.expectedErr != nil && !errors.Is(err, c.expectedErr) {
+					t.Errorf("expected %v, but got '%v'", c.expectedErr, err)
+				}
+				if code := status.Code(err); code != c.code {
+					t.Errorf("expected code %s, but got %s", c.code, code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("InvokeJSON should not return an error, but got '%s'", err)
+			}
+			if !equalJSON(t, c.expected, res) {
+				t.Errorf("expected %s, but got %s", c.expected, res)
+			}
+		})
+	}
+}
+
+// equalJSON reports whether the health check responses a and b are equal.
+func equalJSON(t *testing.T, a string, b []byte) bool {
+	var ra, rb healthpb.HealthCheckResponse
+	if err := protojson.Unmarshal([]byte(a), &ra); err != nil {
+		t.Fatalf("Unmarshal should not return an error, but got '%s'", err)
+	}
+	if err := protojson.Unmarshal(b, &rb); err != nil {
+		t.Fatalf("Unmarshal should not return an error, but got '%s'", err)
+	}
+	return proto.Equal(&ra, &rb)
+}
+
+type fakeReflectionClient struct {
+	requests []*pb.ServerReflectionRequest
+}
+
+func (c *fakeReflectionClient) ServerReflectionInfo(ctx context.Context, _ ...grpc.CallOption) (pb.ServerReflection_ServerReflectionInfoClient, error) {
+	return &fakeReflectionStream{c: c}, nil
+}
+
+type fakeReflectionStream struct {
+	grpc.ClientStream
+	c    *fakeReflectionClient
+	next *pb.ServerReflectionRequest
+}
+
+func (s *fakeReflectionStream) Send(req *pb.ServerReflectionRequest) error {
+	s.c.requests = append(s.c.requests, req)
+	s.next = req
+	return nil
+}
+
+func (s *fakeReflectionStream) Recv() (*pb.ServerReflectionResponse, error) {
+	if s.next == nil {
+		return nil, io.EOF
+	}
+	req := s.next
+	s.next = nil
+	if req.GetFileContainingSymbol() != "grpc.health.v1.Health" {
+		return &pb.ServerReflectionResponse{
+			MessageResponse: &pb.ServerReflectionResponse_ErrorResponse{
+				ErrorResponse: &pb.ErrorResponse{ErrorCode: int32(codes.NotFound), ErrorMessage: "symbol not found"},
+			},
+		}, nil
+	}
+	b, err := proto.Marshal(protodesc.ToFileDescriptorProto(healthpb.File_grpc_health_v1_health_proto))
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ServerReflectionResponse{
+		MessageResponse: &pb.ServerReflectionResponse_FileDescriptorResponse{
+			FileDescriptorResponse: &pb.FileDescriptorResponse{FileDescriptorProto: [][]byte{b}},
+		},
+	}, nil
+}
+
+func (s *fakeReflectionStream) CloseSend() error { return nil }
+
+func TestReflectionSource(t *testing.T) {
+	client := &fakeReflectionClient{}
+	src := newReflectionSource(client)
+
+	for i := 0; i < 2; i++ {
+		res, err := InvokeJSON(context.Background(), healthConn(t), src, "/grpc.health.v1.Health/Check", []byte(`{}`))
+		if err != nil {
+			t.Fatalf("InvokeJSON should not return an error, but got '%s'", err)
+		}
+		if !equalJSON(t, `{"status": "SERVING"}`, res) {
+			t.Errorf("expected a serving status, but got %s", res)
+		}
+	}
+	if n := len(client.requests); n != 1 {
+		t.Errorf("expected the descriptors to be fetched once, but got %d requests", n)
+	}
+
+	_, err := InvokeJSON(context.Background(), healthConn(t), src, "/pkg.Service/Method", []byte(`{}`))
+	if !errors.Is(err, ErrMethodNotFound) {
+		t.Errorf("expected ErrMethodNotFound, but got '%v'", err)
+	}
+}
+
+func TestLoadDescriptorSet(t *testing.T) {
+	set := &descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{protodesc.ToFileDescriptorProto(healthpb.File_grpc_health_v1_health_proto)},
+	}
+	binary, err := proto.Marshal(set)
+	if err != nil {
+		t.Fatalf("Marshal should not return an error, but got '%s'", err)
+	}
+	json, err := protojson.Marshal(set)
+	if err != nil {
+		t.Fatalf("Marshal should not return an error, but got '%s'", err)
+	}
+	var gzipped bytes.Buffer
+	w := gzip.NewWriter(&gzipped)
+	w.Write(binary)
+	w.Close()
+
+	cases := map[string]struct {
+		name      string
+		content   []byte
+		expectErr bool
+	}{
+		"binary":          {name: "health.protoset", content: binary},
+		"json":            {name: "health.json", content: json},
+		"gzip":            {name: "health.binpb.gz", content: gzipped.Bytes()},
+		"malformed":       {name: "health.protoset", content: []byte("health"), expectErr: true},
+		"missing imports": {name: "health.protoset", content: mustMarshal(t, missingImport()), expectErr: true},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), c.name)
+			if err := os.WriteFile(path, c.content, 0o600); err != nil {
+				t.Fatalf("WriteFile should not return an error, but got '%s'", err)
+			}
+			src, err := LoadDescriptorSet(path)
+			if c.expectErr {
+				if err == nil {
+					t.Fatalf("LoadDescriptorSet should return an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadDescriptorSet should not return an error, but got '%s'", err)
+			}
+
+			res, err := InvokeJSON(context.Background(), healthConn(t), src, "/grpc.health.v1.Health/Check", []byte(`{}`))
+			if err != nil {
+				t.Fatalf("InvokeJSON should not return an error, but got '%s'", err)
+			}
+			if !equalJSON(t, `{"status": "SERVING"}`, res) {
+				t.Errorf("expected a serving status, but got %s", res)
+			}
+		})
+	}
+}
+
+// missingImport returns a set with a file importing a file which is neither
+// in the set nor well-known.
+func missingImport() *descriptorpb.FileDescriptorSet {
+	return &descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{{
+			Name:       proto.String("a.proto"),
+			Package:    proto.String("a"),
+			Dependency: []string{"b.proto"},
+		}},
+	}
+}
+
+func mustMarshal(t *testing.T, m proto.Message) []byte {
+	b, err := proto.Marshal(m)
+	if err != nil {
+		t.Fatalf("Marshal should not return an error, but got '%s'", err)
+	}
+	return b
+}
+
+type flushBuffer struct {
+	bytes.Buffer
+	lines []string
+}
+
+func (b *flushBuffer) Flush() error {
+	b.lines = append(b.lines, b.String())
+	return nil
+}
+
+func TestStreamJSONLines(t *testing.T) {
+	cases := map[string]struct {
+		method        string
+		recvErr       error
+		expectedLines int
+		expectedCode  codes.Code
+	}{
+		"server stream":  {method: "/grpc.health.v1.Health/Watch", expectedLines: 3},
+		"unary":          {method: "/grpc.health.v1.Health/Check", expectedLines: 1},
+		"stream failure": {method: "/grpc.health.v1.Health/Watch", recvErr: status.Error(codes.Unavailable, "gone"), expectedLines: 3, expectedCode: codes.Unavailable},
+		"unknown method": {method: "/grpc.health.v1.Health/Get", expectedCode: codes.NotFound},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			statuses := []string{"SERVING", "NOT_SERVING", "SERVING"}
+			stream := &grpcwebmock.StreamMock{
+				SendMsgFunc:   func(m any) error { return nil },
+				CloseSendFunc: func() error { return nil },
+				RecvMsgFunc: func(m any) error {
+					if len(statuses) == 0 {
+						if c.recvErr != nil {
+							return c.recvErr
+						}
+						return io.EOF
+					}
+					s := statuses[0]
+					statuses = statuses[1:]
+					return protojson.Unmarshal([]byte(`{"status": "`+s+`"}`), m.(proto.Message))
+				},
+			}
+			cc := healthConn(t)
+			cc.NewStreamFunc = func(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpcweb.CallOption) (grpc.ClientStream, error) {
+				if !desc.ServerStreams || desc.ClientStreams {
+					t.Errorf("expected a server stream, but got %+v", desc)
+				}
+				return stream, nil
+			}
+
+			var w flushBuffer
+			err := StreamJSONLines(context.Background(), cc, NewFilesSource(protoregistry.GlobalFiles), c.method, []byte(`{}`), &w)
+			if code := status.Code(err); code != c.expectedCode {
+				t.Fatalf("expected code %s, but got '%v'", c.expectedCode, err)
+			}
+			if len(w.lines) != c.expectedLines {
+				t.Fatalf("expected %d flushes, but got %d", c.expectedLines, len(w.lines))
+			}
+			lines := strings.Split(strings.TrimSuffix(w.String(), "\n"), "\n")
+			if c.expectedLines == 0 {
+				return
+			}
+			if len(lines) != c.expectedLines {
+				t.Fatalf("expected %d lines, but got %q", c.expectedLines, w.String())
+			}
+			if !equalJSON(t, `{"status": "SERVING"}`, []byte(lines[0])) {
+				t.Errorf("expected a serving status, but got %s", lines[0])
+			}
+			if len(stream.SendMsgCalls()) != len(stream.CloseSendCalls()) {
+				t.Errorf("expected CloseSend to be called after SendMsg")
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package dynamic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/errs"
+)
+
+// StreamJSONLines calls the server streaming method with the request given in
+// JSON, and writes each response message to w as one line of JSON, in the
+// JSON Lines format. w is flushed after every message if it has a Flush
+// method, like *bufio.Writer and http.Flusher, so that the messages can be
+// piped to tools like jq as they arrive. Unary methods write a single line.
+// It returns once the stream ends, nil if it ends successfully.
+func StreamJSONLines(ctx context.Context, cc grpcweb.ClientConnInterface, src Source, method string, request []byte, w io.Writer, opts ...grpcweb.CallOption) error {
+	md, err := src.FindMethod(ctx, method)
+	if err != nil {
+		return err
+	}
+	if md.IsStreamingClient() {
+		return fmt.Errorf("%w: %s is a client streaming method", ErrStreamingMethod, md.FullName())
+	}
+
+	if !md.IsStreamingServer() {
+		res, err := InvokeJSON(ctx, cc, src, method, request, opts...)
+		if err != nil {
+			return err
+		}
+		return writeLine(w, res)
+	}
+
+	types := methodTypes(md)
+	req, err := parseRequest(md, types, request)
+	if err != nil {
+		return err
+	}
+	stream, err := cc.NewStream(ctx, &grpc.StreamDesc{StreamName: string(md.Name()), ServerStreams: true}, fullMethod(md), opts...)
+	if err != nil {
+		return err
+	}
+	if err := stream.SendMsg(req); err != nil {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+
+	marshal := protojson.MarshalOptions{Resolver: types}
+	for {
+		res := dynamicpb.NewMessage(md.Output())
+		if err := stream.RecvMsg(res); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		b, err := marshal.Marshal(res)
+		if err != nil {
+			return errs.Wrap(err, "failed to marshal a response")
+		}
+		if err := writeLine(w, b); err != nil {
+			return err
+		}
+	}
+}
+
+// writeLine writes the JSON b and a newline to w, and flushes it.
+func writeLine(w io.Writer, b []byte) error {
+	if _, err := w.Write(append(b, '\n')); err != nil {
+		return errs.Wrap(err, "failed to write a response")
+	}
+	switch f := w.(type) {
+	case interface{ Flush() error }:
+		if err := f.Flush(); err != nil {
+			return errs.Wrap(err, "failed to flush a response")
+		}
+	case http.Flusher:
+		f.Flush()
+	}
+	return nil
+}
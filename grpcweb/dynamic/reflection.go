@@ -0,0 +1,145 @@
+package dynamic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	pb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/grpcweb_reflection_v1alpha"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/errs"
+)
+
+// NewReflectionSource returns a Source fetching the descriptors of the
+// services from the server reflection service of the server cc is connected
+// to. The descriptors are fetched once per service and cached.
+func NewReflectionSource(cc *grpcweb.ClientConn) Source {
+	return newReflectionSource(grpcweb_reflection_v1alpha.NewServerReflectionClient(cc))
+}
+
+func newReflectionSource(client pb.ServerReflectionClient) *reflectionSource {
+	return &reflectionSource{client: client, files: new(protoregistry.Files)}
+}
+
+type reflectionSource struct {
+	client pb.ServerReflectionClient
+
+	mu    sync.Mutex
+	files *protoregistry.Files
+}
+
+func (s *reflectionSource) FindMethod(ctx context.Context, method string) (protoreflect.MethodDescriptor, error) {
+	service, _, err := splitMethod(method)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.files.FindDescriptorByName(service); err == nil {
+		return findMethod(s.files, method)
+	}
+	if err := s.fetch(ctx, service); err != nil {
+		return nil, err
+	}
+	return findMethod(s.files, method)
+}
+
+// fetch registers the file defining service and its dependencies.
+func (s *reflectionSource) fetch(ctx context.Context, service protoreflect.FullName) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := s.client.ServerReflectionInfo(ctx)
+	if err != nil {
+		return errs.Wrap(err, "failed to open the reflection stream")
+	}
+	defer stream.CloseSend()
+
+	protos := make(map[string]*descriptorpb.FileDescriptorProto)
+	name, err := s.request(stream, &pb.ServerReflectionRequest{
+		MessageRequest: &pb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: string(service)},
+	}, protos)
+	if err != nil {
+		var notFound *reflectionError
+		if errors.As(err, &notFound) {
+			return fmt.Errorf("%w: %s: %w", ErrMethodNotFound, service, err)
+		}
+		return err
+	}
+
+	// Fetch the dependencies the server didn't send along.
+	pending := []string{name}
+	for len(pending) > 0 {
+		fdp := protos[pending[0]]
+		pending = pending[1:]
+		for _, dep := range fdp.GetDependency() {
+			if _, ok := protos[dep]; ok {
+				continue
+			}
+			if _, err := s.files.FindFileByPath(dep); err == nil {
+				continue
+			}
+			if _, err := protoregistry.GlobalFiles.FindFileByPath(dep); err == nil {
+				continue
+			}
+			if _, err := s.request(stream, &pb.ServerReflectionRequest{
+				MessageRequest: &pb.ServerReflectionRequest_FileByFilename{FileByFilename: dep},
+			}, protos); err != nil {
+				return err
+			}
+			if _, ok := protos[dep]; !ok {
+				return errs.Errorf("the reflection service didn't return the file %q", dep)
+			}
+			pending = append(pending, dep)
+		}
+	}
+	return registerFile(s.files, protos, name)
+}
+
+// reflectionError is an error returned by the reflection service.
+type reflectionError struct {
+	code int32
+	msg  string
+}
+
+func (e *reflectionError) Error() string {
+	return fmt.Sprintf("reflection error %d: %s", e.code, e.msg)
+}
+
+// request sends req and adds the files of the response to protos. It returns
+// the name of the first file, the one req asked for.
+func (s *reflectionSource) request(stream pb.ServerReflection_ServerReflectionInfoClient, req *pb.ServerReflectionRequest, protos map[string]*descriptorpb.FileDescriptorProto) (string, error) {
+	if err := stream.Send(req); err != nil {
+		return "", errs.Wrap(err, "failed to send the reflection request")
+	}
+	res, err := stream.Recv()
+	if err != nil {
+		return "", errs.Wrap(err, "failed to receive the reflection response")
+	}
+	if e := res.GetErrorResponse(); e != nil {
+		return "", &reflectionError{code: e.GetErrorCode(), msg: e.GetErrorMessage()}
+	}
+
+	var first string
+	for i, b := range res.GetFileDescriptorResponse().GetFileDescriptorProto() {
+		fdp := new(descriptorpb.FileDescriptorProto)
+		if err := proto.Unmarshal(b, fdp); err != nil {
+			return "", errs.Wrap(err, "failed to unmarshal a file descriptor")
+		}
+		if i == 0 {
+			first = fdp.GetName()
+		}
+		protos[fdp.GetName()] = fdp
+	}
+	if first == "" {
+		return "", errs.New("the reflection service returned no file")
+	}
+	return first, nil
+}
@@ -0,0 +1,19 @@
+package grpcweb
+
+import (
+	"context"
+
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// InvokeEmptyRequest calls a unary method taking google.protobuf.Empty, such
+// as a parameterless query, and stores its response in reply.
+func (c *ClientConn) InvokeEmptyRequest(ctx context.Context, method string, reply any, opts ...CallOption) error {
+	return c.Invoke(ctx, method, &emptypb.Empty{}, reply, opts...)
+}
+
+// InvokeEmptyResponse calls a unary method returning google.protobuf.Empty,
+// such as a command, with args.
+func (c *ClientConn) InvokeEmptyResponse(ctx context.Context, method string, args any, opts ...CallOption) error {
+	return c.Invoke(ctx, method, args, &emptypb.Empty{}, opts...)
+}
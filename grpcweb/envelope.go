@@ -0,0 +1,69 @@
+package grpcweb
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/errs"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/transport"
+)
+
+// Envelope wraps the framed bodies of the HTTP requests in a gateway-specific
+// envelope, such as a multipart or SOAP-style one, and unwraps the framed
+// bodies of the responses. The framing itself is left untouched.
+type Envelope interface {
+	// Wrap returns the request body of method wrapping the framed body, and
+	// its content type. contentType is the grpc-web content type of the
+	// framed body, and h the request header, which Wrap may alter.
+	Wrap(method, contentType string, h http.Header, body io.Reader) (string, io.Reader, error)
+	// Unwrap returns the framed body wrapped in the response body of method.
+	// h is the response header, which Unwrap may alter, e.g. to restore the
+	// grpc-web content type. Unwrap is responsible for closing body once the
+	// returned body is closed.
+	Unwrap(method string, h http.Header, body io.ReadCloser) (io.ReadCloser, error)
+}
+
+// WithEnvelope wraps the bodies of the unary calls and server streams in the
+// envelope e. The envelope is the closest to the wire: the transport wrappers,
+// the HAR recorder and the diagnostics see the unwrapped frames. Streams
+// carried by websockets are not enveloped. A failing envelope fails the call
+// with codes.Internal.
+func WithEnvelope(e Envelope) DialOption {
+	return func(opt *dialOptions) {
+		opt.envelope = e
+	}
+}
+
+// wrapEnvelope wraps tr in the envelope, if any.
+func (o *dialOptions) wrapEnvelope(method string, tr transport.UnaryTransport) transport.UnaryTransport {
+	if o.envelope == nil {
+		return tr
+	}
+	return &envelopeTransport{UnaryTransport: tr, method: method, envelope: o.envelope}
+}
+
+type envelopeTransport struct {
+	transport.UnaryTransport
+	method   string
+	envelope Envelope
+}
+
+func (t *envelopeTransport) Send(ctx context.Context, endpoint, contentType string, body io.Reader) (http.Header, io.ReadCloser, error) {
+	contentType, body, err := t.envelope.Wrap(t.method, contentType, t.Header(), body)
+	if err != nil {
+		return nil, nil, errs.WithCode(codes.Internal, err, "failed to wrap the request in the envelope")
+	}
+	h, rawBody, err := t.UnaryTransport.Send(ctx, endpoint, contentType, body)
+	if err != nil {
+		return h, rawBody, err
+	}
+	unwrapped, err := t.envelope.Unwrap(t.method, h, rawBody)
+	if err != nil {
+		rawBody.Close()
+		return nil, nil, errs.WithCode(codes.Internal, err, "failed to unwrap the response from the envelope")
+	}
+	return h, unwrapped, nil
+}
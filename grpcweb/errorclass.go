@@ -0,0 +1,110 @@
+package grpcweb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc/codes"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/errs"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/transport"
+)
+
+// ErrorClass is the class of a failure, beneath its gRPC status code. The
+// errors returned by Invoke, NewStream and RecvMsg match their class with
+// errors.Is, without string matching:
+//
+//	if errors.Is(err, grpcweb.ErrTransport) {
+//		// The server couldn't be reached.
+//	}
+//
+// The statuses returned by the server have no class.
+type ErrorClass struct {
+	name string
+}
+
+func (c *ErrorClass) Error() string {
+	return c.name
+}
+
+var (
+	// ErrTransport is the class of the failures to reach the server or to
+	// keep the connection to it, such as the DNS, TCP and TLS failures and
+	// the connections closed in the middle of a response.
+	ErrTransport = &ErrorClass{"transport failure"}
+	// ErrProtocol is the class of the responses violating the gRPC-Web
+	// protocol or the cardinality of the method, and of the messages which
+	// couldn't be compressed, transformed or enveloped.
+	ErrProtocol = &ErrorClass{"protocol violation"}
+	// ErrCanceled is the class of the calls canceled or timed out on the
+	// client side, including the inactivity and receive timeouts.
+	ErrCanceled = &ErrorClass{"canceled"}
+	// ErrGateway is the class of the responses which aren't gRPC-Web ones,
+	// typically sent by a gateway or proxy: the HTTP errors without gRPC
+	// status and the rejected websocket handshakes.
+	ErrGateway = &ErrorClass{"gateway failure"}
+)
+
+// classifiedError is an error with a class, which it matches with errors.Is.
+type classifiedError struct {
+	error
+	class *ErrorClass
+}
+
+func (e *classifiedError) Unwrap() error {
+	return e.error
+}
+
+func (e *classifiedError) Is(target error) bool {
+	return target == e.class
+}
+
+// classify attaches its class to err, if it has one. io.EOF and the
+// statuses returned by the server are returned as is.
+func classify(err error) error {
+	var ce *classifiedError
+	if err == nil || err == io.EOF || errors.As(err, &ce) {
+		return err
+	}
+	if c := classOf(err); c != nil {
+		return &classifiedError{error: err, class: c}
+	}
+	return err
+}
+
+func classOf(err error) *ErrorClass {
+	var (
+		httpErr  *transport.HTTPError
+		dialErr  *transport.DialError
+		netErr   net.Error
+		closeErr *websocket.CloseError
+	)
+	switch {
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, ErrRecvTimeout):
+		return ErrCanceled
+	case errors.As(err, &httpErr) || errors.Is(err, transport.ErrHandshakeRejected) ||
+		errors.Is(err, transport.ErrInvalidResponseCode):
+		return ErrGateway
+	case errors.As(err, &dialErr) || errors.As(err, &netErr) || errors.As(err, &closeErr) ||
+		errors.Is(err, transport.ErrConnectionReset) || errors.Is(err, transport.ErrDNSResolution) ||
+		errors.Is(err, transport.ErrTLSHandshake):
+		return ErrTransport
+	}
+	switch code, _ := errs.ExplicitCode(err); code {
+	case codes.DeadlineExceeded:
+		// The inactivity timeouts.
+		return ErrCanceled
+	case codes.Internal, codes.DataLoss:
+		return ErrProtocol
+	}
+	// A response cut short is a transport failure, unless the frame it cut
+	// was found malformed first.
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrTransport
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+// Package fault provides a transport wrapper injecting gateway misbehavior,
+// so that applications can test their resilience without a faulty proxy.
+//
+// Register an Injector with grpcweb.WithTransportWrapper:
+//
+//	inj := &fault.Injector{Latency: 100 * time.Millisecond, DropRate: 0.1}
+//	client, err := grpcweb.NewClient(host, grpcweb.WithTransportWrapper(inj))
+package fault
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/transport"
+)
+
+// Injector describes the faults to inject. The zero value injects nothing.
+// An Injector must not be modified once it is in use.
+type Injector struct {
+	// Latency delays every request and every response frame.
+	Latency time.Duration
+	// Jitter adds a random delay of up to the given duration to Latency.
+	Jitter time.Duration
+	// DropRate is the probability in [0, 1] of dropping a response message
+	// frame.
+	DropRate float64
+	// TruncateTrailers cuts the trailer frames in half, leaving the length
+	// prefix intact.
+	TruncateTrailers bool
+	// DisconnectAfter fails a response with transport.ErrConnectionReset
+	// after the given number of frames. Zero disables disconnects.
+	DisconnectAfter int
+	// Methods restricts the faults to the given full method names. All
+	// methods are affected if it is empty.
+	Methods []string
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func (i *Injector) applies(method string) bool {
+	if len(i.Methods) == 0 {
+		return true
+	}
+	for _, m := range i.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *Injector) float64() float64 {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.rand == nil {
+		i.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return i.rand.Float64()
+}
+
+// delay sleeps for the configured latency, or until ctx is done.
+func (i *Injector) delay(ctx context.Context) error {
+	d := i.Latency
+	if i.Jitter > 0 {
+		d += time.Duration(i.float64() * float64(i.Jitter))
+	}
+	if d <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// frame applies the faults to a response frame, the n-th one of the
+// response. It returns nil if the frame is dropped.
+func (i *Injector) frame(ctx context.Context, n int, f []byte) ([]byte, error) {
+	if i.DisconnectAfter > 0 && n > i.DisconnectAfter {
+		return nil, fmt.Errorf("%w: injected disconnect", transport.ErrConnectionReset)
+	}
+	if err := i.delay(ctx); err != nil {
+		return nil, err
+	}
+	if len(f) < 5 {
+		return f, nil
+	}
+
+	if f[0]&0x80 != 0 {
+		if i.TruncateTrailers {
+			return f[:5+(len(f)-5)/2], nil
+		}
+		return f, nil
+	}
+	if i.DropRate > 0 && i.float64() < i.DropRate {
+		return nil, nil
+	}
+	return f, nil
+}
+
+func (i *Injector) WrapUnary(method string, tr transport.UnaryTransport) transport.UnaryTransport {
+	if !i.applies(method) {
+		return tr
+	}
+	return &unaryTransport{UnaryTransport: tr, inj: i}
+}
+
+func (i *Injector) WrapClientStream(method string, tr transport.ClientStreamTransport) transport.ClientStreamTransport {
+	if !i.applies(method) {
+		return tr
+	}
+	return &clientStreamTransport{ClientStreamTransport: tr, inj: i}
+}
+
+type unaryTransport struct {
+	transport.UnaryTransport
+	inj *Injector
+}
+
+func (t *unaryTransport) Send(ctx context.Context, endpoint, contentType string, body io.Reader) (http.Header, io.ReadCloser, error) {
+	if err := t.inj.delay(ctx); err != nil {
+		return nil, nil, err
+	}
+	h, r, err := t.UnaryTransport.Send(ctx, endpoint, contentType, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, &frameReader{ctx: ctx, ReadCloser: r, inj: t.inj}, nil
+}
+
+// frameReader splits a response body into frames and applies the faults to
+// each of them.
+type frameReader struct {
+	ctx context.Context
+	io.ReadCloser
+	inj *Injector
+
+	n   int
+	buf bytes.Buffer
+	err error
+}
+
+func (r *frameReader) Read(p []byte) (int, error) {
+	for r.buf.Len() == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.next()
+	}
+	return r.buf.Read(p)
+}
+
+func (r *frameReader) next() error {
+	var h [5]byte
+	if _, err := io.ReadFull(r.ReadCloser, h[:]); err != nil {
+		return err
+	}
+	f := make([]byte, 5+binary.BigEndian.Uint32(h[1:]))
+	copy(f, h[:])
+	if _, err := io.ReadFull(r.ReadCloser, f[5:]); err != nil {
+		return err
+	}
+
+	r.n++
+	f, err := r.inj.frame(r.ctx, r.n, f)
+	if err != nil {
+		return err
+	}
+	r.buf.Write(f)
+	return nil
+}
+
+type clientStreamTransport struct {
+	transport.ClientStreamTransport
+	inj *Injector
+
+	n int
+}
+
+func (t *clientStreamTransport) Send(ctx context.Context, body io.Reader) error {
+	if err := t.inj.delay(ctx); err != nil {
+		return err
+	}
+	return t.ClientStreamTransport.Send(ctx, body)
+}
+
+func (t *clientStreamTransport) Receive(ctx context.Context) (io.ReadCloser, error) {
+	for {
+		r, err := t.ClientStreamTransport.Receive(ctx)
+		if err != nil {
+			return nil, err
+		}
+		b, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		t.n++
+		f, err := t.inj.frame(ctx, t.n, b)
+		if err != nil {
+			return nil, err
+		}
+		if f != nil {
+			return io.NopCloser(bytes.NewReader(f)), nil
+		}
+	}
+}
+
+func (t *unaryTransport) Unwrap() transport.UnaryTransport {
+	return t.UnaryTransport
+}
+
+func (t *clientStreamTransport) Unwrap() transport.ClientStreamTransport {
+	return t.ClientStreamTransport
+}
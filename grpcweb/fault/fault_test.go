@@ -0,0 +1,95 @@
+package fault_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/fault"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/transport"
+)
+
+var (
+	message1 = []byte{0x00, 0x00, 0x00, 0x00, 0x01, 'a'}
+	message2 = []byte{0x00, 0x00, 0x00, 0x00, 0x01, 'b'}
+	trailer  = append([]byte{0x80, 0x00, 0x00, 0x00, 0x10}, "grpc-status: 0\r\n"...)
+)
+
+type unaryTransport struct{}
+
+func (unaryTransport) Header() http.Header {
+	return make(http.Header)
+}
+
+func (unaryTransport) Send(context.Context, string, string, io.Reader) (http.Header, io.ReadCloser, error) {
+	body := bytes.Join([][]byte{message1, message2, trailer}, nil)
+	return make(http.Header), io.NopCloser(bytes.NewReader(body)), nil
+}
+
+func (unaryTransport) Close() error {
+	return nil
+}
+
+func TestInjector(t *testing.T) {
+	cases := map[string]struct {
+		inj          *fault.Injector
+		timeout      time.Duration
+		expectedBody []byte
+		expectedErr  error
+	}{
+		"no faults": {
+			inj:          &fault.Injector{},
+			expectedBody: bytes.Join([][]byte{message1, message2, trailer}, nil),
+		},
+		"dropped messages": {
+			inj:          &fault.Injector{DropRate: 1},
+			expectedBody: trailer,
+		},
+		"truncated trailers": {
+			inj:          &fault.Injector{TruncateTrailers: true},
+			expectedBody: bytes.Join([][]byte{message1, message2, trailer[:13]}, nil),
+		},
+		"disconnect": {
+			inj:          &fault.Injector{DisconnectAfter: 1},
+			expectedBody: message1,
+			expectedErr:  transport.ErrConnectionReset,
+		},
+		"latency": {
+			inj:         &fault.Injector{Latency: time.Second},
+			timeout:     10 * time.Millisecond,
+			expectedErr: context.DeadlineExceeded,
+		},
+		"other method": {
+			inj:          &fault.Injector{DropRate: 1, Methods: []string{"/service/Other"}},
+			expectedBody: bytes.Join([][]byte{message1, message2, trailer}, nil),
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if c.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, c.timeout)
+				defer cancel()
+			}
+
+			tr := c.inj.WrapUnary("/service/Method", unaryTransport{})
+			_, r, err := tr.Send(ctx, "/service/Method", "application/grpc-web+proto", bytes.NewReader(nil))
+			var body []byte
+			if err == nil {
+				body, err = io.ReadAll(r)
+			}
+			if !errors.Is(err, c.expectedErr) {
+				t.Fatalf("expected error '%v', but got '%v'", c.expectedErr, err)
+			}
+			if !bytes.Equal(body, c.expectedBody) {
+				t.Errorf("expected body %q, but got %q", c.expectedBody, body)
+			}
+		})
+	}
+}
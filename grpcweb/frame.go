@@ -0,0 +1,31 @@
+package grpcweb
+
+// FrameEvent describes a gRPC-Web frame sent or received by a call.
+type FrameEvent struct {
+	// Sent is true for the frames of the request, false for the ones of the
+	// response.
+	Sent bool
+	// Flag is the flag byte of the frame, such as 0x80 for a trailer frame.
+	Flag byte
+	// Length is the length of the payload of the frame.
+	Length uint32
+}
+
+// OnFrame makes the call notify f of every frame it sends or receives, before
+// the received ones are decoded, e.g. for integrity checks or frame-level
+// metrics. The heartbeats and the skipped frames are included. The frames are
+// the ones of the gRPC-Web protocol, before any envelope or padding is
+// applied. f is called from the goroutines sending and receiving the
+// messages.
+func OnFrame(f func(FrameEvent)) CallOption {
+	return newCallOption(func(opt *callOptions) {
+		opt.frameHandlers = append(opt.frameHandlers[:len(opt.frameHandlers):len(opt.frameHandlers)], f)
+	})
+}
+
+// frame notifies the frame handlers of the call of a frame.
+func (o *callOptions) frame(sent bool, flag byte, length uint32) {
+	for _, f := range o.frameHandlers {
+		f(FrameEvent{Sent: sent, Flag: flag, Length: length})
+	}
+}
@@ -0,0 +1,87 @@
+// Package grpccompat converts the grpc-go options most commonly shared across
+// a code base into their grpcweb counterparts, easing the migration of
+// clients standardized on grpc-go option sets.
+//
+// grpc.DialOption values are opaque, so the package mirrors the grpc-go
+// constructors instead, taking the same arguments:
+//
+//	conn, err := grpcweb.NewClient(host,
+//		grpccompat.WithTransportCredentials(insecure.NewCredentials()),
+//		grpccompat.WithUserAgent("my-app/1.0"),
+//		grpccompat.WithUnaryInterceptor(interceptor),
+//	)
+//
+// The grpc.CallOption values are grpcweb.CallOptions, they are passed to
+// grpcweb.WithDefaultCallOptions and to the calls as is.
+package grpccompat
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb"
+)
+
+// ErrTransportCredentials is returned by grpcweb.NewClient when it is passed
+// secure credentials with WithTransportCredentials.
+var ErrTransportCredentials = errors.New("grpccompat: the TLS configuration of transport credentials can't be read, use grpcweb.WithTLSConfig")
+
+// WithTransportCredentials maps insecure credentials to grpcweb.WithInsecure.
+// The TLS configuration of other credentials, their root CAs and client
+// certificates, can't be read back, so they make grpcweb.NewClient fail with
+// ErrTransportCredentials rather than connecting with a weaker
+// configuration. Use grpcweb.WithTLSConfig instead.
+func WithTransportCredentials(creds credentials.TransportCredentials) grpcweb.DialOption {
+	if creds.Info().SecurityProtocol == "insecure" {
+		return grpcweb.WithInsecure()
+	}
+	return grpcweb.WithDialError(ErrTransportCredentials)
+}
+
+// WithUserAgent is the counterpart of grpc.WithUserAgent.
+func WithUserAgent(s string) grpcweb.DialOption {
+	return grpcweb.WithUserAgent(s)
+}
+
+// WithUnaryInterceptor runs a grpc-go interceptor on the unary calls, see
+// UnaryClientInterceptor.
+func WithUnaryInterceptor(i grpc.UnaryClientInterceptor) grpcweb.DialOption {
+	return grpcweb.WithUnaryInterceptor(UnaryClientInterceptor(i))
+}
+
+// UnaryClientInterceptor adapts a grpc-go interceptor to grpcweb. The
+// interceptor is passed a *grpc.ClientConn standing for the
+// grpcweb.ClientConn of the call: it never connects, its Invoke and NewStream
+// methods go through the grpcweb.ClientConn.
+func UnaryClientInterceptor(i grpc.UnaryClientInterceptor) grpcweb.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpcweb.ClientConn, invoker grpcweb.UnaryInvoker, opts ...grpcweb.CallOption) error {
+		grpcConn, err := clientConn(cc)
+		if err != nil {
+			return err
+		}
+		defer grpcConn.Close()
+		grpcInvoker := func(ctx context.Context, method string, req, reply any, _ *grpc.ClientConn, opts ...grpc.CallOption) error {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		return i(ctx, method, req, reply, grpcConn, grpcInvoker, opts...)
+	}
+}
+
+// clientConn returns a *grpc.ClientConn whose calls and streams go through
+// cc. It stays idle, the interceptors run instead of its transport.
+func clientConn(cc *grpcweb.ClientConn) (*grpc.ClientConn, error) {
+	return grpc.NewClient(
+		"passthrough:///"+cc.Target(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(func(ctx context.Context, method string, req, reply any, _ *grpc.ClientConn, _ grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			return cc.Invoke(ctx, method, req, reply, opts...)
+		}),
+		grpc.WithStreamInterceptor(func(ctx context.Context, desc *grpc.StreamDesc, _ *grpc.ClientConn, method string, _ grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return cc.NewStream(ctx, desc, method, opts...)
+		}),
+	)
+}
@@ -0,0 +1,88 @@
+package grpccompat
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb"
+)
+
+func TestWithTransportCredentials(t *testing.T) {
+	cases := map[string]struct {
+		creds       credentials.TransportCredentials
+		expectedErr error
+	}{
+		"insecure": {creds: insecure.NewCredentials()},
+		"tls":      {creds: credentials.NewTLS(&tls.Config{}), expectedErr: ErrTransportCredentials},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := grpcweb.NewClient("localhost:50051", WithTransportCredentials(c.creds))
+			if !errors.Is(err, c.expectedErr) {
+				t.Errorf("expected NewClient to return '%v', but got '%v'", c.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.Header().Set("Content-Type", "application/grpc-web+proto")
+		trailer := []byte("grpc-status: 0\r\nx-end: yes\r\n")
+		w.Write([]byte{0, 0, 0, 0, 0})
+		w.Write(append([]byte{0x80, 0, 0, 0, byte(len(trailer))}, trailer...))
+	}))
+	defer srv.Close()
+
+	var (
+		target  string
+		trailer metadata.MD
+	)
+	interceptor := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if method != "/service/Method" {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		// The connection passed to the interceptor calls through grpcweb.
+		target = cc.Target()
+		if err := cc.Invoke(ctx, "/service/Side", req, reply); err != nil {
+			return err
+		}
+		return invoker(ctx, method+"2", req, reply, cc, append(opts, grpc.Trailer(&trailer))...)
+	}
+	host := strings.TrimPrefix(srv.URL, "http://")
+	client, err := grpcweb.NewClient(host, grpcweb.WithInsecure(), WithUnaryInterceptor(interceptor))
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+	var header metadata.MD
+	if err := client.Invoke(context.Background(), "/service/Method", &emptypb.Empty{}, &emptypb.Empty{}, grpc.Header(&header)); err != nil {
+		t.Fatalf("Invoke should not return an error, but got '%s'", err)
+	}
+	if diff := cmp.Diff([]string{"/service/Side", "/service/Method2"}, paths); diff != "" {
+		t.Errorf("-want, +got\n%s", diff)
+	}
+	if target != "passthrough:///"+host {
+		t.Errorf("expected the target of the connection to be the one of the client, but got '%s'", target)
+	}
+	if header == nil {
+		t.Errorf("expected the call options of the call to reach the invoker")
+	}
+	if v := trailer.Get("x-end"); len(v) != 1 || v[0] != "yes" {
+		t.Errorf("expected the call options added by the interceptor to reach the invoker, but got %v", trailer)
+	}
+}
@@ -3,62 +3,235 @@ package grpcweb
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 
-	"github.com/pkg/errors"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/encoding"
 	"google.golang.org/grpc/mem"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
-	"github.com/heartandu/grpc-web-go-client/grpcweb/parser"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/har"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/errs"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/ratelimit"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/statusresolver"
 	"github.com/heartandu/grpc-web-go-client/grpcweb/transport"
 )
 
 var (
 	ErrInsecureWithTLS      = errors.New("insecure and tls configuration couldn't be set simultaniously")
 	ErrNotAStreamingRequest = errors.New("not a streaming request")
+	ErrInvalidTarget        = errors.New("invalid target")
 )
 
+// ClientConn is a client of a gRPC-Web server. It is safe for concurrent use
+// by multiple goroutines, and so are the calls and streams it creates, within
+// the limits documented by Stream.
 type ClientConn struct {
 	host        string
 	dialOptions *dialOptions
+	// addrs are the resolved addresses of the target, if a resolver is set.
+	addrs *addressSet
+	// readiness tracks whether the server is reachable, see WaitForReady.
+	readiness readiness
+	// uploadLimiter and downloadLimiter throttle the transfers, see
+	// WithUploadRateLimit and WithDownloadRateLimit.
+	uploadLimiter, downloadLimiter *ratelimit.Limiter
+	// concurrency limits the unary calls in flight, see
+	// WithAdaptiveConcurrency.
+	concurrency *concurrencyLimiter
+
+	streams streamRegistry
+	traffic trafficRegistry
 }
 
+// ClientConnInterface is the subset of ClientConn the calls and streams are
+// made with. Code depending on it instead of ClientConn can be unit tested
+// with the mocks of package grpcwebmock. It has the method set of
+// grpc.ClientConnInterface, so that the clients generated by
+// protoc-gen-go-grpc take a ClientConn as is:
+//
+//	client := pb.NewExampleClient(conn)
+type ClientConnInterface interface {
+	// Invoke performs a unary call of method.
+	Invoke(ctx context.Context, method string, args, reply any, opts ...CallOption) error
+	// NewStream opens a stream of method. The streams of a ClientConn are
+	// Streams.
+	NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...CallOption) (grpc.ClientStream, error)
+}
+
+var (
+	_ ClientConnInterface      = (*ClientConn)(nil)
+	_ grpc.ClientConnInterface = (*ClientConn)(nil)
+)
+
 func NewClient(host string, opts ...DialOption) (*ClientConn, error) {
+	return DialContext(context.Background(), host, opts...)
+}
+
+// DialContext is NewClient, with ctx bounding the eager connection set up
+// with WithBlock.
+func DialContext(ctx context.Context, host string, opts ...DialOption) (*ClientConn, error) {
 	opt := defaultDialOptions
 	for _, o := range opts {
 		o(&opt)
 	}
 
+	if opt.err != nil {
+		return nil, opt.err
+	}
 	if opt.insecure && opt.tlsConf != nil {
 		return nil, ErrInsecureWithTLS
 	}
+	opt.chainInterceptors()
+	if opt.methodPolicy != nil {
+		if err := opt.methodPolicy.validate(); err != nil {
+			return nil, err
+		}
+	}
 
-	return &ClientConn{
-		host:        host,
-		dialOptions: &opt,
-	}, nil
+	target, err := parseTarget(host, opt.insecure)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &ClientConn{
+		host:            target,
+		dialOptions:     &opt,
+		uploadLimiter:   opt.newLimiter(opt.uploadRate),
+		downloadLimiter: opt.newLimiter(opt.downloadRate),
+		concurrency:     newConcurrencyLimiter(opt.adaptiveConcurrency, opt.clock),
+		streams:         streamRegistry{clock: opt.clock},
+	}
+	if opt.resolver != nil {
+		c.addrs = newAddressSet(target, &opt)
+		if !opt.insecure {
+			opt.tlsConf = resolverTLSConfig(opt.tlsConf, c.addrs.host)
+		}
+	}
+	if opt.block {
+		if err := c.connect(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Target returns the normalized target of the ClientConn, with an explicit
+// port.
+func (c *ClientConn) Target() string {
+	return c.host
+}
+
+// parseTarget validates a target of the form [userinfo@]host[:port][/path]
+// and returns it with an explicit port. IPv6 literals may be given with or
+// without brackets when the port is omitted. The default port is 443, or 80
+// for insecure connections.
+func parseTarget(target string, insecure bool) (string, error) {
+	if strings.Contains(target, "://") {
+		return "", fmt.Errorf("%w %q: the scheme is chosen by the security options and must be omitted", ErrInvalidTarget, target)
+	}
+
+	var userinfo, path string
+	if i := strings.LastIndex(target, "@"); i != -1 {
+		userinfo, target = target[:i+1], target[i+1:]
+	}
+	if i := strings.Index(target, "/"); i != -1 {
+		target, path = target[:i], target[i:]
+	}
+
+	hostname, port, err := net.SplitHostPort(target)
+	if err != nil {
+		// The port is missing or an IPv6 literal is given without one.
+		hostname, port = strings.TrimSuffix(strings.TrimPrefix(target, "["), "]"), "443"
+		if insecure {
+			port = "80"
+		}
+		bracketed := strings.HasPrefix(target, "[")
+		if hostname == "" || bracketed != strings.HasSuffix(target, "]") || strings.ContainsAny(hostname, "[]") {
+			return "", fmt.Errorf("%w %q: %s", ErrInvalidTarget, target, err)
+		}
+		if strings.Contains(hostname, ":") && net.ParseIP(hostname) == nil {
+			return "", fmt.Errorf("%w %q: malformed IPv6 address", ErrInvalidTarget, target)
+		}
+	}
+	if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+		return "", fmt.Errorf("%w %q: invalid port %q", ErrInvalidTarget, target, port)
+	}
+
+	normalized := userinfo + net.JoinHostPort(hostname, port) + path
+	if _, err := url.Parse("http://" + normalized); err != nil {
+		return "", fmt.Errorf("%w %q: %s", ErrInvalidTarget, target, err)
+	}
+	return normalized, nil
 }
 
 func (c *ClientConn) Invoke(ctx context.Context, method string, args, reply interface{}, opts ...CallOption) error {
+	ctx = c.dialOptions.newCallContext(ctx, method, nil)
+	if c.dialOptions.unaryInterceptor != nil {
+		return c.dialOptions.unaryInterceptor(ctx, method, args, reply, c, invoke, opts...)
+	}
+	return c.invokeWithRetry(ctx, method, args, reply, opts...)
+}
+
+func (c *ClientConn) invoke(ctx context.Context, method string, args, reply interface{}, opts ...CallOption) (err error) {
+	defer func() { err = classify(c.dialOptions.truncateStatus(method, err)) }()
+	if err := c.dialOptions.checkMethod(method); err != nil {
+		return err
+	}
+
 	callOptions := c.applyCallOptions(opts)
 	codec := callOptions.codec
 
-	tr, err := transport.NewUnary(c.host, c.connectOptions()...)
+	// The calls held back by the budget don't count towards the concurrency
+	// limit.
+	if err := c.dialOptions.waitRateLimit(ctx); err != nil {
+		return err
+	}
+	release, err := c.concurrency.acquire(ctx, callOptions.priority)
 	if err != nil {
-		return errors.Wrap(err, "failed to create a new unary transport")
+		return err
 	}
+	defer func() { release(err) }()
+
+	ctx, cancel := c.dialOptions.propagate(ctx)
+	defer cancel()
+
+	ctx, rpcStats := c.dialOptions.beginRPC(ctx, method, false, false)
+	defer func() { rpcStats.end(err) }()
+
+	c.startCapture(method, false, callOptions)
+	tr, use, err := c.newUnaryTransport(ctx, method, callOptions)
+	if err != nil {
+		err = errs.Wrap(err, "failed to create a new unary transport")
+		callOptions.capture.End(err)
+		return err
+	}
+	defer func() { use.end(err) }()
+	tr = c.dialOptions.wrapEnvelope(method, tr)
+	tr = c.dialOptions.wrapPadding(method, tr)
+	tr = c.dialOptions.wrapChunking(tr)
+	if callOptions.capture != nil {
+		tr = har.WrapUnary(tr, callOptions.capture)
+	}
+	tr = c.wrapUnary(method, tr)
 	defer tr.Close()
 
-	r, err := encodeRequestBody(codec, args)
+	if err := c.dialOptions.validate(args, false); err != nil {
+		return err
+	}
+	r, err := encodeRequestBody(c.dialOptions, callOptions, method, args)
 	if err != nil {
-		return errors.Wrap(err, "failed to build the request body")
+		return errs.Wrap(err, "failed to build the request body")
 	}
 
 	md, ok := metadata.FromOutgoingContext(ctx)
@@ -69,20 +242,44 @@ func (c *ClientConn) Invoke(ctx context.Context, method string, args, reply inte
 			}
 		}
 	}
+	c.dialOptions.setRequestHeader(ctx, tr.Header())
+	c.dialOptions.setIdempotencyKey(callOptions, tr.Header())
+	callOptions.setCompressionHeader(tr.Header())
 
+	rpcStats.outHeader(method, md)
 	contentType := "application/grpc-web+" + codec.Name()
+	wireLength := r.Len()
 	header, rawBody, err := tr.Send(ctx, method, contentType, r)
+	if h, ok := c.dialOptions.noContent(err); ok {
+		header, rawBody = emptyResponse(h, contentType)
+		err = nil
+	}
 	if err != nil {
-		if errors.Is(err, transport.ErrInvalidResponseCode) {
-			return status.New(codes.Unavailable, err.Error()).Err()
-		}
-
-		return errors.Wrap(err, "failed to send the request")
+		return errs.Wrap(err, "failed to send the request")
 	}
 	defer rawBody.Close()
+	rawBody = c.dialOptions.reorderFrames(method, rawBody)
+	c.dialOptions.affinity.learn(header)
+	c.dialOptions.learnRateLimit(header, callOptions)
+	rpcStats.outPayload(args, wireLength)
+	if res := callOptions.result; res != nil {
+		res.Attempts = AttemptFromContext(ctx)
+		res.RequestSize = wireLength - 5
+	}
 
-	md = toMetadata(header)
-	if err := checkStatus(md).Err(); err != nil {
+	raw := toMetadata(header)
+	md = withoutStatus(raw)
+	rpcStats.inHeader(md)
+	callOptions.attempt.record(raw)
+	if callOptions.rawHeader != nil {
+		*callOptions.rawHeader = raw
+	}
+	res := statusresolver.New()
+	res.OnHeaders(raw)
+	if st := res.HeaderStatus(); st != nil && st.Code() != codes.OK {
+		return res.OnTrailersOnly().Err()
+	}
+	if err := c.dialOptions.checkContentType(method, header); err != nil {
 		return err
 	}
 
@@ -90,33 +287,67 @@ func (c *ClientConn) Invoke(ctx context.Context, method string, args, reply inte
 		*callOptions.header = md
 	}
 
-	resHeader, err := parser.ParseResponseHeader(rawBody)
+	resHeader, err := c.dialOptions.readFrameHeader(method, rawBody, callOptions)
 	if err != nil {
-		return errors.Wrap(err, "failed to parse response header")
+		return errs.Wrap(err, "failed to parse response header")
 	}
 
 	if resHeader.IsMessageHeader() {
-		resBody, err := parser.ParseLengthPrefixedMessage(rawBody, resHeader.ContentLength)
+		if err := checkMessageSize(c.dialOptions.maxBufferSize, resHeader.ContentLength); err != nil {
+			return err
+		}
+		if res := callOptions.result; res != nil {
+			res.ResponseSize = int(resHeader.ContentLength)
+		}
+		resBody, err := c.dialOptions.frameParser.ParseLengthPrefixedMessage(rawBody, resHeader.ContentLength)
 		if err != nil {
-			return errors.Wrap(err, "failed to parse the response body")
+			return errs.Wrap(err, "failed to parse the response body")
+		}
+		resBody, err = decompressMessage(resHeader, md, resBody, c.dialOptions.maxBufferSize)
+		if err != nil {
+			return err
+		}
+		if resBody, err = c.dialOptions.transformResponse(method, resBody); err != nil {
+			return err
 		}
 		if err := codec.Unmarshal([]mem.Buffer{mem.NewBuffer(&resBody, nil)}, reply); err != nil {
-			return errors.Wrapf(err, "failed to unmarshal response body by codec %s", codec.Name())
+			return errs.Wrapf(err, "failed to unmarshal response body by codec %s", codec.Name())
+		}
+		if err := c.dialOptions.validate(reply, true); err != nil {
+			return err
+		}
+		rpcStats.inPayload(reply, len(resBody))
+		if err := callOptions.verify(reply); err != nil {
+			return err
+		}
+		if err := res.OnMessage(); err != nil {
+			return err
 		}
 
-		resHeader, err = parser.ParseResponseHeader(rawBody)
+		resHeader, err = c.dialOptions.readFrameHeader(method, rawBody, callOptions)
+		if errors.Is(err, io.EOF) {
+			return c.dialOptions.resolveEnd(method, res)
+		}
 		if err != nil {
-			return errors.Wrap(err, "failed to parse response header")
+			return errs.Wrap(err, "failed to parse response header")
+		}
+		if resHeader.IsMessageHeader() {
+			return c.dialOptions.extraMessages(method, rawBody, resHeader, callOptions)
 		}
 	}
 	if !resHeader.IsTrailerHeader() {
-		return errors.New("unexpected header")
+		return errs.WithCode(codes.Internal, nil, "unexpected header")
 	}
 
-	status, trailer, err := parser.ParseStatusAndTrailer(rawBody, resHeader.ContentLength)
+	status, trailer, err := parseStatusAndTrailer(c.dialOptions, rawBody, resHeader.ContentLength)
 	if err != nil {
-		return errors.Wrap(err, "failed to parse status and trailer")
+		return errs.Wrap(err, "failed to parse status and trailer")
 	}
+	if err := res.OnTrailer(status, trailer); err != nil {
+		return err
+	}
+	rpcStats.inTrailer(trailer, int(resHeader.ContentLength))
+	callOptions.attempt.record(trailer)
 	if callOptions.trailer != nil {
 		*callOptions.trailer = trailer
 	}
@@ -124,17 +355,53 @@ func (c *ClientConn) Invoke(ctx context.Context, method string, args, reply inte
 	return status.Err()
 }
 
+// NewStream opens a stream of method. The streams are Streams, and the
+// bidirectional ones BidiStreams.
 func (c *ClientConn) NewStream(
 	ctx context.Context,
 	desc *grpc.StreamDesc,
 	method string,
 	opts ...CallOption,
-) (Stream, error) {
+) (grpc.ClientStream, error) {
+	return c.newInterceptedStream(ctx, desc, method, opts...)
+}
+
+// newInterceptedStream opens a stream of method like NewStream, returning a
+// Stream.
+func (c *ClientConn) newInterceptedStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...CallOption) (s Stream, err error) {
+	defer func() { err = classify(err) }()
+	ctx = c.dialOptions.newCallContext(ctx, method, desc)
+	if c.dialOptions.streamInterceptor != nil {
+		return c.dialOptions.streamInterceptor(ctx, desc, c, method, newStream, opts...)
+	}
+	return c.openStream(ctx, desc, method, opts...)
+}
+
+// openStream opens a stream of method, once intercepted.
+func (c *ClientConn) openStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...CallOption) (Stream, error) {
+	if err := c.dialOptions.checkMethod(method); err != nil {
+		return nil, err
+	}
+
+	stream, err := c.newStream(ctx, desc, method, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if d := c.applyCallOptions(opts).recvTimeout; d > 0 {
+		if _, ok := stream.(BidiStream); ok {
+			return recvTimeoutBidiStream{newRecvTimeoutStream(stream, d, c.dialOptions.clock)}, nil
+		}
+		return newRecvTimeoutStream(stream, d, c.dialOptions.clock), nil
+	}
+	return stream, nil
+}
+
+func (c *ClientConn) newStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...CallOption) (Stream, error) {
 	switch {
 	case desc.ClientStreams && desc.ServerStreams:
 		return c.newBidiStream(ctx, method, opts...)
 	case desc.ClientStreams:
-		return c.newClientStream(ctx, method, opts...)
+		return c.newClientStream(ctx, method, false, opts...)
 	case desc.ServerStreams:
 		return c.newServerStream(ctx, method, opts...)
 	default:
@@ -142,90 +409,296 @@ func (c *ClientConn) NewStream(
 	}
 }
 
-func (c *ClientConn) newClientStream(ctx context.Context, method string, opts ...CallOption) (Stream, error) {
-	tr, err := transport.NewClientStream(c.host, method, c.connectOptions()...)
+func (c *ClientConn) newClientStream(ctx context.Context, method string, serverStreams bool, opts ...CallOption) (*clientStream, error) {
+	callOptions := c.applyCallOptions(opts)
+	ctx, cancel := c.dialOptions.propagate(ctx)
+	ctx, rpcStats := c.dialOptions.beginRPC(ctx, method, true, serverStreams)
+	ctx, inactivity := newInactivityTimer(ctx, callOptions.inactivityTimeout, c.dialOptions.clock)
+	c.startCapture(method, true, callOptions)
+	var (
+		tr  transport.ClientStreamTransport
+		use *addressUse
+	)
+	err := c.queue(ctx, callOptions.waitForReady, func() error {
+		target, u, err := c.pickTarget(ctx, callOptions)
+		if err != nil {
+			return err
+		}
+		tr, err = c.newStreamTransport(ctx, target, method, callOptions, u)
+		if err != nil {
+			c.connectionFailed(u, target, err)
+			u.end(err)
+			return err
+		}
+		use = u
+		return nil
+	})
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create a new transport stream")
+		err = errs.Wrap(err, "failed to create a new transport stream")
+		rpcStats.end(err)
+		callOptions.capture.End(err)
+		inactivity.stop()
+		cancel()
+		return nil, err
 	}
+	tr = c.dialOptions.wrapPaddingStream(method, tr)
+	tr = c.dialOptions.wrapChunkingStream(tr)
+	if callOptions.capture != nil {
+		tr = har.WrapClientStream(tr, callOptions.capture)
+	}
+	for _, w := range c.dialOptions.transportWrappers {
+		tr = w.WrapClientStream(method, tr)
+	}
+
+	// The websocket transport sends the request headers once, with the first
+	// message, so they are built once too.
+	md, _ := metadata.FromOutgoingContext(ctx)
+	h := make(http.Header)
+	for k, v := range md {
+		for _, vv := range v {
+			h.Add(k, vv)
+		}
+	}
+	c.dialOptions.setRequestHeader(ctx, h)
+	callOptions.setCompressionHeader(h)
 
 	return &clientStream{
-		ctx:         ctx,
-		endpoint:    method,
-		transport:   tr,
-		callOptions: c.applyCallOptions(opts),
+		ctx:           ctx,
+		endpoint:      method,
+		requestMD:     md,
+		requestHeader: h,
+		transport:     tr,
+		callOptions:   callOptions,
+		dialOptions:   c.dialOptions,
+		stats:         rpcStats,
+		inactivity:    inactivity,
+		release:       releaseFunc(c.streams.add(ctx, method), inactivity.stop, cancel, use.release),
+		res:           statusresolver.New(),
 	}, nil
 }
 
 func (c *ClientConn) newServerStream(ctx context.Context, method string, opts ...CallOption) (Stream, error) {
-	tr, err := transport.NewUnary(c.host, c.connectOptions()...)
+	callOptions := c.applyCallOptions(opts)
+	ctx, cancel := c.dialOptions.propagate(ctx)
+	ctx, rpcStats := c.dialOptions.beginRPC(ctx, method, false, true)
+	ctx, inactivity := newInactivityTimer(ctx, callOptions.inactivityTimeout, c.dialOptions.clock)
+	c.startCapture(method, false, callOptions)
+	tr, use, err := c.newUnaryTransport(ctx, method, callOptions)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create a new unary transport")
+		err = errs.Wrap(err, "failed to create a new unary transport")
+		rpcStats.end(err)
+		callOptions.capture.End(err)
+		inactivity.stop()
+		cancel()
+		return nil, err
 	}
+	tr = c.dialOptions.wrapEnvelope(method, tr)
+	tr = c.dialOptions.wrapPadding(method, tr)
+	tr = c.dialOptions.wrapChunking(tr)
+	if callOptions.capture != nil {
+		tr = har.WrapUnary(tr, callOptions.capture)
+	}
+	tr = c.wrapUnary(method, tr)
 
 	return &serverStream{
 		ctx:         ctx,
 		endpoint:    method,
 		transport:   tr,
-		callOptions: c.applyCallOptions(opts),
+		callOptions: callOptions,
+		dialOptions: c.dialOptions,
+		stats:       rpcStats,
+		inactivity:  inactivity,
+		release:     releaseFunc(c.streams.add(ctx, method), inactivity.stop, cancel, use.release),
+		res:         statusresolver.New(),
 	}, nil
 }
 
 func (c *ClientConn) newBidiStream(ctx context.Context, method string, opts ...CallOption) (Stream, error) {
-	stream, err := c.newClientStream(ctx, method, opts...)
+	stream, err := c.newClientStream(ctx, method, true, opts...)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create a new client stream")
+		return nil, errs.Wrap(err, "failed to create a new client stream")
 	}
 
 	return &bidiStream{
-		clientStream: stream.(*clientStream),
+		clientStream: stream,
 	}, nil
 }
 
+// releaseFunc returns a function calling release and then the cancel
+// functions.
+func releaseFunc(release func(), cancel ...context.CancelFunc) func() {
+	return func() {
+		release()
+		for _, f := range cancel {
+			f()
+		}
+	}
+}
+
 func (c *ClientConn) applyCallOptions(opts []CallOption) *callOptions {
 	callOpts := append(c.dialOptions.defaultCallOptions, opts...)
 	callOptions := defaultCallOptions
+	callOptions.compressionThreshold = c.dialOptions.compressionThreshold
 	for _, o := range callOpts {
-		o(&callOptions)
+		applyCallOption(o, &callOptions)
 	}
 	return &callOptions
 }
 
-func (c *ClientConn) connectOptions() []transport.ConnectOption {
-	connOpts := make([]transport.ConnectOption, 0)
+func (c *ClientConn) wrapUnary(method string, tr transport.UnaryTransport) transport.UnaryTransport {
+	for _, w := range c.dialOptions.transportWrappers {
+		tr = w.WrapUnary(method, tr)
+	}
+	return tr
+}
+
+// startCapture starts recording the call if a HAR recorder is set.
+func (c *ClientConn) startCapture(method string, websocket bool, callOptions *callOptions) {
+	if c.dialOptions.harRecorder != nil {
+		callOptions.capture = c.dialOptions.harRecorder.StartCall(method, websocket)
+		if c.dialOptions.redactor != nil {
+			callOptions.capture.SetRedactor(func(payload []byte) []byte {
+				return c.dialOptions.redactor(method, payload)
+			})
+		}
+	}
+}
+
+func (c *ClientConn) connectOptions(method string, callOptions *callOptions) []transport.ConnectOption {
+	connOpts := []transport.ConnectOption{transport.WithTrafficCounter(c.traffic.counter(method))}
 	if c.dialOptions.insecure {
 		connOpts = append(connOpts, transport.WithInsecure())
 	}
 
+	if c.dialOptions.clock != transport.SystemClock {
+		connOpts = append(connOpts, transport.WithClock(c.dialOptions.clock))
+	}
+
 	if c.dialOptions.tlsConf != nil {
 		connOpts = append(connOpts, transport.WithTLSConfig(c.dialOptions.tlsConf))
 	}
 
+	if c.dialOptions.maxBufferSize > 0 {
+		connOpts = append(connOpts, transport.WithMaxBufferSize(c.dialOptions.maxBufferSize))
+	}
+
+	if c.dialOptions.maxHeaderListSize > 0 || c.dialOptions.maxHeaderCount > 0 {
+		connOpts = append(connOpts, transport.WithHeaderLimits(c.dialOptions.maxHeaderListSize, c.dialOptions.maxHeaderCount))
+	}
+
+	if c.dialOptions.dialAttempts > 1 {
+		connOpts = append(connOpts, transport.WithDialRetry(c.dialOptions.dialAttempts, c.dialOptions.backoffConfig(c.dialOptions.dialBackoff)))
+	}
+
+	if p := c.dialOptions.connectParams; p != nil && p.MinConnectTimeout > 0 {
+		connOpts = append(connOpts, transport.WithMinConnectTimeout(p.MinConnectTimeout))
+	}
+
+	if listeners := c.dialOptions.eventListeners; len(listeners) > 0 {
+		connOpts = append(connOpts, transport.WithEventListener(func(e transport.Event) {
+			for _, l := range listeners {
+				l(e)
+			}
+		}))
+	}
+
+	keepalive := c.dialOptions.keepalive != nil && c.dialOptions.keepalive.Time > 0
+	if keepalive {
+		p := c.dialOptions.keepalive
+		connOpts = append(connOpts, transport.WithKeepalive(p.Time, p.Timeout))
+	}
+
+	if c.dialOptions.readBufferSize > 0 {
+		connOpts = append(connOpts, transport.WithReadBufferSize(c.dialOptions.readBufferSize))
+	}
+	if c.dialOptions.writeBufferSize > 0 {
+		connOpts = append(connOpts, transport.WithWriteBufferSize(c.dialOptions.writeBufferSize))
+	}
+	if c.dialOptions.wsReadLimit > 0 {
+		connOpts = append(connOpts, transport.WithReadLimit(c.dialOptions.wsReadLimit))
+	}
+
+	// The pongs of the keepalive pings are read along with the responses, the
+	// pump reads them even if the stream doesn't receive yet.
+	if c.dialOptions.receivePump || callOptions.receivePump || callOptions.inactivityTimeout > 0 || keepalive {
+		connOpts = append(connOpts, transport.WithReceivePump(c.dialOptions.receiveBuffer))
+	}
+
+	if c.dialOptions.lowercaseHeaders {
+		connOpts = append(connOpts, transport.WithLowercaseHeaders())
+	}
+	if c.dialOptions.contentLength {
+		connOpts = append(connOpts, transport.WithContentLength())
+	}
+	if c.dialOptions.requestTemplate != nil {
+		connOpts = append(connOpts, transport.WithRequestTemplate(c.dialOptions.requestTemplate))
+	}
+	if h := c.dialOptions.handshakeHeader(); len(h) > 0 {
+		connOpts = append(connOpts, transport.WithHandshakeHeader(h))
+	}
+	if f := c.dialOptions.throttle(c.uploadLimiter, callOptions.uploadRate); f != nil {
+		connOpts = append(connOpts, transport.WithUploadThrottle(f))
+	}
+	if f := c.dialOptions.throttle(c.downloadLimiter, callOptions.downloadRate); f != nil {
+		connOpts = append(connOpts, transport.WithDownloadThrottle(f))
+	}
+
+	if c.dialOptions.urlRewriter != nil || callOptions.stats != nil || callOptions.capture != nil {
+		connOpts = append(connOpts, transport.WithURLHook(func(u *url.URL) error {
+			if c.dialOptions.urlRewriter != nil {
+				if err := c.dialOptions.urlRewriter(method, u); err != nil {
+					return err
+				}
+			}
+			if callOptions.stats != nil {
+				callOptions.stats.URL = u.String()
+			}
+			if callOptions.capture != nil {
+				callOptions.capture.SetURL(u.String())
+			}
+			return nil
+		}))
+	}
+
 	return connOpts
 }
 
-func checkStatus(md metadata.MD) *status.Status {
-	var (
-		code codes.Code
-		msg  string
-	)
-
-	gs := md.Get("grpc-status")
-	gm := md.Get("grpc-message")
+// checkMessageSize returns an error wrapping transport.ErrBufferLimitExceeded
+// if a received message of the given length doesn't fit into the buffer limit.
+func checkMessageSize(limit int, length uint32) error {
+	if limit > 0 && int64(length) > int64(limit) {
+		return messageTooLarge(int64(length), limit)
+	}
+	return nil
+}
 
-	if len(gs) > 0 {
-		c, err := strconv.ParseUint(gs[0], 10, 32)
-		if err != nil {
-			return status.New(codes.Unknown, "unknown status code "+gs[0])
-		}
+func messageTooLarge(length int64, limit int) error {
+	return fmt.Errorf("%w: received message larger than max (%d vs. %d)", transport.ErrBufferLimitExceeded, length, limit)
+}
 
-		code = codes.Code(c)
+// parseStatusAndTrailer parses a trailer frame, enforcing the header limits.
+func parseStatusAndTrailer(opts *dialOptions, r io.Reader, length uint32) (*status.Status, metadata.MD, error) {
+	if opts.maxHeaderListSize > 0 && length > opts.maxHeaderListSize {
+		return nil, nil, fmt.Errorf("%w: trailer of %d bytes, the limit is %d",
+			transport.ErrHeaderLimitExceeded, length, opts.maxHeaderListSize)
 	}
 
-	if len(gm) > 0 {
-		msg = gm[0]
+	st, trailer, err := opts.frameParser.ParseStatusAndTrailer(r, length)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return status.New(code, msg)
+	if opts.maxHeaderCount > 0 {
+		var n int
+		for _, v := range trailer {
+			n += len(v)
+		}
+		if n > opts.maxHeaderCount {
+			return nil, nil, fmt.Errorf("%w: trailer of %d entries, the limit is %d",
+				transport.ErrHeaderLimitExceeded, n, opts.maxHeaderCount)
+		}
+	}
+	return st, trailer, nil
 }
 
 // copied from rpc_util.go#msgHeader
@@ -239,25 +712,108 @@ func header(bodyLen int) []byte {
 }
 
 // header (compressed-flag(1) + message-length(4)) + body
-// TODO: compressed message
-func encodeRequestBody(codec encoding.CodecV2, in interface{}) (io.Reader, error) {
-	body, err := codec.Marshal(in)
+func encodeRequestBody(d *dialOptions, o *callOptions, method string, in interface{}) (*bytes.Buffer, error) {
+	o.stamp(in)
+	body, err := o.codec.Marshal(in)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to marshal the request body")
+		return nil, errs.Wrap(err, "failed to marshal the request body")
+	}
+	if body, err = d.transformRequest(method, body); err != nil {
+		return nil, err
+	}
+	var buf *bytes.Buffer
+	if o.compressor != "" && body.Len() > o.compressionThreshold {
+		if buf, err = o.compressRequestBody(body); err != nil {
+			return nil, err
+		}
+	} else {
+		buf = bytes.NewBuffer(make([]byte, 0, headerLen+len(body)))
+		_, _ = buf.Write(header(body.Len()))
+		_, _ = buf.ReadFrom(body.Reader())
 	}
-	buf := bytes.NewBuffer(make([]byte, 0, headerLen+len(body)))
-	_, _ = buf.Write(header(body.Len()))
-	_, _ = buf.ReadFrom(body.Reader())
+	b := buf.Bytes()
+	o.frame(true, b[0], binary.BigEndian.Uint32(b[1:headerLen]))
 	return buf, nil
 }
 
+// toMetadata converts the header of a response to the metadata a grpc-go
+// client would see, see appendMetadata.
 func toMetadata(h http.Header) metadata.MD {
 	if len(h) == 0 {
 		return nil
 	}
 	md := metadata.New(nil)
+	appendMetadata(md, h)
+	return md
+}
+
+// statusHeaders are the headers carrying the status of a trailers-only
+// response, which grpc-go doesn't expose in the header metadata.
+var statusHeaders = []string{"grpc-status", "grpc-message", "grpc-status-details-bin"}
+
+// withoutStatus returns md without the status headers.
+func withoutStatus(md metadata.MD) metadata.MD {
+	if md == nil {
+		return nil
+	}
+	stripped, copied := md, false
+	for _, k := range statusHeaders {
+		if _, ok := md[k]; !ok {
+			continue
+		}
+		if !copied {
+			stripped, copied = md.Copy(), true
+		}
+		delete(stripped, k)
+	}
+	return stripped
+}
+
+// reservedHeaders are the response headers grpc-go doesn't expose as
+// metadata, along with the connection specific headers HTTP/2 forbids.
+var reservedHeaders = map[string]bool{
+	"content-type":      true,
+	"te":                true,
+	"trailer":           true,
+	"connection":        true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+}
+
+// appendMetadata appends h to md like grpc-go: keys are lowercased, repeated
+// keys keep all their values in order, reserved and pseudo-headers are
+// dropped, and the values of binary keys are base64 decoded, splitting the
+// values joined with commas by HTTP/1.1 proxies. Values which can't be
+// decoded are kept as is.
+func appendMetadata(md metadata.MD, h http.Header) {
 	for k, v := range h {
-		md.Append(k, v...)
+		k = strings.ToLower(k)
+		if reservedHeaders[k] || strings.HasPrefix(k, ":") {
+			continue
+		}
+		if !strings.HasSuffix(k, "-bin") {
+			md.Append(k, v...)
+			continue
+		}
+		for _, vv := range v {
+			for _, s := range strings.Split(vv, ",") {
+				md.Append(k, decodeBinHeader(strings.TrimSpace(s)))
+			}
+		}
 	}
-	return md
+}
+
+// decodeBinHeader decodes the value of a binary header, padded or not.
+func decodeBinHeader(v string) string {
+	enc := base64.RawStdEncoding
+	if len(v)%4 == 0 {
+		enc = base64.StdEncoding
+	}
+	b, err := enc.DecodeString(v)
+	if err != nil {
+		return v
+	}
+	return string(b)
 }
@@ -1,8 +1,6 @@
 package grpcweb_reflection_v1alpha
 
 import (
-	"errors"
-
 	context "golang.org/x/net/context"
 	grpc "google.golang.org/grpc"
 	pb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
@@ -26,14 +24,11 @@ func (c *serverReflectionClient) ServerReflectionInfo(
 	ctx context.Context,
 	opts ...grpc.CallOption,
 ) (pb.ServerReflection_ServerReflectionInfoClient, error) {
-	if len(opts) != 0 {
-		return nil, errors.New("currently, heartandu/grpc-web-go-client does not support grpc.CallOption")
-	}
-
 	stream, err := c.cc.NewStream(
 		ctx,
 		&grpc.StreamDesc{StreamName: "ServerReflectionInfo", ServerStreams: true, ClientStreams: true},
 		"/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo",
+		opts...,
 	)
 	if err != nil {
 		return nil, err
@@ -44,7 +39,7 @@ func (c *serverReflectionClient) ServerReflectionInfo(
 
 type serverReflectionServerReflectionInfoClient struct {
 	ctx    context.Context
-	stream grpcweb.Stream
+	stream grpc.ClientStream
 
 	// To satisfy pb.ServerReflection_ServerReflectionInfoClient
 	grpc.ClientStream
@@ -1,23 +1,51 @@
 package grpcweb
 
 import (
+	"bufio"
 	"bytes"
+	gz "compress/gzip"
 	"context"
+	"encoding/base64"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/gorilla/websocket"
 	"github.com/ktr0731/grpc-test/api"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding/gzip"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 
+	"github.com/heartandu/grpc-web-go-client/grpcweb/parser"
 	"github.com/heartandu/grpc-web-go-client/grpcweb/transport"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/transport/transporttest"
 )
 
 type unaryTransport struct {
@@ -101,6 +129,18 @@ func TestInvoke(t *testing.T) {
 			expectedStatus: status.New(codes.Internal, "internal error"),
 			wantErr:        true,
 		},
+		"error (multiple responses)": {
+			transportHeader:          header,
+			transportContentFileName: "multiple_response.in",
+			expectedHeader: metadata.New(map[string]string{
+				"hakase": "shinonome",
+				"nano":   "shinonome",
+			}),
+			expectedTrailer: metadata.MD{},
+			expectedContent: api.SimpleResponse{Message: "hello, ktr"},
+			expectedStatus:  status.New(codes.Internal, "received 2 response messages for a unary call, expected exactly one"),
+			wantErr:         true,
+		},
 		"error (empty body)": {
 			transportHeader: http.Header{
 				"Grpc-Status":  []string{"13"},
@@ -323,7 +363,7 @@ type clientStreamTransport struct {
 	tt             *testing.T
 	expectedHeader http.Header
 
-	sentCloseSend bool
+	sentCloseSend, sentHeader bool
 
 	h, t http.Header
 	r    []io.ReadCloser
@@ -333,6 +373,7 @@ type clientStreamTransport struct {
 }
 
 func (s *clientStreamTransport) SetRequestHeader(h http.Header) {
+	s.sentHeader = true
 	if diff := cmp.Diff(s.expectedHeader, h); diff != "" {
 		s.tt.Fatalf("-want, +got\n%s", diff)
 	}
@@ -669,11 +710,4281 @@ func TestBidiStream(t *testing.T) {
 }
 
 func injectClientStreamTransport(t *testing.T, tr transport.ClientStreamTransport) {
-	old := transport.NewClientStream
+	old := transport.NewClientStreamContext
 	t.Cleanup(func() {
-		transport.NewClientStream = old
+		transport.NewClientStreamContext = old
 	})
-	transport.NewClientStream = func(string, string, ...transport.ConnectOption) (transport.ClientStreamTransport, error) {
+	transport.NewClientStreamContext = func(context.Context, string, string, ...transport.ConnectOption) (transport.ClientStreamTransport, error) {
 		return tr, nil
 	}
 }
+
+func TestActiveStreams(t *testing.T) {
+	r, err := os.Open(filepath.Join("testdata", "server_stream_response.in"))
+	if err != nil {
+		t.Fatalf("Open should not return an error, but got '%s'", err)
+	}
+
+	md := metadata.Pairs("yuko", "aioi")
+	injectUnaryTransport(t, &unaryTransport{
+		t:          t,
+		expectedMD: md,
+		h:          make(http.Header),
+		r:          r,
+	})
+
+	clock := transporttest.NewClock(time.Now())
+	client, err := NewClient(":50051", WithClock(clock))
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+
+	ctx := metadata.NewOutgoingContext(context.Background(), md)
+	stm, err := client.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/service/Method")
+	if err != nil {
+		t.Fatalf("should not return an error, but got '%s'", err)
+	}
+
+	streams := client.ActiveStreams()
+	if len(streams) != 1 || streams[0].Method != "/service/Method" {
+		t.Fatalf("expected one active stream for /service/Method, but got %v", streams)
+	}
+	clock.Advance(time.Hour)
+	if age := streams[0].Age(); age != time.Hour {
+		t.Errorf("expected the age of the stream to follow the clock, but got %s", age)
+	}
+
+	if err := stm.SendMsg(&api.SimpleRequest{Name: "nano"}); err != nil {
+		t.Fatalf("Send should not return an error, but got '%s'", err)
+	}
+	for {
+		var res api.SimpleResponse
+		if err := stm.RecvMsg(&res); err != nil {
+			break
+		}
+	}
+
+	if n := client.NumActiveStreams(); n != 0 {
+		t.Errorf("expected no active streams after the stream finished, but got %d", n)
+	}
+}
+
+func TestStrictMode(t *testing.T) {
+	cases := map[string]struct {
+		opts         []DialOption
+		contentType  string
+		expectedCode codes.Code
+	}{
+		"lenient mode accepts a missing content-type": {
+			expectedCode: codes.OK,
+		},
+		"strict mode rejects a missing content-type": {
+			opts:         []DialOption{WithStrictMode()},
+			expectedCode: codes.Internal,
+		},
+		"strict mode accepts a gRPC-Web content-type": {
+			opts:         []DialOption{WithStrictMode()},
+			contentType:  "application/grpc-web+proto",
+			expectedCode: codes.OK,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			r, err := os.Open(filepath.Join("testdata", "response.in"))
+			if err != nil {
+				t.Fatalf("Open should not return an error, but got '%s'", err)
+			}
+
+			h := make(http.Header)
+			if c.contentType != "" {
+				h.Set("Content-Type", c.contentType)
+			}
+			md := metadata.Pairs("yuko", "aioi")
+			injectUnaryTransport(t, &unaryTransport{
+				t:          t,
+				expectedMD: md,
+				h:          h,
+				r:          r,
+			})
+
+			client, err := NewClient(":50051", c.opts...)
+			if err != nil {
+				t.Fatalf("NewClient should not return an error, but got '%s'", err)
+			}
+
+			var res api.SimpleResponse
+			ctx := metadata.NewOutgoingContext(context.Background(), md)
+			err = client.Invoke(ctx, "/service/Method", &api.SimpleRequest{Name: "nano"}, &res)
+			if code := status.Code(err); code != c.expectedCode {
+				t.Errorf("expected status code: %s, but got %s (%v)", c.expectedCode, code, err)
+			}
+		})
+	}
+}
+
+type countingFrameParser struct {
+	parser.FrameParser
+
+	headers, messages, trailers int
+}
+
+func (p *countingFrameParser) ParseResponseHeader(r io.Reader) (*parser.Header, error) {
+	p.headers++
+	return p.FrameParser.ParseResponseHeader(r)
+}
+
+func (p *countingFrameParser) ParseLengthPrefixedMessage(r io.Reader, length uint32) ([]byte, error) {
+	p.messages++
+	return p.FrameParser.ParseLengthPrefixedMessage(r, length)
+}
+
+func (p *countingFrameParser) ParseStatusAndTrailer(r io.Reader, length uint32) (*status.Status, metadata.MD, error) {
+	p.trailers++
+	return p.FrameParser.ParseStatusAndTrailer(r, length)
+}
+
+func TestFrameParser(t *testing.T) {
+	r, err := os.Open(filepath.Join("testdata", "trailer_response.in"))
+	if err != nil {
+		t.Fatalf("Open should not return an error, but got '%s'", err)
+	}
+
+	md := metadata.Pairs("yuko", "aioi")
+	injectUnaryTransport(t, &unaryTransport{
+		t:          t,
+		expectedMD: md,
+		h:          make(http.Header),
+		r:          r,
+	})
+
+	p := &countingFrameParser{FrameParser: parser.DefaultFrameParser}
+	client, err := NewClient(":50051", WithFrameParser(p))
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+
+	var res api.SimpleResponse
+	ctx := metadata.NewOutgoingContext(context.Background(), md)
+	if err := client.Invoke(ctx, "/service/Method", &api.SimpleRequest{Name: "nano"}, &res); err != nil {
+		t.Fatalf("Invoke should not return an error, but got '%s'", err)
+	}
+
+	if p.headers != 2 || p.messages != 1 || p.trailers != 1 {
+		t.Errorf("expected the custom parser to parse 2 headers, 1 message and 1 trailer, but got %d, %d and %d", p.headers, p.messages, p.trailers)
+	}
+}
+
+type recordingStatsHandler struct {
+	events []string
+	times  []time.Time
+}
+
+func (h *recordingStatsHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (h *recordingStatsHandler) HandleRPC(_ context.Context, rs stats.RPCStats) {
+	h.events = append(h.events, fmt.Sprintf("%T", rs))
+	switch rs := rs.(type) {
+	case *stats.Begin:
+		h.times = append(h.times, rs.BeginTime)
+	case *stats.OutPayload:
+		h.times = append(h.times, rs.SentTime)
+	case *stats.InPayload:
+		h.times = append(h.times, rs.RecvTime)
+	case *stats.End:
+		h.times = append(h.times, rs.BeginTime, rs.EndTime)
+	}
+}
+
+func (h *recordingStatsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (h *recordingStatsHandler) HandleConn(context.Context, stats.ConnStats) {}
+
+func TestStatsHandler(t *testing.T) {
+	r, err := os.Open(filepath.Join("testdata", "trailer_response.in"))
+	if err != nil {
+		t.Fatalf("Open should not return an error, but got '%s'", err)
+	}
+
+	md := metadata.Pairs("yuko", "aioi")
+	injectUnaryTransport(t, &unaryTransport{
+		t:          t,
+		expectedMD: md,
+		h:          make(http.Header),
+		r:          r,
+	})
+
+	h := &recordingStatsHandler{}
+	clock := transporttest.NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	client, err := NewClient(":50051", WithStatsHandler(h), WithClock(clock))
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+
+	var res api.SimpleResponse
+	ctx := metadata.NewOutgoingContext(context.Background(), md)
+	if err := client.Invoke(ctx, "/service/Method", &api.SimpleRequest{Name: "nano"}, &res); err != nil {
+		t.Fatalf("Invoke should not return an error, but got '%s'", err)
+	}
+
+	expected := []string{
+		"*stats.Begin",
+		"*stats.OutHeader",
+		"*stats.OutPayload",
+		"*stats.InHeader",
+		"*stats.InPayload",
+		"*stats.InTrailer",
+		"*stats.End",
+	}
+	if diff := cmp.Diff(expected, h.events); diff != "" {
+		t.Errorf("-want, +got\n%s", diff)
+	}
+	for _, tm := range h.times {
+		if !tm.Equal(clock.Now()) {
+			t.Errorf("expected the times to be taken from the clock, but got %s", tm)
+		}
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	cases := map[string]struct {
+		target   string
+		insecure bool
+		expected string
+		wantErr  bool
+	}{
+		"host and port":         {target: "example.com:8080", expected: "example.com:8080"},
+		"port only":             {target: ":50051", expected: ":50051"},
+		"default secure port":   {target: "example.com", expected: "example.com:443"},
+		"default insecure port": {target: "example.com", insecure: true, expected: "example.com:80"},
+		"IPv6 with port":        {target: "[::1]:8080", expected: "[::1]:8080"},
+		"IPv6 without port":     {target: "[::1]", expected: "[::1]:443"},
+		"bare IPv6":             {target: "::1", insecure: true, expected: "[::1]:80"},
+		"userinfo":              {target: "user:pass@example.com", expected: "user:pass@example.com:443"},
+		"path":                  {target: "example.com/api", expected: "example.com:443/api"},
+		"scheme":                {target: "https://example.com", wantErr: true},
+		"invalid port":          {target: "example.com:http", wantErr: true},
+		"port out of range":     {target: "example.com:70000", wantErr: true},
+		"empty":                 {target: "", wantErr: true},
+		"malformed IPv6":        {target: "[::1", wantErr: true},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseTarget(c.target, c.insecure)
+			if c.wantErr {
+				if !errors.Is(err, ErrInvalidTarget) {
+					t.Fatalf("expected ErrInvalidTarget, but got '%v'", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("should not return an error, but got '%s'", err)
+			}
+			if got != c.expected {
+				t.Errorf("expected '%s', but got '%s'", c.expected, got)
+			}
+		})
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	p := Propagation{DeadlineMargin: time.Second, Keys: []string{"X-Request-Id", "authorization", "X-B3-*"}}
+
+	cases := map[string]struct {
+		incoming, outgoing metadata.MD
+		timeout            time.Duration
+		expectedMD         metadata.MD
+		expectDeadline     bool
+	}{
+		"no parent": {
+			expectedMD: nil,
+		},
+		"allowed keys": {
+			incoming:   metadata.Pairs("x-request-id", "1", "authorization", "token", "cookie", "secret"),
+			expectedMD: metadata.Pairs("x-request-id", "1", "authorization", "token"),
+		},
+		"key prefix": {
+			incoming:   metadata.Pairs("x-b3-traceid", "1", "x-b3-spanid", "2", "x-b3", "3"),
+			expectedMD: metadata.Pairs("x-b3-traceid", "1", "x-b3-spanid", "2"),
+		},
+		"outgoing metadata wins": {
+			incoming:   metadata.Pairs("x-request-id", "1"),
+			outgoing:   metadata.Pairs("x-request-id", "2"),
+			expectedMD: metadata.Pairs("x-request-id", "2"),
+		},
+		"deadline": {
+			timeout:        time.Minute,
+			expectDeadline: true,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			parent := context.Background()
+			if c.incoming != nil {
+				parent = metadata.NewIncomingContext(parent, c.incoming)
+			}
+			if c.outgoing != nil {
+				parent = metadata.NewOutgoingContext(parent, c.outgoing)
+			}
+			if c.timeout > 0 {
+				var cancel context.CancelFunc
+				parent, cancel = context.WithTimeout(parent, c.timeout)
+				defer cancel()
+			}
+
+			ctx, cancel := p.Context(parent)
+			defer cancel()
+
+			md, _ := metadata.FromOutgoingContext(ctx)
+			if diff := cmp.Diff(c.expectedMD, md); diff != "" {
+				t.Errorf("-want, +got\n%s", diff)
+			}
+
+			deadline, ok := ctx.Deadline()
+			if ok != c.expectDeadline {
+				t.Fatalf("expected deadline: %t, but got %t", c.expectDeadline, ok)
+			}
+			if ok {
+				parentDeadline, _ := parent.Deadline()
+				if got := parentDeadline.Sub(deadline); got != p.DeadlineMargin {
+					t.Errorf("expected the deadline to be %s before the parent one, but got %s", p.DeadlineMargin, got)
+				}
+			}
+		})
+	}
+}
+
+func TestUnaryInterceptor(t *testing.T) {
+	r, err := os.Open(filepath.Join("testdata", "trailer_response.in"))
+	if err != nil {
+		t.Fatalf("Open should not return an error, but got '%s'", err)
+	}
+
+	injectUnaryTransport(t, &unaryTransport{
+		t:          t,
+		expectedMD: metadata.Pairs("yuko", "aioi"),
+		h:          make(http.Header),
+		r:          r,
+	})
+
+	var intercepted string
+	interceptor := func(ctx context.Context, method string, req, reply any, cc *ClientConn, invoker UnaryInvoker, opts ...CallOption) error {
+		intercepted = method
+		if m, _ := MethodFromContext(ctx); m != method {
+			t.Errorf("expected the method in the context to be '%s', but got '%s'", method, m)
+		}
+		if a := AttemptFromContext(ctx); a != 1 {
+			t.Errorf("expected the attempt to be 1, but got %d", a)
+		}
+		if k, _ := TransportKindFromContext(ctx); k != TransportHTTP {
+			t.Errorf("expected the transport kind to be %s, but got %s", TransportHTTP, k)
+		}
+		if start, ok := StartTimeFromContext(ctx); !ok || start.IsZero() {
+			t.Errorf("expected the start time to be set")
+		}
+		info, ok := CallInfoFromContext(ctx)
+		if !ok {
+			t.Fatalf("expected the call info to be set")
+		}
+		if info.Service != "service" || info.Method != "Method" || info.StreamDesc != nil {
+			t.Errorf("expected the call info of the unary call of service/Method, but got %+v", info)
+		}
+		ctx = metadata.AppendToOutgoingContext(ctx, "yuko", "aioi")
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	client, err := NewClient(":50051", WithUnaryInterceptor(interceptor))
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+
+	var res api.SimpleResponse
+	if err := client.Invoke(context.Background(), "/service/Method", &api.SimpleRequest{Name: "nano"}, &res); err != nil {
+		t.Fatalf("Invoke should not return an error, but got '%s'", err)
+	}
+	if intercepted != "/service/Method" {
+		t.Errorf("expected the interceptor to intercept '/service/Method', but got '%s'", intercepted)
+	}
+}
+
+func TestMethodPolicy(t *testing.T) {
+	cases := map[string]struct {
+		policy   MethodPolicy
+		method   string
+		expected bool
+	}{
+		"empty":          {method: "/pkg.Service/Method", expected: true},
+		"allowed":        {policy: MethodPolicy{Allow: []string{"/pkg.Service/*"}}, method: "/pkg.Service/Method", expected: true},
+		"not allowed":    {policy: MethodPolicy{Allow: []string{"/pkg.Service/*"}}, method: "/pkg.Admin/Method"},
+		"denied":         {policy: MethodPolicy{Deny: []string{"/pkg.Admin/*"}}, method: "/pkg.Admin/Method"},
+		"deny wins":      {policy: MethodPolicy{Allow: []string{"/pkg.*/*"}, Deny: []string{"/pkg.Admin/Delete*"}}, method: "/pkg.Admin/DeleteAll"},
+		"not denied":     {policy: MethodPolicy{Deny: []string{"/pkg.Admin/*"}}, method: "/pkg.Service/Method", expected: true},
+		"exact":          {policy: MethodPolicy{Allow: []string{"/pkg.Service/Method"}}, method: "/pkg.Service/Method", expected: true},
+		"exact mismatch": {policy: MethodPolicy{Allow: []string{"/pkg.Service/Method"}}, method: "/pkg.Service/Method2"},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := c.policy.Allows(c.method); got != c.expected {
+				t.Errorf("expected %t, but got %t", c.expected, got)
+			}
+		})
+	}
+
+	t.Run("invalid pattern", func(t *testing.T) {
+		if _, err := NewClient(":50051", WithMethodPolicy(MethodPolicy{Allow: []string{"["}})); err == nil {
+			t.Fatalf("NewClient should return an error")
+		}
+	})
+
+	t.Run("rejected call", func(t *testing.T) {
+		client, err := NewClient(":50051", WithMethodPolicy(MethodPolicy{Deny: []string{"/service/*"}}))
+		if err != nil {
+			t.Fatalf("NewClient should not return an error, but got '%s'", err)
+		}
+
+		err = client.Invoke(context.Background(), "/service/Method", &api.SimpleRequest{}, &api.SimpleResponse{})
+		if !errors.Is(err, ErrMethodNotAllowed) || status.Code(err) != codes.PermissionDenied {
+			t.Errorf("expected ErrMethodNotAllowed with PermissionDenied, but got '%v'", err)
+		}
+		_, err = client.NewStream(context.Background(), &grpc.StreamDesc{ServerStreams: true}, "/service/Method")
+		if !errors.Is(err, ErrMethodNotAllowed) {
+			t.Errorf("expected ErrMethodNotAllowed, but got '%v'", err)
+		}
+	})
+}
+
+type validatorFunc func(proto.Message) error
+
+func (f validatorFunc) Validate(msg proto.Message) error {
+	return f(msg)
+}
+
+func TestValidator(t *testing.T) {
+	errInvalid := errors.New("invalid")
+	cases := map[string]struct {
+		validator validatorFunc
+		wantErr   bool
+	}{
+		"valid": {
+			validator: func(proto.Message) error { return nil },
+		},
+		"invalid request": {
+			validator: func(m proto.Message) error {
+				if _, ok := protoadapt.MessageV1Of(m).(*api.SimpleRequest); ok {
+					return errInvalid
+				}
+				return nil
+			},
+			wantErr: true,
+		},
+		"invalid response": {
+			validator: func(m proto.Message) error {
+				if _, ok := protoadapt.MessageV1Of(m).(*api.SimpleResponse); ok {
+					return errInvalid
+				}
+				return nil
+			},
+			wantErr: true,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			r, err := os.Open(filepath.Join("testdata", "response.in"))
+			if err != nil {
+				t.Fatalf("Open should not return an error, but got '%s'", err)
+			}
+			injectUnaryTransport(t, &unaryTransport{
+				t:          t,
+				expectedMD: metadata.MD{},
+				h:          make(http.Header),
+				r:          r,
+			})
+
+			client, err := NewClient(":50051", WithValidator(c.validator))
+			if err != nil {
+				t.Fatalf("NewClient should not return an error, but got '%s'", err)
+			}
+
+			ctx := metadata.NewOutgoingContext(context.Background(), metadata.MD{})
+			err = client.Invoke(ctx, "/service/Method", &api.SimpleRequest{Name: "nano"}, &api.SimpleResponse{})
+			if !c.wantErr {
+				if err != nil {
+					t.Fatalf("Invoke should not return an error, but got '%s'", err)
+				}
+				return
+			}
+			if !errors.Is(err, errInvalid) || status.Code(err) != codes.InvalidArgument {
+				t.Errorf("expected an InvalidArgument error wrapping the validation error, but got '%v'", err)
+			}
+		})
+	}
+}
+
+// stalledUnaryTransport blocks until the context of the request is done, like
+// a proxy which silently dropped the request.
+type stalledUnaryTransport struct {
+	unaryTransport
+}
+
+func (t *stalledUnaryTransport) Send(ctx context.Context, _, _ string, _ io.Reader) (http.Header, io.ReadCloser, error) {
+	<-ctx.Done()
+	return nil, nil, ctx.Err()
+}
+
+// stalledClientStreamTransport never receives anything.
+type stalledClientStreamTransport struct {
+	clientStreamTransport
+}
+
+func (s *stalledClientStreamTransport) Receive(ctx context.Context) (io.ReadCloser, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestInactivityTimeout(t *testing.T) {
+	cases := map[string]struct {
+		desc   *grpc.StreamDesc
+		inject func(t *testing.T)
+	}{
+		"server stream": {
+			desc: &grpc.StreamDesc{ServerStreams: true},
+			inject: func(t *testing.T) {
+				injectUnaryTransport(t, &stalledUnaryTransport{})
+			},
+		},
+		"bidi stream": {
+			desc: &grpc.StreamDesc{ClientStreams: true, ServerStreams: true},
+			inject: func(t *testing.T) {
+				injectClientStreamTransport(t, &stalledClientStreamTransport{clientStreamTransport{tt: t, expectedHeader: http.Header{}}})
+			},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			c.inject(t)
+
+			client, err := NewClient(":50051")
+			if err != nil {
+				t.Fatalf("NewClient should not return an error, but got '%s'", err)
+			}
+			stream, err := client.NewStream(context.Background(), c.desc, "/service/Method", InactivityTimeout(10*time.Millisecond))
+			if err != nil {
+				t.Fatalf("NewStream should not return an error, but got '%s'", err)
+			}
+
+			err = stream.SendMsg(&api.SimpleRequest{Name: "nano"})
+			if err == nil {
+				err = stream.RecvMsg(&api.SimpleResponse{})
+			}
+			if status.Code(err) != codes.DeadlineExceeded {
+				t.Errorf("expected DeadlineExceeded, but got '%v'", err)
+			}
+			if stream.Context().Err() == nil {
+				t.Errorf("expected the stream context to be done")
+			}
+		})
+	}
+}
+
+func TestHeartbeats(t *testing.T) {
+	r, err := os.Open(filepath.Join("testdata", "heartbeat_response.in"))
+	if err != nil {
+		t.Fatalf("Open should not return an error, but got '%s'", err)
+	}
+	injectUnaryTransport(t, &unaryTransport{
+		t:          t,
+		expectedMD: metadata.MD{},
+		h:          make(http.Header),
+		r:          r,
+	})
+
+	client, err := NewClient(":50051")
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+
+	var (
+		res api.SimpleResponse
+		cs  CallStats
+	)
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.MD{})
+	if err := client.Invoke(ctx, "/service/Method", &api.SimpleRequest{Name: "nano"}, &res, Stats(&cs)); err != nil {
+		t.Fatalf("Invoke should not return an error, but got '%s'", err)
+	}
+	if cs.Heartbeats != 2 {
+		t.Errorf("expected 2 heartbeats, but got %d", cs.Heartbeats)
+	}
+}
+
+func TestBidiStreamHeartbeats(t *testing.T) {
+	const heartbeats = 10000
+	var rs []io.ReadCloser
+	for range heartbeats {
+		rs = append(rs, io.NopCloser(bytes.NewReader([]byte{0x80, 0, 0, 0, 0})))
+	}
+	for _, fname := range []string{"bidi_stream_response1.in", "bidi_stream_trailer_response.in"} {
+		r, err := os.Open(filepath.Join("testdata", fname))
+		if err != nil {
+			t.Fatalf("Open should not return an error, but got '%s'", err)
+		}
+		rs = append(rs, r)
+	}
+	injectClientStreamTransport(t, &clientStreamTransport{
+		tt:             t,
+		expectedHeader: make(http.Header),
+		h:              make(http.Header),
+		r:              rs,
+	})
+
+	client, err := NewClient(":50051")
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+	var cs CallStats
+	stm, err := client.NewStream(context.Background(), &grpc.StreamDesc{ClientStreams: true, ServerStreams: true}, "/service/Method", Stats(&cs))
+	if err != nil {
+		t.Fatalf("NewStream should not return an error, but got '%s'", err)
+	}
+	if err := stm.CloseSend(); err != nil {
+		t.Fatalf("CloseSend should not return an error, but got '%s'", err)
+	}
+	var res api.SimpleResponse
+	if err := stm.RecvMsg(&res); err != nil {
+		t.Fatalf("RecvMsg should not return an error, but got '%s'", err)
+	}
+	if res.Message != "hello ktr, I greet 1 times." {
+		t.Errorf("expected the message after the heartbeats, but got %q", res.Message)
+	}
+	if err := stm.RecvMsg(&res); !errors.Is(err, io.EOF) {
+		t.Errorf("expected RecvMsg to return io.EOF, but got '%v'", err)
+	}
+	if cs.Heartbeats != heartbeats {
+		t.Errorf("expected %d heartbeats, but got %d", heartbeats, cs.Heartbeats)
+	}
+}
+
+func TestResumableStream(t *testing.T) {
+	b, err := os.ReadFile(filepath.Join("testdata", "server_stream_response.in"))
+	if err != nil {
+		t.Fatalf("ReadFile should not return an error, but got '%s'", err)
+	}
+	// Cut the body in the middle of the second message, then resume with the
+	// frames following the first one.
+	first := 5 + int(b[4])
+	bodies := [][]byte{b[:first+7], b[first:]}
+
+	md := metadata.Pairs("yuko", "aioi")
+	old := transport.NewUnary
+	t.Cleanup(func() {
+		transport.NewUnary = old
+	})
+	transport.NewUnary = func(string, ...transport.ConnectOption) (transport.UnaryTransport, error) {
+		body := bodies[0]
+		bodies = bodies[1:]
+		return &unaryTransport{
+			t:          t,
+			expectedMD: md,
+			h:          make(http.Header),
+			r:          io.NopCloser(bytes.NewReader(body)),
+		}, nil
+	}
+
+	client, err := NewClient(":50051")
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+
+	var requested []int
+	ctx := metadata.NewOutgoingContext(context.Background(), md)
+	stream, err := client.NewResumableStream(ctx, "/service/Method", ResumeConfig{
+		Request: func(received int) any {
+			requested = append(requested, received)
+			return &api.SimpleRequest{Name: "nano"}
+		},
+		Backoff: backoff.Config{BaseDelay: time.Millisecond, Multiplier: 1, MaxDelay: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("NewResumableStream should not return an error, but got '%s'", err)
+	}
+
+	var got []string
+	for {
+		var res api.SimpleResponse
+		err := stream.RecvMsg(&res)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("RecvMsg should not return an error, but got '%s'", err)
+		}
+		got = append(got, res.Message)
+	}
+
+	expected := []string{"hello nano, I greet 1 times.", "hello nano, I greet 2 times.", "hello nano, I greet 3 times."}
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Errorf("-want, +got\n%s", diff)
+	}
+	if diff := cmp.Diff([]int{0, 1}, requested); diff != "" {
+		t.Errorf("-want, +got\n%s", diff)
+	}
+}
+
+// headerUnaryTransport keeps the request header so that tests can inspect it.
+type headerUnaryTransport struct {
+	unaryTransport
+	header http.Header
+}
+
+func (t *headerUnaryTransport) Header() http.Header {
+	return t.header
+}
+
+func TestRetryPolicy(t *testing.T) {
+	r, err := os.ReadFile(filepath.Join("testdata", "response.in"))
+	if err != nil {
+		t.Fatalf("ReadFile should not return an error, but got '%s'", err)
+	}
+	unavailable := http.Header{"Grpc-Status": {"14"}}
+
+	cases := map[string]struct {
+		headers          []http.Header
+		expectedAttempts int
+		expectedCode     codes.Code
+	}{
+		"success after retries": {
+			headers:          []http.Header{unavailable, unavailable, {}},
+			expectedAttempts: 3,
+		},
+		"attempts exhausted": {
+			headers:          []http.Header{unavailable, unavailable, unavailable},
+			expectedAttempts: 3,
+			expectedCode:     codes.Unavailable,
+		},
+		"not retryable": {
+			headers:          []http.Header{{"Grpc-Status": {"3"}}},
+			expectedAttempts: 1,
+			expectedCode:     codes.InvalidArgument,
+		},
+		"pushback": {
+			headers:          []http.Header{{"Grpc-Status": {"14"}, "Grpc-Retry-Pushback-Ms": {"1"}}, {}},
+			expectedAttempts: 2,
+		},
+		"negative pushback": {
+			headers:          []http.Header{{"Grpc-Status": {"14"}, "Grpc-Retry-Pushback-Ms": {"-1"}}},
+			expectedAttempts: 1,
+			expectedCode:     codes.Unavailable,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			md := metadata.Pairs("yuko", "aioi")
+			var transports []*headerUnaryTransport
+			old := transport.NewUnary
+			t.Cleanup(func() {
+				transport.NewUnary = old
+			})
+			transport.NewUnary = func(string, ...transport.ConnectOption) (transport.UnaryTransport, error) {
+				tr := &headerUnaryTransport{
+					unaryTransport: unaryTransport{
+						t:          t,
+						expectedMD: md,
+						h:          c.headers[len(transports)],
+						r:          io.NopCloser(bytes.NewReader(r)),
+					},
+					header: make(http.Header),
+				}
+				transports = append(transports, tr)
+				return tr, nil
+			}
+
+			client, err := NewClient(":50051", WithRetryPolicy(RetryPolicy{
+				MaxAttempts:          3,
+				InitialBackoff:       time.Millisecond,
+				MaxBackoff:           time.Millisecond,
+				BackoffMultiplier:    2,
+				RetryableStatusCodes: []codes.Code{codes.Unavailable},
+			}))
+			if err != nil {
+				t.Fatalf("NewClient should not return an error, but got '%s'", err)
+			}
+
+			ctx := metadata.NewOutgoingContext(context.Background(), md)
+			err = client.Invoke(ctx, "/service/Method", &api.SimpleRequest{Name: "nano"}, &api.SimpleResponse{})
+			if code := status.Code(err); code != c.expectedCode {
+				t.Errorf("expected code %s, but got '%v'", c.expectedCode, err)
+			}
+			if len(transports) != c.expectedAttempts {
+				t.Fatalf("expected %d attempts, but got %d", c.expectedAttempts, len(transports))
+			}
+			for i, tr := range transports {
+				expected := ""
+				if i > 0 {
+					expected = strconv.Itoa(i)
+				}
+				if got := tr.header.Get("grpc-previous-rpc-attempts"); got != expected {
+					t.Errorf("attempt %d: expected grpc-previous-rpc-attempts '%s', but got '%s'", i+1, expected, got)
+				}
+			}
+		})
+	}
+}
+
+// TestConcurrentUse is meant to be run with the race detector.
+func TestConcurrentUse(t *testing.T) {
+	responses := map[string][]byte{}
+	for method, name := range map[string]string{
+		"/service/Unary":  "trailer_response.in",
+		"/service/Stream": "server_stream_trailer_response.in",
+	} {
+		b, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatalf("failed to read the testdata: %s", err)
+		}
+		responses[method] = b
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/grpc-web+proto")
+		w.Write(responses[r.URL.Path])
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure())
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			var header, trailer metadata.MD
+			var res api.SimpleResponse
+			err := client.Invoke(context.Background(), "/service/Unary", &api.SimpleRequest{Name: "nano"}, &res, Header(&header), Trailer(&trailer))
+			if err != nil {
+				t.Errorf("Invoke should not return an error, but got '%s'", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			stm, err := client.NewStream(context.Background(), &grpc.StreamDesc{ServerStreams: true}, "/service/Stream")
+			if err != nil {
+				t.Errorf("NewStream should not return an error, but got '%s'", err)
+				return
+			}
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				if err := stm.SendMsg(&api.SimpleRequest{Name: "nano"}); err != nil {
+					t.Errorf("SendMsg should not return an error, but got '%s'", err)
+					return
+				}
+				for {
+					var res api.SimpleResponse
+					if err := stm.RecvMsg(&res); err != nil {
+						if err != io.EOF {
+							t.Errorf("RecvMsg should not return an error, but got '%s'", err)
+						}
+						return
+					}
+				}
+			}()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					stm.Header()
+					client.ActiveStreams()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestMessages(t *testing.T) {
+	cases := map[string]struct {
+		transportContentFileName string
+		breakAfter               int
+		expectedMessages         []string
+		expectedCode             codes.Code
+	}{
+		"all messages": {
+			transportContentFileName: "server_stream_response.in",
+			expectedMessages: []string{
+				"hello nano, I greet 1 times.",
+				"hello nano, I greet 2 times.",
+				"hello nano, I greet 3 times.",
+			},
+		},
+		"early break": {
+			transportContentFileName: "server_stream_response.in",
+			breakAfter:               1,
+			expectedMessages:         []string{"hello nano, I greet 1 times."},
+		},
+		"error": {
+			transportContentFileName: "server_stream_trailer_response_error.in",
+			expectedMessages:         []string{"hello nano, I greet 1 times."},
+			expectedCode:             codes.Internal,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			r, err := os.Open(filepath.Join("testdata", c.transportContentFileName))
+			if err != nil {
+				t.Fatalf("Open should not return an error, but got '%s'", err)
+			}
+
+			md := metadata.Pairs("yuko", "aioi")
+			injectUnaryTransport(t, &unaryTransport{
+				t:          t,
+				expectedMD: md,
+				h:          make(http.Header),
+				r:          r,
+			})
+
+			client, err := NewClient(":50051")
+			if err != nil {
+				t.Fatalf("NewClient should not return an error, but got '%s'", err)
+			}
+			ctx := metadata.NewOutgoingContext(context.Background(), md)
+			stm, err := client.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/service/Method")
+			if err != nil {
+				t.Fatalf("should not return an error, but got '%s'", err)
+			}
+			if err := stm.SendMsg(&api.SimpleRequest{Name: "nano"}); err != nil {
+				t.Fatalf("Send should not return an error, but got '%s'", err)
+			}
+
+			var msgs []string
+			var code codes.Code
+			for msg, err := range Messages[api.SimpleResponse](stm) {
+				if err != nil {
+					code = status.Code(err)
+					continue
+				}
+				msgs = append(msgs, msg.Message)
+				if len(msgs) == c.breakAfter {
+					break
+				}
+			}
+
+			if diff := cmp.Diff(c.expectedMessages, msgs); diff != "" {
+				t.Errorf("-want, +got\n%s", diff)
+			}
+			if code != c.expectedCode {
+				t.Errorf("expected status code %s, but got %s", c.expectedCode, code)
+			}
+			if n := client.NumActiveStreams(); n != 0 {
+				t.Errorf("expected the stream to be released, but got %d active streams", n)
+			}
+		})
+	}
+}
+
+func TestCompression(t *testing.T) {
+	cases := map[string]struct {
+		dialOpts           []DialOption
+		callOpts           []CallOption
+		expectedCompressed bool
+	}{
+		"compressed": {
+			callOpts:           []CallOption{UseCompressor(gzip.Name)},
+			expectedCompressed: true,
+		},
+		"below the threshold": {
+			dialOpts: []DialOption{WithCompressionThreshold(1024)},
+			callOpts: []CallOption{UseCompressor(gzip.Name)},
+		},
+		"threshold overridden": {
+			dialOpts:           []DialOption{WithCompressionThreshold(1024)},
+			callOpts:           []CallOption{UseCompressor(gzip.Name), CompressionThreshold(0)},
+			expectedCompressed: true,
+		},
+		"no compressor": {},
+	}
+
+	resMsg, err := proto.Marshal(protoadapt.MessageV2Of(&api.SimpleResponse{Message: "hello, nano"}))
+	if err != nil {
+		t.Fatalf("failed to marshal the response: %s", err)
+	}
+	var compressed bytes.Buffer
+	w := gz.NewWriter(&compressed)
+	w.Write(resMsg)
+	w.Close()
+	trailer := []byte("grpc-status: 0\r\n")
+	var res bytes.Buffer
+	res.Write([]byte{0x01, 0, 0, 0, 0})
+	binary.BigEndian.PutUint32(res.Bytes()[1:], uint32(compressed.Len()))
+	res.Write(compressed.Bytes())
+	res.Write([]byte{0x80, 0, 0, 0, byte(len(trailer))})
+	res.Write(trailer)
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			var (
+				encoding string
+				flag     byte
+				req      api.SimpleRequest
+			)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				encoding = r.Header.Get("grpc-encoding")
+				b, _ := io.ReadAll(r.Body)
+				flag = b[0]
+				msg := b[headerLen:]
+				if flag == 0x01 {
+					zr, err := gz.NewReader(bytes.NewReader(msg))
+					if err != nil {
+						t.Errorf("the request isn't gzipped: %s", err)
+						return
+					}
+					msg, _ = io.ReadAll(zr)
+				}
+				if err := proto.Unmarshal(msg, protoadapt.MessageV2Of(&req)); err != nil {
+					t.Errorf("failed to unmarshal the request: %s", err)
+				}
+				w.Header().Set("Content-Type", "application/grpc-web+proto")
+				w.Header().Set("grpc-encoding", gzip.Name)
+				w.Write(res.Bytes())
+			}))
+			defer srv.Close()
+
+			client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), append(c.dialOpts, WithInsecure())...)
+			if err != nil {
+				t.Fatalf("NewClient should not return an error, but got '%s'", err)
+			}
+			var reply api.SimpleResponse
+			if err := client.Invoke(context.Background(), "/service/Method", &api.SimpleRequest{Name: "nano"}, &reply, c.callOpts...); err != nil {
+				t.Fatalf("Invoke should not return an error, but got '%s'", err)
+			}
+
+			if compressed := flag == 0x01; compressed != c.expectedCompressed {
+				t.Errorf("expected the request to be compressed: %t, but got %t", c.expectedCompressed, compressed)
+			}
+			if expected := c.expectedCompressed || len(c.callOpts) > 0; (encoding == gzip.Name) != expected {
+				t.Errorf("unexpected grpc-encoding header %q", encoding)
+			}
+			if req.Name != "nano" {
+				t.Errorf("expected the request to be received, but got %q", req.Name)
+			}
+			if reply.Message != "hello, nano" {
+				t.Errorf("expected the compressed response to be decoded, but got %q", reply.Message)
+			}
+		})
+	}
+}
+
+func TestHTTPTrailers(t *testing.T) {
+	cases := map[string]struct {
+		withMessage     bool
+		status          string
+		expectedCode    codes.Code
+		expectedTrailer metadata.MD
+	}{
+		"response": {
+			withMessage:     true,
+			status:          "0",
+			expectedTrailer: metadata.Pairs("trailer-key", "trailer-val"),
+		},
+		"trailers only": {
+			status:          "5",
+			expectedCode:    codes.NotFound,
+			expectedTrailer: metadata.Pairs("trailer-key", "trailer-val"),
+		},
+	}
+
+	resMsg, err := proto.Marshal(protoadapt.MessageV2Of(&api.SimpleResponse{Message: "hello, nano"}))
+	if err != nil {
+		t.Fatalf("failed to marshal the response: %s", err)
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.Copy(io.Discard, r.Body)
+				w.Header().Set("Content-Type", "application/grpc-web+proto")
+				w.Header().Set("Trailer", "Grpc-Status, Grpc-Message, Trailer-Key")
+				w.WriteHeader(http.StatusOK)
+				if c.withMessage {
+					w.Write(header(len(resMsg)))
+					w.Write(resMsg)
+				}
+				w.Header().Set("Grpc-Status", c.status)
+				w.Header().Set("Grpc-Message", "status message")
+				w.Header().Set("Trailer-Key", "trailer-val")
+			}))
+			defer srv.Close()
+
+			client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure())
+			if err != nil {
+				t.Fatalf("NewClient should not return an error, but got '%s'", err)
+			}
+			var (
+				reply   api.SimpleResponse
+				trailer metadata.MD
+			)
+			err = client.Invoke(context.Background(), "/service/Method", &api.SimpleRequest{Name: "nano"}, &reply, Trailer(&trailer))
+			if code := status.Code(err); code != c.expectedCode {
+				t.Fatalf("expected status code %s, but got %s (%v)", c.expectedCode, code, err)
+			}
+			if c.withMessage && reply.Message != "hello, nano" {
+				t.Errorf("expected the response message, but got %q", reply.Message)
+			}
+			if diff := cmp.Diff(c.expectedTrailer, trailer); diff != "" {
+				t.Errorf("-want, +got\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEmptyMessages(t *testing.T) {
+	var reqBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/grpc-web+proto")
+		trailer := []byte("grpc-status: 0\r\n")
+		w.Write(header(0))
+		w.Write(append([]byte{0x80, 0, 0, 0, byte(len(trailer))}, trailer...))
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure())
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+
+	var reply emptypb.Empty
+	if err := client.InvokeEmptyRequest(context.Background(), "/service/Method", &reply); err != nil {
+		t.Fatalf("InvokeEmptyRequest should not return an error, but got '%s'", err)
+	}
+	if diff := cmp.Diff(header(0), reqBody); diff != "" {
+		t.Errorf("expected an empty request frame, -want, +got\n%s", diff)
+	}
+
+	if err := client.InvokeEmptyResponse(context.Background(), "/service/Method", &api.SimpleRequest{}); err != nil {
+		t.Fatalf("InvokeEmptyResponse should not return an error, but got '%s'", err)
+	}
+}
+
+// envelope prefixes the messages with its tag.
+type envelope byte
+
+func (e envelope) TransformRequest(_ string, msg []byte) ([]byte, error) {
+	return append([]byte{byte(e)}, msg...), nil
+}
+
+func (e envelope) TransformResponse(_ string, msg []byte) ([]byte, error) {
+	if len(msg) == 0 || msg[0] != byte(e) {
+		return nil, fmt.Errorf("missing envelope %c", e)
+	}
+	return msg[1:], nil
+}
+
+func TestMessageTransformers(t *testing.T) {
+	resMsg, err := proto.Marshal(protoadapt.MessageV2Of(&api.SimpleResponse{Message: "hello, nano"}))
+	if err != nil {
+		t.Fatalf("failed to marshal the response: %s", err)
+	}
+
+	cases := map[string]struct {
+		resEnvelope  string
+		expectedCode codes.Code
+	}{
+		"wrapped response": {
+			resEnvelope: "ba",
+		},
+		"missing envelope": {
+			resEnvelope:  "a",
+			expectedCode: codes.Internal,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			var req api.SimpleRequest
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				msg, ok := bytes.CutPrefix(b[headerLen:], []byte("ba"))
+				if !ok {
+					t.Errorf("expected the request to be wrapped in both envelopes, but got %q", b[headerLen:])
+				}
+				if err := proto.Unmarshal(msg, protoadapt.MessageV2Of(&req)); err != nil {
+					t.Errorf("failed to unmarshal the request: %s", err)
+				}
+
+				res := append([]byte(c.resEnvelope), resMsg...)
+				trailer := []byte("grpc-status: 0\r\n")
+				w.Header().Set("Content-Type", "application/grpc-web+proto")
+				w.Write(header(len(res)))
+				w.Write(res)
+				w.Write(append([]byte{0x80, 0, 0, 0, byte(len(trailer))}, trailer...))
+			}))
+			defer srv.Close()
+
+			client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithMessageTransformers(envelope('a'), envelope('b')))
+			if err != nil {
+				t.Fatalf("NewClient should not return an error, but got '%s'", err)
+			}
+			var reply api.SimpleResponse
+			err = client.Invoke(context.Background(), "/service/Method", &api.SimpleRequest{Name: "nano"}, &reply)
+			if code := status.Code(err); code != c.expectedCode {
+				t.Fatalf("expected status code %s, but got %s (%v)", c.expectedCode, code, err)
+			}
+			if req.Name != "nano" {
+				t.Errorf("expected the request to be received, but got %q", req.Name)
+			}
+			if err == nil && reply.Message != "hello, nano" {
+				t.Errorf("expected the response to be unwrapped, but got %q", reply.Message)
+			}
+		})
+	}
+}
+
+func TestResolver(t *testing.T) {
+	hosts := make(chan string, 10)
+	upgrader := websocket.Upgrader{Subprotocols: []string{"grpc-websockets"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts <- r.Host
+		if websocket.IsWebSocketUpgrade(r) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			conn.ReadMessage()
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc-web+proto")
+		trailer := []byte("grpc-status: 0\r\n")
+		w.Write(header(0))
+		w.Write(append([]byte{0x80, 0, 0, 0, byte(len(trailer))}, trailer...))
+	}))
+	defer srv.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	resolved := make(chan string, 10)
+	deadlines := make(chan bool, 10)
+	r := ResolverFunc(func(ctx context.Context, host string) ([]string, error) {
+		resolved <- host
+		_, ok := ctx.Deadline()
+		deadlines <- ok
+		return []string{strings.TrimPrefix(dead.URL, "http://"), strings.TrimPrefix(srv.URL, "http://")}, nil
+	})
+	client, err := NewClient("backend.internal", WithInsecure(), WithResolver(r, 0), WithRetryPolicy(RetryPolicy{
+		MaxAttempts:          2,
+		InitialBackoff:       time.Millisecond,
+		MaxBackoff:           time.Millisecond,
+		BackoffMultiplier:    1,
+		RetryableStatusCodes: []codes.Code{codes.Unavailable},
+	}))
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+
+	var reply emptypb.Empty
+	if err := client.InvokeEmptyRequest(context.Background(), "/service/Method", &reply); err != nil {
+		t.Fatalf("InvokeEmptyRequest should not return an error, but got '%s'", err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case host := <-resolved:
+			if host != "backend.internal" {
+				t.Errorf("expected the resolved host to be 'backend.internal', but got '%s'", host)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %d resolutions, but got %d", 2, i)
+		}
+	}
+	<-deadlines
+	if !<-deadlines {
+		t.Errorf("expected the re-resolution to have a deadline")
+	}
+
+	// The requests to the resolved addresses keep the Host of the target.
+	if host := <-hosts; host != client.Target() {
+		t.Errorf("expected the request Host to be %s, but got %s", client.Target(), host)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The stream may go to the dead address first, which is skipped next.
+	for i := 0; ; i++ {
+		_, err := client.NewStream(ctx, &grpc.StreamDesc{ClientStreams: true, ServerStreams: true}, "/service/Method")
+		if err == nil {
+			break
+		}
+		if i == 1 {
+			t.Fatalf("NewStream should not return an error, but got '%s'", err)
+		}
+	}
+	if host := <-hosts; host != client.Target() {
+		t.Errorf("expected the handshake Host to be %s, but got %s", client.Target(), host)
+	}
+}
+
+func TestLoadBalancingPolicy(t *testing.T) {
+	addrs := []string{"10.0.0.1:443", "10.0.0.2:443", "10.0.0.3:443"}
+
+	cases := map[string]struct {
+		policy   LoadBalancingPolicy
+		pending  []int
+		expected []string
+	}{
+		"round robin": {
+			policy:   RoundRobin(),
+			pending:  []int{0, 0, 0},
+			expected: []string{"10.0.0.1:443", "10.0.0.2:443", "10.0.0.3:443", "10.0.0.1:443"},
+		},
+		"weighted": {
+			policy:   Weighted(map[string]int{"10.0.0.1": 3, "10.0.0.3:443": 0}),
+			pending:  []int{0, 0, 0},
+			expected: []string{"10.0.0.1:443", "10.0.0.1:443", "10.0.0.2:443", "10.0.0.1:443", "10.0.0.1:443", "10.0.0.1:443", "10.0.0.2:443", "10.0.0.1:443"},
+		},
+		"least pending": {
+			policy:   LeastPending(),
+			pending:  []int{2, 0, 1},
+			expected: []string{"10.0.0.2:443", "10.0.0.2:443"},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			var picked []string
+			for range c.expected {
+				picked = append(picked, addrs[c.policy.Pick(addrs, c.pending)])
+			}
+			if diff := cmp.Diff(c.expected, picked); diff != "" {
+				t.Errorf("-want, +got\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestOutlierDetection(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc-web+proto")
+		trailer := []byte("grpc-status: 0\r\n")
+		w.Write(header(0))
+		w.Write(append([]byte{0x80, 0, 0, 0, byte(len(trailer))}, trailer...))
+	}))
+	defer srv.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc-web+proto")
+		w.Header().Set("grpc-status", strconv.Itoa(int(codes.Internal)))
+	}))
+	defer failing.Close()
+
+	r := ResolverFunc(func(context.Context, string) ([]string, error) {
+		return []string{strings.TrimPrefix(failing.URL, "http://"), strings.TrimPrefix(srv.URL, "http://")}, nil
+	})
+	client, err := NewClient("backend.internal", WithInsecure(), WithResolver(r, 0), WithOutlierDetection(OutlierDetection{
+		ConsecutiveFailures: 1,
+	}))
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+
+	var reply emptypb.Empty
+	err = client.InvokeEmptyRequest(context.Background(), "/service/Method", &reply)
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("expected the first call to fail with %s, but got '%v'", codes.Internal, err)
+	}
+	for i := 0; i < 4; i++ {
+		if err := client.InvokeEmptyRequest(context.Background(), "/service/Method", &reply); err != nil {
+			t.Fatalf("InvokeEmptyRequest should not return an error once the failing address is ejected, but got '%s'", err)
+		}
+	}
+}
+
+func TestAffinity(t *testing.T) {
+	cases := map[string]struct {
+		opt             DialOption
+		resHeader       [2]string
+		expectedReqName string
+		expected        string
+	}{
+		"header": {
+			opt:             WithAffinityHeader("x-backend"),
+			resHeader:       [2]string{"X-Backend", "b1"},
+			expectedReqName: "X-Backend",
+			expected:        "b1",
+		},
+		"cookie": {
+			opt:             WithAffinityCookie("route"),
+			resHeader:       [2]string{"Set-Cookie", "route=b1; Path=/; HttpOnly"},
+			expectedReqName: "Cookie",
+			expected:        "route=b1",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			var received []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received = append(received, r.Header.Get(c.expectedReqName))
+				w.Header().Set(c.resHeader[0], c.resHeader[1])
+				w.Header().Set("Content-Type", "application/grpc-web+proto")
+				trailer := []byte("grpc-status: 0\r\n")
+				w.Write(header(0))
+				w.Write(append([]byte{0x80, 0, 0, 0, byte(len(trailer))}, trailer...))
+			}))
+			defer srv.Close()
+
+			client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), c.opt)
+			if err != nil {
+				t.Fatalf("NewClient should not return an error, but got '%s'", err)
+			}
+			for i := 0; i < 2; i++ {
+				if err := client.InvokeEmptyRequest(context.Background(), "/service/Method", &emptypb.Empty{}); err != nil {
+					t.Fatalf("InvokeEmptyRequest should not return an error, but got '%s'", err)
+				}
+			}
+			if diff := cmp.Diff([]string{"", c.expected}, received); diff != "" {
+				t.Errorf("-want, +got\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("websocket handshake", func(t *testing.T) {
+		handshake := make(chan http.Header, 1)
+		upgrader := websocket.Upgrader{Subprotocols: []string{"grpc-websockets"}}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !websocket.IsWebSocketUpgrade(r) {
+				w.Header().Set("Set-Cookie", "route=b1; Path=/; HttpOnly")
+				w.Header().Set("Content-Type", "application/grpc-web+proto")
+				trailer := []byte("grpc-status: 0\r\n")
+				w.Write(header(0))
+				w.Write(append([]byte{0x80, 0, 0, 0, byte(len(trailer))}, trailer...))
+				return
+			}
+			handshake <- r.Header
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			conn.ReadMessage()
+		}))
+		defer srv.Close()
+
+		client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithAffinityCookie("route"), WithUserAgent("nano/1.0"))
+		if err != nil {
+			t.Fatalf("NewClient should not return an error, but got '%s'", err)
+		}
+		if err := client.InvokeEmptyRequest(context.Background(), "/service/Method", &emptypb.Empty{}); err != nil {
+			t.Fatalf("InvokeEmptyRequest should not return an error, but got '%s'", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		if _, err := client.NewStream(ctx, &grpc.StreamDesc{ClientStreams: true, ServerStreams: true}, "/service/Method"); err != nil {
+			t.Fatalf("NewStream should not return an error, but got '%s'", err)
+		}
+		h := <-handshake
+		if got := h.Get("Cookie"); got != "route=b1" {
+			t.Errorf("expected the handshake to carry the affinity cookie, but got %q", got)
+		}
+		if got := h.Get("User-Agent"); got != "nano/1.0" {
+			t.Errorf("expected the handshake to carry the user agent, but got %q", got)
+		}
+	})
+
+	t.Run("key", func(t *testing.T) {
+		var hits [3]int
+		var addrs []string
+		for i := range hits {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				hits[i]++
+				w.Header().Set("Content-Type", "application/grpc-web+proto")
+				trailer := []byte("grpc-status: 0\r\n")
+				w.Write(header(0))
+				w.Write(append([]byte{0x80, 0, 0, 0, byte(len(trailer))}, trailer...))
+			}))
+			defer srv.Close()
+			addrs = append(addrs, strings.TrimPrefix(srv.URL, "http://"))
+		}
+		r := ResolverFunc(func(context.Context, string) ([]string, error) {
+			return addrs, nil
+		})
+		client, err := NewClient("backend.internal", WithInsecure(), WithResolver(r, 0))
+		if err != nil {
+			t.Fatalf("NewClient should not return an error, but got '%s'", err)
+		}
+		for i := 0; i < 6; i++ {
+			if err := client.InvokeEmptyRequest(context.Background(), "/service/Method", &emptypb.Empty{}, AffinityKey("user-1")); err != nil {
+				t.Fatalf("InvokeEmptyRequest should not return an error, but got '%s'", err)
+			}
+		}
+		if diff := cmp.Diff(6, hits[rendezvous("user-1", addrs)]); diff != "" {
+			t.Errorf("expected all the calls to go to the same address, -want, +got\n%s", diff)
+		}
+	})
+}
+
+func TestWaitForReady(t *testing.T) {
+	cases := map[string]struct {
+		callOpts     []CallOption
+		dialOpts     []DialOption
+		serverStream bool
+		expectedOK   bool
+	}{
+		"fail fast": {},
+		"wait for ready": {
+			callOpts:   []CallOption{WaitForReady(true)},
+			expectedOK: true,
+		},
+		"server stream": {
+			callOpts:     []CallOption{WaitForReady(true)},
+			serverStream: true,
+		},
+		"queue timeout": {
+			callOpts: []CallOption{WaitForReady(true)},
+			dialOpts: []DialOption{WithQueueTimeout(50 * time.Millisecond)},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("failed to listen: %s", err)
+			}
+			addr := l.Addr().String()
+			l.Close()
+
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/grpc-web+proto")
+				trailer := []byte("grpc-status: 0\r\n")
+				w.Write(header(0))
+				w.Write(append([]byte{0x80, 0, 0, 0, byte(len(trailer))}, trailer...))
+			}))
+			defer srv.Close()
+
+			dialOpts := append([]DialOption{
+				WithInsecure(),
+				WithConnectParams(grpc.ConnectParams{Backoff: backoff.Config{BaseDelay: 20 * time.Millisecond, Multiplier: 1, MaxDelay: 20 * time.Millisecond}}),
+			}, c.dialOpts...)
+			client, err := NewClient(addr, dialOpts...)
+			if err != nil {
+				t.Fatalf("NewClient should not return an error, but got '%s'", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			done := make(chan error, 1)
+			go func() {
+				if !c.serverStream {
+					done <- client.InvokeEmptyRequest(ctx, "/service/Method", &emptypb.Empty{}, c.callOpts...)
+					return
+				}
+				stream, err := client.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/service/Method", c.callOpts...)
+				if err != nil {
+					done <- err
+					return
+				}
+				if err := stream.SendMsg(&emptypb.Empty{}); err != nil {
+					done <- err
+					return
+				}
+				if err := stream.RecvMsg(&emptypb.Empty{}); !errors.Is(err, io.EOF) {
+					done <- err
+					return
+				}
+				done <- nil
+			}()
+
+			// The server comes back while the call is queued.
+			time.Sleep(200 * time.Millisecond)
+			srv.Listener.Close()
+			srv.Listener, err = net.Listen("tcp", addr)
+			if err != nil {
+				t.Fatalf("failed to listen: %s", err)
+			}
+			srv.Start()
+
+			err = <-done
+			if c.expectedOK && err != nil {
+				t.Errorf("the call should not return an error, but got '%s'", err)
+			}
+			if !c.expectedOK && err == nil {
+				t.Errorf("the call should return an error, but got nil")
+			}
+		})
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		w.Header().Set("Content-Type", "application/grpc-web+proto")
+		trailer := []byte(fmt.Sprintf("grpc-status: %d\r\n", codes.Unavailable))
+		w.Write(append([]byte{0x80, 0, 0, 0, byte(len(trailer))}, trailer...))
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithIdempotencyKey(""), WithRetryPolicy(RetryPolicy{
+		MaxAttempts:          2,
+		InitialBackoff:       time.Millisecond,
+		MaxBackoff:           time.Millisecond,
+		BackoffMultiplier:    1,
+		RetryableStatusCodes: []codes.Code{codes.Unavailable},
+	}))
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := client.InvokeEmptyRequest(context.Background(), "/service/Method", &emptypb.Empty{}); status.Code(err) != codes.Unavailable {
+			t.Fatalf("expected the call to fail with %s, but got '%v'", codes.Unavailable, err)
+		}
+	}
+
+	if len(keys) != 4 {
+		t.Fatalf("expected 4 attempts, but got %d", len(keys))
+	}
+	if keys[0] == "" || keys[0] != keys[1] {
+		t.Errorf("expected the attempts of a call to share their key, but got %q", keys[:2])
+	}
+	if keys[0] == keys[2] || keys[2] != keys[3] {
+		t.Errorf("expected the calls to have distinct keys, but got %q", keys)
+	}
+}
+
+func TestInvokeFull(t *testing.T) {
+	resMsg, err := proto.Marshal(protoadapt.MessageV2Of(&api.SimpleResponse{Message: "hello, nano"}))
+	if err != nil {
+		t.Fatalf("failed to marshal the response: %s", err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trailer := []byte("grpc-status: 0\r\nx-trailer: t\r\n")
+		w.Header().Set("Content-Type", "application/grpc-web+proto")
+		w.Header().Set("X-Header", "h")
+		w.Write(header(len(resMsg)))
+		w.Write(resMsg)
+		w.Write(append([]byte{0x80, 0, 0, 0, byte(len(trailer))}, trailer...))
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure())
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+	var reply api.SimpleResponse
+	res, err := client.InvokeFull(context.Background(), "/service/Method", &api.SimpleRequest{Name: "nano"}, &reply)
+	if err != nil {
+		t.Fatalf("InvokeFull should not return an error, but got '%s'", err)
+	}
+
+	if diff := cmp.Diff([]string{"h"}, res.Header.Get("x-header")); diff != "" {
+		t.Errorf("header: -want, +got\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"t"}, res.Trailer.Get("x-trailer")); diff != "" {
+		t.Errorf("trailer: -want, +got\n%s", diff)
+	}
+	if res.Status.Code() != codes.OK {
+		t.Errorf("expected status %s, but got %s", codes.OK, res.Status.Code())
+	}
+	reqMsg, _ := proto.Marshal(protoadapt.MessageV2Of(&api.SimpleRequest{Name: "nano"}))
+	if res.RequestSize != len(reqMsg) || res.ResponseSize != len(resMsg) {
+		t.Errorf("expected sizes %d and %d, but got %d and %d", len(reqMsg), len(resMsg), res.RequestSize, res.ResponseSize)
+	}
+	if res.Attempts != 1 || res.Duration <= 0 || res.Stats.URL == "" {
+		t.Errorf("expected the attempts, duration and stats to be set, but got %+v", res)
+	}
+}
+
+func TestRecvTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc-web+proto")
+		for i, name := range []string{"first", "second"} {
+			if i > 0 {
+				// The second message is late.
+				time.Sleep(300 * time.Millisecond)
+			}
+			msg, _ := proto.Marshal(protoadapt.MessageV2Of(&api.SimpleResponse{Message: name}))
+			w.Write(header(len(msg)))
+			w.Write(msg)
+			w.(http.Flusher).Flush()
+		}
+		trailer := []byte("grpc-status: 0\r\n")
+		w.Write(append([]byte{0x80, 0, 0, 0, byte(len(trailer))}, trailer...))
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure())
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+	stream, err := client.NewStream(context.Background(), &grpc.StreamDesc{ServerStreams: true}, "/service/Method", RecvTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewStream should not return an error, but got '%s'", err)
+	}
+	if err := stream.SendMsg(&api.SimpleRequest{Name: "nano"}); err != nil {
+		t.Fatalf("SendMsg should not return an error, but got '%s'", err)
+	}
+
+	var (
+		received []string
+		timeouts int
+	)
+	for {
+		var res api.SimpleResponse
+		err := stream.RecvMsg(&res)
+		if errors.Is(err, ErrRecvTimeout) {
+			if status.Code(err) != codes.DeadlineExceeded {
+				t.Errorf("expected the timeout to have code %s, but got %s", codes.DeadlineExceeded, status.Code(err))
+			}
+			timeouts++
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("RecvMsg should not return an error, but got '%s'", err)
+		}
+		received = append(received, res.Message)
+	}
+
+	if diff := cmp.Diff([]string{"first", "second"}, received); diff != "" {
+		t.Errorf("-want, +got\n%s", diff)
+	}
+	if timeouts == 0 {
+		t.Errorf("expected the late message to time out")
+	}
+}
+
+func TestBidiStreamHalves(t *testing.T) {
+	for name, opts := range map[string][]CallOption{
+		"plain":        nil,
+		"recv timeout": {RecvTimeout(time.Second)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var rs []io.ReadCloser
+			for _, fname := range []string{"bidi_stream_response1.in", "bidi_stream_response2.in", "bidi_stream_response3.in", "bidi_stream_response4.in"} {
+				r, err := os.Open(filepath.Join("testdata", fname))
+				if err != nil {
+					t.Fatalf("Open should not return an error, but got '%s'", err)
+				}
+				rs = append(rs, r)
+			}
+			injectClientStreamTransport(t, &clientStreamTransport{
+				tt:             t,
+				expectedHeader: make(http.Header),
+				h:              make(http.Header),
+				r:              rs,
+			})
+
+			client, err := NewClient(":50051")
+			if err != nil {
+				t.Fatalf("NewClient should not return an error, but got '%s'", err)
+			}
+			ctx := metadata.NewOutgoingContext(context.Background(), metadata.MD{})
+			stm, err := client.NewStream(ctx, &grpc.StreamDesc{ClientStreams: true, ServerStreams: true}, "/service/Method", opts...)
+			if err != nil {
+				t.Fatalf("should not return an error, but got '%s'", err)
+			}
+			bidi, ok := stm.(BidiStream)
+			if !ok {
+				t.Fatalf("expected a BidiStream, but got %T", stm)
+			}
+			send, recv := bidi.SendStream(), bidi.RecvStream()
+			if _, ok := send.(RecvStream); ok {
+				t.Errorf("expected the sending half not to be a RecvStream")
+			}
+
+			if err := send.SendMsg(&api.SimpleRequest{Name: "nano"}); err != nil {
+				t.Fatalf("SendMsg should not return an error, but got '%s'", err)
+			}
+			if err := send.CloseSend(); err != nil {
+				t.Fatalf("CloseSend should not return an error, but got '%s'", err)
+			}
+
+			var n int
+			for {
+				var res api.SimpleResponse
+				err := recv.RecvMsg(&res)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("RecvMsg should not return an error, but got '%s'", err)
+				}
+				n++
+			}
+			if n != 3 {
+				t.Errorf("expected 3 messages, but got %d", n)
+			}
+		})
+	}
+}
+
+func TestToMetadata(t *testing.T) {
+	cases := map[string]struct {
+		header   http.Header
+		expected metadata.MD
+	}{
+		"empty": {},
+		"repeated keys": {
+			header:   http.Header{"X-Id": {"a", "b, c"}},
+			expected: metadata.MD{"x-id": {"a", "b, c"}},
+		},
+		"reserved headers": {
+			header: http.Header{
+				"Content-Type":      {"application/grpc-web+proto"},
+				"Trailer":           {"Grpc-Status"},
+				"Transfer-Encoding": {"chunked"},
+				":status":           {"200"},
+				"X-Id":              {"a"},
+			},
+			expected: metadata.MD{"x-id": {"a"}},
+		},
+		"binary headers": {
+			header:   http.Header{"X-Token-Bin": {"AQI=, AwQ", "%%"}},
+			expected: metadata.MD{"x-token-bin": {"\x01\x02", "\x03\x04", "%%"}},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(c.expected, toMetadata(c.header)); diff != "" {
+				t.Errorf("-want, +got\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRawHeader(t *testing.T) {
+	cases := map[string]struct {
+		status         codes.Code
+		expectedHeader metadata.MD
+	}{
+		"ok": {
+			status:         codes.OK,
+			expectedHeader: metadata.MD{"x-id": {"a"}},
+		},
+		"trailers-only error": {
+			status: codes.NotFound,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/grpc-web+proto")
+				w.Header().Set("X-Id", "a")
+				w.Header().Set("Grpc-Status", strconv.Itoa(int(c.status)))
+				w.Header().Set("Grpc-Message", "msg")
+				if c.status != codes.OK {
+					return
+				}
+				trailer := []byte("grpc-status: 0\r\n")
+				w.Write(header(0))
+				w.Write(append([]byte{0x80, 0, 0, 0, byte(len(trailer))}, trailer...))
+			}))
+			defer srv.Close()
+
+			client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure())
+			if err != nil {
+				t.Fatalf("NewClient should not return an error, but got '%s'", err)
+			}
+			var h, raw metadata.MD
+			err = client.InvokeEmptyRequest(context.Background(), "/service/Method", &emptypb.Empty{}, Header(&h), RawHeader(&raw))
+			if status.Code(err) != c.status {
+				t.Fatalf("expected status %s, but got '%v'", c.status, err)
+			}
+
+			for _, k := range []string{"date", "content-length"} {
+				delete(h, k)
+				delete(raw, k)
+			}
+			if c.expectedHeader != nil {
+				if diff := cmp.Diff(c.expectedHeader, h); diff != "" {
+					t.Errorf("header: -want, +got\n%s", diff)
+				}
+			}
+			expectedRaw := metadata.MD{"x-id": {"a"}, "grpc-status": {strconv.Itoa(int(c.status))}, "grpc-message": {"msg"}}
+			if diff := cmp.Diff(expectedRaw, raw); diff != "" {
+				t.Errorf("raw header: -want, +got\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCallInfo(t *testing.T) {
+	cases := map[string]struct {
+		method   string
+		desc     *grpc.StreamDesc
+		expected CallInfo
+	}{
+		"unary": {
+			method:   "/pkg.Service/Method",
+			expected: CallInfo{FullMethod: "/pkg.Service/Method", Service: "pkg.Service", Method: "Method", Transport: TransportHTTP, Attempt: 1},
+		},
+		"server stream": {
+			method:   "/pkg.Service/Method",
+			desc:     &grpc.StreamDesc{ServerStreams: true},
+			expected: CallInfo{FullMethod: "/pkg.Service/Method", Service: "pkg.Service", Method: "Method", Transport: TransportHTTP, Attempt: 1},
+		},
+		"bidi stream": {
+			method:   "/pkg.Service/Method",
+			desc:     &grpc.StreamDesc{ClientStreams: true, ServerStreams: true},
+			expected: CallInfo{FullMethod: "/pkg.Service/Method", Service: "pkg.Service", Method: "Method", Transport: TransportWebSocket, Attempt: 1},
+		},
+		"no service": {
+			method:   "Method",
+			expected: CallInfo{FullMethod: "Method", Method: "Method", Transport: TransportHTTP, Attempt: 1},
+		},
+	}
+
+	o := &dialOptions{clock: transporttest.NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			info, ok := CallInfoFromContext(o.newCallContext(context.Background(), c.method, c.desc))
+			if !ok {
+				t.Fatalf("expected the call info to be set")
+			}
+			c.expected.StreamDesc = c.desc
+			c.expected.StartTime = o.clock.Now()
+			if diff := cmp.Diff(&c.expected, info); diff != "" {
+				t.Errorf("-want, +got\n%s", diff)
+			}
+		})
+	}
+}
+
+type testEnvelope struct {
+	failUnwrap bool
+}
+
+func (e testEnvelope) Wrap(method, contentType string, h http.Header, body io.Reader) (string, io.Reader, error) {
+	h.Set("X-Content-Type", contentType)
+	return "application/x-envelope", io.MultiReader(strings.NewReader("<env>"), body, strings.NewReader("</env>")), nil
+}
+
+func (e testEnvelope) Unwrap(method string, h http.Header, body io.ReadCloser) (io.ReadCloser, error) {
+	defer body.Close()
+	if e.failUnwrap {
+		return nil, errors.New("malformed envelope")
+	}
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	h.Set("Content-Type", h.Get("X-Content-Type"))
+	return io.NopCloser(bytes.NewReader(bytes.TrimSuffix(bytes.TrimPrefix(b, []byte("<env>")), []byte("</env>")))), nil
+}
+
+func TestEnvelope(t *testing.T) {
+	cases := map[string]struct {
+		envelope     testEnvelope
+		expectedCode codes.Code
+	}{
+		"ok":             {},
+		"unwrap failure": {envelope: testEnvelope{failUnwrap: true}, expectedCode: codes.Internal},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if ct := r.Header.Get("Content-Type"); ct != "application/x-envelope" {
+					t.Errorf("expected the enveloped content type, but got '%s'", ct)
+				}
+				b, _ := io.ReadAll(r.Body)
+				if diff := cmp.Diff("<env>"+string(header(0))+"</env>", string(b)); diff != "" {
+					t.Errorf("request body: -want, +got\n%s", diff)
+				}
+				w.Header().Set("Content-Type", "application/x-envelope")
+				w.Header().Set("X-Content-Type", r.Header.Get("X-Content-Type"))
+				trailer := []byte("grpc-status: 0\r\n")
+				w.Write([]byte("<env>"))
+				w.Write(header(0))
+				w.Write(append([]byte{0x80, 0, 0, 0, byte(len(trailer))}, trailer...))
+				w.Write([]byte("</env>"))
+			}))
+			defer srv.Close()
+
+			client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithEnvelope(c.envelope))
+			if err != nil {
+				t.Fatalf("NewClient should not return an error, but got '%s'", err)
+			}
+			err = client.InvokeEmptyRequest(context.Background(), "/service/Method", &emptypb.Empty{})
+			if code := status.Code(err); code != c.expectedCode {
+				t.Fatalf("expected status %s, but got '%v'", c.expectedCode, err)
+			}
+		})
+	}
+}
+
+func TestInvokeServerStreamInto(t *testing.T) {
+	cases := map[string]struct {
+		transportContentFileName string
+		decode                   DecodeFunc
+		expected                 string
+		expectedCode             codes.Code
+	}{
+		"decode": {
+			transportContentFileName: "server_stream_response.in",
+			decode: func(w io.Writer, msg []byte) error {
+				var res api.SimpleResponse
+				if err := proto.Unmarshal(msg, protoadapt.MessageV2Of(&res)); err != nil {
+					return err
+				}
+				_, err := fmt.Fprintln(w, res.Message)
+				return err
+			},
+			expected: "hello nano, I greet 1 times.\nhello nano, I greet 2 times.\nhello nano, I greet 3 times.\n",
+		},
+		"raw": {
+			transportContentFileName: "server_stream_response.in",
+			expected:                 "\n\x1chello nano, I greet 1 times.\n\x1chello nano, I greet 2 times.\n\x1chello nano, I greet 3 times.",
+		},
+		"decode failure": {
+			transportContentFileName: "server_stream_response.in",
+			decode: func(w io.Writer, msg []byte) error {
+				return errors.New("disk full")
+			},
+			expectedCode: codes.Unknown,
+		},
+		"stream failure": {
+			transportContentFileName: "server_stream_trailer_response_error.in",
+			decode: func(w io.Writer, msg []byte) error {
+				_, err := w.Write([]byte("."))
+				return err
+			},
+			expected:     ".",
+			expectedCode: codes.Internal,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			r, err := os.Open(filepath.Join("testdata", c.transportContentFileName))
+			if err != nil {
+				t.Fatalf("Open should not return an error, but got '%s'", err)
+			}
+			md := metadata.Pairs("yuko", "aioi")
+			injectUnaryTransport(t, &unaryTransport{
+				t:          t,
+				expectedMD: md,
+				h:          make(http.Header),
+				r:          r,
+			})
+
+			client, err := NewClient(":50051")
+			if err != nil {
+				t.Fatalf("NewClient should not return an error, but got '%s'", err)
+			}
+			var w bytes.Buffer
+			ctx := metadata.NewOutgoingContext(context.Background(), md)
+			err = client.InvokeServerStreamInto(ctx, "/service/Method", &api.SimpleRequest{Name: "nano"}, &w, c.decode)
+			if code := status.Code(err); code != c.expectedCode {
+				t.Fatalf("expected code %s, but got '%v'", c.expectedCode, err)
+			}
+			if diff := cmp.Diff(c.expected, w.String()); diff != "" {
+				t.Errorf("-want, +got\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestStreamRequestMetadata(t *testing.T) {
+	cases := map[string]struct {
+		callMD         metadata.MD
+		mutate         func(md metadata.MD)
+		closeSend      bool
+		expectedHeader http.Header
+		expectedErr    error
+	}{
+		"unchanged": {
+			mutate:         func(metadata.MD) {},
+			expectedHeader: http.Header{"Yuko": {"aioi"}},
+		},
+		"changed": {
+			mutate:         func(md metadata.MD) { md.Set("yuko", "hakase") },
+			expectedHeader: http.Header{"Yuko": {"aioi"}},
+			expectedErr:    ErrRequestMetadataChanged,
+		},
+		"call metadata": {
+			callMD:         metadata.Pairs("yuko", "mio", "nano", "shinonome"),
+			mutate:         func(metadata.MD) {},
+			expectedHeader: http.Header{"Yuko": {"aioi", "mio"}, "Nano": {"shinonome"}},
+		},
+		"close send without messages": {
+			callMD:         metadata.Pairs("nano", "shinonome"),
+			mutate:         func(metadata.MD) {},
+			closeSend:      true,
+			expectedHeader: http.Header{"Yuko": {"aioi"}, "Nano": {"shinonome"}},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			tr := &clientStreamTransport{
+				tt:             t,
+				expectedHeader: c.expectedHeader,
+				h:              make(http.Header),
+			}
+			injectClientStreamTransport(t, tr)
+
+			client, err := NewClient(":50051")
+			if err != nil {
+				t.Fatalf("NewClient should not return an error, but got '%s'", err)
+			}
+			md := metadata.Pairs("yuko", "aioi")
+			ctx := metadata.NewOutgoingContext(context.Background(), md)
+			s, err := client.NewStream(ctx, &grpc.StreamDesc{ClientStreams: true, ServerStreams: true}, "/service/Method")
+			if err != nil {
+				t.Fatalf("NewStream should not return an error, but got '%s'", err)
+			}
+			stm := s.(Stream)
+			if c.callMD != nil {
+				if err := stm.SetCallMetadata(c.callMD); err != nil {
+					t.Fatalf("SetCallMetadata should not return an error, but got '%s'", err)
+				}
+			}
+
+			c.mutate(md)
+			if c.closeSend {
+				if err := stm.CloseSend(); err != nil {
+					t.Fatalf("CloseSend should not return an error, but got '%s'", err)
+				}
+				if !tr.sentHeader {
+					t.Errorf("expected CloseSend to send the request header")
+				}
+			}
+			for i := 0; !c.closeSend && i < 2; i++ {
+				err = stm.SendMsg(&api.SimpleRequest{Name: "nano"})
+				if !errors.Is(err, c.expectedErr) {
+					t.Fatalf("expected SendMsg to return '%v', but got '%v'", c.expectedErr, err)
+				}
+			}
+			if c.expectedErr != nil {
+				if status.Code(err) != codes.FailedPrecondition {
+					t.Errorf("expected code FailedPrecondition, but got %s", status.Code(err))
+				}
+				return
+			}
+			if err := stm.SetCallMetadata(metadata.Pairs("late", "1")); !errors.Is(err, ErrStreamStarted) {
+				t.Errorf("expected SetCallMetadata to return ErrStreamStarted once started, but got '%v'", err)
+			}
+		})
+	}
+}
+
+func TestClock(t *testing.T) {
+	r, err := os.ReadFile(filepath.Join("testdata", "response.in"))
+	if err != nil {
+		t.Fatalf("ReadFile should not return an error, but got '%s'", err)
+	}
+	md := metadata.Pairs("yuko", "aioi")
+	headers := []http.Header{{"Grpc-Status": {"14"}}, {"Grpc-Status": {"14"}}, {}}
+	var attempts int
+	old := transport.NewUnary
+	t.Cleanup(func() {
+		transport.NewUnary = old
+	})
+	transport.NewUnary = func(string, ...transport.ConnectOption) (transport.UnaryTransport, error) {
+		tr := &unaryTransport{t: t, expectedMD: md, h: headers[attempts], r: io.NopCloser(bytes.NewReader(r))}
+		attempts++
+		return tr, nil
+	}
+
+	clock := transporttest.NewClock(time.Now())
+	client, err := NewClient(":50051", WithClock(clock), WithRetryPolicy(RetryPolicy{
+		MaxAttempts:          3,
+		InitialBackoff:       time.Hour,
+		MaxBackoff:           time.Hour,
+		BackoffMultiplier:    2,
+		RetryableStatusCodes: []codes.Code{codes.Unavailable},
+	}))
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		ctx := metadata.NewOutgoingContext(context.Background(), md)
+		done <- client.Invoke(ctx, "/service/Method", &api.SimpleRequest{Name: "nano"}, &api.SimpleResponse{})
+	}()
+	// Each retry waits for a backoff of at most an hour.
+	for i := 0; i < 2; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(time.Hour)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Invoke should not return an error, but got '%s'", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the retries should not wait for the real time")
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts, but got %d", attempts)
+	}
+}
+
+func TestFrameReordering(t *testing.T) {
+	resMsg, err := proto.Marshal(protoadapt.MessageV2Of(&api.SimpleResponse{Message: "hello, nano"}))
+	if err != nil {
+		t.Fatalf("failed to marshal the response: %s", err)
+	}
+	msg := append([]byte{0, 0, 0, 0, byte(len(resMsg))}, resMsg...)
+	trailer := append([]byte{0x80, 0, 0, 0, 16}, "grpc-status: 0\r\n"...)
+
+	cases := map[string]struct {
+		window          int
+		frames          [][]byte
+		expectedMessage string
+	}{
+		"in order": {
+			window:          2,
+			frames:          [][]byte{msg, trailer},
+			expectedMessage: "hello, nano",
+		},
+		"trailer first": {
+			window:          2,
+			frames:          [][]byte{trailer, msg},
+			expectedMessage: "hello, nano",
+		},
+		"duplicate trailers": {
+			window:          2,
+			frames:          [][]byte{trailer, trailer, msg, trailer},
+			expectedMessage: "hello, nano",
+		},
+		"beyond the window": {
+			window: 1,
+			frames: [][]byte{trailer, trailer, msg},
+		},
+		"disabled": {
+			frames: [][]byte{trailer, msg},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/grpc-web+proto")
+				w.Write(bytes.Join(c.frames, nil))
+			}))
+			defer srv.Close()
+
+			client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithFrameReordering(c.window))
+			if err != nil {
+				t.Fatalf("NewClient should not return an error, but got '%s'", err)
+			}
+			var reply api.SimpleResponse
+			if err := client.Invoke(context.Background(), "/service/Method", &api.SimpleRequest{Name: "nano"}, &reply); err != nil {
+				t.Fatalf("Invoke should not return an error, but got '%s'", err)
+			}
+			if reply.Message != c.expectedMessage {
+				t.Errorf("expected the message %q, but got %q", c.expectedMessage, reply.Message)
+			}
+		})
+	}
+}
+
+func TestNoContentAsEmpty(t *testing.T) {
+	cases := map[string]struct {
+		code         int
+		header       http.Header
+		dialOpts     []DialOption
+		expectedCode codes.Code
+	}{
+		"no content": {
+			code:         http.StatusNoContent,
+			dialOpts:     []DialOption{WithNoContentAsEmpty()},
+			expectedCode: codes.OK,
+		},
+		"reset content": {
+			code:         http.StatusResetContent,
+			dialOpts:     []DialOption{WithNoContentAsEmpty()},
+			expectedCode: codes.OK,
+		},
+		"status header": {
+			code:         http.StatusNoContent,
+			header:       http.Header{"Grpc-Status": {"5"}},
+			dialOpts:     []DialOption{WithNoContentAsEmpty()},
+			expectedCode: codes.NotFound,
+		},
+		"other code": {
+			code:         http.StatusAccepted,
+			dialOpts:     []DialOption{WithNoContentAsEmpty()},
+			expectedCode: codes.Unknown,
+		},
+		"disabled": {
+			code:         http.StatusNoContent,
+			expectedCode: codes.Unknown,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for k, v := range c.header {
+					w.Header()[k] = v
+				}
+				w.WriteHeader(c.code)
+			}))
+			defer srv.Close()
+
+			client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), append(c.dialOpts, WithInsecure(), WithStrictMode())...)
+			if err != nil {
+				t.Fatalf("NewClient should not return an error, but got '%s'", err)
+			}
+			reply := api.SimpleResponse{Message: "stale"}
+			err = client.Invoke(context.Background(), "/service/Method", &api.SimpleRequest{Name: "nano"}, &reply)
+			if code := status.Code(err); code != c.expectedCode {
+				t.Fatalf("expected code %s, but got %s: %v", c.expectedCode, code, err)
+			}
+			if err == nil && reply.Message != "" {
+				t.Errorf("expected an empty response, but got %q", reply.Message)
+			}
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	resMsg, err := proto.Marshal(protoadapt.MessageV2Of(&api.SimpleResponse{Message: "hello, nano"}))
+	if err != nil {
+		t.Fatalf("failed to marshal the response: %s", err)
+	}
+	res := append([]byte{0, 0, 0, 0, byte(len(resMsg))}, resMsg...)
+	res = append(res, append([]byte{0x80, 0, 0, 0, 16}, "grpc-status: 0\r\n"...)...)
+
+	cases := map[string]struct {
+		dialOpts []DialOption
+		callOpts []CallOption
+	}{
+		"conn upload": {
+			dialOpts: []DialOption{WithUploadRateLimit(10)},
+		},
+		"call upload": {
+			callOpts: []CallOption{UploadRateLimit(10)},
+		},
+		"conn download": {
+			dialOpts: []DialOption{WithDownloadRateLimit(10)},
+		},
+		"call download": {
+			callOpts: []CallOption{DownloadRateLimit(10)},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.Copy(io.Discard, r.Body)
+				w.Header().Set("Content-Type", "application/grpc-web+proto")
+				w.Write(res)
+			}))
+			defer srv.Close()
+
+			clock := transporttest.NewClock(time.Now())
+			client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), append(c.dialOpts, WithInsecure(), WithClock(clock))...)
+			if err != nil {
+				t.Fatalf("NewClient should not return an error, but got '%s'", err)
+			}
+
+			// The request and the response of more than 10 bytes exceed the
+			// bucket.
+			done := make(chan error)
+			go func() {
+				var reply api.SimpleResponse
+				done <- client.Invoke(context.Background(), "/service/Method", &api.SimpleRequest{Name: "nano nano nano"}, &reply, c.callOpts...)
+			}()
+			clock.BlockUntil(1)
+			select {
+			case err := <-done:
+				t.Fatalf("Invoke should wait for the rate limit, but returned '%v'", err)
+			case <-time.After(10 * time.Millisecond):
+			}
+			for range 10 {
+				clock.Advance(time.Second)
+				select {
+				case err := <-done:
+					if err != nil {
+						t.Fatalf("Invoke should not return an error, but got '%s'", err)
+					}
+					return
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+			t.Fatalf("Invoke should return once the bytes are allowed")
+		})
+	}
+}
+
+func TestTraffic(t *testing.T) {
+	resMsg, err := proto.Marshal(protoadapt.MessageV2Of(&api.SimpleResponse{Message: "hello, nano"}))
+	if err != nil {
+		t.Fatalf("failed to marshal the response: %s", err)
+	}
+	res := append([]byte{0, 0, 0, 0, byte(len(resMsg))}, resMsg...)
+	res = append(res, append([]byte{0x80, 0, 0, 0, 16}, "grpc-status: 0\r\n"...)...)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/grpc-web+proto")
+		w.Write(res)
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure())
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+	req := &api.SimpleRequest{Name: "nano"}
+	for _, method := range []string{"/service/B", "/service/A", "/service/B"} {
+		var reply api.SimpleResponse
+		if err := client.Invoke(context.Background(), method, req, &reply); err != nil {
+			t.Fatalf("Invoke should not return an error, but got '%s'", err)
+		}
+	}
+
+	traffic := client.Traffic()
+	if len(traffic) != 2 || traffic[0].Method != "/service/A" || traffic[1].Method != "/service/B" {
+		t.Fatalf("expected the traffic of /service/A and /service/B, but got %+v", traffic)
+	}
+	reqLen := proto.Size(protoadapt.MessageV2Of(req)) + 5
+	for _, tr := range traffic {
+		// The headers are counted on top of the frames.
+		if tr.SentBytes <= int64(reqLen) || tr.ReceivedBytes <= int64(len(res)) {
+			t.Errorf("expected more bytes than the frames for %s, but got %+v", tr.Method, tr)
+		}
+	}
+	if a, b := traffic[0], traffic[1]; b.SentBytes != 2*a.SentBytes || b.ReceivedBytes != 2*a.ReceivedBytes {
+		t.Errorf("expected the traffic of /service/B to be twice the one of /service/A, but got %+v and %+v", a, b)
+	}
+}
+
+func TestMaxStatusSize(t *testing.T) {
+	detail, err := anypb.New(wrapperspb.String("a detail larger than the limit"))
+	if err != nil {
+		t.Fatalf("New should not return an error, but got '%s'", err)
+	}
+	details, err := proto.Marshal(&spb.Status{Code: int32(codes.NotFound), Message: "detailed", Details: []*anypb.Any{detail}})
+	if err != nil {
+		t.Fatalf("Marshal should not return an error, but got '%s'", err)
+	}
+
+	cases := map[string]struct {
+		header          http.Header
+		expectedMessage string
+		expectedLogged  bool
+	}{
+		"short": {
+			header:          http.Header{"Grpc-Status": {"5"}, "Grpc-Message": {"short"}},
+			expectedMessage: "short",
+		},
+		"long message": {
+			header:          http.Header{"Grpc-Status": {"5"}, "Grpc-Message": {"0123456789abcdef"}},
+			expectedMessage: "0123456789... (6 bytes truncated)",
+			expectedLogged:  true,
+		},
+		"large details": {
+			header:          http.Header{"Grpc-Status": {"5"}, "Grpc-Status-Details-Bin": {base64.RawStdEncoding.EncodeToString(details)}},
+			expectedMessage: "detailed",
+			expectedLogged:  true,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for k, v := range c.header {
+					w.Header()[k] = v
+				}
+				w.Header().Set("Content-Type", "application/grpc-web+proto")
+			}))
+			defer srv.Close()
+
+			var logs bytes.Buffer
+			logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+			client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithMaxStatusSize(10), WithLogger(logger))
+			if err != nil {
+				t.Fatalf("NewClient should not return an error, but got '%s'", err)
+			}
+			var reply api.SimpleResponse
+			err = client.Invoke(context.Background(), "/service/Method", &api.SimpleRequest{Name: "nano"}, &reply)
+			st := status.Convert(err)
+			if st.Code() != codes.NotFound {
+				t.Fatalf("expected code %s, but got %s", codes.NotFound, st.Code())
+			}
+			if st.Message() != c.expectedMessage {
+				t.Errorf("expected message %q, but got %q", c.expectedMessage, st.Message())
+			}
+			if len(st.Details()) != 0 {
+				t.Errorf("expected the details to be dropped, but got %v", st.Details())
+			}
+			if logged := strings.Contains(logs.String(), "truncated status"); logged != c.expectedLogged {
+				t.Errorf("expected the full status to be logged: %t, but got logs %q", c.expectedLogged, logs.String())
+			}
+		})
+	}
+}
+
+func TestFramePadding(t *testing.T) {
+	padding := BlockPadding(16)
+	resMsg, err := proto.Marshal(protoadapt.MessageV2Of(&api.SimpleResponse{Message: "hello, nano"}))
+	if err != nil {
+		t.Fatalf("failed to marshal the response: %s", err)
+	}
+	msg, _ := padding.Pad("", append([]byte{0, 0, 0, 0, byte(len(resMsg))}, resMsg...))
+	trailer, _ := padding.Pad("", append([]byte{0x80, 0, 0, 0, 16}, "grpc-status: 0\r\n"...))
+
+	cases := map[string]struct {
+		res          []byte
+		expectedCode codes.Code
+	}{
+		"padded": {
+			res: append(msg, trailer...),
+		},
+		"truncated padding": {
+			res:          append(msg, trailer[:len(trailer)-1]...),
+			expectedCode: codes.Internal,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			var req api.SimpleRequest
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				if len(b)%16 != 0 {
+					t.Errorf("expected a padded request, but got %d bytes", len(b))
+				}
+				frame, err := padding.Unpad("", bytes.NewReader(b))
+				if err != nil {
+					t.Errorf("Unpad should not return an error, but got '%s'", err)
+					return
+				}
+				if err := proto.Unmarshal(frame[headerLen:], protoadapt.MessageV2Of(&req)); err != nil {
+					t.Errorf("failed to unmarshal the request: %s", err)
+				}
+				w.Header().Set("Content-Type", "application/grpc-web+proto")
+				w.Write(c.res)
+			}))
+			defer srv.Close()
+
+			client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithFramePadding(padding))
+			if err != nil {
+				t.Fatalf("NewClient should not return an error, but got '%s'", err)
+			}
+			var reply api.SimpleResponse
+			err = client.Invoke(context.Background(), "/service/Method", &api.SimpleRequest{Name: "nano"}, &reply)
+			if code := status.Code(err); code != c.expectedCode {
+				t.Fatalf("expected code %s, but got %s: %v", c.expectedCode, code, err)
+			}
+			if req.Name != "nano" {
+				t.Errorf("expected the request to be received, but got %q", req.Name)
+			}
+			if err == nil && reply.Message != "hello, nano" {
+				t.Errorf("expected the padded response to be decoded, but got %q", reply.Message)
+			}
+		})
+	}
+}
+
+func TestBlock(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		methods []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		methods = append(methods, r.Method)
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithBlock())
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+	mu.Lock()
+	if len(methods) != 1 || methods[0] != http.MethodOptions {
+		t.Errorf("expected the connection to be set up eagerly, but got the requests %v", methods)
+	}
+	mu.Unlock()
+	if traffic := client.Traffic(); len(traffic) != 0 {
+		t.Errorf("expected no traffic accounted, but got %v", traffic)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	_, err = DialContext(context.Background(), addr, WithInsecure(), WithBlock())
+	var dialErr *transport.DialError
+	if !errors.As(err, &dialErr) {
+		t.Fatalf("expected a DialError, but got '%v'", err)
+	}
+	if dialErr.Stage != transport.DialStageTCP {
+		t.Errorf("expected the tcp stage, but got %s", dialErr.Stage)
+	}
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		t.Errorf("expected the cause to be preserved, but got '%v'", err)
+	}
+}
+
+func TestWebSocketConn(t *testing.T) {
+	upgrader := websocket.Upgrader{Subprotocols: []string{"grpc-websockets"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// Read the request until the end of the sending, then answer.
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if bytes.Equal(msg, []byte{0x01}) {
+				break
+			}
+		}
+		trailer := []byte("grpc-status: 0\r\n")
+		conn.WriteMessage(websocket.BinaryMessage, []byte{0x00})
+		conn.WriteMessage(websocket.BinaryMessage, []byte("content-type: application/grpc-web+proto\r\n"))
+		conn.WriteMessage(websocket.BinaryMessage, header(0))
+		conn.WriteMessage(websocket.BinaryMessage, nil)
+		conn.WriteMessage(websocket.BinaryMessage, []byte{0x80, 0, 0, 0, byte(len(trailer))})
+		conn.WriteMessage(websocket.BinaryMessage, trailer)
+	}))
+	defer srv.Close()
+
+	var targets []string
+	client, err := NewClient("tunnel.invalid", WithInsecure(), WithWebSocketConn(func(ctx context.Context, target, method string) (*websocket.Conn, error) {
+		targets = append(targets, target)
+		d := websocket.Dialer{Subprotocols: []string{"grpc-websockets"}}
+		conn, _, err := d.DialContext(ctx, "ws"+strings.TrimPrefix(srv.URL, "http")+method, nil)
+		return conn, err
+	}))
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+
+	stream, err := client.NewStream(context.Background(), &grpc.StreamDesc{ClientStreams: true}, "/service/Method")
+	if err != nil {
+		t.Fatalf("NewStream should not return an error, but got '%s'", err)
+	}
+	if err := stream.SendMsg(&emptypb.Empty{}); err != nil {
+		t.Fatalf("SendMsg should not return an error, but got '%s'", err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatalf("CloseSend should not return an error, but got '%s'", err)
+	}
+	if err := stream.RecvMsg(&emptypb.Empty{}); err != nil {
+		t.Fatalf("RecvMsg should not return an error, but got '%s'", err)
+	}
+	if diff := cmp.Diff([]string{"tunnel.invalid:80"}, targets); diff != "" {
+		t.Errorf("-want, +got\n%s", diff)
+	}
+}
+
+func TestStreamInterceptor(t *testing.T) {
+	resMsg, err := proto.Marshal(wrapperspb.String("yuko"))
+	if err != nil {
+		t.Fatalf("Marshal should not return an error, but got '%s'", err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc-web+proto")
+		w.Write(append([]byte{0, 0, 0, 0, byte(len(resMsg))}, resMsg...))
+		w.Write(append([]byte{0x80, 0, 0, 0, 16}, "grpc-status: 0\r\n"...))
+	}))
+	defer srv.Close()
+
+	var (
+		intercepted string
+		events      []FrameEvent
+	)
+	interceptor := func(ctx context.Context, desc *grpc.StreamDesc, cc *ClientConn, method string, streamer Streamer, opts ...CallOption) (Stream, error) {
+		intercepted = method
+		if info, _ := CallInfoFromContext(ctx); info.StreamDesc != desc {
+			t.Errorf("expected the call info of the stream, but got %+v", info)
+		}
+		return streamer(ctx, desc, cc, method, append(opts, OnFrame(func(e FrameEvent) {
+			events = append(events, e)
+		}))...)
+	}
+	client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithStreamInterceptor(interceptor))
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+
+	stream, err := client.NewStream(context.Background(), &grpc.StreamDesc{ServerStreams: true}, "/service/Method")
+	if err != nil {
+		t.Fatalf("NewStream should not return an error, but got '%s'", err)
+	}
+	if err := stream.SendMsg(wrapperspb.String("nano")); err != nil {
+		t.Fatalf("SendMsg should not return an error, but got '%s'", err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatalf("CloseSend should not return an error, but got '%s'", err)
+	}
+	var res wrapperspb.StringValue
+	if err := stream.RecvMsg(&res); err != nil {
+		t.Fatalf("RecvMsg should not return an error, but got '%s'", err)
+	}
+	if err := stream.RecvMsg(&res); err != io.EOF {
+		t.Fatalf("expected io.EOF, but got '%v'", err)
+	}
+
+	if intercepted != "/service/Method" {
+		t.Errorf("expected the interceptor to intercept '/service/Method', but got '%s'", intercepted)
+	}
+	expected := []FrameEvent{
+		{Sent: true, Flag: 0, Length: 6},
+		{Flag: 0, Length: uint32(len(resMsg))},
+		{Flag: 0x80, Length: 16},
+	}
+	if diff := cmp.Diff(expected, events); diff != "" {
+		t.Errorf("-want, +got\n%s", diff)
+	}
+}
+
+func TestUnknownFrames(t *testing.T) {
+	var handled []string
+	handler := func(method string, flag byte, payload []byte) error {
+		handled = append(handled, fmt.Sprintf("%s 0x%02x %s", method, flag, payload))
+		return nil
+	}
+	failingHandler := func(method string, flag byte, payload []byte) error {
+		return status.Error(codes.Unimplemented, "unsupported extension")
+	}
+
+	cases := map[string]struct {
+		flag            byte
+		opts            []DialOption
+		expectedCode    codes.Code
+		expectedHandled []string
+	}{
+		"default":         {flag: 0x02},
+		"default strict":  {flag: 0x02, opts: []DialOption{WithStrictMode()}, expectedCode: codes.Internal},
+		"trailer-like":    {flag: 0x81, opts: []DialOption{WithStrictMode()}, expectedCode: codes.Internal},
+		"skip":            {flag: 0x02, opts: []DialOption{WithStrictMode(), WithUnknownFrames(SkipUnknownFrames)}},
+		"reject":          {flag: 0x02, opts: []DialOption{WithUnknownFrames(RejectUnknownFrames)}, expectedCode: codes.Internal},
+		"handler":         {flag: 0x02, opts: []DialOption{WithUnknownFrameHandler(handler)}, expectedHandled: []string{"/service/Method 0x02 ext"}},
+		"failing handler": {flag: 0x02, opts: []DialOption{WithUnknownFrameHandler(failingHandler)}, expectedCode: codes.Unimplemented},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			handled = nil
+			resMsg, _ := proto.Marshal(wrapperspb.String("yuko"))
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/grpc-web+proto")
+				w.Write(append([]byte{c.flag, 0, 0, 0, 3}, "ext"...))
+				w.Write(append([]byte{0, 0, 0, 0, byte(len(resMsg))}, resMsg...))
+				w.Write(append([]byte{0x80, 0, 0, 0, 16}, "grpc-status: 0\r\n"...))
+			}))
+			defer srv.Close()
+
+			client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), append(c.opts, WithInsecure())...)
+			if err != nil {
+				t.Fatalf("NewClient should not return an error, but got '%s'", err)
+			}
+			var res wrapperspb.StringValue
+			err = client.Invoke(context.Background(), "/service/Method", wrapperspb.String("nano"), &res)
+			if code := status.Code(err); code != c.expectedCode {
+				t.Fatalf("expected status code %s, but got %s (%v)", c.expectedCode, code, err)
+			}
+			if err == nil && res.GetValue() != "yuko" {
+				t.Errorf("expected the response 'yuko', but got '%s'", res.GetValue())
+			}
+			if diff := cmp.Diff(c.expectedHandled, handled); diff != "" {
+				t.Errorf("-want, +got\n%s", diff)
+			}
+		})
+	}
+}
+
+// headerlessClientStreamTransport accepts any request header.
+type headerlessClientStreamTransport struct {
+	clientStreamTransport
+}
+
+func (s *headerlessClientStreamTransport) SetRequestHeader(http.Header) {}
+
+func TestStreamCardinality(t *testing.T) {
+	resMsg, _ := proto.Marshal(wrapperspb.String("yuko"))
+	msgFrame := append([]byte{0, 0, 0, 0, byte(len(resMsg))}, resMsg...)
+	trailerFrame := append([]byte{0x80, 0, 0, 0, 16}, "grpc-status: 0\r\n"...)
+
+	cases := map[string]struct {
+		run func(t *testing.T) error
+	}{
+		"frames after the trailer": {
+			run: func(t *testing.T) error {
+				srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", "application/grpc-web+proto")
+					w.Write(msgFrame)
+					w.Write(trailerFrame)
+					w.Write(msgFrame)
+				}))
+				t.Cleanup(srv.Close)
+				client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure())
+				if err != nil {
+					t.Fatalf("NewClient should not return an error, but got '%s'", err)
+				}
+				stream, err := client.NewStream(context.Background(), &grpc.StreamDesc{ServerStreams: true}, "/service/Method")
+				if err != nil {
+					t.Fatalf("NewStream should not return an error, but got '%s'", err)
+				}
+				if err := stream.SendMsg(wrapperspb.String("nano")); err != nil {
+					t.Fatalf("SendMsg should not return an error, but got '%s'", err)
+				}
+				var res wrapperspb.StringValue
+				if err := stream.RecvMsg(&res); err != nil {
+					t.Fatalf("RecvMsg should not return an error, but got '%s'", err)
+				}
+				return stream.RecvMsg(&res)
+			},
+		},
+		"several requests on a server stream": {
+			run: func(t *testing.T) error {
+				srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", "application/grpc-web+proto")
+					w.Write(trailerFrame)
+				}))
+				t.Cleanup(srv.Close)
+				client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure())
+				if err != nil {
+					t.Fatalf("NewClient should not return an error, but got '%s'", err)
+				}
+				stream, err := client.NewStream(context.Background(), &grpc.StreamDesc{ServerStreams: true}, "/service/Method")
+				if err != nil {
+					t.Fatalf("NewStream should not return an error, but got '%s'", err)
+				}
+				if err := stream.SendMsg(wrapperspb.String("nano")); err != nil {
+					t.Fatalf("SendMsg should not return an error, but got '%s'", err)
+				}
+				return stream.SendMsg(wrapperspb.String("nano"))
+			},
+		},
+		"several responses on a client stream": {
+			run: func(t *testing.T) error {
+				injectClientStreamTransport(t, &headerlessClientStreamTransport{clientStreamTransport{
+					tt: t,
+					h:  make(http.Header),
+					r:  []io.ReadCloser{io.NopCloser(bytes.NewReader(msgFrame)), io.NopCloser(bytes.NewReader(msgFrame))},
+				}})
+				client, err := NewClient(":50051")
+				if err != nil {
+					t.Fatalf("NewClient should not return an error, but got '%s'", err)
+				}
+				stream, err := client.NewStream(context.Background(), &grpc.StreamDesc{ClientStreams: true}, "/service/Method")
+				if err != nil {
+					t.Fatalf("NewStream should not return an error, but got '%s'", err)
+				}
+				if err := stream.CloseSend(); err != nil {
+					t.Fatalf("CloseSend should not return an error, but got '%s'", err)
+				}
+				return stream.RecvMsg(&wrapperspb.StringValue{})
+			},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := c.run(t)
+			if code := status.Code(err); code != codes.Internal {
+				t.Fatalf("expected status code %s, but got %s (%v)", codes.Internal, code, err)
+			}
+			if !strings.Contains(err.Error(), "cardinality violation") {
+				t.Errorf("expected a cardinality violation, but got '%s'", err)
+			}
+		})
+	}
+}
+
+func TestServerStreamLazySend(t *testing.T) {
+	resMsg, _ := proto.Marshal(wrapperspb.String("yuko"))
+	var requests int
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/grpc-web+proto")
+		w.Header().Set("x-shard", "3")
+		w.Write(append([]byte{0, 0, 0, 0, byte(len(resMsg))}, resMsg...))
+		w.Write(append([]byte{0x80, 0, 0, 0, 16}, "grpc-status: 0\r\n"...))
+	}))
+	defer srv.Close()
+	client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure())
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+
+	cases := map[string]struct {
+		start func(stream grpc.ClientStream) error
+	}{
+		"RecvMsg": {
+			start: func(grpc.ClientStream) error { return nil },
+		},
+		"Header": {
+			start: func(stream grpc.ClientStream) error {
+				md, err := stream.Header()
+				if err != nil {
+					return err
+				}
+				if got := md.Get("x-shard"); len(got) != 1 || got[0] != "3" {
+					t.Errorf("expected the header x-shard to be 3, but got %v", got)
+				}
+				return nil
+			},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			mu.Lock()
+			requests = 0
+			mu.Unlock()
+			stream, err := client.NewStream(context.Background(), &grpc.StreamDesc{ServerStreams: true}, "/service/Method")
+			if err != nil {
+				t.Fatalf("NewStream should not return an error, but got '%s'", err)
+			}
+			if err := stream.SendMsg(wrapperspb.String("nano")); err != nil {
+				t.Fatalf("SendMsg should not return an error, but got '%s'", err)
+			}
+			if err := stream.CloseSend(); err != nil {
+				t.Fatalf("CloseSend should not return an error, but got '%s'", err)
+			}
+			mu.Lock()
+			n := requests
+			mu.Unlock()
+			if n != 0 {
+				t.Fatalf("expected SendMsg not to send the request, but %d were sent", n)
+			}
+
+			if err := c.start(stream); err != nil {
+				t.Fatalf("starting the stream should not return an error, but got '%s'", err)
+			}
+			var res wrapperspb.StringValue
+			if err := stream.RecvMsg(&res); err != nil {
+				t.Fatalf("RecvMsg should not return an error, but got '%s'", err)
+			}
+			if res.Value != "yuko" {
+				t.Errorf("expected the response yuko, but got %q", res.Value)
+			}
+			if err := stream.RecvMsg(&res); err != io.EOF {
+				t.Errorf("expected io.EOF, but got '%v'", err)
+			}
+			mu.Lock()
+			n = requests
+			mu.Unlock()
+			if n != 1 {
+				t.Errorf("expected a single request, but got %d", n)
+			}
+		})
+	}
+}
+
+func TestHost(t *testing.T) {
+	newServer := func(name string) *httptest.Server {
+		resMsg, _ := proto.Marshal(wrapperspb.String(name))
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/grpc-web+proto")
+			w.Write(append([]byte{0, 0, 0, 0, byte(len(resMsg))}, resMsg...))
+			w.Write(append([]byte{0x80, 0, 0, 0, 16}, "grpc-status: 0\r\n"...))
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	def, tenant := newServer("default"), newServer("tenant")
+
+	client, err := NewClient(strings.TrimPrefix(def.URL, "http://"), WithInsecure())
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+
+	cases := map[string]struct {
+		opts     []CallOption
+		expected string
+	}{
+		"default target": {
+			expected: "default",
+		},
+		"host override": {
+			opts:     []CallOption{Host(strings.TrimPrefix(tenant.URL, "http://"))},
+			expected: "tenant",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			var res wrapperspb.StringValue
+			if err := client.Invoke(context.Background(), "/service/Method", wrapperspb.String("nano"), &res, c.opts...); err != nil {
+				t.Fatalf("Invoke should not return an error, but got '%s'", err)
+			}
+			if res.Value != c.expected {
+				t.Errorf("expected the call to be sent to %s, but got %s", c.expected, res.Value)
+			}
+
+			stream, err := client.NewStream(context.Background(), &grpc.StreamDesc{ServerStreams: true}, "/service/Method", c.opts...)
+			if err != nil {
+				t.Fatalf("NewStream should not return an error, but got '%s'", err)
+			}
+			if err := stream.SendMsg(wrapperspb.String("nano")); err != nil {
+				t.Fatalf("SendMsg should not return an error, but got '%s'", err)
+			}
+			if err := stream.RecvMsg(&res); err != nil {
+				t.Fatalf("RecvMsg should not return an error, but got '%s'", err)
+			}
+			if res.Value != c.expected {
+				t.Errorf("expected the stream to be sent to %s, but got %s", c.expected, res.Value)
+			}
+		})
+	}
+}
+
+func TestRunBidiStream(t *testing.T) {
+	upgrader := websocket.Upgrader{Subprotocols: []string{"grpc-websockets"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.BinaryMessage, []byte{0x00})
+		conn.WriteMessage(websocket.BinaryMessage, []byte("content-type: application/grpc-web+proto\r\n"))
+		// Echo the request messages until the end of the sending.
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if bytes.Equal(msg, []byte{0x01}) {
+				break
+			}
+			if len(msg) > 5 && msg[0] == 0x00 {
+				conn.WriteMessage(websocket.BinaryMessage, msg[1:6])
+				conn.WriteMessage(websocket.BinaryMessage, msg[6:])
+			}
+		}
+		trailer := []byte("grpc-status: 0\r\nx-end: yes\r\n")
+		conn.WriteMessage(websocket.BinaryMessage, []byte{0x80, 0, 0, 0, byte(len(trailer))})
+		conn.WriteMessage(websocket.BinaryMessage, trailer)
+	}))
+	defer srv.Close()
+	client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure())
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+
+	errStop := errors.New("stop")
+	send := func(s SendStream) error {
+		for _, v := range []string{"nano", "yuko", "mio"} {
+			if err := s.SendMsg(wrapperspb.String(v)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	cases := map[string]struct {
+		send        func(s SendStream) error
+		stopAt      int
+		expected    []string
+		expectedErr error
+	}{
+		"echo": {
+			send:     send,
+			expected: []string{"nano", "yuko", "mio"},
+		},
+		"send error": {
+			send: func(s SendStream) error {
+				return errStop
+			},
+			expectedErr: errStop,
+		},
+		"recv error": {
+			send:        send,
+			stopAt:      2,
+			expected:    []string{"nano", "yuko"},
+			expectedErr: errStop,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			var got []string
+			trailer, err := RunBidiStream(context.Background(), client, "/service/Method", c.send, func(res *wrapperspb.StringValue) error {
+				got = append(got, res.Value)
+				if len(got) == c.stopAt {
+					return errStop
+				}
+				return nil
+			})
+			if !errors.Is(err, c.expectedErr) {
+				t.Fatalf("expected the error '%v', but got '%v'", c.expectedErr, err)
+			}
+			if diff := cmp.Diff(c.expected, got); diff != "" {
+				t.Errorf("-want, +got\n%s", diff)
+			}
+			if c.expectedErr == nil {
+				if v := trailer.Get("x-end"); len(v) != 1 || v[0] != "yes" {
+					t.Errorf("expected the trailer x-end to be yes, but got %v", trailer)
+				}
+			}
+		})
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	frame := func(v string) []byte {
+		b, _ := proto.Marshal(wrapperspb.String(v))
+		return append([]byte{0, 0, 0, 0, byte(len(b))}, b...)
+	}
+	var (
+		mu       sync.Mutex
+		requests []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req wrapperspb.StringValue
+		if len(body) >= 5 {
+			proto.Unmarshal(body[5:], &req)
+		}
+		mu.Lock()
+		requests = append(requests, req.Value)
+		n := len(requests)
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/grpc-web+proto")
+		switch n {
+		case 1:
+			w.Write(frame("1"))
+			w.Write(frame("2"))
+			w.Write(append([]byte{0x80, 0, 0, 0, 16}, "grpc-status: 0\r\n"...))
+		default:
+			// The resumed stream repeats the last message.
+			w.Write(frame("2"))
+			w.Write(frame("3"))
+			w.Write(append([]byte{0x80, 0, 0, 0, 16}, "grpc-status: 5\r\n"...))
+		}
+	}))
+	defer srv.Close()
+	client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure())
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+
+	sub := Subscribe(context.Background(), client, "/service/Watch", SubscribeConfig[wrapperspb.StringValue]{
+		Request: func(token string) any { return wrapperspb.String(token) },
+		Token:   func(msg *wrapperspb.StringValue) string { return msg.Value },
+		Backoff: backoff.Config{BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+	})
+	var got []string
+	for msg := range sub.C {
+		got = append(got, msg.Value)
+	}
+	if diff := cmp.Diff([]string{"1", "2", "3"}, got); diff != "" {
+		t.Errorf("-want, +got\n%s", diff)
+	}
+	if code := status.Code(sub.Err()); code != codes.NotFound {
+		t.Errorf("expected the subscription to end with NotFound, but got '%v'", sub.Err())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if diff := cmp.Diff([]string{"", "2"}, requests); diff != "" {
+		t.Errorf("-want, +got\n%s", diff)
+	}
+}
+
+func TestChainInterceptors(t *testing.T) {
+	resMsg, _ := proto.Marshal(wrapperspb.String("yuko"))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc-web+proto")
+		w.Write(append([]byte{0, 0, 0, 0, byte(len(resMsg))}, resMsg...))
+		w.Write(append([]byte{0x80, 0, 0, 0, 16}, "grpc-status: 0\r\n"...))
+	}))
+	defer srv.Close()
+
+	var calls []string
+	unary := func(name string) UnaryClientInterceptor {
+		return func(ctx context.Context, method string, req, reply any, cc *ClientConn, invoker UnaryInvoker, opts ...CallOption) error {
+			calls = append(calls, name)
+			err := invoker(ctx, method, req, reply, cc, opts...)
+			calls = append(calls, name+" done")
+			return err
+		}
+	}
+	stream := func(name string) StreamClientInterceptor {
+		return func(ctx context.Context, desc *grpc.StreamDesc, cc *ClientConn, method string, streamer Streamer, opts ...CallOption) (Stream, error) {
+			calls = append(calls, name)
+			s, err := streamer(ctx, desc, cc, method, opts...)
+			calls = append(calls, name+" done")
+			return s, err
+		}
+	}
+	client, err := NewClient(
+		strings.TrimPrefix(srv.URL, "http://"),
+		WithInsecure(),
+		WithChainUnaryInterceptor(unary("first"), unary("second")),
+		WithUnaryInterceptor(unary("single")),
+		WithChainUnaryInterceptor(unary("third")),
+		WithChainStreamInterceptor(stream("first"), stream("second")),
+	)
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+
+	cases := map[string]struct {
+		call     func() error
+		expected []string
+	}{
+		"unary": {
+			call: func() error {
+				return client.Invoke(context.Background(), "/service/Method", wrapperspb.String("nano"), &wrapperspb.StringValue{})
+			},
+			expected: []string{"single", "first", "second", "third", "third done", "second done", "first done", "single done"},
+		},
+		"stream": {
+			call: func() error {
+				_, err := client.NewStream(context.Background(), &grpc.StreamDesc{ServerStreams: true}, "/service/Method")
+				return err
+			},
+			expected: []string{"first", "second", "second done", "first done"},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			calls = nil
+			if err := c.call(); err != nil {
+				t.Fatalf("the call should not return an error, but got '%s'", err)
+			}
+			if diff := cmp.Diff(c.expected, calls); diff != "" {
+				t.Errorf("-want, +got\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSequence(t *testing.T) {
+	sequencer := Sequencer{
+		Stamp: func(msg any, seq uint64) {
+			msg.(*wrapperspb.StringValue).Value = strconv.FormatUint(seq, 10)
+		},
+		Sequence: func(msg any) (uint64, bool) {
+			n, err := strconv.ParseUint(msg.(*wrapperspb.StringValue).Value, 10, 64)
+			return n, err == nil
+		},
+	}
+	strict := sequencer
+	strict.Strict = true
+
+	cases := map[string]struct {
+		responses   []string
+		sequencer   Sequencer
+		expected    CallStats
+		expectedErr codes.Code
+	}{
+		"in order": {
+			responses: []string{"4", "5", "6"},
+			sequencer: sequencer,
+			expected:  CallStats{MessagesSent: 1, MessagesReceived: 3},
+		},
+		"missing and duplicated": {
+			responses: []string{"1", "3", "3", "x", "4"},
+			sequencer: sequencer,
+			expected:  CallStats{MessagesSent: 1, MessagesReceived: 5, MissingMessages: 1, DuplicateMessages: 1},
+		},
+		"strict": {
+			responses:   []string{"1", "3"},
+			sequencer:   strict,
+			expected:    CallStats{MessagesSent: 1, MessagesReceived: 2, MissingMessages: 1},
+			expectedErr: codes.DataLoss,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			var stamped string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				var req wrapperspb.StringValue
+				if len(body) >= 5 {
+					proto.Unmarshal(body[5:], &req)
+				}
+				stamped = req.Value
+				w.Header().Set("Content-Type", "application/grpc-web+proto")
+				for _, v := range c.responses {
+					b, _ := proto.Marshal(wrapperspb.String(v))
+					w.Write(append([]byte{0, 0, 0, 0, byte(len(b))}, b...))
+				}
+				w.Write(append([]byte{0x80, 0, 0, 0, 16}, "grpc-status: 0\r\n"...))
+			}))
+			defer srv.Close()
+			client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure())
+			if err != nil {
+				t.Fatalf("NewClient should not return an error, but got '%s'", err)
+			}
+
+			var callStats CallStats
+			stream, err := client.NewStream(context.Background(), &grpc.StreamDesc{ServerStreams: true}, "/service/Method", Sequence(c.sequencer), Stats(&callStats))
+			if err != nil {
+				t.Fatalf("NewStream should not return an error, but got '%s'", err)
+			}
+			if err := stream.SendMsg(wrapperspb.String("")); err != nil {
+				t.Fatalf("SendMsg should not return an error, but got '%s'", err)
+			}
+			for {
+				err = stream.RecvMsg(&wrapperspb.StringValue{})
+				if err != nil {
+					break
+				}
+			}
+			if err == io.EOF {
+				err = nil
+			}
+			if code := status.Code(err); code != c.expectedErr {
+				t.Errorf("expected the code %s, but got '%v'", c.expectedErr, err)
+			}
+			if stamped != "1" {
+				t.Errorf("expected the request to be stamped with 1, but got %q", stamped)
+			}
+			if diff := cmp.Diff(c.expected, callStats, cmp.FilterPath(func(p cmp.Path) bool {
+				return p.Last().String() == ".URL"
+			}, cmp.Ignore())); diff != "" {
+				t.Errorf("-want, +got\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPropagatedMetadata(t *testing.T) {
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		w.Header().Set("Content-Type", "application/grpc-web+proto")
+		w.Write(append([]byte{0x80, 0, 0, 0, 16}, "grpc-status: 0\r\n"...))
+	}))
+	defer srv.Close()
+	client, err := NewClient(
+		strings.TrimPrefix(srv.URL, "http://"),
+		WithInsecure(),
+		WithPropagation(Propagation{DeadlineMargin: time.Second}),
+		WithPropagatedMetadata("authorization"),
+		WithPropagatedMetadata("x-request-id"),
+	)
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "token", "x-request-id", "1", "cookie", "secret"))
+	if err := client.Invoke(ctx, "/service/Method", &emptypb.Empty{}, &emptypb.Empty{}); err != nil {
+		t.Fatalf("Invoke should not return an error, but got '%s'", err)
+	}
+	for k, v := range map[string]string{"Authorization": "token", "X-Request-Id": "1", "Cookie": ""} {
+		if got := header.Get(k); got != v {
+			t.Errorf("expected the header %s to be %q, but got %q", k, v, got)
+		}
+	}
+	if client.dialOptions.propagation.DeadlineMargin != time.Second {
+		t.Errorf("expected the deadline margin to be kept, but got %s", client.dialOptions.propagation.DeadlineMargin)
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	classes := []*ErrorClass{ErrTransport, ErrProtocol, ErrCanceled, ErrGateway}
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closedHost := strings.TrimPrefix(closed.URL, "http://")
+	closed.Close()
+
+	cases := map[string]struct {
+		handler      http.HandlerFunc
+		host         string
+		canceled     bool
+		expected     *ErrorClass
+		expectedCode codes.Code
+	}{
+		"transport": {
+			host:         closedHost,
+			expected:     ErrTransport,
+			expectedCode: codes.Unknown,
+		},
+		"gateway": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				w.WriteHeader(http.StatusBadGateway)
+			},
+			expected:     ErrGateway,
+			expectedCode: codes.Unavailable,
+		},
+		"protocol": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/grpc-web+proto")
+				w.Write(append([]byte{0x80, 0, 0, 0, 9}, "malformed"...))
+			},
+			expected:     ErrProtocol,
+			expectedCode: codes.Internal,
+		},
+		"canceled": {
+			handler:      func(w http.ResponseWriter, r *http.Request) {},
+			canceled:     true,
+			expected:     ErrCanceled,
+			expectedCode: codes.Canceled,
+		},
+		"server status": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/grpc-web+proto")
+				w.Write(append([]byte{0x80, 0, 0, 0, 16}, "grpc-status: 5\r\n"...))
+			},
+			expectedCode: codes.NotFound,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			host := c.host
+			if c.handler != nil {
+				srv := httptest.NewServer(c.handler)
+				defer srv.Close()
+				host = strings.TrimPrefix(srv.URL, "http://")
+			}
+			client, err := NewClient(host, WithInsecure())
+			if err != nil {
+				t.Fatalf("NewClient should not return an error, but got '%s'", err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			if c.canceled {
+				cancel()
+			}
+			defer cancel()
+
+			err = client.Invoke(ctx, "/service/Method", &emptypb.Empty{}, &emptypb.Empty{})
+			for _, class := range classes {
+				if got := errors.Is(err, class); got != (class == c.expected) {
+					t.Errorf("expected errors.Is(err, %s) to be %t, but got '%v'", class, class == c.expected, err)
+				}
+			}
+			if code := status.Code(err); code != c.expectedCode {
+				t.Errorf("expected the code %s, but got %s", c.expectedCode, code)
+			}
+		})
+	}
+}
+
+func TestAdaptiveConcurrency(t *testing.T) {
+	block := make(chan struct{})
+	clock := transporttest.NewClock(time.Now())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-block") != "" {
+			<-block
+		}
+		if d, err := time.ParseDuration(r.Header.Get("x-latency")); err == nil {
+			clock.Advance(d)
+		}
+		w.Header().Set("Content-Type", "application/grpc-web+proto")
+		if code := r.Header.Get("x-code"); code != "" {
+			w.Header().Set("grpc-status", code)
+			return
+		}
+		trailer := []byte("grpc-status: 0\r\n")
+		w.Write(header(0))
+		w.Write(append([]byte{0x80, 0, 0, 0, byte(len(trailer))}, trailer...))
+	}))
+	defer srv.Close()
+	defer close(block)
+
+	client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithClock(clock), WithAdaptiveConcurrency(AdaptiveConcurrency{
+		InitialLimit: 3,
+		BackoffRatio: 0.5,
+	}))
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+	invoke := func(kv ...string) error {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), kv...)
+		return client.InvokeEmptyRequest(ctx, "/service/Method", &emptypb.Empty{})
+	}
+
+	if err := invoke("x-latency", "10ms"); err != nil {
+		t.Fatalf("InvokeEmptyRequest should not return an error, but got '%s'", err)
+	}
+	if limit, _ := client.ConcurrencyLimit(); limit != 3 {
+		t.Errorf("expected the limit to stay 3 below half of it in use, but got %d", limit)
+	}
+	if err := invoke("x-code", strconv.Itoa(int(codes.Unavailable))); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected the call to fail with %s, but got '%v'", codes.Unavailable, err)
+	}
+	if limit, _ := client.ConcurrencyLimit(); limit != 1 {
+		t.Errorf("expected the limit to shrink to 1, but got %d", limit)
+	}
+
+	done := make(chan error)
+	go func() { done <- invoke("x-block", "1", "x-latency", "10ms") }()
+	for {
+		if _, inFlight := client.ConcurrencyLimit(); inFlight == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := invoke(); !errors.Is(err, ErrConcurrencyLimitExceeded) {
+		t.Errorf("expected the call exceeding the limit to fail with ErrConcurrencyLimitExceeded, but got '%v'", err)
+	}
+	block <- struct{}{}
+	if err := <-done; err != nil {
+		t.Fatalf("InvokeEmptyRequest should not return an error, but got '%s'", err)
+	}
+	if limit, inFlight := client.ConcurrencyLimit(); limit != 2 || inFlight != 0 {
+		t.Errorf("expected the limit to grow to 2 with no call in flight, but got %d and %d", limit, inFlight)
+	}
+	if err := invoke("x-latency", "30ms"); err != nil {
+		t.Fatalf("InvokeEmptyRequest should not return an error, but got '%s'", err)
+	}
+	if limit, _ := client.ConcurrencyLimit(); limit != 1 {
+		t.Errorf("expected a slow call to shrink the limit to 1, but got %d", limit)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	block := make(chan struct{})
+	received := make(chan string, 3)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-block") != "" {
+			<-block
+		}
+		received <- r.Header.Get("x-name")
+		w.Header().Set("Content-Type", "application/grpc-web+proto")
+		trailer := []byte("grpc-status: 0\r\n")
+		w.Write(header(0))
+		w.Write(append([]byte{0x80, 0, 0, 0, byte(len(trailer))}, trailer...))
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithClock(transporttest.NewClock(time.Now())), WithAdaptiveConcurrency(AdaptiveConcurrency{
+		InitialLimit: 1,
+		MaxLimit:     1,
+		MaxWait:      time.Hour,
+	}))
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+	invoke := func(p PriorityClass, kv ...string) error {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), kv...)
+		return client.InvokeEmptyRequest(ctx, "/service/Method", &emptypb.Empty{}, Priority(p))
+	}
+	waitFor := func(inFlight, waiters int) {
+		for {
+			client.concurrency.mu.Lock()
+			n, m := client.concurrency.inFlight, len(client.concurrency.waiters)
+			client.concurrency.mu.Unlock()
+			if n == inFlight && m == waiters {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	done := make(chan error, 3)
+	go func() { done <- invoke(PriorityNormal, "x-name", "blocking", "x-block", "1") }()
+	waitFor(1, 0)
+	if err := invoke(PriorityLow, "x-name", "low"); !errors.Is(err, ErrConcurrencyLimitExceeded) {
+		t.Errorf("expected the low priority call to be shed with ErrConcurrencyLimitExceeded, but got '%v'", err)
+	}
+	go func() { done <- invoke(PriorityNormal, "x-name", "normal") }()
+	waitFor(1, 1)
+	go func() { done <- invoke(PriorityHigh, "x-name", "high") }()
+	waitFor(1, 2)
+
+	close(block)
+	for i := 0; i < 3; i++ {
+		if err := <-done; err != nil {
+			t.Fatalf("InvokeEmptyRequest should not return an error, but got '%s'", err)
+		}
+	}
+	var order []string
+	for i := 0; i < 3; i++ {
+		order = append(order, <-received)
+	}
+	if diff := cmp.Diff([]string{"blocking", "high", "normal"}, order); diff != "" {
+		t.Errorf("expected the high priority call to be served first, -want, +got\n%s", diff)
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	cases := map[string]struct {
+		header   http.Header
+		expected *RateLimit
+	}{
+		"none": {
+			header: http.Header{},
+		},
+		"all": {
+			header:   http.Header{"Ratelimit-Limit": {"100"}, "Ratelimit-Remaining": {"42"}, "Ratelimit-Reset": {"30"}},
+			expected: &RateLimit{Limit: 100, Remaining: 42, Reset: 30 * time.Second},
+		},
+		"quota policy": {
+			header:   http.Header{"Ratelimit-Limit": {"100, 100;w=60"}, "Ratelimit-Remaining": {"0"}},
+			expected: &RateLimit{Limit: 100},
+		},
+		"unix time": {
+			header:   http.Header{"Ratelimit-Remaining": {"1"}, "Ratelimit-Reset": {"1700000005"}},
+			expected: &RateLimit{Remaining: 1, Reset: 5 * time.Second},
+		},
+		"invalid": {
+			header: http.Header{"Ratelimit-Remaining": {"-1"}},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			rl, _ := parseRateLimit(c.header, now)
+			if diff := cmp.Diff(c.expected, rl); diff != "" {
+				t.Errorf("-want, +got\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRateLimitThrottling(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/grpc-web+proto")
+		w.Header().Set("RateLimit-Limit", "2")
+		w.Header().Set("RateLimit-Remaining", "0")
+		w.Header().Set("RateLimit-Reset", "10")
+		trailer := []byte("grpc-status: 0\r\n")
+		w.Write(header(0))
+		w.Write(append([]byte{0x80, 0, 0, 0, byte(len(trailer))}, trailer...))
+	}))
+	defer srv.Close()
+
+	clock := transporttest.NewClock(time.Now())
+	client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithClock(clock), WithRateLimitThrottling())
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+
+	var s CallStats
+	if err := client.InvokeEmptyRequest(context.Background(), "/service/Method", &emptypb.Empty{}, Stats(&s)); err != nil {
+		t.Fatalf("InvokeEmptyRequest should not return an error, but got '%s'", err)
+	}
+	if diff := cmp.Diff(&RateLimit{Limit: 2, Reset: 10 * time.Second}, s.RateLimit); diff != "" {
+		t.Errorf("-want, +got\n%s", diff)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := client.InvokeEmptyRequest(ctx, "/service/Method", &emptypb.Empty{}); !errors.Is(err, ErrRateLimitExceeded) {
+		t.Errorf("expected the canceled call to fail with ErrRateLimitExceeded, but got '%v'", err)
+	}
+
+	done := make(chan error)
+	go func() { done <- client.InvokeEmptyRequest(context.Background(), "/service/Method", &emptypb.Empty{}) }()
+	clock.BlockUntil(1)
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("expected the call to be held back until the reset, but the server got %d calls", n)
+	}
+	clock.Advance(10 * time.Second)
+	if err := <-done; err != nil {
+		t.Fatalf("InvokeEmptyRequest should not return an error, but got '%s'", err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("expected the server to get 2 calls, but got %d", n)
+	}
+}
+
+func TestMessageChunking(t *testing.T) {
+	// chunks returns the chunk frames of payload.
+	chunks := func(payload []byte, size int) []byte {
+		var b []byte
+		for {
+			n := min(len(payload), size-1)
+			more := byte(0)
+			if n < len(payload) {
+				more = 1
+			}
+			b = append(b, 0, 0, 0, 0, byte(n+1), more)
+			b = append(b, payload[:n]...)
+			payload = payload[n:]
+			if len(payload) == 0 {
+				return b
+			}
+		}
+	}
+	req := wrapperspb.String("nano, yuko and mio")
+	reqMsg, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatalf("proto.Marshal should not return an error, but got '%s'", err)
+	}
+	res := wrapperspb.String("hakase and sakamoto")
+	resMsg, err := proto.Marshal(res)
+	if err != nil {
+		t.Fatalf("proto.Marshal should not return an error, but got '%s'", err)
+	}
+	trailer := []byte("grpc-status: 0\r\n")
+	trailerFrame := append([]byte{0x80, 0, 0, 0, byte(len(trailer))}, trailer...)
+
+	var (
+		received     [][]byte
+		announcement []string
+	)
+	upgrader := websocket.Upgrader{Subprotocols: []string{"grpc-websockets"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !websocket.IsWebSocketUpgrade(r) {
+			announcement = append(announcement, r.Header.Get("Grpc-Web-Chunking"))
+			b, _ := io.ReadAll(r.Body)
+			received = append(received, b)
+			w.Header().Set("Content-Type", "application/grpc-web+proto")
+			w.Header().Set("Grpc-Web-Chunking", "8")
+			w.Write(chunks(resMsg, 8))
+			w.Write(trailerFrame)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var b []byte
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if bytes.Equal(msg, []byte{0x01}) {
+				break
+			}
+			if msg[0] == 0x00 {
+				b = append(b, msg[1:]...)
+			} else if strings.Contains(strings.ToLower(string(msg)), "grpc-web-chunking: 8") {
+				announcement = append(announcement, "8")
+			}
+		}
+		received = append(received, b)
+		conn.WriteMessage(websocket.BinaryMessage, []byte{0x00})
+		conn.WriteMessage(websocket.BinaryMessage, []byte("content-type: application/grpc-web+proto\r\ngrpc-web-chunking: 8\r\n"))
+		frames := chunks(resMsg, 8)
+		for len(frames) > 0 {
+			n := 5 + int(frames[4])
+			conn.WriteMessage(websocket.BinaryMessage, frames[:5])
+			conn.WriteMessage(websocket.BinaryMessage, frames[5:n])
+			frames = frames[n:]
+		}
+		conn.WriteMessage(websocket.BinaryMessage, trailerFrame[:5])
+		conn.WriteMessage(websocket.BinaryMessage, trailerFrame[5:])
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithMessageChunking(8))
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+	for i := 0; i < 2; i++ {
+		var reply wrapperspb.StringValue
+		if err := client.Invoke(context.Background(), "/service/Method", req, &reply); err != nil {
+			t.Fatalf("Invoke should not return an error, but got '%s'", err)
+		}
+		if reply.Value != res.Value {
+			t.Errorf("expected the reassembled response %q, but got %q", res.Value, reply.Value)
+		}
+	}
+
+	stream, err := client.NewStream(context.Background(), &grpc.StreamDesc{ClientStreams: true, ServerStreams: true}, "/service/Method")
+	if err != nil {
+		t.Fatalf("NewStream should not return an error, but got '%s'", err)
+	}
+	if err := stream.SendMsg(req); err != nil {
+		t.Fatalf("SendMsg should not return an error, but got '%s'", err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatalf("CloseSend should not return an error, but got '%s'", err)
+	}
+	var reply wrapperspb.StringValue
+	if err := stream.RecvMsg(&reply); err != nil {
+		t.Fatalf("RecvMsg should not return an error, but got '%s'", err)
+	}
+	if reply.Value != res.Value {
+		t.Errorf("expected the reassembled response %q, but got %q", res.Value, reply.Value)
+	}
+	if err := stream.RecvMsg(&reply); err != io.EOF {
+		t.Errorf("expected io.EOF after the last message, but got '%v'", err)
+	}
+
+	if diff := cmp.Diff([]string{"8", "8", "8"}, announcement); diff != "" {
+		t.Errorf("announcements: -want, +got\n%s", diff)
+	}
+	// The first request is sent before the server advertised the chunking.
+	expected := [][]byte{
+		append([]byte{0, 0, 0, 0, byte(len(reqMsg))}, reqMsg...),
+		chunks(reqMsg, 8),
+		chunks(reqMsg, 8),
+	}
+	if diff := cmp.Diff(expected, received); diff != "" {
+		t.Errorf("request bodies: -want, +got\n%s", diff)
+	}
+}
+
+func TestMaxBufferSize(t *testing.T) {
+	resMsg, err := proto.Marshal(wrapperspb.String(strings.Repeat("a", 64)))
+	if err != nil {
+		t.Fatalf("proto.Marshal should not return an error, but got '%s'", err)
+	}
+	frame := append([]byte{0, 0, 0, 0, byte(len(resMsg))}, resMsg...)
+	trailer := []byte("grpc-status: 0\r\n")
+	trailerFrame := append([]byte{0x80, 0, 0, 0, byte(len(trailer))}, trailer...)
+
+	upgrader := websocket.Upgrader{Subprotocols: []string{"grpc-websockets"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !websocket.IsWebSocketUpgrade(r) {
+			w.Header().Set("Content-Type", "application/grpc-web+proto")
+			w.Write(frame)
+			w.Write(trailerFrame)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.BinaryMessage, []byte{0x00})
+		conn.WriteMessage(websocket.BinaryMessage, []byte("content-type: application/grpc-web+proto\r\n"))
+		conn.WriteMessage(websocket.BinaryMessage, frame[:5])
+		conn.WriteMessage(websocket.BinaryMessage, frame[5:])
+		conn.ReadMessage()
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithMaxBufferSize(32))
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+	recv := func(desc *grpc.StreamDesc) error {
+		stream, err := client.NewStream(context.Background(), desc, "/service/Method")
+		if err != nil {
+			return err
+		}
+		if err := stream.SendMsg(wrapperspb.String("nano")); err != nil {
+			return err
+		}
+		return stream.RecvMsg(&wrapperspb.StringValue{})
+	}
+	cases := map[string]struct {
+		call func() error
+	}{
+		"unary": {
+			call: func() error {
+				return client.Invoke(context.Background(), "/service/Method", wrapperspb.String("nano"), &wrapperspb.StringValue{})
+			},
+		},
+		"server stream": {
+			call: func() error {
+				return recv(&grpc.StreamDesc{ServerStreams: true})
+			},
+		},
+		"websocket stream": {
+			call: func() error {
+				return recv(&grpc.StreamDesc{ClientStreams: true, ServerStreams: true})
+			},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := c.call()
+			if !errors.Is(err, transport.ErrBufferLimitExceeded) {
+				t.Errorf("expected the error to match ErrBufferLimitExceeded, but got '%v'", err)
+			}
+			if code := status.Code(err); code != codes.ResourceExhausted {
+				t.Errorf("expected code %s, but got %s", codes.ResourceExhausted, code)
+			}
+		})
+	}
+}
+
+func TestBufferSizes(t *testing.T) {
+	msg, err := proto.Marshal(wrapperspb.String(strings.Repeat("a", 64)))
+	if err != nil {
+		t.Fatalf("proto.Marshal should not return an error, but got '%s'", err)
+	}
+	frame := append([]byte{0, 0, 0, 0, byte(len(msg))}, msg...)
+	trailer := []byte("grpc-status: 0\r\n")
+	trailerFrame := append([]byte{0x80, 0, 0, 0, byte(len(trailer))}, trailer...)
+
+	upgrader := websocket.Upgrader{Subprotocols: []string{"grpc-websockets"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !websocket.IsWebSocketUpgrade(r) {
+			w.Header().Set("Content-Type", "application/grpc-web+proto")
+			w.Write(append(frame, trailerFrame...))
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.BinaryMessage, []byte{0x00})
+		conn.WriteMessage(websocket.BinaryMessage, []byte("content-type: application/grpc-web+proto\r\n"))
+		// Echo the request message, larger than the write buffer, which
+		// follows the request headers.
+		var req []byte
+		for len(req) <= 6 || req[0] != 0x00 {
+			if _, req, err = conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+		conn.WriteMessage(websocket.BinaryMessage, req[1:6])
+		conn.WriteMessage(websocket.BinaryMessage, req[6:])
+		conn.WriteMessage(websocket.BinaryMessage, trailerFrame[:5])
+		conn.WriteMessage(websocket.BinaryMessage, trailerFrame[5:])
+	}))
+	defer srv.Close()
+
+	// The frames span several fills of the smallest buffers.
+	client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithReadBufferSize(16), WithWriteBufferSize(16))
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+	cases := map[string]struct {
+		desc *grpc.StreamDesc
+	}{
+		"unary":            {},
+		"server stream":    {desc: &grpc.StreamDesc{ServerStreams: true}},
+		"websocket stream": {desc: &grpc.StreamDesc{ClientStreams: true, ServerStreams: true}},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			req := wrapperspb.String(strings.Repeat("a", 64))
+			var res wrapperspb.StringValue
+			if c.desc == nil {
+				if err := client.Invoke(context.Background(), "/service/Method", req, &res); err != nil {
+					t.Fatalf("Invoke should not return an error, but got '%s'", err)
+				}
+			} else {
+				stream, err := client.NewStream(context.Background(), c.desc, "/service/Method")
+				if err != nil {
+					t.Fatalf("NewStream should not return an error, but got '%s'", err)
+				}
+				if err := stream.SendMsg(req); err != nil {
+					t.Fatalf("SendMsg should not return an error, but got '%s'", err)
+				}
+				if err := stream.RecvMsg(&res); err != nil {
+					t.Fatalf("RecvMsg should not return an error, but got '%s'", err)
+				}
+			}
+			if res.Value != req.Value {
+				t.Errorf("expected the response %q, but got %q", req.Value, res.Value)
+			}
+		})
+	}
+}
+
+func TestURLRewriter(t *testing.T) {
+	var requestURI string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestURI = r.URL.RequestURI()
+		w.Header().Set("Content-Type", "application/grpc-web+proto")
+		trailer := []byte("grpc-status: 0\r\n")
+		w.Write(header(0))
+		w.Write(append([]byte{0x80, 0, 0, 0, byte(len(trailer))}, trailer...))
+	}))
+	defer srv.Close()
+
+	errRewrite := errors.New("rewrite")
+	cases := map[string]struct {
+		rewriter    URLRewriter
+		expectedURI string
+		expectedErr error
+	}{
+		"path and query": {
+			rewriter: func(method string, u *url.URL) error {
+				u.Path = "/rpc"
+				u.RawQuery = url.Values{"method": {method}}.Encode()
+				return nil
+			},
+			expectedURI: "/rpc?method=%2Fservice%2FMethod",
+		},
+		"error": {
+			rewriter: func(string, *url.URL) error {
+				return errRewrite
+			},
+			expectedErr: errRewrite,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			requestURI = ""
+			client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithURLRewriter(c.rewriter))
+			if err != nil {
+				t.Fatalf("NewClient should not return an error, but got '%s'", err)
+			}
+
+			var s CallStats
+			err = client.InvokeEmptyRequest(context.Background(), "/service/Method", &emptypb.Empty{}, Stats(&s))
+			if !errors.Is(err, c.expectedErr) {
+				t.Fatalf("expected the error '%v', but got '%v'", c.expectedErr, err)
+			}
+			if requestURI != c.expectedURI {
+				t.Errorf("expected the server to receive %q, but got %q", c.expectedURI, requestURI)
+			}
+			if c.expectedErr != nil {
+				return
+			}
+			if expected := srv.URL + c.expectedURI; s.URL != expected {
+				t.Errorf("expected the stats to report the URL %q, but got %q", expected, s.URL)
+			}
+		})
+	}
+}
+
+func TestLowercaseHeaders(t *testing.T) {
+	trailer := []byte("grpc-status: 0\r\n")
+	trailerFrame := append([]byte{0x80, 0, 0, 0, byte(len(trailer))}, trailer...)
+
+	t.Run("http", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("net.Listen should not return an error, but got '%s'", err)
+		}
+		defer l.Close()
+		// The raw request is captured, as net/http canonicalizes the keys.
+		raw := make(chan string, 1)
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			var b bytes.Buffer
+			req, err := http.ReadRequest(bufio.NewReader(io.TeeReader(conn, &b)))
+			if err != nil {
+				return
+			}
+			io.ReadAll(req.Body)
+			raw <- b.String()
+			res := &http.Response{
+				StatusCode:    http.StatusOK,
+				ProtoMajor:    1,
+				ProtoMinor:    1,
+				Header:        http.Header{"Content-Type": {"application/grpc-web+proto"}},
+				Body:          io.NopCloser(bytes.NewReader(trailerFrame)),
+				ContentLength: int64(len(trailerFrame)),
+				Close:         true,
+			}
+			res.Write(conn)
+		}()
+
+		client, err := NewClient(l.Addr().String(), WithInsecure(), WithLowercaseHeaders())
+		if err != nil {
+			t.Fatalf("NewClient should not return an error, but got '%s'", err)
+		}
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "X-User-Id", "nano")
+		if err := client.InvokeEmptyRequest(ctx, "/service/Method", &emptypb.Empty{}); err != nil {
+			t.Fatalf("InvokeEmptyRequest should not return an error, but got '%s'", err)
+		}
+
+		req := <-raw
+		for _, k := range []string{"content-type", "x-grpc-web", "x-user-id"} {
+			if !strings.Contains(req, "\r\n"+k+": ") {
+				t.Errorf("expected the request to carry the lowercase key %q, but got:\n%s", k, req)
+			}
+			if canonical := http.CanonicalHeaderKey(k); strings.Contains(req, "\r\n"+canonical+": ") {
+				t.Errorf("expected the request not to carry the canonical key %q, but got:\n%s", canonical, req)
+			}
+		}
+	})
+
+	t.Run("websocket", func(t *testing.T) {
+		headerFrame := make(chan string, 1)
+		upgrader := websocket.Upgrader{Subprotocols: []string{"grpc-websockets"}}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			headerFrame <- string(msg)
+			conn.WriteMessage(websocket.BinaryMessage, []byte{0x00})
+			conn.WriteMessage(websocket.BinaryMessage, []byte("content-type: application/grpc-web+proto\r\n"))
+			conn.WriteMessage(websocket.BinaryMessage, trailerFrame[:5])
+			conn.WriteMessage(websocket.BinaryMessage, trailerFrame[5:])
+			conn.ReadMessage()
+		}))
+		defer srv.Close()
+
+		client, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithLowercaseHeaders())
+		if err != nil {
+			t.Fatalf("NewClient should not return an error, but got '%s'", err)
+		}
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "X-User-Id", "nano")
+		stream, err := client.NewStream(ctx, &grpc.StreamDesc{ClientStreams: true, ServerStreams: true}, "/service/Method")
+		if err != nil {
+			t.Fatalf("NewStream should not return an error, but got '%s'", err)
+		}
+		if err := stream.SendMsg(&emptypb.Empty{}); err != nil {
+			t.Fatalf("SendMsg should not return an error, but got '%s'", err)
+		}
+
+		h := <-headerFrame
+		for _, k := range []string{"content-type", "x-grpc-web", "x-user-id"} {
+			if !strings.Contains(h, k+": ") {
+				t.Errorf("expected the header frame to carry the lowercase key %q, but got:\n%s", k, h)
+			}
+			if canonical := http.CanonicalHeaderKey(k); strings.Contains(h, canonical+": ") {
+				t.Errorf("expected the header frame not to carry the canonical key %q, but got:\n%s", canonical, h)
+			}
+		}
+	})
+}
+
+func TestGRPCClientConnInterface(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}
+		if r.URL.Path == "/grpc.health.v1.Health/Watch" {
+			res.Status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		resMsg, _ := proto.Marshal(res)
+		w.Header().Set("Content-Type", "application/grpc-web+proto")
+		w.Header().Set("x-served-by", "gateway")
+		w.Write(append(header(len(resMsg)), resMsg...))
+		trailer := []byte("grpc-status: 0\r\nx-trailer: 1\r\n")
+		w.Write(append([]byte{0x80, 0, 0, 0, byte(len(trailer))}, trailer...))
+	}))
+	defer srv.Close()
+	conn, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), WithInsecure())
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+	client := healthpb.NewHealthClient(conn)
+
+	t.Run("unary", func(t *testing.T) {
+		var md, trailer metadata.MD
+		res, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{}, grpc.Header(&md), grpc.Trailer(&trailer), grpc.MaxCallRecvMsgSize(1<<20))
+		if err != nil {
+			t.Fatalf("Check should not return an error, but got '%s'", err)
+		}
+		if res.Status != healthpb.HealthCheckResponse_SERVING {
+			t.Errorf("expected the status SERVING, but got %s", res.Status)
+		}
+		if v := md.Get("x-served-by"); len(v) != 1 || v[0] != "gateway" {
+			t.Errorf("expected grpc.Header to be honored, but got %v", md)
+		}
+		if v := trailer.Get("x-trailer"); len(v) != 1 || v[0] != "1" {
+			t.Errorf("expected grpc.Trailer to be honored, but got %v", trailer)
+		}
+	})
+
+	t.Run("server stream", func(t *testing.T) {
+		var stats CallStats
+		stream, err := client.Watch(context.Background(), &healthpb.HealthCheckRequest{}, Stats(&stats))
+		if err != nil {
+			t.Fatalf("Watch should not return an error, but got '%s'", err)
+		}
+		res, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv should not return an error, but got '%s'", err)
+		}
+		if res.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+			t.Errorf("expected the status NOT_SERVING, but got %s", res.Status)
+		}
+		if _, err := stream.Recv(); err != io.EOF {
+			t.Errorf("expected Recv to return io.EOF, but got '%v'", err)
+		}
+		if stats.URL != srv.URL+"/grpc.health.v1.Health/Watch" {
+			t.Errorf("expected the grpcweb call options to be honored, but got the stats %+v", stats)
+		}
+	})
+}
+
+func TestKeepaliveParams(t *testing.T) {
+	var pings atomic.Int32
+	upgrader := websocket.Upgrader{Subprotocols: []string{"grpc-websockets"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetPingHandler(func(data string) error {
+			pings.Add(1)
+			return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+		})
+		conn.WriteMessage(websocket.BinaryMessage, []byte{0x00})
+		conn.WriteMessage(websocket.BinaryMessage, []byte("content-type: application/grpc-web+proto\r\n"))
+		var last []byte
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if bytes.Equal(msg, []byte{0x01}) {
+				break
+			}
+			last = msg[1:]
+		}
+		// Respond with the last request message.
+		conn.WriteMessage(websocket.BinaryMessage, last[:5])
+		conn.WriteMessage(websocket.BinaryMessage, last[5:])
+		trailer := []byte("grpc-status: 0\r\n")
+		conn.WriteMessage(websocket.BinaryMessage, []byte{0x80, 0, 0, 0, byte(len(trailer))})
+		conn.WriteMessage(websocket.BinaryMessage, trailer)
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(
+		strings.TrimPrefix(srv.URL, "http://"),
+		WithInsecure(),
+		WithKeepaliveParams(keepalive.ClientParameters{Time: 10 * time.Millisecond, Timeout: 100 * time.Millisecond}),
+	)
+	if err != nil {
+		t.Fatalf("NewClient should not return an error, but got '%s'", err)
+	}
+	stream, err := client.NewStream(context.Background(), &grpc.StreamDesc{ClientStreams: true}, "/service/Method")
+	if err != nil {
+		t.Fatalf("NewStream should not return an error, but got '%s'", err)
+	}
+	// The stream only sends for longer than the keepalive timeout, the pongs
+	// must be read meanwhile.
+	for i := range 10 {
+		if err := stream.SendMsg(wrapperspb.String(strconv.Itoa(i))); err != nil {
+			t.Fatalf("SendMsg should not return an error, but got '%s'", err)
+		}
+		time.Sleep(30 * time.Millisecond)
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatalf("CloseSend should not return an error, but got '%s'", err)
+	}
+	var res wrapperspb.StringValue
+	if err := stream.RecvMsg(&res); err != nil {
+		t.Fatalf("RecvMsg should not return an error, but got '%s'", err)
+	}
+	if res.Value != "9" {
+		t.Errorf("expected the response 9, but got %q", res.Value)
+	}
+	if pings.Load() == 0 {
+		t.Errorf("expected the stream to ping the server")
+	}
+}
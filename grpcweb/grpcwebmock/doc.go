@@ -0,0 +1,13 @@
+// Package grpcwebmock provides mocks of grpcweb.ClientConnInterface and
+// grpcweb.Stream generated with moq, so that the code making calls with a
+// grpcweb client can be unit tested without a server:
+//
+//	cc := &grpcwebmock.ClientConnInterfaceMock{
+//		InvokeFunc: func(ctx context.Context, method string, args, reply any, opts ...grpcweb.CallOption) error {
+//			reply.(*api.SimpleResponse).Message = "hello"
+//			return nil
+//		},
+//	}
+package grpcwebmock
+
+//go:generate moq -rm -out grpcwebmock.go -pkg grpcwebmock .. ClientConnInterface Stream
@@ -0,0 +1,72 @@
+package grpcweb
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// SendStream is the sending half of a bidirectional stream. It may be used by
+// one goroutine while another one uses the RecvStream of the same stream.
+type SendStream interface {
+	// Context returns the context associated with the stream.
+	Context() context.Context
+	// SendMsg sends a message on the stream.
+	SendMsg(m any) error
+	// CloseSend closes the sending side of the stream.
+	CloseSend() error
+}
+
+// RecvStream is the receiving half of a bidirectional stream.
+type RecvStream interface {
+	// Context returns the context associated with the stream.
+	Context() context.Context
+	// Header returns the header metadata from the server.
+	Header() (metadata.MD, error)
+	// Trailer returns the trailer metadata from the server, once RecvMsg has
+	// returned an error.
+	Trailer() metadata.MD
+	// RecvMsg receives a message from the stream.
+	RecvMsg(m any) error
+}
+
+// BidiStream is the Stream returned by NewStream for bidirectional streams.
+// Its halves can be handed to different components, the compiler enforcing
+// the direction each of them uses.
+type BidiStream interface {
+	Stream
+	// SendStream returns the sending half of the stream.
+	SendStream() SendStream
+	// RecvStream returns the receiving half of the stream.
+	RecvStream() RecvStream
+}
+
+// sendHalf and recvHalf hide the other half of s, even from type assertions.
+type sendHalf struct {
+	s grpc.ClientStream
+}
+
+func (h sendHalf) Context() context.Context { return h.s.Context() }
+func (h sendHalf) SendMsg(m any) error      { return h.s.SendMsg(m) }
+func (h sendHalf) CloseSend() error         { return h.s.CloseSend() }
+
+type recvHalf struct {
+	s grpc.ClientStream
+}
+
+func (h recvHalf) Context() context.Context     { return h.s.Context() }
+func (h recvHalf) Header() (metadata.MD, error) { return h.s.Header() }
+func (h recvHalf) Trailer() metadata.MD         { return h.s.Trailer() }
+func (h recvHalf) RecvMsg(m any) error          { return h.s.RecvMsg(m) }
+
+func (s *bidiStream) SendStream() SendStream { return sendHalf{s} }
+func (s *bidiStream) RecvStream() RecvStream { return recvHalf{s} }
+
+// recvTimeoutBidiStream is a bidirectional stream with a receive timeout.
+type recvTimeoutBidiStream struct {
+	*recvTimeoutStream
+}
+
+func (s recvTimeoutBidiStream) SendStream() SendStream { return sendHalf{s} }
+func (s recvTimeoutBidiStream) RecvStream() RecvStream { return recvHalf{s} }
@@ -0,0 +1,356 @@
+package har
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/transport"
+)
+
+// Call is a call in the course of being recorded. It is safe for concurrent
+// use.
+type Call struct {
+	method      string
+	websocket   bool
+	maxBodySize int
+	start       time.Time
+
+	mu                   sync.Mutex
+	url                  string
+	reqHeader, resHeader http.Header
+	status               int
+	reqBody, resBody     []byte
+	reqSize, resSize     int
+	headersAt, end       time.Time
+	messages             []WebSocketMessage
+	messagesSize         int
+	err                  error
+	redactor             func([]byte) []byte
+}
+
+// SetURL records the request URL.
+func (c *Call) SetURL(u string) {
+	c.mu.Lock()
+	c.url = u
+	c.mu.Unlock()
+}
+
+// SetRedactor sets a function which masks the payload of every message
+// before it appears in the capture. Messages cut by the body size limit can't
+// be redacted, so they are left out.
+func (c *Call) SetRedactor(f func(payload []byte) []byte) {
+	c.mu.Lock()
+	c.redactor = f
+	c.mu.Unlock()
+}
+
+// End records the end of the call and the error it ended with, if any. Only
+// the first call has an effect. c may be nil.
+func (c *Call) End(err error) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.end.IsZero() {
+		c.end = time.Now()
+		c.err = err
+	}
+}
+
+func (c *Call) setRequestHeader(h http.Header) {
+	c.mu.Lock()
+	c.reqHeader = h.Clone()
+	c.mu.Unlock()
+}
+
+func (c *Call) setResponse(status int, h http.Header) {
+	c.mu.Lock()
+	c.status = status
+	c.resHeader = h.Clone()
+	c.headersAt = time.Now()
+	c.mu.Unlock()
+}
+
+// append adds b to body, truncated according to the body size limit, and
+// returns the new body.
+func (c *Call) append(body, b []byte) []byte {
+	if c.maxBodySize > 0 {
+		if room := c.maxBodySize - len(body); room < len(b) {
+			b = b[:max(room, 0)]
+		}
+	}
+	return append(body, b...)
+}
+
+func (c *Call) sent(b []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reqSize += len(b)
+	if c.websocket {
+		c.message("send", b)
+		return
+	}
+	c.reqBody = c.append(c.reqBody, b)
+}
+
+func (c *Call) received(b []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.resSize += len(b)
+	if c.websocket {
+		c.message("receive", b)
+		return
+	}
+	c.resBody = c.append(c.resBody, b)
+}
+
+// message records a websocket frame. The frames of both directions share the
+// body size limit.
+func (c *Call) message(typ string, b []byte) {
+	if c.maxBodySize > 0 {
+		room := c.maxBodySize - c.messagesSize
+		if room <= 0 {
+			return
+		}
+		if room < len(b) {
+			b = b[:room]
+		}
+	}
+	c.messagesSize += len(b)
+	c.messages = append(c.messages, WebSocketMessage{
+		Type:   typ,
+		Time:   float64(time.Now().UnixNano()) / float64(time.Second),
+		Opcode: 2,
+		Data:   base64.StdEncoding.EncodeToString(b),
+	})
+}
+
+func (c *Call) entry() Entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	end, headersAt := c.end, c.headersAt
+	if end.IsZero() {
+		end = time.Now()
+	}
+	if headersAt.IsZero() {
+		headersAt = end
+	}
+
+	e := Entry{
+		StartedDateTime: c.start,
+		Time:            ms(end.Sub(c.start)),
+		Request: Request{
+			Method:      http.MethodPost,
+			URL:         c.url,
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []NameValue{},
+			Headers:     nameValues(c.reqHeader),
+			QueryString: []NameValue{},
+			HeadersSize: -1,
+			BodySize:    c.reqSize,
+		},
+		Response: Response{
+			Status:      c.status,
+			StatusText:  http.StatusText(c.status),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []NameValue{},
+			Headers:     nameValues(c.resHeader),
+			Content: Content{
+				Size:     c.resSize,
+				MimeType: c.resHeader.Get("content-type"),
+			},
+			HeadersSize: -1,
+			BodySize:    c.resSize,
+		},
+		Timings: Timings{
+			Blocked: -1,
+			DNS:     -1,
+			Connect: -1,
+			SSL:     -1,
+			Wait:    ms(headersAt.Sub(c.start)),
+			Receive: ms(end.Sub(headersAt)),
+		},
+		Comment: c.method,
+	}
+	if c.err != nil {
+		e.Comment += ": " + c.err.Error()
+	}
+
+	if c.websocket {
+		e.Request.Method = http.MethodGet
+		e.WebSocketMessages = append([]WebSocketMessage(nil), c.messages...)
+		if c.redactor != nil {
+			for i, m := range e.WebSocketMessages {
+				b, _ := base64.StdEncoding.DecodeString(m.Data)
+				e.WebSocketMessages[i].Data = base64.StdEncoding.EncodeToString(c.redact(b))
+			}
+		}
+		return e
+	}
+	e.Request.PostData = &PostData{
+		MimeType: c.reqHeader.Get("content-type"),
+		Text:     base64.StdEncoding.EncodeToString(c.redact(c.reqBody)),
+		Encoding: "base64",
+	}
+	if len(c.resBody) > 0 {
+		e.Response.Content.Text = base64.StdEncoding.EncodeToString(c.redact(c.resBody))
+		e.Response.Content.Encoding = "base64"
+	}
+	return e
+}
+
+// redact passes the payload of the message frames of b through the redactor,
+// if any. Trailer frames are kept as is, and a trailing incomplete frame is
+// dropped.
+func (c *Call) redact(b []byte) []byte {
+	if c.redactor == nil {
+		return b
+	}
+
+	var out []byte
+	for len(b) >= 5 {
+		n := binary.BigEndian.Uint32(b[1:5])
+		if uint64(len(b)-5) < uint64(n) {
+			break
+		}
+		flag, payload := b[0], b[5:5+n]
+		b = b[5+n:]
+
+		if flag&0x80 == 0 {
+			payload = c.redactor(append([]byte(nil), payload...))
+		}
+		out = append(out, flag)
+		out = binary.BigEndian.AppendUint32(out, uint32(len(payload)))
+		out = append(out, payload...)
+	}
+	return out
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// WrapUnary returns a transport which records the exchange into c.
+func WrapUnary(tr transport.UnaryTransport, c *Call) transport.UnaryTransport {
+	return &unaryTransport{UnaryTransport: tr, call: c}
+}
+
+type unaryTransport struct {
+	transport.UnaryTransport
+	call *Call
+}
+
+func (t *unaryTransport) Send(ctx context.Context, endpoint, contentType string, body io.Reader) (http.Header, io.ReadCloser, error) {
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	t.call.sent(b)
+
+	h, r, err := t.UnaryTransport.Send(ctx, endpoint, contentType, bytes.NewReader(b))
+	reqHeader := t.Header().Clone()
+	if reqHeader.Get("content-type") == "" {
+		reqHeader.Set("content-type", contentType)
+	}
+	t.call.setRequestHeader(reqHeader)
+	if err != nil {
+		var httpErr *transport.HTTPError
+		if errors.As(err, &httpErr) {
+			t.call.setResponse(httpErr.StatusCode, httpErr.Header)
+		}
+		t.call.End(err)
+		return nil, nil, err
+	}
+	t.call.setResponse(http.StatusOK, h)
+	return h, &recordingReader{ReadCloser: r, call: t.call}, nil
+}
+
+func (t *unaryTransport) Close() error {
+	t.call.End(nil)
+	return t.UnaryTransport.Close()
+}
+
+type recordingReader struct {
+	io.ReadCloser
+	call *Call
+}
+
+func (r *recordingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.call.received(p[:n])
+	}
+	return n, err
+}
+
+// WrapClientStream returns a transport which records the frames of the
+// stream into c.
+func WrapClientStream(tr transport.ClientStreamTransport, c *Call) transport.ClientStreamTransport {
+	return &clientStreamTransport{ClientStreamTransport: tr, call: c}
+}
+
+type clientStreamTransport struct {
+	transport.ClientStreamTransport
+	call *Call
+
+	headerOnce sync.Once
+}
+
+func (t *clientStreamTransport) SetRequestHeader(h http.Header) {
+	t.call.setRequestHeader(h)
+	t.ClientStreamTransport.SetRequestHeader(h)
+}
+
+func (t *clientStreamTransport) Send(ctx context.Context, body io.Reader) error {
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	t.call.sent(b)
+	return t.ClientStreamTransport.Send(ctx, bytes.NewReader(b))
+}
+
+func (t *clientStreamTransport) Receive(ctx context.Context) (io.ReadCloser, error) {
+	r, err := t.ClientStreamTransport.Receive(ctx)
+	if errors.Is(err, io.EOF) {
+		t.call.End(nil)
+	}
+	if err != nil {
+		t.call.End(err)
+		return nil, err
+	}
+	t.headerOnce.Do(func() {
+		h, _ := t.ClientStreamTransport.Header()
+		t.call.setResponse(http.StatusSwitchingProtocols, h)
+	})
+
+	b, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return nil, err
+	}
+	t.call.received(b)
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (t *clientStreamTransport) Close() error {
+	t.call.End(nil)
+	return t.ClientStreamTransport.Close()
+}
+
+func (t *unaryTransport) Unwrap() transport.UnaryTransport {
+	return t.UnaryTransport
+}
+
+func (t *clientStreamTransport) Unwrap() transport.ClientStreamTransport {
+	return t.ClientStreamTransport
+}
@@ -0,0 +1,212 @@
+// Package har records gRPC-Web calls into HAR (HTTP Archive) captures, which
+// can be opened by browser developer tools and other HTTP analysis tooling.
+//
+// Unary and server streaming calls are recorded as plain HTTP exchanges.
+// Websocket streams carry their frames in the _webSocketMessages field used by
+// Chrome.
+package har
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Log is the root of a HAR capture.
+type Log struct {
+	Version string  `json:"version"`
+	Creator Creator `json:"creator"`
+	Entries []Entry `json:"entries"`
+}
+
+type Creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Entry is a single recorded call.
+type Entry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	// Time is the total duration of the call in milliseconds.
+	Time     float64  `json:"time"`
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+	Cache    struct{} `json:"cache"`
+	Timings  Timings  `json:"timings"`
+	Comment  string   `json:"comment,omitempty"`
+
+	WebSocketMessages []WebSocketMessage `json:"_webSocketMessages,omitempty"`
+}
+
+type Request struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []NameValue `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	QueryString []NameValue `json:"queryString"`
+	PostData    *PostData   `json:"postData,omitempty"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type Response struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []NameValue `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	Content     Content     `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// PostData is the request body. Text is base64 encoded since gRPC-Web bodies
+// are binary.
+type PostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"_encoding,omitempty"`
+}
+
+// Content is the response body. Text is base64 encoded since gRPC-Web bodies
+// are binary.
+type Content struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// Timings are in milliseconds, -1 meaning that the phase doesn't apply.
+type Timings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	SSL     float64 `json:"ssl"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// WebSocketMessage is a frame sent or received over a websocket. Data is
+// base64 encoded.
+type WebSocketMessage struct {
+	Type   string  `json:"type"`
+	Time   float64 `json:"time"`
+	Opcode int     `json:"opcode"`
+	Data   string  `json:"data"`
+}
+
+// Recorder collects the calls of the ClientConns it is registered with. It is
+// safe for concurrent use.
+type Recorder struct {
+	maxEntries  int
+	maxBodySize int
+
+	mu    sync.Mutex
+	calls []*Call
+}
+
+type Option func(*Recorder)
+
+// WithMaxEntries limits the number of recorded calls. Once it is reached, the
+// oldest calls are dropped. Zero means no limit.
+func WithMaxEntries(n int) Option {
+	return func(r *Recorder) {
+		r.maxEntries = n
+	}
+}
+
+// WithMaxBodySize limits the number of bytes recorded for the request and
+// the response of each call. Longer bodies are truncated. Zero means no limit.
+func WithMaxBodySize(n int) Option {
+	return func(r *Recorder) {
+		r.maxBodySize = n
+	}
+}
+
+func NewRecorder(opts ...Option) *Recorder {
+	r := &Recorder{}
+	for _, o := range opts {
+		o(r)
+	}
+	return r
+}
+
+// StartCall starts recording a call of the given method.
+func (r *Recorder) StartCall(method string, websocket bool) *Call {
+	c := &Call{
+		method:      method,
+		websocket:   websocket,
+		maxBodySize: r.maxBodySize,
+		start:       time.Now(),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, c)
+	if r.maxEntries > 0 && len(r.calls) > r.maxEntries {
+		r.calls = append(r.calls[:0:0], r.calls[len(r.calls)-r.maxEntries:]...)
+	}
+	return c
+}
+
+// Log returns the calls recorded so far, including the ones in progress.
+func (r *Recorder) Log() *Log {
+	r.mu.Lock()
+	calls := append([]*Call(nil), r.calls...)
+	r.mu.Unlock()
+
+	entries := make([]Entry, 0, len(calls))
+	for _, c := range calls {
+		entries = append(entries, c.entry())
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].StartedDateTime.Before(entries[j].StartedDateTime)
+	})
+	return &Log{
+		Version: "1.2",
+		Creator: Creator{Name: "grpc-web-go-client", Version: "1"},
+		Entries: entries,
+	}
+}
+
+// WriteTo writes the capture as a HAR JSON document to w.
+func (r *Recorder) WriteTo(w io.Writer) (int64, error) {
+	b, err := json.MarshalIndent(struct {
+		Log *Log `json:"log"`
+	}{r.Log()}, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+// Reset drops the recorded calls.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	r.calls = nil
+	r.mu.Unlock()
+}
+
+func nameValues(h http.Header) []NameValue {
+	nv := make([]NameValue, 0, len(h))
+	for k, vs := range h {
+		for _, v := range vs {
+			nv = append(nv, NameValue{Name: k, Value: v})
+		}
+	}
+	sort.SliceStable(nv, func(i, j int) bool { return nv[i].Name < nv[j].Name })
+	return nv
+}
@@ -0,0 +1,159 @@
+package har_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/har"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/transport"
+)
+
+type unaryTransport struct {
+	header http.Header
+}
+
+func (t *unaryTransport) Header() http.Header {
+	return t.header
+}
+
+func (t *unaryTransport) Send(_ context.Context, _, contentType string, body io.Reader) (http.Header, io.ReadCloser, error) {
+	t.header.Set("content-type", contentType)
+	io.Copy(io.Discard, body)
+	h := http.Header{"Content-Type": {"application/grpc-web+proto"}}
+	return h, io.NopCloser(bytes.NewReader([]byte("response body"))), nil
+}
+
+func (t *unaryTransport) Close() error {
+	return nil
+}
+
+func TestRecorder(t *testing.T) {
+	cases := map[string]struct {
+		opts            []har.Option
+		calls           int
+		expectedEntries int
+		expectedRequest string
+		expectedContent string
+	}{
+		"no limits": {
+			calls:           2,
+			expectedEntries: 2,
+			expectedRequest: "request body",
+			expectedContent: "response body",
+		},
+		"limited": {
+			opts:            []har.Option{har.WithMaxEntries(1), har.WithMaxBodySize(7)},
+			calls:           2,
+			expectedEntries: 1,
+			expectedRequest: "request",
+			expectedContent: "respons",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := har.NewRecorder(c.opts...)
+			for i := 0; i < c.calls; i++ {
+				call := r.StartCall("/service/Method", false)
+				call.SetURL("http://example.com/service/Method")
+
+				var tr transport.UnaryTransport = &unaryTransport{header: make(http.Header)}
+				tr = har.WrapUnary(tr, call)
+				_, body, err := tr.Send(context.Background(), "/service/Method", "application/grpc-web+proto", bytes.NewReader([]byte("request body")))
+				if err != nil {
+					t.Fatalf("Send should not return an error, but got '%s'", err)
+				}
+				io.Copy(io.Discard, body)
+				body.Close()
+				tr.Close()
+			}
+
+			var buf bytes.Buffer
+			if _, err := r.WriteTo(&buf); err != nil {
+				t.Fatalf("WriteTo should not return an error, but got '%s'", err)
+			}
+			var doc struct {
+				Log har.Log `json:"log"`
+			}
+			if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+				t.Fatalf("the capture should be valid JSON, but got '%s'", err)
+			}
+
+			if n := len(doc.Log.Entries); n != c.expectedEntries {
+				t.Fatalf("expected %d entries, but got %d", c.expectedEntries, n)
+			}
+			e := doc.Log.Entries[0]
+			if e.Request.URL != "http://example.com/service/Method" || e.Response.Status != http.StatusOK {
+				t.Errorf("unexpected entry %+v", e)
+			}
+			if e.Request.BodySize != len("request body") || e.Response.Content.Size != len("response body") {
+				t.Errorf("expected the sizes of the whole bodies, but got %d and %d", e.Request.BodySize, e.Response.Content.Size)
+			}
+			if got := decode(t, e.Request.PostData.Text); got != c.expectedRequest {
+				t.Errorf("expected request body %q, but got %q", c.expectedRequest, got)
+			}
+			if got := decode(t, e.Response.Content.Text); got != c.expectedContent {
+				t.Errorf("expected response content %q, but got %q", c.expectedContent, got)
+			}
+		})
+	}
+}
+
+func decode(t *testing.T, s string) string {
+	t.Helper()
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatalf("the body should be base64 encoded, but got '%s'", err)
+	}
+	return string(b)
+}
+
+func TestRedactor(t *testing.T) {
+	frame := func(flag byte, payload string) []byte {
+		return append([]byte{flag, 0, 0, 0, byte(len(payload))}, payload...)
+	}
+
+	cases := map[string]struct {
+		body     []byte
+		expected []byte
+	}{
+		"message": {
+			body:     frame(0, "secret"),
+			expected: frame(0, "***"),
+		},
+		"messages and trailer": {
+			body:     bytes.Join([][]byte{frame(0, "a"), frame(0, "b"), frame(0x80, "grpc-status: 0")}, nil),
+			expected: bytes.Join([][]byte{frame(0, "***"), frame(0, "***"), frame(0x80, "grpc-status: 0")}, nil),
+		},
+		"truncated": {
+			body:     append(frame(0, "secret"), frame(0, "secret")[:7]...),
+			expected: frame(0, "***"),
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := har.NewRecorder()
+			call := r.StartCall("/service/Method", false)
+			call.SetRedactor(func([]byte) []byte { return []byte("***") })
+
+			tr := har.WrapUnary(&unaryTransport{header: make(http.Header)}, call)
+			_, body, err := tr.Send(context.Background(), "/service/Method", "application/grpc-web+proto", bytes.NewReader(c.body))
+			if err != nil {
+				t.Fatalf("Send should not return an error, but got '%s'", err)
+			}
+			body.Close()
+			tr.Close()
+
+			e := r.Log().Entries[0]
+			if got := decode(t, e.Request.PostData.Text); got != string(c.expected) {
+				t.Errorf("expected request body %q, but got %q", c.expected, got)
+			}
+		})
+	}
+}
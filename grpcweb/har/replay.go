@@ -0,0 +1,221 @@
+package har
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+)
+
+// ReadLog reads a HAR capture, such as one written by Recorder.WriteTo.
+func ReadLog(r io.Reader) (*Log, error) {
+	var doc struct {
+		Log *Log `json:"log"`
+	}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to decode the capture: %w", err)
+	}
+	if doc.Log == nil {
+		return nil, fmt.Errorf("failed to decode the capture: no log")
+	}
+	return doc.Log, nil
+}
+
+// Replayer sends the unary and server streaming calls of a capture again, to
+// the same or another target, and compares the responses with the recorded
+// ones, e.g. to check a gateway upgrade. Websocket streams are not replayed.
+type Replayer struct {
+	// Target replaces the scheme and host of the recorded URLs if set, such
+	// as "https://staging.example.com".
+	Target string
+	// Client sends the requests. It defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// ReplayResult is the outcome of the replay of an entry.
+type ReplayResult struct {
+	// URL is the URL the call was sent to.
+	URL string
+	// Skipped is the reason why the call wasn't replayed, if it wasn't.
+	Skipped string
+	// Err is the error which failed the replay, if any.
+	Err error
+	// Diffs describes the differences between the recorded response and the
+	// replayed one. It is empty if they match.
+	Diffs []string
+}
+
+// OK reports whether the call was replayed and got the recorded response.
+func (r *ReplayResult) OK() bool {
+	return r.Skipped == "" && r.Err == nil && len(r.Diffs) == 0
+}
+
+// Replay replays the entries of log in order.
+func (r *Replayer) Replay(ctx context.Context, log *Log) []ReplayResult {
+	results := make([]ReplayResult, 0, len(log.Entries))
+	for i := range log.Entries {
+		results = append(results, r.ReplayEntry(ctx, &log.Entries[i]))
+	}
+	return results
+}
+
+// ReplayEntry replays a single entry.
+func (r *Replayer) ReplayEntry(ctx context.Context, e *Entry) ReplayResult {
+	res := ReplayResult{URL: e.Request.URL}
+	if e.Request.PostData == nil || len(e.WebSocketMessages) > 0 {
+		res.Skipped = "websocket streams can't be replayed"
+		return res
+	}
+	body, err := base64.StdEncoding.DecodeString(e.Request.PostData.Text)
+	if err != nil {
+		res.Err = fmt.Errorf("failed to decode the request body: %w", err)
+		return res
+	}
+	if len(body) < e.Request.BodySize {
+		res.Skipped = "the request body was truncated"
+		return res
+	}
+	recorded, err := base64.StdEncoding.DecodeString(e.Response.Content.Text)
+	if err != nil {
+		res.Err = fmt.Errorf("failed to decode the response body: %w", err)
+		return res
+	}
+	if len(recorded) < e.Response.BodySize {
+		res.Skipped = "the response body was truncated"
+		return res
+	}
+
+	if r.Target != "" {
+		u, err := retarget(e.Request.URL, r.Target)
+		if err != nil {
+			res.Err = err
+			return res
+		}
+		res.URL = u
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, res.URL, bytes.NewReader(body))
+	if err != nil {
+		res.Err = fmt.Errorf("failed to build the request: %w", err)
+		return res
+	}
+	for _, h := range e.Request.Headers {
+		switch textproto.CanonicalMIMEHeaderKey(h.Name) {
+		case "Host", "Content-Length", "Connection", "Keep-Alive", "Proxy-Connection", "Transfer-Encoding", "Upgrade":
+		default:
+			req.Header.Add(h.Name, h.Value)
+		}
+	}
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		res.Err = fmt.Errorf("failed to send the request: %w", err)
+		return res
+	}
+	defer resp.Body.Close()
+	replayed, err := io.ReadAll(resp.Body)
+	if err != nil {
+		res.Err = fmt.Errorf("failed to read the response body: %w", err)
+		return res
+	}
+
+	res.Diffs = compare(e.Response, recorded, resp, replayed)
+	return res
+}
+
+// retarget replaces the scheme and host of rawURL with the ones of target,
+// keeping the path of target as a prefix.
+func retarget(rawURL, target string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid recorded URL %q: %w", rawURL, err)
+	}
+	t, err := url.Parse(target)
+	if err != nil || t.Scheme == "" || t.Host == "" {
+		return "", fmt.Errorf("invalid target %q", target)
+	}
+	u.Scheme, u.Host, u.User = t.Scheme, t.Host, t.User
+	if p := strings.TrimSuffix(t.Path, "/"); p != "" {
+		u.Path = p + u.Path
+		u.RawPath = ""
+	}
+	return u.String(), nil
+}
+
+// compare returns the differences between the recorded response and the
+// replayed one: the HTTP status, the content type, the messages and the gRPC
+// status. The other headers and the order of the trailers are ignored.
+func compare(rec Response, recBody []byte, resp *http.Response, body []byte) []string {
+	var diffs []string
+	if rec.Status != resp.StatusCode {
+		diffs = append(diffs, fmt.Sprintf("HTTP status: recorded %d, replayed %d", rec.Status, resp.StatusCode))
+	}
+	recHeader := make(http.Header)
+	for _, h := range rec.Headers {
+		recHeader.Add(h.Name, h.Value)
+	}
+	if a, b := recHeader.Get("Content-Type"), resp.Header.Get("Content-Type"); a != b {
+		diffs = append(diffs, fmt.Sprintf("content type: recorded %q, replayed %q", a, b))
+	}
+
+	recMsgs, recTrailer := splitFrames(recBody)
+	msgs, trailer := splitFrames(body)
+	if len(recMsgs) != len(msgs) {
+		diffs = append(diffs, fmt.Sprintf("messages: recorded %d, replayed %d", len(recMsgs), len(msgs)))
+	}
+	for i := 0; i < min(len(recMsgs), len(msgs)); i++ {
+		if !bytes.Equal(recMsgs[i], msgs[i]) {
+			diffs = append(diffs, fmt.Sprintf("message %d: recorded %d bytes, replayed %d bytes differing", i+1, len(recMsgs[i]), len(msgs[i])))
+		}
+	}
+
+	for _, k := range []string{"Grpc-Status", "Grpc-Message"} {
+		a, b := recTrailer.Get(k), trailer.Get(k)
+		if a == "" {
+			a = recHeader.Get(k)
+		}
+		if b == "" {
+			b = resp.Header.Get(k)
+		}
+		if a != b {
+			diffs = append(diffs, fmt.Sprintf("%s: recorded %q, replayed %q", strings.ToLower(k), a, b))
+		}
+	}
+	return diffs
+}
+
+// splitFrames returns the payloads of the message frames of body and the
+// content of its trailer frames. A trailing incomplete frame is ignored.
+func splitFrames(body []byte) ([][]byte, http.Header) {
+	var msgs [][]byte
+	trailer := make(http.Header)
+	for len(body) >= 5 {
+		n := binary.BigEndian.Uint32(body[1:5])
+		if uint64(len(body)-5) < uint64(n) {
+			break
+		}
+		flag, payload := body[0], body[5:5+n]
+		body = body[5+n:]
+
+		if flag&0x80 == 0 {
+			msgs = append(msgs, payload)
+			continue
+		}
+		for _, line := range strings.Split(string(payload), "\r\n") {
+			if k, v, ok := strings.Cut(line, ":"); ok {
+				trailer.Add(strings.TrimSpace(k), strings.TrimSpace(v))
+			}
+		}
+	}
+	return msgs, trailer
+}
@@ -0,0 +1,132 @@
+package har_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/har"
+)
+
+func TestReplayer(t *testing.T) {
+	frame := func(flag byte, payload string) []byte {
+		return append([]byte{flag, 0, 0, 0, byte(len(payload))}, payload...)
+	}
+	recorded := bytes.Join([][]byte{frame(0, "nano"), frame(0x80, "grpc-status: 0\r\n")}, nil)
+	entry := func() har.Entry {
+		var e har.Entry
+		e.Request.URL = "http://example.com/api/service/Method"
+		e.Request.Headers = []har.NameValue{{Name: "Content-Type", Value: "application/grpc-web+proto"}, {Name: "Host", Value: "example.com"}}
+		e.Request.PostData = &har.PostData{Text: base64.StdEncoding.EncodeToString(frame(0, "request"))}
+		e.Request.BodySize = len(frame(0, "request"))
+		e.Response.Status = http.StatusOK
+		e.Response.Headers = []har.NameValue{{Name: "Content-Type", Value: "application/grpc-web+proto"}}
+		e.Response.Content.Text = base64.StdEncoding.EncodeToString(recorded)
+		e.Response.BodySize = len(recorded)
+		return e
+	}
+
+	cases := map[string]struct {
+		entry           func() har.Entry
+		response        []byte
+		expectedSkipped bool
+		expectedDiffs   []string
+	}{
+		"same response": {
+			entry:    entry,
+			response: recorded,
+		},
+		"different message": {
+			entry:         entry,
+			response:      bytes.Join([][]byte{frame(0, "yuko"), frame(0x80, "grpc-status: 0\r\n")}, nil),
+			expectedDiffs: []string{"message 1"},
+		},
+		"different status": {
+			entry:         entry,
+			response:      frame(0x80, "grpc-status: 5\r\ngrpc-message: not found\r\n"),
+			expectedDiffs: []string{"messages", "grpc-status", "grpc-message"},
+		},
+		"websocket": {
+			entry: func() har.Entry {
+				e := entry()
+				e.Request.PostData = nil
+				return e
+			},
+			expectedSkipped: true,
+		},
+		"truncated": {
+			entry: func() har.Entry {
+				e := entry()
+				e.Response.BodySize++
+				return e
+			},
+			expectedSkipped: true,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/prefix/api/service/Method" {
+					t.Errorf("expected the path to be retargeted, but got '%s'", r.URL.Path)
+				}
+				if ct := r.Header.Get("Content-Type"); ct != "application/grpc-web+proto" {
+					t.Errorf("expected the recorded content type, but got '%s'", ct)
+				}
+				w.Header().Set("Content-Type", "application/grpc-web+proto")
+				w.Write(c.response)
+			}))
+			defer srv.Close()
+
+			e := c.entry()
+			r := &har.Replayer{Target: srv.URL + "/prefix/"}
+			res := r.Replay(context.Background(), &har.Log{Entries: []har.Entry{e}})[0]
+			if res.Err != nil {
+				t.Fatalf("Replay should not return an error, but got '%s'", res.Err)
+			}
+			if c.expectedSkipped {
+				if res.Skipped == "" {
+					t.Errorf("expected the entry to be skipped")
+				}
+				return
+			}
+			if len(res.Diffs) != len(c.expectedDiffs) {
+				t.Fatalf("expected %d diffs, but got %q", len(c.expectedDiffs), res.Diffs)
+			}
+			for i, d := range c.expectedDiffs {
+				if !strings.HasPrefix(res.Diffs[i], d) {
+					t.Errorf("expected diff %d to be about %s, but got '%s'", i, d, res.Diffs[i])
+				}
+			}
+			if res.OK() != (len(c.expectedDiffs) == 0) {
+				t.Errorf("unexpected OK %t", res.OK())
+			}
+		})
+	}
+}
+
+func TestReadLog(t *testing.T) {
+	r := har.NewRecorder()
+	call := r.StartCall("/service/Method", false)
+	call.SetURL("http://example.com/service/Method")
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo should not return an error, but got '%s'", err)
+	}
+
+	log, err := har.ReadLog(&buf)
+	if err != nil {
+		t.Fatalf("ReadLog should not return an error, but got '%s'", err)
+	}
+	if len(log.Entries) != len(r.Log().Entries) {
+		t.Errorf("expected %d entries, but got %d", len(r.Log().Entries), len(log.Entries))
+	}
+
+	if _, err := har.ReadLog(strings.NewReader(`{}`)); err == nil {
+		t.Errorf("ReadLog should return an error for a document without a log")
+	}
+}
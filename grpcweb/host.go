@@ -0,0 +1,13 @@
+package grpcweb
+
+// Host sends the call to host, such as "tenant.example.com:443", instead of
+// the target of the ClientConn, e.g. to fan out to tenant specific gateways
+// with a single ClientConn. The resolver set with WithResolver doesn't apply
+// to the call. The connections are pooled per host, so the calls to the same
+// host share them. The host of a request template set with
+// WithRequestTemplate takes precedence over host.
+func Host(host string) CallOption {
+	return newCallOption(func(opt *callOptions) {
+		opt.host = host
+	})
+}
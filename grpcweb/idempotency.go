@@ -0,0 +1,40 @@
+package grpcweb
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// WithIdempotencyKey attaches a generated UUID to every unary call in the
+// given header, idempotency-key if it is empty, for the servers deduplicating
+// the calls on it. The retries of a call carry the key of its first attempt.
+// The calls whose metadata already sets the header keep their value.
+func WithIdempotencyKey(header string) DialOption {
+	if header == "" {
+		header = "idempotency-key"
+	}
+	return func(opt *dialOptions) {
+		opt.idempotencyHeader = header
+	}
+}
+
+// withIdempotencyKey returns opts with a new idempotency key if the keys are
+// enabled.
+func (o *dialOptions) withIdempotencyKey(opts []CallOption) []CallOption {
+	if o.idempotencyHeader == "" {
+		return opts
+	}
+	key := uuid.NewString()
+	return append(opts[:len(opts):len(opts)], newCallOption(func(opt *callOptions) {
+		opt.idempotencyKey = key
+	}))
+}
+
+// setIdempotencyKey sets the idempotency key of the call, if any, unless the
+// header is already set.
+func (o *dialOptions) setIdempotencyKey(callOptions *callOptions, h http.Header) {
+	if callOptions.idempotencyKey != "" && h.Get(o.idempotencyHeader) == "" {
+		h.Set(o.idempotencyHeader, callOptions.idempotencyKey)
+	}
+}
@@ -0,0 +1,60 @@
+package grpcweb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/atomic"
+	"google.golang.org/grpc/codes"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/errs"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/transport"
+)
+
+// inactivityTimer fails a stream when no frame arrives within the timeout
+// while the stream is waiting for one.
+type inactivityTimer struct {
+	timeout time.Duration
+	clock   transport.Clock
+	cancel  context.CancelFunc
+	expired atomic.Bool
+}
+
+// newInactivityTimer derives the context of a stream whose receives are
+// aborted by cancelling it. It returns a nil timer if d isn't positive.
+func newInactivityTimer(ctx context.Context, d time.Duration, clock transport.Clock) (context.Context, *inactivityTimer) {
+	if d <= 0 {
+		return ctx, nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return ctx, &inactivityTimer{timeout: d, clock: clock, cancel: cancel}
+}
+
+// wait arms the timer and returns a function disarming it. t may be nil.
+func (t *inactivityTimer) wait() func() {
+	if t == nil {
+		return func() {}
+	}
+	timer := t.clock.AfterFunc(t.timeout, func() {
+		t.expired.Store(true)
+		t.cancel()
+	})
+	return func() { timer.Stop() }
+}
+
+// err returns a DeadlineExceeded error in place of err once the timer has
+// expired. t may be nil.
+func (t *inactivityTimer) err(err error) error {
+	if t == nil || err == nil || !t.expired.Load() {
+		return err
+	}
+	return errs.WithCode(codes.DeadlineExceeded, err, fmt.Sprintf("no frame received within %s", t.timeout))
+}
+
+// stop releases the context of the timer. t may be nil.
+func (t *inactivityTimer) stop() {
+	if t != nil {
+		t.cancel()
+	}
+}
@@ -0,0 +1,118 @@
+package grpcweb
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// UnaryInvoker is called by a UnaryClientInterceptor to complete the call.
+type UnaryInvoker func(ctx context.Context, method string, req, reply any, cc *ClientConn, opts ...CallOption) error
+
+// UnaryClientInterceptor intercepts the unary calls of a ClientConn, the same
+// way as its grpc-go counterpart. It is responsible for calling invoker to
+// complete the call.
+type UnaryClientInterceptor func(ctx context.Context, method string, req, reply any, cc *ClientConn, invoker UnaryInvoker, opts ...CallOption) error
+
+// WithUnaryInterceptor sets the interceptor of the unary calls.
+func WithUnaryInterceptor(i UnaryClientInterceptor) DialOption {
+	return func(opt *dialOptions) {
+		opt.unaryInterceptor = i
+	}
+}
+
+// WithChainUnaryInterceptor adds interceptors to the chain of the unary
+// calls. Like with grpc-go, the first interceptor is the outermost one, and
+// the interceptor set with WithUnaryInterceptor runs before the chain.
+func WithChainUnaryInterceptor(interceptors ...UnaryClientInterceptor) DialOption {
+	return func(opt *dialOptions) {
+		opt.chainUnaryInterceptors = append(opt.chainUnaryInterceptors, interceptors...)
+	}
+}
+
+// Streamer is called by a StreamClientInterceptor to create the stream.
+type Streamer func(ctx context.Context, desc *grpc.StreamDesc, cc *ClientConn, method string, opts ...CallOption) (Stream, error)
+
+// StreamClientInterceptor intercepts the creation of the streams of a
+// ClientConn, the same way as its grpc-go counterpart. It is responsible for
+// calling streamer to create the stream, and may wrap the returned Stream,
+// which must remain a BidiStream for the bidirectional streams. It may
+// observe the frames of the stream by passing OnFrame to streamer.
+type StreamClientInterceptor func(ctx context.Context, desc *grpc.StreamDesc, cc *ClientConn, method string, streamer Streamer, opts ...CallOption) (Stream, error)
+
+// WithStreamInterceptor sets the interceptor of the streams.
+func WithStreamInterceptor(i StreamClientInterceptor) DialOption {
+	return func(opt *dialOptions) {
+		opt.streamInterceptor = i
+	}
+}
+
+// WithChainStreamInterceptor adds interceptors to the chain of the streams.
+// Like with grpc-go, the first interceptor is the outermost one, and the
+// interceptor set with WithStreamInterceptor runs before the chain.
+func WithChainStreamInterceptor(interceptors ...StreamClientInterceptor) DialOption {
+	return func(opt *dialOptions) {
+		opt.chainStreamInterceptors = append(opt.chainStreamInterceptors, interceptors...)
+	}
+}
+
+// chainInterceptors combines the interceptors set with the options into
+// unaryInterceptor and streamInterceptor.
+func (o *dialOptions) chainInterceptors() {
+	unary := o.chainUnaryInterceptors
+	if o.unaryInterceptor != nil {
+		unary = append([]UnaryClientInterceptor{o.unaryInterceptor}, unary...)
+	}
+	switch len(unary) {
+	case 0:
+	case 1:
+		o.unaryInterceptor = unary[0]
+	default:
+		o.unaryInterceptor = func(ctx context.Context, method string, req, reply any, cc *ClientConn, invoker UnaryInvoker, opts ...CallOption) error {
+			return unary[0](ctx, method, req, reply, cc, chainUnaryInvoker(unary, 0, invoker), opts...)
+		}
+	}
+
+	stream := o.chainStreamInterceptors
+	if o.streamInterceptor != nil {
+		stream = append([]StreamClientInterceptor{o.streamInterceptor}, stream...)
+	}
+	switch len(stream) {
+	case 0:
+	case 1:
+		o.streamInterceptor = stream[0]
+	default:
+		o.streamInterceptor = func(ctx context.Context, desc *grpc.StreamDesc, cc *ClientConn, method string, streamer Streamer, opts ...CallOption) (Stream, error) {
+			return stream[0](ctx, desc, cc, method, chainStreamer(stream, 0, streamer), opts...)
+		}
+	}
+}
+
+// chainUnaryInvoker returns the invoker calling the interceptor following
+// the current one, or finalInvoker after the last one.
+func chainUnaryInvoker(interceptors []UnaryClientInterceptor, current int, finalInvoker UnaryInvoker) UnaryInvoker {
+	if current == len(interceptors)-1 {
+		return finalInvoker
+	}
+	return func(ctx context.Context, method string, req, reply any, cc *ClientConn, opts ...CallOption) error {
+		return interceptors[current+1](ctx, method, req, reply, cc, chainUnaryInvoker(interceptors, current+1, finalInvoker), opts...)
+	}
+}
+
+// chainStreamer is chainUnaryInvoker for the streams.
+func chainStreamer(interceptors []StreamClientInterceptor, current int, finalStreamer Streamer) Streamer {
+	if current == len(interceptors)-1 {
+		return finalStreamer
+	}
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *ClientConn, method string, opts ...CallOption) (Stream, error) {
+		return interceptors[current+1](ctx, desc, cc, method, chainStreamer(interceptors, current+1, finalStreamer), opts...)
+	}
+}
+
+func invoke(ctx context.Context, method string, req, reply any, cc *ClientConn, opts ...CallOption) error {
+	return cc.invokeWithRetry(ctx, method, req, reply, opts...)
+}
+
+func newStream(ctx context.Context, desc *grpc.StreamDesc, cc *ClientConn, method string, opts ...CallOption) (Stream, error) {
+	return cc.openStream(ctx, desc, method, opts...)
+}
@@ -0,0 +1,34 @@
+// Package backoff computes retry delays from a grpc backoff.Config, the same
+// way grpc-go does for its connection attempts.
+package backoff
+
+import (
+	"math/rand"
+	"time"
+
+	"google.golang.org/grpc/backoff"
+)
+
+// Delay returns how long to wait before the retry following the given number
+// of failed retries. The delay grows exponentially from BaseDelay up to
+// MaxDelay and is randomized by Jitter.
+func Delay(c backoff.Config, retries int) time.Duration {
+	if retries == 0 {
+		return c.BaseDelay
+	}
+
+	d, maxDelay := float64(c.BaseDelay), float64(c.MaxDelay)
+	for d < maxDelay && retries > 0 {
+		d *= c.Multiplier
+		retries--
+	}
+	if d > maxDelay {
+		d = maxDelay
+	}
+	// Randomize the delay to avoid synchronized retries of many clients.
+	d *= 1 + c.Jitter*(rand.Float64()*2-1)
+	if d < 0 {
+		return 0
+	}
+	return time.Duration(d)
+}
@@ -0,0 +1,40 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/backoff"
+)
+
+func TestDelay(t *testing.T) {
+	c := backoff.Config{
+		BaseDelay:  100 * time.Millisecond,
+		Multiplier: 2,
+		MaxDelay:   time.Second,
+	}
+
+	cases := map[string]struct {
+		retries  int
+		expected time.Duration
+	}{
+		"first retry":   {retries: 0, expected: 100 * time.Millisecond},
+		"growing delay": {retries: 2, expected: 400 * time.Millisecond},
+		"capped delay":  {retries: 10, expected: time.Second},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if d := Delay(c, tc.retries); d != tc.expected {
+				t.Errorf("expected %s, but got %s", tc.expected, d)
+			}
+		})
+	}
+
+	c.Jitter = 0.2
+	for i := 0; i < 100; i++ {
+		if d := Delay(c, 10); d < 800*time.Millisecond || d > 1200*time.Millisecond {
+			t.Fatalf("expected the delay to be within 20%% of 1s, but got %s", d)
+		}
+	}
+}
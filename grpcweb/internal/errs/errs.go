@@ -0,0 +1,123 @@
+// Package errs provides the error type used across the client. It keeps the
+// context added while an error travels up the stack, like fmt.Errorf with %w,
+// and resolves to a gRPC status so that status.FromError and status.Code
+// report a meaningful code for transport and protocol failures.
+package errs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Error is an error with an optional gRPC code which wraps another error.
+type Error struct {
+	code    codes.Code
+	hasCode bool
+	msg     string
+	err     error
+}
+
+// New returns an error with the given message.
+func New(msg string) error {
+	return &Error{msg: msg}
+}
+
+// Errorf returns an error formatted according to a format specifier.
+func Errorf(format string, args ...any) error {
+	return &Error{msg: fmt.Sprintf(format, args...)}
+}
+
+// Wrap annotates err with msg. It returns nil if err is nil.
+func Wrap(err error, msg string) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{msg: msg, err: err}
+}
+
+// Wrapf annotates err with a formatted message. It returns nil if err is nil.
+func Wrapf(err error, format string, args ...any) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{msg: fmt.Sprintf(format, args...), err: err}
+}
+
+// WithCode annotates err with msg and makes it resolve to the given code.
+// err may be nil.
+func WithCode(c codes.Code, err error, msg string) error {
+	return &Error{code: c, hasCode: true, msg: msg, err: err}
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.err == nil:
+		return e.msg
+	case e.msg == "":
+		return e.err.Error()
+	default:
+		return e.msg + ": " + e.err.Error()
+	}
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// Code returns the gRPC code the error resolves to. An explicitly attached
+// code wins, otherwise the code of the closest wrapped gRPC status or context
+// error is used, falling back to codes.Unknown.
+func (e *Error) Code() codes.Code {
+	if e.hasCode {
+		return e.code
+	}
+
+	var se interface{ GRPCStatus() *status.Status }
+	switch {
+	case errors.As(e.err, &se):
+		return se.GRPCStatus().Code()
+	case errors.Is(e.err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded
+	case errors.Is(e.err, context.Canceled):
+		return codes.Canceled
+	default:
+		return codes.Unknown
+	}
+}
+
+// ExplicitCode returns the code attached with WithCode to the outermost Error
+// of the tree of err, if any, searching it in the order of errors.As.
+func ExplicitCode(err error) (codes.Code, bool) {
+	switch e := err.(type) {
+	case *Error:
+		if e.hasCode {
+			return e.code, true
+		}
+		return ExplicitCode(e.err)
+	case interface{ Unwrap() error }:
+		return ExplicitCode(e.Unwrap())
+	case interface{ Unwrap() []error }:
+		for _, err := range e.Unwrap() {
+			if c, ok := ExplicitCode(err); ok {
+				return c, true
+			}
+		}
+	}
+	return codes.OK, false
+}
+
+// GRPCStatus implements the interface used by the status package. Details of a
+// wrapped status are preserved unless the error carries its own code.
+func (e *Error) GRPCStatus() *status.Status {
+	var se interface{ GRPCStatus() *status.Status }
+	if !e.hasCode && errors.As(e.err, &se) {
+		p := se.GRPCStatus().Proto()
+		p.Message = e.Error()
+		return status.FromProto(p)
+	}
+	return status.New(e.Code(), e.Error())
+}
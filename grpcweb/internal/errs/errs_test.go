@@ -0,0 +1,103 @@
+package errs_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/errs"
+)
+
+func TestError(t *testing.T) {
+	cases := map[string]struct {
+		err          error
+		expectedMsg  string
+		expectedCode codes.Code
+		expectedIs   error
+	}{
+		"wrap": {
+			err:          errs.Wrap(io.ErrUnexpectedEOF, "failed to read"),
+			expectedMsg:  "failed to read: unexpected EOF",
+			expectedCode: codes.Unknown,
+			expectedIs:   io.ErrUnexpectedEOF,
+		},
+		"wrap status": {
+			err:          errs.Wrap(status.Error(codes.NotFound, "not found"), "failed to call"),
+			expectedMsg:  "failed to call: rpc error: code = NotFound desc = not found",
+			expectedCode: codes.NotFound,
+		},
+		"wrap context error": {
+			err:          errs.Wrapf(context.DeadlineExceeded, "failed to send %s", "request"),
+			expectedMsg:  "failed to send request: context deadline exceeded",
+			expectedCode: codes.DeadlineExceeded,
+			expectedIs:   context.DeadlineExceeded,
+		},
+		"with code": {
+			err:          errs.WithCode(codes.Unavailable, status.Error(codes.NotFound, "not found"), "gateway is down"),
+			expectedMsg:  "gateway is down: rpc error: code = NotFound desc = not found",
+			expectedCode: codes.Unavailable,
+		},
+		"nested": {
+			err:          errs.Wrap(errs.WithCode(codes.Internal, io.EOF, "bad frame"), "failed to receive"),
+			expectedMsg:  "failed to receive: bad frame: EOF",
+			expectedCode: codes.Internal,
+			expectedIs:   io.EOF,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			if c.err.Error() != c.expectedMsg {
+				t.Errorf("expected message '%s', but got '%s'", c.expectedMsg, c.err.Error())
+			}
+			if code := status.Code(c.err); code != c.expectedCode {
+				t.Errorf("expected code %s, but got %s", c.expectedCode, code)
+			}
+			if c.expectedIs != nil && !errors.Is(c.err, c.expectedIs) {
+				t.Errorf("expected the error to wrap '%s'", c.expectedIs)
+			}
+		})
+	}
+}
+
+func TestWrapNil(t *testing.T) {
+	if err := errs.Wrap(nil, "msg"); err != nil {
+		t.Errorf("Wrap(nil) should return nil, but got '%s'", err)
+	}
+}
+
+func TestExplicitCode(t *testing.T) {
+	cases := map[string]struct {
+		err          error
+		expectedCode codes.Code
+		expectedOK   bool
+	}{
+		"no code": {
+			err: errs.Wrap(status.Error(codes.NotFound, "not found"), "failed to call"),
+		},
+		"wrapped code": {
+			err:          errs.Wrap(errs.WithCode(codes.Internal, io.EOF, "bad frame"), "failed to receive"),
+			expectedCode: codes.Internal,
+			expectedOK:   true,
+		},
+		"joined": {
+			err:          fmt.Errorf("%w: %w", errs.WithCode(codes.DataLoss, nil, "lost"), io.ErrUnexpectedEOF),
+			expectedCode: codes.DataLoss,
+			expectedOK:   true,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			code, ok := errs.ExplicitCode(c.err)
+			if code != c.expectedCode || ok != c.expectedOK {
+				t.Errorf("expected (%s, %t), but got (%s, %t)", c.expectedCode, c.expectedOK, code, ok)
+			}
+		})
+	}
+}
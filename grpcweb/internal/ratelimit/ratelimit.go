@@ -0,0 +1,61 @@
+// Package ratelimit implements the token buckets throttling the bandwidth of
+// calls and streams.
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/transport"
+)
+
+// Limiter is a bucket of bytes refilled at a constant rate, which holds up to
+// one second worth of them. It is safe for concurrent use.
+type Limiter struct {
+	rate  float64
+	clock transport.Clock
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// New returns a full Limiter refilled with bytesPerSecond bytes per second.
+func New(bytesPerSecond int, clock transport.Clock) *Limiter {
+	return &Limiter{
+		rate:   float64(bytesPerSecond),
+		clock:  clock,
+		tokens: float64(bytesPerSecond),
+		last:   clock.Now(),
+	}
+}
+
+// WaitN takes n bytes from the bucket, blocking until they are available or
+// ctx is done. More bytes than the bucket holds may be taken at once, the
+// next callers then wait for the debt to be paid off.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := l.clock.Now()
+	l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+
+	t := l.clock.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C():
+		return nil
+	case <-ctx.Done():
+		// The bytes aren't transferred, they are given back.
+		l.mu.Lock()
+		l.tokens += float64(n)
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
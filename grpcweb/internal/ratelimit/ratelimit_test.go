@@ -0,0 +1,51 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/transport/transporttest"
+)
+
+func TestLimiter(t *testing.T) {
+	clock := transporttest.NewClock(time.Now())
+	l := New(100, clock)
+
+	// The bucket starts full.
+	if err := l.WaitN(context.Background(), 100); err != nil {
+		t.Fatalf("WaitN should not return an error, but got '%s'", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- l.WaitN(context.Background(), 50)
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(400 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatalf("WaitN should wait for 500ms")
+	case <-time.After(10 * time.Millisecond):
+	}
+	clock.Advance(100 * time.Millisecond)
+	if err := <-done; err != nil {
+		t.Fatalf("WaitN should not return an error, but got '%s'", err)
+	}
+
+	// The bucket is empty, a canceled wait gives the bytes back.
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		done <- l.WaitN(ctx, 100)
+	}()
+	clock.BlockUntil(1)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, but got '%v'", err)
+	}
+	clock.Advance(time.Second)
+	if err := l.WaitN(context.Background(), 100); err != nil {
+		t.Fatalf("WaitN should not return an error, but got '%s'", err)
+	}
+}
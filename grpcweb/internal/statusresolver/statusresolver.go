@@ -0,0 +1,185 @@
+// Package statusresolver resolves the status and the trailer of a call from
+// the parts of its response, the same way for unary calls and all kinds of
+// streams.
+//
+// A Resolver goes through the following states, driven by the events reported
+// by the caller:
+//
+//	Headers ──OnMessage──▶ Messages ──OnTrailer──▶ Done
+//	   │                      │
+//	   │                      └──OnEnd──▶ Done, without trailer
+//	   ├──OnTrailer──▶ Done
+//	   ├──OnEnd──▶ Done, trailers-only if the headers carry a status
+//	   └──OnTrailersOnly──▶ Done, trailers-only
+//
+// OnHeaders may be called in any state before Done, the headers arriving with
+// the first message on websocket streams. The status of a trailer frame wins.
+// A response whose body ends without one is resolved with the status of the
+// headers, as a trailers-only response, and is OK if they carry none, which
+// OnEnd reports as a protocol deviation. A response closed right after its
+// headers, as reported by OnTrailersOnly, must carry a status in them.
+package statusresolver
+
+import (
+	"strconv"
+	"strings"
+
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/errs"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/parser"
+)
+
+// State is the state of a Resolver.
+type State int
+
+const (
+	// Headers is the initial state, no frame has been received yet.
+	Headers State = iota
+	// Messages is the state once a message frame has been received.
+	Messages
+	// Done is the final state, the status is resolved.
+	Done
+)
+
+// Resolver resolves the status of a response. It must not be used
+// concurrently.
+type Resolver struct {
+	state        State
+	header       metadata.MD
+	status       *status.Status
+	trailer      metadata.MD
+	trailersOnly bool
+}
+
+// New returns a Resolver in the Headers state.
+func New() *Resolver {
+	return &Resolver{}
+}
+
+// State returns the current state.
+func (r *Resolver) State() State {
+	return r.state
+}
+
+// OnHeaders records the response headers md, including the status headers.
+func (r *Resolver) OnHeaders(md metadata.MD) {
+	r.header = md
+}
+
+// HeaderStatus returns the status carried by the headers, nil if there is
+// none.
+func (r *Resolver) HeaderStatus() *status.Status {
+	st, _ := FromMetadata(r.header)
+	return st
+}
+
+// OnMessage records a message frame. It fails once the status is resolved.
+func (r *Resolver) OnMessage() error {
+	if r.state == Done {
+		return errs.WithCode(codes.Internal, nil, "received a message after the trailer")
+	}
+	r.state = Messages
+	return nil
+}
+
+// OnTrailer resolves the status with the one of a trailer frame, st, and its
+// metadata md. It fails if the status is already resolved.
+func (r *Resolver) OnTrailer(st *status.Status, md metadata.MD) error {
+	if r.state == Done {
+		return errs.WithCode(codes.Internal, nil, "received a second trailer")
+	}
+	r.resolve(st, md, false)
+	return nil
+}
+
+// OnEnd resolves the status of a response whose body ended without a trailer
+// frame. deviation reports whether the response isn't a trailers-only one.
+func (r *Resolver) OnEnd() (st *status.Status, deviation bool) {
+	if r.state == Done {
+		return r.status, false
+	}
+	if st, ok := FromMetadata(r.header); ok {
+		if r.state == Headers {
+			r.resolve(st, r.header, true)
+			return st, false
+		}
+		r.resolve(st, nil, false)
+		return st, true
+	}
+	r.resolve(status.New(codes.OK, ""), nil, false)
+	return r.status, true
+}
+
+// OnTrailersOnly resolves the status of a response closed right after its
+// headers, which must carry it.
+func (r *Resolver) OnTrailersOnly() *status.Status {
+	if r.state == Done {
+		return r.status
+	}
+	st, ok := FromMetadata(r.header)
+	if !ok {
+		st = status.New(codes.Unknown, "response closed without grpc-status (headers only)")
+	}
+	r.resolve(st, r.header, true)
+	return st
+}
+
+func (r *Resolver) resolve(st *status.Status, trailer metadata.MD, trailersOnly bool) {
+	r.status, r.trailer, r.trailersOnly = st, trailer, trailersOnly
+	r.state = Done
+}
+
+// Status returns the resolved status, nil before the Done state.
+func (r *Resolver) Status() *status.Status {
+	return r.status
+}
+
+// Trailer returns the trailer metadata. The one of a trailers-only response
+// are its headers.
+func (r *Resolver) Trailer() metadata.MD {
+	return r.trailer
+}
+
+// TrailersOnly reports whether the status was resolved from the headers of a
+// trailers-only response.
+func (r *Resolver) TrailersOnly() bool {
+	return r.trailersOnly
+}
+
+// FromMetadata returns the status carried by the grpc-status, grpc-message
+// and grpc-status-details-bin values of md, and whether md has a grpc-status.
+// Several grpc-status values must agree, several grpc-message values are
+// joined like HTTP headers are. The message is percent-decoded.
+func FromMetadata(md metadata.MD) (*status.Status, bool) {
+	values := md.Get("grpc-status")
+	if len(values) == 0 {
+		return nil, false
+	}
+	v := strings.TrimSpace(values[0])
+	for _, vv := range values[1:] {
+		if strings.TrimSpace(vv) != v {
+			return status.Newf(codes.Unknown, "conflicting grpc-status values %q", values), true
+		}
+	}
+	n, err := strconv.ParseUint(v, 10, 32)
+	if err != nil {
+		return status.Newf(codes.Unknown, "invalid grpc-status %q", v), true
+	}
+
+	if details := md.Get("grpc-status-details-bin"); len(details) > 0 {
+		s := new(spb.Status)
+		if err := proto.Unmarshal([]byte(details[len(details)-1]), s); err != nil {
+			// Same behavior as grpc/grpc-go.
+			return status.Newf(codes.Internal, "transport: malformed grpc-status-details-bin: %v", err), true
+		}
+		return status.FromProto(s), true
+	}
+
+	msg := parser.DecodeMessage(strings.Join(md.Get("grpc-message"), ", "))
+	return status.New(codes.Code(n), msg), true
+}
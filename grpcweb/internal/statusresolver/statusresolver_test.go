@@ -0,0 +1,232 @@
+package statusresolver
+
+import (
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestResolver(t *testing.T) {
+	header := metadata.Pairs("grpc-status", "5", "grpc-message", "header")
+	trailer := metadata.Pairs("grpc-status", "3", "grpc-message", "trailer")
+
+	cases := map[string]struct {
+		header               metadata.MD
+		events               func(r *Resolver) *status.Status
+		expectedCode         codes.Code
+		expectedMessage      string
+		expectedDeviation    bool
+		expectedTrailersOnly bool
+		expectedTrailer      metadata.MD
+	}{
+		"trailer": {
+			header: metadata.MD{},
+			events: func(r *Resolver) *status.Status {
+				if err := r.OnMessage(); err != nil {
+					t.Fatalf("OnMessage should not return an error, but got '%s'", err)
+				}
+				if err := r.OnTrailer(status.New(codes.InvalidArgument, "trailer"), trailer); err != nil {
+					t.Fatalf("OnTrailer should not return an error, but got '%s'", err)
+				}
+				return r.Status()
+			},
+			expectedCode:    codes.InvalidArgument,
+			expectedMessage: "trailer",
+			expectedTrailer: trailer,
+		},
+		"trailer wins over the headers": {
+			header: header,
+			events: func(r *Resolver) *status.Status {
+				if err := r.OnTrailer(status.New(codes.InvalidArgument, "trailer"), trailer); err != nil {
+					t.Fatalf("OnTrailer should not return an error, but got '%s'", err)
+				}
+				return r.Status()
+			},
+			expectedCode:    codes.InvalidArgument,
+			expectedMessage: "trailer",
+			expectedTrailer: trailer,
+		},
+		"end after a message": {
+			header: metadata.MD{},
+			events: func(r *Resolver) *status.Status {
+				if err := r.OnMessage(); err != nil {
+					t.Fatalf("OnMessage should not return an error, but got '%s'", err)
+				}
+				st, deviation := r.OnEnd()
+				if !deviation {
+					t.Errorf("expected a deviation")
+				}
+				return st
+			},
+			expectedCode: codes.OK,
+		},
+		"end after a message with a status in the headers": {
+			header: header,
+			events: func(r *Resolver) *status.Status {
+				if err := r.OnMessage(); err != nil {
+					t.Fatalf("OnMessage should not return an error, but got '%s'", err)
+				}
+				st, deviation := r.OnEnd()
+				if !deviation {
+					t.Errorf("expected a deviation")
+				}
+				return st
+			},
+			expectedCode:    codes.NotFound,
+			expectedMessage: "header",
+		},
+		"trailers-only end": {
+			header: header,
+			events: func(r *Resolver) *status.Status {
+				st, deviation := r.OnEnd()
+				if deviation {
+					t.Errorf("expected no deviation")
+				}
+				return st
+			},
+			expectedCode:         codes.NotFound,
+			expectedMessage:      "header",
+			expectedTrailersOnly: true,
+			expectedTrailer:      header,
+		},
+		"trailers-only": {
+			header:               header,
+			events:               (*Resolver).OnTrailersOnly,
+			expectedCode:         codes.NotFound,
+			expectedMessage:      "header",
+			expectedTrailersOnly: true,
+			expectedTrailer:      header,
+		},
+		"trailers-only without status": {
+			header:               metadata.Pairs("nano", "yuko"),
+			events:               (*Resolver).OnTrailersOnly,
+			expectedCode:         codes.Unknown,
+			expectedMessage:      "response closed without grpc-status (headers only)",
+			expectedTrailersOnly: true,
+			expectedTrailer:      metadata.Pairs("nano", "yuko"),
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := New()
+			r.OnHeaders(c.header)
+			st := c.events(r)
+			if r.State() != Done {
+				t.Errorf("expected the Done state, but got %d", r.State())
+			}
+			if st.Code() != c.expectedCode {
+				t.Errorf("expected code %s, but got %s", c.expectedCode, st.Code())
+			}
+			if st.Message() != c.expectedMessage {
+				t.Errorf("expected message %q, but got %q", c.expectedMessage, st.Message())
+			}
+			if r.TrailersOnly() != c.expectedTrailersOnly {
+				t.Errorf("expected trailers-only %t, but got %t", c.expectedTrailersOnly, r.TrailersOnly())
+			}
+			if len(r.Trailer()) != len(c.expectedTrailer) {
+				t.Errorf("expected trailer %v, but got %v", c.expectedTrailer, r.Trailer())
+			}
+		})
+	}
+}
+
+func TestResolverDone(t *testing.T) {
+	r := New()
+	if err := r.OnTrailer(status.New(codes.OK, ""), nil); err != nil {
+		t.Fatalf("OnTrailer should not return an error, but got '%s'", err)
+	}
+	if err := r.OnMessage(); status.Code(err) != codes.Internal {
+		t.Errorf("expected OnMessage to fail with Internal, but got '%v'", err)
+	}
+	if err := r.OnTrailer(status.New(codes.OK, ""), nil); status.Code(err) != codes.Internal {
+		t.Errorf("expected OnTrailer to fail with Internal, but got '%v'", err)
+	}
+	if st, deviation := r.OnEnd(); st.Code() != codes.OK || deviation {
+		t.Errorf("expected OnEnd to keep the resolved status, but got %s, %t", st.Code(), deviation)
+	}
+}
+
+func TestFromMetadata(t *testing.T) {
+	details, err := proto.Marshal(status.New(codes.NotFound, "detailed").Proto())
+	if err != nil {
+		t.Fatalf("Marshal should not return an error, but got '%s'", err)
+	}
+
+	cases := map[string]struct {
+		md              metadata.MD
+		expectedOK      bool
+		expectedCode    codes.Code
+		expectedMessage string
+	}{
+		"absent": {
+			md: metadata.Pairs("grpc-message", "ignored"),
+		},
+		"status only": {
+			md:           metadata.Pairs("grpc-status", "5"),
+			expectedOK:   true,
+			expectedCode: codes.NotFound,
+		},
+		"percent-encoded message": {
+			md:              metadata.Pairs("grpc-status", "3", "grpc-message", "caf%C3%A9"),
+			expectedOK:      true,
+			expectedCode:    codes.InvalidArgument,
+			expectedMessage: "café",
+		},
+		"several messages": {
+			md:              metadata.Pairs("grpc-status", "3", "grpc-message", "a", "grpc-message", "b"),
+			expectedOK:      true,
+			expectedCode:    codes.InvalidArgument,
+			expectedMessage: "a, b",
+		},
+		"repeated status": {
+			md:           metadata.Pairs("grpc-status", "5", "grpc-status", " 5"),
+			expectedOK:   true,
+			expectedCode: codes.NotFound,
+		},
+		"conflicting statuses": {
+			md:              metadata.Pairs("grpc-status", "5", "grpc-status", "0"),
+			expectedOK:      true,
+			expectedCode:    codes.Unknown,
+			expectedMessage: `conflicting grpc-status values ["5" "0"]`,
+		},
+		"invalid status": {
+			md:              metadata.Pairs("grpc-status", "nano"),
+			expectedOK:      true,
+			expectedCode:    codes.Unknown,
+			expectedMessage: `invalid grpc-status "nano"`,
+		},
+		"details": {
+			md:              metadata.Pairs("grpc-status", "5", "grpc-status-details-bin", string(details)),
+			expectedOK:      true,
+			expectedCode:    codes.NotFound,
+			expectedMessage: "detailed",
+		},
+		"malformed details": {
+			md:           metadata.Pairs("grpc-status", "5", "grpc-status-details-bin", "\xff"),
+			expectedOK:   true,
+			expectedCode: codes.Internal,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			st, ok := FromMetadata(c.md)
+			if ok != c.expectedOK {
+				t.Fatalf("expected ok %t, but got %t", c.expectedOK, ok)
+			}
+			if !ok {
+				return
+			}
+			if st.Code() != c.expectedCode {
+				t.Errorf("expected code %s, but got %s", c.expectedCode, st.Code())
+			}
+			if c.expectedMessage != "" && st.Message() != c.expectedMessage {
+				t.Errorf("expected message %q, but got %q", c.expectedMessage, st.Message())
+			}
+		})
+	}
+}
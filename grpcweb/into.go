@@ -0,0 +1,78 @@
+package grpcweb
+
+import (
+	"context"
+	"io"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/mem"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/errs"
+)
+
+// DecodeFunc writes the encoded response message msg to w, e.g. after
+// decoding it and extracting the bytes of a chunk.
+type DecodeFunc func(w io.Writer, msg []byte) error
+
+// InvokeServerStreamInto calls the server streaming method with req, and
+// hands every response message over to decode, which writes it to w. The
+// encoded messages are written to w as is if decode is nil. It suits the
+// download-style methods, sparing the caller the management of the Stream. It
+// returns once the stream ends, nil if it ends successfully.
+func (c *ClientConn) InvokeServerStreamInto(ctx context.Context, method string, req any, w io.Writer, decode DecodeFunc, opts ...CallOption) error {
+	if decode == nil {
+		decode = func(w io.Writer, msg []byte) error {
+			_, err := w.Write(msg)
+			return err
+		}
+	}
+
+	// The raw codec is applied last, on top of the codec of the call.
+	opts = append(opts[:len(opts):len(opts)], newCallOption(func(o *callOptions) {
+		o.codec = rawCodec{o.codec}
+	}))
+	// Canceling the stream releases it if decode fails.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := c.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, method, opts...)
+	if err != nil {
+		return err
+	}
+	if err := stream.SendMsg(req); err != nil {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+
+	for {
+		var msg rawMessage
+		if err := stream.RecvMsg(&msg); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := decode(w, msg); err != nil {
+			return errs.Wrap(err, "failed to decode a response message")
+		}
+	}
+}
+
+// rawMessage is an encoded message, which rawCodec doesn't decode.
+type rawMessage []byte
+
+// rawCodec keeps the response messages received into a *rawMessage encoded,
+// and delegates the rest to the codec of the call.
+type rawCodec struct {
+	encoding.CodecV2
+}
+
+func (c rawCodec) Unmarshal(data mem.BufferSlice, v any) error {
+	if m, ok := v.(*rawMessage); ok {
+		*m = data.Materialize()
+		return nil
+	}
+	return c.CodecV2.Unmarshal(data, v)
+}
@@ -0,0 +1,53 @@
+package grpcweb
+
+import (
+	"io"
+	"iter"
+
+	"google.golang.org/grpc"
+)
+
+// Messages returns an iterator over the messages received on s, for use with
+// a range loop:
+//
+//	for msg, err := range grpcweb.Messages[pb.Item](stream) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// The iteration ends after the last message, or after yielding the error
+// which ended the stream; io.EOF is not yielded. If the loop is exited early,
+// the sending side of s is closed and the remaining messages are received and
+// discarded, so that the stream is released. Cancel the context of s to
+// abandon the remaining messages instead.
+func Messages[T any](s grpc.ClientStream) iter.Seq2[*T, error] {
+	return func(yield func(*T, error) bool) {
+		for {
+			msg := new(T)
+			err := s.RecvMsg(msg)
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if !yield(msg, nil) {
+				drain[T](s)
+				return
+			}
+		}
+	}
+}
+
+// drain closes the sending side of s and discards its remaining messages.
+func drain[T any](s grpc.ClientStream) {
+	s.CloseSend()
+	for {
+		if err := s.RecvMsg(new(T)); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Package metrics exports ClientConn internals as Prometheus metrics.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb"
+)
+
+// StreamCollector is a prometheus.Collector which reports the streams that are
+// currently open on a ClientConn as gauges partitioned by method.
+type StreamCollector struct {
+	cc *grpcweb.ClientConn
+
+	activeStreams   *prometheus.Desc
+	oldestStreamAge *prometheus.Desc
+}
+
+// NewStreamCollector returns a collector for the active streams of cc.
+func NewStreamCollector(cc *grpcweb.ClientConn, constLabels prometheus.Labels) *StreamCollector {
+	return &StreamCollector{
+		cc: cc,
+		activeStreams: prometheus.NewDesc(
+			"grpcweb_client_active_streams",
+			"Number of streams which are currently open.",
+			[]string{"grpc_method"},
+			constLabels,
+		),
+		oldestStreamAge: prometheus.NewDesc(
+			"grpcweb_client_oldest_stream_age_seconds",
+			"Age of the oldest stream which is currently open.",
+			[]string{"grpc_method"},
+			constLabels,
+		),
+	}
+}
+
+func (c *StreamCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.activeStreams
+	ch <- c.oldestStreamAge
+}
+
+func (c *StreamCollector) Collect(ch chan<- prometheus.Metric) {
+	counts := make(map[string]int)
+	oldest := make(map[string]float64)
+	for _, s := range c.cc.ActiveStreams() {
+		counts[s.Method]++
+		// ActiveStreams is sorted by age, so the first stream is the oldest one.
+		if _, ok := oldest[s.Method]; !ok {
+			oldest[s.Method] = s.Age().Seconds()
+		}
+	}
+
+	for method, n := range counts {
+		ch <- prometheus.MustNewConstMetric(c.activeStreams, prometheus.GaugeValue, float64(n), method)
+		ch <- prometheus.MustNewConstMetric(c.oldestStreamAge, prometheus.GaugeValue, oldest[method], method)
+	}
+}
+
+// TrafficCollector is a prometheus.Collector which reports the cumulative
+// traffic of a ClientConn as counters partitioned by method, see
+// grpcweb.ClientConn.Traffic.
+type TrafficCollector struct {
+	cc *grpcweb.ClientConn
+
+	sentBytes     *prometheus.Desc
+	receivedBytes *prometheus.Desc
+}
+
+// NewTrafficCollector returns a collector for the traffic of cc.
+func NewTrafficCollector(cc *grpcweb.ClientConn, constLabels prometheus.Labels) *TrafficCollector {
+	return &TrafficCollector{
+		cc: cc,
+		sentBytes: prometheus.NewDesc(
+			"grpcweb_client_sent_bytes_total",
+			"Number of bytes sent on the wire, headers and frames included.",
+			[]string{"grpc_method"},
+			constLabels,
+		),
+		receivedBytes: prometheus.NewDesc(
+			"grpcweb_client_received_bytes_total",
+			"Number of bytes received on the wire, headers and frames included.",
+			[]string{"grpc_method"},
+			constLabels,
+		),
+	}
+}
+
+func (c *TrafficCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.sentBytes
+	ch <- c.receivedBytes
+}
+
+func (c *TrafficCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, t := range c.cc.Traffic() {
+		ch <- prometheus.MustNewConstMetric(c.sentBytes, prometheus.CounterValue, float64(t.SentBytes), t.Method)
+		ch <- prometheus.MustNewConstMetric(c.receivedBytes, prometheus.CounterValue, float64(t.ReceivedBytes), t.Method)
+	}
+}
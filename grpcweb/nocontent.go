@@ -0,0 +1,49 @@
+package grpcweb
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/transport"
+)
+
+// WithNoContentAsEmpty makes the unary calls answered with HTTP 204 No
+// Content or 205 Reset Content succeed with an empty response message, rather
+// than fail with transport.ErrInvalidResponseCode, for the gateways which
+// collapse empty responses into them. A grpc-status header in such a response
+// still takes precedence.
+func WithNoContentAsEmpty() DialOption {
+	return func(opt *dialOptions) {
+		opt.noContentAsEmpty = true
+	}
+}
+
+// noContent returns the header of the response reported by err if it is a
+// 204 or 205 one which WithNoContentAsEmpty maps to an empty response.
+func (o *dialOptions) noContent(err error) (http.Header, bool) {
+	var he *transport.HTTPError
+	if !o.noContentAsEmpty || !errors.As(err, &he) {
+		return nil, false
+	}
+	if he.StatusCode != http.StatusNoContent && he.StatusCode != http.StatusResetContent {
+		return nil, false
+	}
+	return he.Header, true
+}
+
+// emptyResponse returns the header and the body of a gRPC-Web response made of
+// an empty message and an OK status, in place of a response without content
+// whose header is h.
+func emptyResponse(h http.Header, contentType string) (http.Header, io.ReadCloser) {
+	h = h.Clone()
+	if h == nil {
+		h = make(http.Header)
+	}
+	h.Set("Content-Type", contentType)
+
+	trailer := "grpc-status: 0\r\n"
+	body := append([]byte{0, 0, 0, 0, 0, 0x80, 0, 0, 0, byte(len(trailer))}, trailer...)
+	return h, io.NopCloser(bytes.NewReader(body))
+}
@@ -1,28 +1,120 @@
 package grpcweb
 
 import (
+	"context"
 	"crypto/tls"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
 	"google.golang.org/grpc/encoding"
 	"google.golang.org/grpc/encoding/proto"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/har"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/parser"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/transport"
 )
 
 var (
-	defaultDialOptions = dialOptions{}
+	defaultDialOptions = dialOptions{
+		frameParser: parser.DefaultFrameParser,
+		clock:       transport.SystemClock,
+	}
 	defaultCallOptions = callOptions{
 		codec: encoding.GetCodecV2(proto.Name),
 	}
 )
 
 type dialOptions struct {
+	// err is returned by NewClient, see WithDialError.
+	err                error
 	defaultCallOptions []CallOption
+	clock              transport.Clock
 	insecure           bool
 	tlsConf            *tls.Config
+	maxBufferSize      int
+	urlRewriter        URLRewriter
+	lowercaseHeaders   bool
+	contentLength      bool
+	maxHeaderListSize  uint32
+	maxHeaderCount     int
+	strict             bool
+	logger             *slog.Logger
+	frameParser        parser.FrameParser
+	statsHandlers      []stats.Handler
+	dialAttempts       int
+	dialBackoff        backoff.Config
+	connectParams      *grpc.ConnectParams
+	keepalive          *keepalive.ClientParameters
+	eventListeners     []transport.EventListener
+	receivePump        bool
+	receiveBuffer      int
+	harRecorder        *har.Recorder
+	transportWrappers  []TransportWrapper
+	propagation        *Propagation
+	userAgent          string
+	unaryInterceptor   UnaryClientInterceptor
+	streamInterceptor  StreamClientInterceptor
+	methodPolicy       *MethodPolicy
+	redactor           Redactor
+	validator          Validator
+	readBufferSize     int
+	writeBufferSize    int
+	retryPolicy        *RetryPolicy
+
+	compressionThreshold int
+	transformers         []MessageTransformer
+	resolver             Resolver
+	resolveInterval      time.Duration
+	balancer             LoadBalancingPolicy
+	outlierDetection     *OutlierDetection
+	affinity             *affinity
+	queueTimeout         time.Duration
+	idempotencyHeader    string
+	wsReadLimit          int64
+	envelope             Envelope
+	reorderWindow        int
+	noContentAsEmpty     bool
+	uploadRate           int
+	downloadRate         int
+	maxStatusSize        int
+	padding              FramePadding
+	block                bool
+	webSocketConn        WebSocketConnFunc
+	requestTemplate      *http.Request
+	unknownFrames        UnknownFrameAction
+	unknownFrameHandler  UnknownFrameHandler
+
+	// chainUnaryInterceptors and chainStreamInterceptors are combined into
+	// unaryInterceptor and streamInterceptor by NewClient.
+	chainUnaryInterceptors  []UnaryClientInterceptor
+	chainStreamInterceptors []StreamClientInterceptor
+
+	adaptiveConcurrency *AdaptiveConcurrency
+	rateBudget          *rateBudget
+	chunking            *chunking
 }
 
 type DialOption func(*dialOptions)
 
+// WithDialError makes NewClient fail with err, so that the packages building
+// options on top of grpcweb, such as grpccompat, can reject a configuration
+// they can't honor. The first error wins.
+func WithDialError(err error) DialOption {
+	return func(opt *dialOptions) {
+		if opt.err == nil {
+			opt.err = err
+		}
+	}
+}
+
 func WithDefaultCallOptions(opts ...CallOption) DialOption {
 	return func(opt *dialOptions) {
 		opt.defaultCallOptions = opts
@@ -41,29 +133,422 @@ func WithTLSConfig(conf *tls.Config) DialOption {
 	}
 }
 
+// WithMaxBufferSize limits the number of bytes buffered for a single response
+// message of a call or stream. Messages exceeding the limit fail the call with
+// codes.ResourceExhausted, see transport.ErrBufferLimitExceeded. Zero means no
+// limit.
+func WithMaxBufferSize(n int) DialOption {
+	return func(opt *dialOptions) {
+		opt.maxBufferSize = n
+	}
+}
+
+// WithReadBufferSize sets the size of the buffer response frames are read
+// through. Larger buffers favor throughput for large messages, smaller ones
+// lower the memory held by streams of small messages. Unary and server
+// streaming responses are unbuffered if n is zero, websocket streams use a
+// 4 KiB buffer.
+func WithReadBufferSize(n int) DialOption {
+	return func(opt *dialOptions) {
+		opt.readBufferSize = n
+	}
+}
+
+// WithWebSocketReadLimit limits the size of the websocket messages received by
+// client and bidirectional streams. A larger message fails the stream with
+// codes.ResourceExhausted, see transport.ErrReadLimitExceeded.
+func WithWebSocketReadLimit(n int64) DialOption {
+	return func(opt *dialOptions) {
+		opt.wsReadLimit = n
+	}
+}
+
+// WithWriteBufferSize sets the size of the write buffer of websocket streams,
+// which is 4 KiB if n is zero.
+func WithWriteBufferSize(n int) DialOption {
+	return func(opt *dialOptions) {
+		opt.writeBufferSize = n
+	}
+}
+
+// URLRewriter is called with the full method name and the fully resolved
+// request URL before each call. It may modify the URL in place, e.g. to move
+// the method name into a query parameter. Returning an error fails the call.
+type URLRewriter func(method string, u *url.URL) error
+
+// WithURLRewriter sets a function to observe or rewrite request URLs.
+func WithURLRewriter(f URLRewriter) DialOption {
+	return func(opt *dialOptions) {
+		opt.urlRewriter = f
+	}
+}
+
+// WithLowercaseHeaders makes the client send request header keys in lowercase,
+// both as HTTP headers and in the websocket header frame. By default keys are
+// canonicalized (e.g. Grpc-Timeout), which some gateways reject.
+func WithLowercaseHeaders() DialOption {
+	return func(opt *dialOptions) {
+		opt.lowercaseHeaders = true
+	}
+}
+
+// WithRequestTemplate makes the requests start from req, for the gateways
+// expecting a request shape the other options can't express: the scheme,
+// host and query values of its URL, its Host and its header apply to every
+// call, unless the call sets the same header. See
+// transport.WithRequestTemplate.
+func WithRequestTemplate(req *http.Request) DialOption {
+	return func(opt *dialOptions) {
+		opt.requestTemplate = req.Clone(context.Background())
+	}
+}
+
+// WithContentLength makes the unary calls and server streams send their
+// request with a Content-Length rather than chunked, and without the Expect
+// header, for the WAFs rejecting such requests. The request bodies which
+// aren't in memory, such as the ones streamed by a TransportWrapper, are
+// buffered for it.
+func WithContentLength() DialOption {
+	return func(opt *dialOptions) {
+		opt.contentLength = true
+	}
+}
+
+// WithMaxHeaderListSize limits the total size in bytes of the keys and values
+// of the response headers and of the trailers. Responses exceeding the limit
+// fail with codes.ResourceExhausted. Zero means no limit.
+func WithMaxHeaderListSize(n uint32) DialOption {
+	return func(opt *dialOptions) {
+		opt.maxHeaderListSize = n
+	}
+}
+
+// WithMaxHeaderCount limits the number of key/value pairs in the response
+// headers and in the trailers. Zero means no limit.
+func WithMaxHeaderCount(n int) DialOption {
+	return func(opt *dialOptions) {
+		opt.maxHeaderCount = n
+	}
+}
+
+// WithStrictMode makes the client fail calls with codes.Internal on any
+// deviation from the gRPC-Web protocol, such as missing trailers, a wrong
+// content-type or unexpected frames. By default the client is lenient: it
+// tolerates known gateway quirks and reports them through the logger.
+func WithStrictMode() DialOption {
+	return func(opt *dialOptions) {
+		opt.strict = true
+	}
+}
+
+// WithLogger sets the logger used to report diagnostics such as protocol
+// deviations. Nothing is logged by default.
+func WithLogger(l *slog.Logger) DialOption {
+	return func(opt *dialOptions) {
+		opt.logger = l
+	}
+}
+
+// WithFrameParser replaces the parser used to read response frames of calls
+// and streams. It defaults to parser.DefaultFrameParser.
+func WithFrameParser(p parser.FrameParser) DialOption {
+	return func(opt *dialOptions) {
+		if p == nil {
+			p = parser.DefaultFrameParser
+		}
+		opt.frameParser = p
+	}
+}
+
+// WithStatsHandler adds a handler which is notified of the events of every
+// call and stream, such as sent and received messages. The events are the
+// same as the ones of grpc-go, so handlers written for grpc-go clients can be
+// used.
+func WithStatsHandler(h stats.Handler) DialOption {
+	return func(opt *dialOptions) {
+		opt.statsHandlers = append(opt.statsHandlers, h)
+	}
+}
+
+// WithDialRetry makes streams retry a failed websocket dial up to attempts
+// times in total, waiting according to c between the attempts. A zero c falls
+// back to the backoff set with WithConnectParams. DNS, TLS and handshake
+// failures which can't be transient, such as 403 responses, are not retried.
+func WithDialRetry(attempts int, c backoff.Config) DialOption {
+	return func(opt *dialOptions) {
+		opt.dialAttempts = attempts
+		opt.dialBackoff = c
+	}
+}
+
+// WithConnectParams sets the backoff used between the websocket dial attempts
+// and the resumptions of resumable streams when they don't configure their
+// own, and bounds each dial attempt to the larger of p.MinConnectTimeout and
+// the backoff delay, like grpc-go does for its connection attempts.
+func WithConnectParams(p grpc.ConnectParams) DialOption {
+	return func(opt *dialOptions) {
+		opt.connectParams = &p
+	}
+}
+
+// WithKeepaliveParams makes client and bidi streams ping the server every
+// p.Time, like grpc-go does, and fail with transport.ErrPingTimeout when the
+// pong doesn't arrive within p.Timeout, 20 seconds by default. The streams
+// read their websocket in a dedicated goroutine then, as with
+// WithReceivePump, so that the pongs arrive even while they only send.
+// p.PermitWithoutStream doesn't apply, every stream has its own websocket.
+func WithKeepaliveParams(p keepalive.ClientParameters) DialOption {
+	return func(opt *dialOptions) {
+		if p.Timeout <= 0 {
+			p.Timeout = 20 * time.Second
+		}
+		opt.keepalive = &p
+	}
+}
+
+// backoffConfig returns c unless it is the zero value, in which case it falls
+// back to the backoff of the connect params, then to backoff.DefaultConfig.
+func (o *dialOptions) backoffConfig(c backoff.Config) backoff.Config {
+	switch {
+	case c != backoff.Config{}:
+		return c
+	case o.connectParams != nil && o.connectParams.Backoff != backoff.Config{}:
+		return o.connectParams.Backoff
+	default:
+		return backoff.DefaultConfig
+	}
+}
+
+// WithClock sets the clock of the retries, backoffs and timeouts of the
+// client, and of the times reported in CallInfo, CallResult and to the stats
+// handlers, e.g. a transporttest.Clock in tests. It defaults to
+// transport.SystemClock.
+func WithClock(c transport.Clock) DialOption {
+	return func(opt *dialOptions) {
+		opt.clock = c
+	}
+}
+
+// WithTransportEventListener adds a listener which is notified of the
+// connection events of the transports, such as established connections,
+// reconnects and stream resets. Listeners are called synchronously, so they
+// must not block.
+func WithTransportEventListener(l transport.EventListener) DialOption {
+	return func(opt *dialOptions) {
+		opt.eventListeners = append(opt.eventListeners, l)
+	}
+}
+
+// WithReceivePump makes client and bidi streams read their websocket in a
+// dedicated goroutine, so that the connection is drained independently of the
+// RecvMsg calls and RecvMsg can be interrupted by the stream context. Up to
+// buffer response messages are read ahead.
+func WithReceivePump(buffer int) DialOption {
+	return func(opt *dialOptions) {
+		opt.receivePump = true
+		opt.receiveBuffer = buffer
+	}
+}
+
+// WithHARRecorder records the URL, headers, timing and frames of every call
+// and stream into r. The size of the capture is bounded by the options r was
+// created with.
+func WithHARRecorder(r *har.Recorder) DialOption {
+	return func(opt *dialOptions) {
+		opt.harRecorder = r
+	}
+}
+
+// Redactor masks sensitive data in the encoded payload of a message of the
+// given method. It returns the payload to expose in place of the original one.
+type Redactor func(method string, payload []byte) []byte
+
+// WithRedactor sets the redactor applied to every payload before it is handed
+// to the logger or the HAR recorder, so that PII can be masked centrally
+// instead of disabling payload logging entirely.
+func WithRedactor(r Redactor) DialOption {
+	return func(opt *dialOptions) {
+		opt.redactor = r
+	}
+}
+
+// redact applies the redactor, if any, to payload.
+func (o *dialOptions) redact(method string, payload []byte) []byte {
+	if o.redactor == nil {
+		return payload
+	}
+	return o.redactor(method, payload)
+}
+
+// TransportWrapper decorates the transports created for calls and streams,
+// e.g. to observe or alter the exchanged frames.
+type TransportWrapper interface {
+	WrapUnary(method string, tr transport.UnaryTransport) transport.UnaryTransport
+	WrapClientStream(method string, tr transport.ClientStreamTransport) transport.ClientStreamTransport
+}
+
+// WithTransportWrapper adds a wrapper around the transports of every call and
+// stream. Wrappers are applied in the order they are added, so the last one
+// is the outermost.
+func WithTransportWrapper(w TransportWrapper) DialOption {
+	return func(opt *dialOptions) {
+		opt.transportWrappers = append(opt.transportWrappers, w)
+	}
+}
+
+// WithUserAgent sets the User-Agent header of every call and stream.
+func WithUserAgent(s string) DialOption {
+	return func(opt *dialOptions) {
+		opt.userAgent = s
+	}
+}
+
+// setRequestHeader sets the headers the client adds to the request of every
+// call made with ctx.
+func (o *dialOptions) setRequestHeader(ctx context.Context, h http.Header) {
+	if o.userAgent != "" {
+		h.Set("User-Agent", o.userAgent)
+	}
+	if attempt := AttemptFromContext(ctx); attempt > 1 {
+		h.Set("grpc-previous-rpc-attempts", strconv.Itoa(attempt-1))
+	}
+	o.affinity.set(h)
+}
+
+// handshakeHeader returns the headers the client adds to the websocket
+// handshakes of the streams, on which the gateways route.
+func (o *dialOptions) handshakeHeader() http.Header {
+	h := make(http.Header)
+	if o.userAgent != "" {
+		h.Set("User-Agent", o.userAgent)
+	}
+	o.affinity.set(h)
+	return h
+}
+
 type callOptions struct {
 	codec           encoding.CodecV2
 	header, trailer *metadata.MD
+	rawHeader       *metadata.MD
+	stats           *CallStats
+
+	inactivityTimeout time.Duration
+	recvTimeout       time.Duration
+	// receivePump enables the receive pump of the dial options for the call.
+	receivePump bool
+
+	// compressor is the name of the compressor of the request messages.
+	compressor           string
+	compressionThreshold int
+
+	// host overrides the target of the ClientConn, see Host.
+	host string
+	// affinityKey pins the call to an address of the resolver.
+	affinityKey    string
+	waitForReady   bool
+	idempotencyKey string
+
+	// attempt collects the retry information of the attempt of a call.
+	attempt *attemptInfo
+
+	// capture records the call when a HAR recorder is set.
+	capture *har.Call
+
+	// result collects the details of a call made with InvokeFull.
+	result *CallResult
+
+	uploadRate, downloadRate int
+
+	// frameHandlers are notified of the frames of the call, see OnFrame.
+	frameHandlers []func(FrameEvent)
+
+	// sequencer stamps and verifies the messages, see Sequence.
+	sequencer *Sequencer
+	seq       sequence
+
+	// priority orders the admission of the call, see Priority.
+	priority PriorityClass
 }
 
-type CallOption func(*callOptions)
+// CallOption configures a call. It is a grpc.CallOption, so that ClientConn
+// implements grpc.ClientConnInterface: the grpc-go call options with a
+// counterpart, i.e. grpc.Header, grpc.Trailer, grpc.CallContentSubtype and
+// grpc.WaitForReady, are honored, the other ones are ignored.
+type CallOption = grpc.CallOption
+
+// funcCallOption is a CallOption of this package.
+type funcCallOption struct {
+	grpc.EmptyCallOption
+	apply func(*callOptions)
+}
+
+func newCallOption(f func(*callOptions)) CallOption {
+	return funcCallOption{apply: f}
+}
+
+// applyCallOption applies o to opt.
+func applyCallOption(o CallOption, opt *callOptions) {
+	switch o := o.(type) {
+	case funcCallOption:
+		o.apply(opt)
+	case grpc.HeaderCallOption:
+		applyCallOption(Header(o.HeaderAddr), opt)
+	case grpc.TrailerCallOption:
+		applyCallOption(Trailer(o.TrailerAddr), opt)
+	case grpc.ContentSubtypeCallOption:
+		applyCallOption(CallContentSubtype(o.ContentSubtype), opt)
+	case grpc.FailFastCallOption:
+		applyCallOption(WaitForReady(!o.FailFast), opt)
+	}
+}
 
 func CallContentSubtype(contentSubtype string) CallOption {
-	return func(opt *callOptions) {
+	return newCallOption(func(opt *callOptions) {
 		opt.codec = encoding.GetCodecV2(contentSubtype)
-	}
+	})
 }
 
 func Header(h *metadata.MD) CallOption {
-	return func(opt *callOptions) {
+	return newCallOption(func(opt *callOptions) {
 		*h = metadata.New(nil)
 		opt.header = h
-	}
+	})
 }
 
+// RawHeader returns a CallOption which stores the header metadata of the call
+// in h, including the grpc-status, grpc-message and grpc-status-details-bin
+// headers of trailers-only responses which Header leaves out like grpc-go.
+// Unlike Header, it is filled even if the call fails.
+func RawHeader(h *metadata.MD) CallOption {
+	return newCallOption(func(opt *callOptions) {
+		*h = metadata.New(nil)
+		opt.rawHeader = h
+	})
+}
+
+// Trailer returns a CallOption which stores the trailer metadata of the call
+// in t. The streams fill it once they receive the trailer.
 func Trailer(t *metadata.MD) CallOption {
-	return func(opt *callOptions) {
+	return newCallOption(func(opt *callOptions) {
 		*t = metadata.New(nil)
 		opt.trailer = t
-	}
+	})
+}
+
+// InactivityTimeout makes a stream fail with codes.DeadlineExceeded when it
+// waits more than d for the response headers or the next response frame,
+// regardless of the deadline of its context. It detects streams silently
+// dropped by proxies. Websocket streams use a receive pump for that purpose.
+func InactivityTimeout(d time.Duration) CallOption {
+	return newCallOption(func(opt *callOptions) {
+		opt.inactivityTimeout = d
+	})
+}
+
+// Stats returns a CallOption which fills s with the statistics of the call.
+func Stats(s *CallStats) CallOption {
+	return newCallOption(func(opt *callOptions) {
+		*s = CallStats{}
+		opt.stats = s
+	})
 }
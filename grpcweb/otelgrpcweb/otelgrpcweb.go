@@ -0,0 +1,198 @@
+// Package otelgrpcweb instruments grpcweb clients with OpenTelemetry.
+//
+// The metrics follow the RPC semantic conventions and carry the same names and
+// attributes as the ones of grpc-go clients instrumented by otelgrpc, so
+// existing dashboards keep working. Message events can be added to the span of
+// the calls, see WithMessageEvents.
+package otelgrpcweb
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+)
+
+const instrumentationName = "github.com/heartandu/grpc-web-go-client/grpcweb/otelgrpcweb"
+
+type config struct {
+	meterProvider metric.MeterProvider
+	messageEvents bool
+}
+
+type Option func(*config)
+
+// WithMeterProvider sets the provider of the meter. It defaults to the global
+// one.
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return func(c *config) {
+		c.meterProvider = mp
+	}
+}
+
+// WithMessageEvents adds an event to the span of the call context, if any, for
+// every sent and received message. The events carry the direction, the
+// sequence number and the size of the message, like the ones of otelgrpc, so
+// that long-lived streams can be followed message by message.
+func WithMessageEvents() Option {
+	return func(c *config) {
+		c.messageEvents = true
+	}
+}
+
+type handler struct {
+	messageEvents bool
+
+	duration        metric.Float64Histogram
+	requestSize     metric.Int64Histogram
+	responseSize    metric.Int64Histogram
+	requestsPerRPC  metric.Int64Histogram
+	responsesPerRPC metric.Int64Histogram
+}
+
+// NewClientHandler returns a stats.Handler recording the rpc.client metrics.
+// Register it with grpcweb.WithStatsHandler.
+//
+// Measurements are recorded with the context of the call, so the exemplars of
+// a sampled trace are attached when the SDK is configured for them.
+func NewClientHandler(opts ...Option) stats.Handler {
+	c := config{meterProvider: otel.GetMeterProvider()}
+	for _, o := range opts {
+		o(&c)
+	}
+	meter := c.meterProvider.Meter(instrumentationName)
+
+	var (
+		h   = handler{messageEvents: c.messageEvents}
+		err error
+	)
+	h.duration, err = meter.Float64Histogram(
+		semconv.RPCClientDurationName,
+		metric.WithDescription(semconv.RPCClientDurationDescription),
+		metric.WithUnit(semconv.RPCClientDurationUnit),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+	h.requestSize, err = meter.Int64Histogram(
+		semconv.RPCClientRequestSizeName,
+		metric.WithDescription(semconv.RPCClientRequestSizeDescription),
+		metric.WithUnit(semconv.RPCClientRequestSizeUnit),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+	h.responseSize, err = meter.Int64Histogram(
+		semconv.RPCClientResponseSizeName,
+		metric.WithDescription(semconv.RPCClientResponseSizeDescription),
+		metric.WithUnit(semconv.RPCClientResponseSizeUnit),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+	h.requestsPerRPC, err = meter.Int64Histogram(
+		semconv.RPCClientRequestsPerRPCName,
+		metric.WithDescription(semconv.RPCClientRequestsPerRPCDescription),
+		metric.WithUnit(semconv.RPCClientRequestsPerRPCUnit),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+	h.responsesPerRPC, err = meter.Int64Histogram(
+		semconv.RPCClientResponsesPerRPCName,
+		metric.WithDescription(semconv.RPCClientResponsesPerRPCDescription),
+		metric.WithUnit(semconv.RPCClientResponsesPerRPCUnit),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+	return &h
+}
+
+type rpcInfoKey struct{}
+
+// rpcInfo holds the state of a single RPC.
+type rpcInfo struct {
+	attrs          []attribute.KeyValue
+	sent, received atomic.Int64
+}
+
+func (h *handler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, rpcInfoKey{}, &rpcInfo{attrs: methodAttrs(info.FullMethodName)})
+}
+
+func (h *handler) HandleRPC(ctx context.Context, rs stats.RPCStats) {
+	info, ok := ctx.Value(rpcInfoKey{}).(*rpcInfo)
+	if !ok {
+		return
+	}
+
+	switch rs := rs.(type) {
+	case *stats.OutPayload:
+		id := info.sent.Add(1)
+		h.requestSize.Record(ctx, int64(rs.Length), metric.WithAttributes(info.attrs...))
+		h.messageEvent(ctx, semconv.RPCMessageTypeSent, id, rs.Length, rs.CompressedLength)
+	case *stats.InPayload:
+		id := info.received.Add(1)
+		h.responseSize.Record(ctx, int64(rs.Length), metric.WithAttributes(info.attrs...))
+		h.messageEvent(ctx, semconv.RPCMessageTypeReceived, id, rs.Length, rs.CompressedLength)
+	case *stats.End:
+		attrs := metric.WithAttributes(append(
+			info.attrs[:len(info.attrs):len(info.attrs)],
+			semconv.RPCGRPCStatusCodeKey.Int64(int64(status.Code(rs.Error))),
+		)...)
+		elapsed := float64(rs.EndTime.Sub(rs.BeginTime)) / float64(time.Millisecond)
+		h.duration.Record(ctx, elapsed, attrs)
+		h.requestsPerRPC.Record(ctx, info.sent.Load(), attrs)
+		h.responsesPerRPC.Record(ctx, info.received.Load(), attrs)
+	}
+}
+
+// messageEvent adds the event of the id-th message in a direction to the span
+// of ctx, if enabled.
+func (h *handler) messageEvent(ctx context.Context, typ attribute.KeyValue, id int64, size, compressedSize int) {
+	if !h.messageEvents {
+		return
+	}
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	span.AddEvent("message", trace.WithAttributes(
+		typ,
+		semconv.RPCMessageIDKey.Int64(id),
+		semconv.RPCMessageUncompressedSizeKey.Int(size),
+		semconv.RPCMessageCompressedSizeKey.Int(compressedSize),
+	))
+}
+
+func (h *handler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (h *handler) HandleConn(context.Context, stats.ConnStats) {}
+
+// methodAttrs returns the attributes of a full method name of the form
+// /package.Service/Method.
+func methodAttrs(fullMethod string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{semconv.RPCSystemGRPC}
+	service, method, ok := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if !ok {
+		return append(attrs, semconv.RPCMethod(fullMethod))
+	}
+	if service != "" {
+		attrs = append(attrs, semconv.RPCService(service))
+	}
+	if method != "" {
+		attrs = append(attrs, semconv.RPCMethod(method))
+	}
+	return attrs
+}
@@ -0,0 +1,142 @@
+package otelgrpcweb_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/otelgrpcweb"
+)
+
+func TestClientHandler(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	h := otelgrpcweb.NewClientHandler(otelgrpcweb.WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))))
+
+	ctx := h.TagRPC(context.Background(), &stats.RPCTagInfo{FullMethodName: "/pkg.Service/Method"})
+	begin := time.Now()
+	h.HandleRPC(ctx, &stats.Begin{Client: true, BeginTime: begin})
+	h.HandleRPC(ctx, &stats.OutPayload{Client: true, Length: 10})
+	h.HandleRPC(ctx, &stats.InPayload{Client: true, Length: 20})
+	h.HandleRPC(ctx, &stats.InPayload{Client: true, Length: 30})
+	h.HandleRPC(ctx, &stats.End{
+		Client:    true,
+		BeginTime: begin,
+		EndTime:   begin.Add(1500 * time.Microsecond),
+		Error:     status.Error(codes.NotFound, "not found"),
+	})
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Collect should not return an error, but got '%s'", err)
+	}
+
+	type point struct {
+		count      uint64
+		sum        float64
+		statusCode bool
+	}
+	expected := map[string]point{
+		"rpc.client.duration":          {count: 1, sum: 1.5, statusCode: true},
+		"rpc.client.request.size":      {count: 1, sum: 10},
+		"rpc.client.response.size":     {count: 2, sum: 50},
+		"rpc.client.requests_per_rpc":  {count: 1, sum: 1, statusCode: true},
+		"rpc.client.responses_per_rpc": {count: 1, sum: 2, statusCode: true},
+	}
+
+	got := make(map[string]point)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			var (
+				p     point
+				attrs attribute.Set
+			)
+			switch data := m.Data.(type) {
+			case metricdata.Histogram[float64]:
+				p.count, p.sum, attrs = data.DataPoints[0].Count, data.DataPoints[0].Sum, data.DataPoints[0].Attributes
+			case metricdata.Histogram[int64]:
+				p.count, p.sum, attrs = data.DataPoints[0].Count, float64(data.DataPoints[0].Sum), data.DataPoints[0].Attributes
+			default:
+				t.Fatalf("unexpected data type %T of %s", m.Data, m.Name)
+			}
+
+			if v, ok := attrs.Value("rpc.service"); !ok || v.AsString() != "pkg.Service" {
+				t.Errorf("%s: unexpected rpc.service %v", m.Name, v)
+			}
+			if v, ok := attrs.Value("rpc.method"); !ok || v.AsString() != "Method" {
+				t.Errorf("%s: unexpected rpc.method %v", m.Name, v)
+			}
+			v, ok := attrs.Value("rpc.grpc.status_code")
+			p.statusCode = ok
+			if ok && v.AsInt64() != int64(codes.NotFound) {
+				t.Errorf("%s: unexpected rpc.grpc.status_code %v", m.Name, v)
+			}
+			got[m.Name] = p
+		}
+	}
+
+	for name, want := range expected {
+		if got[name] != want {
+			t.Errorf("%s: expected %+v, but got %+v", name, want, got[name])
+		}
+	}
+}
+
+func TestMessageEvents(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	h := otelgrpcweb.NewClientHandler(
+		otelgrpcweb.WithMeterProvider(sdkmetric.NewMeterProvider()),
+		otelgrpcweb.WithMessageEvents(),
+	)
+
+	ctx, span := tp.Tracer("test").Start(context.Background(), "stream")
+	ctx = h.TagRPC(ctx, &stats.RPCTagInfo{FullMethodName: "/pkg.Service/Method"})
+	h.HandleRPC(ctx, &stats.OutPayload{Client: true, Length: 10, CompressedLength: 10})
+	h.HandleRPC(ctx, &stats.InPayload{Client: true, Length: 20, CompressedLength: 8})
+	h.HandleRPC(ctx, &stats.InPayload{Client: true, Length: 30, CompressedLength: 30})
+	span.End()
+
+	type event struct {
+		typ                  string
+		id, size, compressed int64
+	}
+	expected := []event{
+		{typ: "SENT", id: 1, size: 10, compressed: 10},
+		{typ: "RECEIVED", id: 1, size: 20, compressed: 8},
+		{typ: "RECEIVED", id: 2, size: 30, compressed: 30},
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expected one span, but got %d", len(spans))
+	}
+	var got []event
+	for _, e := range spans[0].Events() {
+		if e.Name != "message" {
+			t.Errorf("unexpected event %q", e.Name)
+		}
+		attrs := attribute.NewSet(e.Attributes...)
+		typ, _ := attrs.Value("rpc.message.type")
+		id, _ := attrs.Value("rpc.message.id")
+		size, _ := attrs.Value("rpc.message.uncompressed_size")
+		compressed, _ := attrs.Value("rpc.message.compressed_size")
+		got = append(got, event{typ: typ.AsString(), id: id.AsInt64(), size: size.AsInt64(), compressed: compressed.AsInt64()})
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d events, but got %+v", len(expected), got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("event %d: expected %+v, but got %+v", i, expected[i], got[i])
+		}
+	}
+}
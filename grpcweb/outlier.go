@@ -0,0 +1,235 @@
+package grpcweb
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// OutlierDetection configures the ejection of the failing or slow addresses
+// of the resolver from the load balancing, like the outlier detection of
+// Envoy. Only unary calls are accounted for. A call fails if it couldn't
+// reach the server or returned codes.Unavailable, codes.Internal,
+// codes.Unknown or codes.DeadlineExceeded. At least one address is always
+// left in the rotation.
+type OutlierDetection struct {
+	// Interval is the period over which the failure rates and latencies are
+	// computed. It defaults to 10 seconds.
+	Interval time.Duration
+	// BaseEjectionTime is the duration of the first ejection of an address,
+	// multiplied by the number of recent ejections for the next ones. It
+	// defaults to 30 seconds.
+	BaseEjectionTime time.Duration
+	// MaxEjectionTime caps the duration of an ejection. It defaults to 5
+	// minutes, or BaseEjectionTime if it is longer.
+	MaxEjectionTime time.Duration
+	// MaxEjectionPercent caps the share of the addresses ejected at a time.
+	// An address may be ejected anyway if none is. It defaults to 10.
+	MaxEjectionPercent int
+	// RampUp spreads the reintroduction of an address after its ejection: it
+	// receives a share of its calls growing linearly over RampUp. The address
+	// is reintroduced at once if it is zero.
+	RampUp time.Duration
+
+	// ConsecutiveFailures ejects an address after the given number of
+	// consecutive failed calls. Zero disables it.
+	ConsecutiveFailures int
+	// FailureRate ejects an address whose share of failed calls over an
+	// interval exceeds the given rate in (0, 1]. Zero disables it.
+	FailureRate float64
+	// LatencyFactor ejects an address whose mean latency of successful calls
+	// over an interval exceeds the median of the means of all the addresses
+	// times the given factor. Zero disables it.
+	LatencyFactor float64
+	// MinimumCalls is the number of calls an address must receive over an
+	// interval to be evaluated by FailureRate and LatencyFactor. It defaults
+	// to 5.
+	MinimumCalls int
+}
+
+// WithOutlierDetection ejects the outliers among the addresses of the
+// resolver set with WithResolver.
+func WithOutlierDetection(o OutlierDetection) DialOption {
+	return func(opt *dialOptions) {
+		opt.outlierDetection = &o
+	}
+}
+
+// outlierDetector implements the outlier detection of an addressSet. It is
+// guarded by the mutex of the set.
+type outlierDetector struct {
+	config    OutlierDetection
+	hosts     map[string]*hostStats
+	lastSweep time.Time
+}
+
+type hostStats struct {
+	// successes, failures and latency are the counters of the current
+	// interval, latency summing the durations of the successful calls.
+	successes, failures int
+	latency             time.Duration
+	consecutive         int
+
+	// ejections is the number of recent ejections, decreasing over the
+	// intervals without any.
+	ejections    int
+	ejectedUntil time.Time
+}
+
+func newOutlierDetector(c OutlierDetection, now time.Time) *outlierDetector {
+	if c.Interval <= 0 {
+		c.Interval = 10 * time.Second
+	}
+	if c.BaseEjectionTime <= 0 {
+		c.BaseEjectionTime = 30 * time.Second
+	}
+	if c.MaxEjectionTime <= 0 {
+		c.MaxEjectionTime = 5 * time.Minute
+	}
+	c.MaxEjectionTime = max(c.MaxEjectionTime, c.BaseEjectionTime)
+	if c.MaxEjectionPercent <= 0 {
+		c.MaxEjectionPercent = 10
+	}
+	if c.MinimumCalls <= 0 {
+		c.MinimumCalls = 5
+	}
+	return &outlierDetector{config: c, hosts: make(map[string]*hostStats), lastSweep: now}
+}
+
+func (d *outlierDetector) stats(addr string) *hostStats {
+	h, ok := d.hosts[addr]
+	if !ok {
+		h = &hostStats{}
+		d.hosts[addr] = h
+	}
+	return h
+}
+
+func isCallFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	if isConnectionError(err) {
+		return true
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Internal, codes.Unknown, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
+
+// record accounts for a call to addr which returned err after latency, at now,
+// among the current addresses addrs.
+func (d *outlierDetector) record(addr string, err error, latency time.Duration, now time.Time, addrs []string) {
+	h := d.stats(addr)
+	if !isCallFailure(err) {
+		h.successes++
+		h.latency += latency
+		h.consecutive = 0
+		return
+	}
+
+	h.failures++
+	h.consecutive++
+	if n := d.config.ConsecutiveFailures; n > 0 && h.consecutive >= n {
+		d.eject(addr, now, addrs)
+	}
+}
+
+// available reports whether addr may be picked. Ramping up addresses are
+// randomly left out.
+func (d *outlierDetector) available(addr string, now time.Time) bool {
+	h, ok := d.hosts[addr]
+	if !ok {
+		return true
+	}
+	if now.Before(h.ejectedUntil) {
+		return false
+	}
+	if ramp := d.config.RampUp; ramp > 0 && !h.ejectedUntil.IsZero() {
+		if since := now.Sub(h.ejectedUntil); since < ramp {
+			return rand.Float64() < float64(since)/float64(ramp)
+		}
+	}
+	return true
+}
+
+// eject ejects addr, unless the maximum share of ejected addresses is
+// reached.
+func (d *outlierDetector) eject(addr string, now time.Time, addrs []string) {
+	h := d.stats(addr)
+	if now.Before(h.ejectedUntil) {
+		return
+	}
+	ejected := 0
+	for _, a := range addrs {
+		if s, ok := d.hosts[a]; ok && now.Before(s.ejectedUntil) {
+			ejected++
+		}
+	}
+	if ejected > 0 && (ejected+1)*100 > len(addrs)*d.config.MaxEjectionPercent || ejected+1 >= len(addrs) {
+		return
+	}
+
+	h.ejections++
+	h.consecutive = 0
+	h.ejectedUntil = now.Add(min(d.config.BaseEjectionTime*time.Duration(h.ejections), d.config.MaxEjectionTime))
+}
+
+// sweep evaluates the failure rates and latencies of the addresses once per
+// interval, and starts a new interval.
+func (d *outlierDetector) sweep(now time.Time, addrs []string) {
+	if now.Sub(d.lastSweep) < d.config.Interval {
+		return
+	}
+	d.lastSweep = now
+
+	var means []time.Duration
+	for _, a := range addrs {
+		if h, ok := d.hosts[a]; ok && h.successes >= d.config.MinimumCalls {
+			means = append(means, h.latency/time.Duration(h.successes))
+		}
+	}
+	sort.Slice(means, func(i, j int) bool { return means[i] < means[j] })
+
+	for _, a := range addrs {
+		h, ok := d.hosts[a]
+		if !ok {
+			continue
+		}
+		calls := h.successes + h.failures
+		switch {
+		case calls < d.config.MinimumCalls:
+		case d.config.FailureRate > 0 && float64(h.failures)/float64(calls) > d.config.FailureRate:
+			d.eject(a, now, addrs)
+		case d.config.LatencyFactor > 0 && len(means) > 1 && h.successes >= d.config.MinimumCalls &&
+			float64(h.latency/time.Duration(h.successes)) > float64(means[len(means)/2])*d.config.LatencyFactor:
+			d.eject(a, now, addrs)
+		}
+		if h.ejections > 0 && !now.Before(h.ejectedUntil) && h.ejectedUntil.Before(now.Add(-d.config.Interval)) {
+			h.ejections--
+		}
+		h.successes, h.failures, h.latency = 0, 0, 0
+	}
+
+	// Forget the addresses the resolver no longer returns.
+	for a := range d.hosts {
+		if !contains(addrs, a) {
+			delete(d.hosts, a)
+		}
+	}
+}
+
+func contains(addrs []string, addr string) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
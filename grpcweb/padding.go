@@ -0,0 +1,176 @@
+package grpcweb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/errs"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/transport"
+)
+
+// FramePadding pads the request frames to the block sizes required by some
+// gateways, such as encrypted tunnel ones, and strips the padding of the
+// response frames. A frame is a length-prefixed message or trailer.
+type FramePadding interface {
+	// Pad returns frame, a whole request frame of method, padded.
+	Pad(method string, frame []byte) ([]byte, error)
+	// Unpad reads the next padded response frame of method from r and returns
+	// it without its padding. It returns io.EOF if r ends before the frame.
+	Unpad(method string, r io.Reader) ([]byte, error)
+}
+
+// WithFramePadding pads the frames of every call and stream with p. The
+// padding is the closest to the wire after the envelope: the transport
+// wrappers, the HAR recorder and the diagnostics see the unpadded frames. A
+// failing padding fails the call with codes.Internal.
+func WithFramePadding(p FramePadding) DialOption {
+	return func(opt *dialOptions) {
+		opt.padding = p
+	}
+}
+
+// BlockPadding returns a FramePadding appending zeros to the frames up to a
+// multiple of size bytes. The length prefixes of the frames are left as is,
+// which tells the padding apart.
+func BlockPadding(size int) FramePadding {
+	return blockPadding(max(size, 1))
+}
+
+type blockPadding int
+
+func (p blockPadding) Pad(_ string, frame []byte) ([]byte, error) {
+	return append(frame, make([]byte, p.padLen(len(frame)))...), nil
+}
+
+func (p blockPadding) Unpad(_ string, r io.Reader) ([]byte, error) {
+	var h [headerLen]byte
+	if n, err := io.ReadFull(r, h[:]); err != nil {
+		if n > 0 && errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	length := int64(binary.BigEndian.Uint32(h[1:]))
+	frame := bytes.NewBuffer(h[:])
+	// The frame isn't allocated upfront, its length may be bogus.
+	if _, err := io.CopyN(frame, r, length); err != nil {
+		return nil, errs.Wrap(io.ErrUnexpectedEOF, "truncated frame")
+	}
+	if _, err := io.CopyN(io.Discard, r, int64(p.padLen(frame.Len()))); err != nil {
+		return nil, errs.Wrap(io.ErrUnexpectedEOF, "truncated padding")
+	}
+	return frame.Bytes(), nil
+}
+
+// padLen returns the length of the padding of a frame of n bytes.
+func (p blockPadding) padLen(n int) int {
+	return (int(p) - n%int(p)) % int(p)
+}
+
+// padFrames pads the frames of body, a request body.
+func (o *dialOptions) padFrames(method string, body io.Reader) (io.Reader, error) {
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return nil, errs.Wrap(err, "failed to read the request body")
+	}
+	var padded bytes.Buffer
+	for len(b) > 0 {
+		n := len(b)
+		if n >= headerLen {
+			n = min(n, headerLen+int(binary.BigEndian.Uint32(b[1:headerLen])))
+		}
+		frame, err := o.padding.Pad(method, b[:n:n])
+		if err != nil {
+			return nil, errs.WithCode(codes.Internal, err, "failed to pad a request frame")
+		}
+		padded.Write(frame)
+		b = b[n:]
+	}
+	return &padded, nil
+}
+
+// unpaddedBody strips the padding of the frames of a response body.
+type unpaddedBody struct {
+	io.ReadCloser
+	method  string
+	padding FramePadding
+	frame   []byte
+}
+
+func (b *unpaddedBody) Read(p []byte) (int, error) {
+	if len(b.frame) == 0 {
+		frame, err := b.padding.Unpad(b.method, b.ReadCloser)
+		switch {
+		case errors.Is(err, io.EOF), errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+			return 0, err
+		case err != nil:
+			return 0, errs.WithCode(codes.Internal, err, "failed to strip the padding of a response frame")
+		}
+		b.frame = frame
+	}
+	n := copy(p, b.frame)
+	b.frame = b.frame[n:]
+	return n, nil
+}
+
+// wrapPadding pads the frames of tr, if a padding is set.
+func (o *dialOptions) wrapPadding(method string, tr transport.UnaryTransport) transport.UnaryTransport {
+	if o.padding == nil {
+		return tr
+	}
+	return &paddingTransport{UnaryTransport: tr, opts: o, method: method}
+}
+
+type paddingTransport struct {
+	transport.UnaryTransport
+	opts   *dialOptions
+	method string
+}
+
+func (t *paddingTransport) Send(ctx context.Context, endpoint, contentType string, body io.Reader) (http.Header, io.ReadCloser, error) {
+	body, err := t.opts.padFrames(t.method, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	h, rawBody, err := t.UnaryTransport.Send(ctx, endpoint, contentType, body)
+	if err != nil {
+		return h, rawBody, err
+	}
+	return h, &unpaddedBody{ReadCloser: rawBody, method: t.method, padding: t.opts.padding}, nil
+}
+
+// wrapPaddingStream pads the frames of tr, if a padding is set.
+func (o *dialOptions) wrapPaddingStream(method string, tr transport.ClientStreamTransport) transport.ClientStreamTransport {
+	if o.padding == nil {
+		return tr
+	}
+	return &paddingStreamTransport{ClientStreamTransport: tr, opts: o, method: method}
+}
+
+type paddingStreamTransport struct {
+	transport.ClientStreamTransport
+	opts   *dialOptions
+	method string
+}
+
+func (t *paddingStreamTransport) Send(ctx context.Context, body io.Reader) error {
+	body, err := t.opts.padFrames(t.method, body)
+	if err != nil {
+		return err
+	}
+	return t.ClientStreamTransport.Send(ctx, body)
+}
+
+func (t *paddingStreamTransport) Receive(ctx context.Context) (io.ReadCloser, error) {
+	r, err := t.ClientStreamTransport.Receive(ctx)
+	if err != nil {
+		return r, err
+	}
+	return &unpaddedBody{ReadCloser: r, method: t.method, padding: t.opts.padding}, nil
+}
@@ -2,25 +2,68 @@ package parser
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/base64"
 	"encoding/binary"
+	"fmt"
 	"io"
+	"net/textproto"
 	"strconv"
 	"strings"
 
 	"github.com/golang/protobuf/proto"
-	"github.com/pkg/errors"
 	spb "google.golang.org/genproto/googleapis/rpc/status"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/errs"
 )
 
+// FrameParser parses the frames of a gRPC-Web response body. Implementations
+// may be supplied to handle gateways which deviate from the wire format.
+type FrameParser interface {
+	ParseResponseHeader(r io.Reader) (*Header, error)
+	ParseLengthPrefixedMessage(r io.Reader, length uint32) ([]byte, error)
+	ParseStatusAndTrailer(r io.Reader, length uint32) (*status.Status, metadata.MD, error)
+}
+
+// DefaultFrameParser implements the standard gRPC-Web wire format using the
+// package level functions.
+var DefaultFrameParser FrameParser = defaultFrameParser{}
+
+type defaultFrameParser struct{}
+
+func (defaultFrameParser) ParseResponseHeader(r io.Reader) (*Header, error) {
+	return ParseResponseHeader(r)
+}
+
+func (defaultFrameParser) ParseLengthPrefixedMessage(r io.Reader, length uint32) ([]byte, error) {
+	return ParseLengthPrefixedMessage(r, length)
+}
+
+func (defaultFrameParser) ParseStatusAndTrailer(r io.Reader, length uint32) (*status.Status, metadata.MD, error) {
+	return ParseStatusAndTrailer(r, length)
+}
+
 type Header struct {
 	flag          byte
 	ContentLength uint32
 }
 
+// NewHeader returns the header of a frame with the given flag byte and length.
+func NewHeader(flag byte, length uint32) *Header {
+	return &Header{
+		flag:          flag,
+		ContentLength: length,
+	}
+}
+
+// Flag returns the flag byte of the frame.
+func (h *Header) Flag() byte {
+	return h.flag
+}
+
 func (h *Header) IsMessageHeader() bool {
 	return h.flag == 0 || h.flag == 1
 }
@@ -29,75 +72,130 @@ func (h *Header) IsTrailerHeader() bool {
 	return h.flag>>7 == 0x01
 }
 
+// IsHeartbeat reports whether the frame is an empty frame other than a
+// message, which some gateways send to keep idle connections alive.
+func (h *Header) IsHeartbeat() bool {
+	return h.ContentLength == 0 && !h.IsMessageHeader()
+}
+
+// ParseResponseHeader parses the header of the next frame. Empty frames other
+// than messages are returned too, see Header.IsHeartbeat.
 func ParseResponseHeader(r io.Reader) (*Header, error) {
 	var h [5]byte
-	n, err := r.Read(h[:])
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to read header")
-	}
-	if n != len(h) {
+	n, err := readFull(r, h[:])
+	switch {
+	case n == 0 && err != nil:
+		return nil, errs.Wrap(err, "failed to read header")
+	case n != len(h):
 		return nil, io.ErrUnexpectedEOF
 	}
 
-	length := binary.BigEndian.Uint32(h[1:])
-	if length == 0 {
-		return nil, io.EOF
-	}
 	return &Header{
 		flag:          h[0],
-		ContentLength: length,
+		ContentLength: binary.BigEndian.Uint32(h[1:]),
 	}, nil
 }
 
 func ParseLengthPrefixedMessage(r io.Reader, length uint32) ([]byte, error) {
+	if length == 0 {
+		// An empty message, e.g. google.protobuf.Empty.
+		return []byte{}, nil
+	}
 	content := make([]byte, length)
-	n, err := r.Read(content)
+	n, err := readFull(r, content)
 	switch {
 	case uint32(n) != length:
 		return nil, io.ErrUnexpectedEOF
-	case err == io.EOF:
+	case err == io.EOF && n == 0:
 		return nil, io.EOF
-	case err != nil:
+	case err != nil && err != io.EOF:
 		return nil, err
 	}
 	return content, nil
 }
 
+// readFull reads len(p) bytes from r, since a single Read may return less
+// than that, e.g. when the frame spans several chunks of the body. Unlike
+// io.ReadFull it calls Read at least once, so that the end of r is reported
+// even for an empty p.
+func readFull(r io.Reader, p []byte) (n int, err error) {
+	for {
+		var m int
+		m, err = r.Read(p[n:])
+		n += m
+		if n == len(p) || err != nil {
+			return n, err
+		}
+	}
+}
+
+// Limits of the trailer frames, beyond which they are rejected as malformed.
+const (
+	// MaxTrailerSize is the maximum length of a trailer frame in bytes.
+	MaxTrailerSize = 1 << 20
+	// MaxTrailerLines is the maximum number of lines of a trailer frame.
+	MaxTrailerLines = 1024
+)
+
+// ErrMalformedTrailer is returned by ParseStatusAndTrailer when the trailer
+// frame can't be parsed. It resolves to codes.Internal, and the errors wrapping
+// it wrap io.ErrUnexpectedEOF too.
+var ErrMalformedTrailer = errs.WithCode(codes.Internal, nil, "malformed trailer")
+
+// ParseStatusAndTrailer parses a trailer frame of the given length, made of
+// "key: value" lines. It reads exactly length bytes from r.
 func ParseStatusAndTrailer(r io.Reader, length uint32) (*status.Status, metadata.MD, error) {
+	if length > MaxTrailerSize {
+		return nil, nil, malformedTrailer("%d bytes, the limit is %d", length, MaxTrailerSize)
+	}
+	b := make([]byte, length)
+	if n, err := io.ReadFull(r, b); err != nil {
+		return nil, nil, malformedTrailer("truncated to %d of %d bytes", n, length)
+	}
+
 	var (
-		readLen    uint32
 		headerStat *status.Status
 		code       codes.Code
 		msg        string
 	)
 	trailer := metadata.New(nil)
-	s := bufio.NewScanner(r)
-	for s.Scan() {
-		readLen += uint32(len(s.Bytes()))
-		if readLen > length {
-			return nil, nil, io.ErrUnexpectedEOF
+	tr := textproto.NewReader(bufio.NewReader(bytes.NewReader(b)))
+	for lines := 0; ; lines++ {
+		line, err := tr.ReadLine()
+		if err != nil {
+			// The reader of b only fails at its end.
+			break
+		}
+		if lines >= MaxTrailerLines {
+			return nil, nil, malformedTrailer("more than %d lines", MaxTrailerLines)
+		}
+		if line == "" {
+			continue
 		}
 
-		t := s.Text()
-		i := strings.Index(t, ":")
-		if i == -1 {
-			return nil, nil, io.ErrUnexpectedEOF
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, nil, malformedTrailer("line %d has no colon", lines+1)
+		}
+		if !validKey(k) {
+			return nil, nil, malformedTrailer("line %d has an invalid key %q", lines+1, k)
+		}
+		v = strings.TrimSpace(v)
+		if !validValue(v) {
+			return nil, nil, malformedTrailer("line %d has an invalid value", lines+1)
 		}
 
 		// Check reserved keys.
-		k, v := strings.ToLower(t[:i]), strings.TrimSpace(t[i+1:])
+		k = strings.ToLower(k)
 		switch k {
 		case "grpc-status":
 			n, err := strconv.ParseUint(v, 10, 32)
 			if err != nil {
-				code = codes.Unknown
-			} else {
-				code = codes.Code(uint32(n))
+				return nil, nil, malformedTrailer("line %d has an invalid status %q", lines+1, v)
 			}
-			continue
+			code = codes.Code(uint32(n))
 		case "grpc-message":
-			msg = v
-			continue
+			msg = DecodeMessage(v)
 		case "grpc-status-details-bin":
 			b, err := decodeBase64Value(v)
 			if err != nil {
@@ -136,6 +234,55 @@ func ParseStatusAndTrailer(r io.Reader, length uint32) (*status.Status, metadata
 	return stat, trailer, nil
 }
 
+// DecodeMessage decodes the percent-encoded value v of a grpc-message header.
+// Malformed escapes are kept as is, like grpc-go does.
+func DecodeMessage(v string) string {
+	if !strings.Contains(v, "%") {
+		return v
+	}
+	var b strings.Builder
+	b.Grow(len(v))
+	for i := 0; i < len(v); i++ {
+		if v[i] == '%' && i+2 < len(v) {
+			if n, err := strconv.ParseUint(v[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(v[i])
+	}
+	return b.String()
+}
+
+func malformedTrailer(format string, args ...any) error {
+	return fmt.Errorf("%w: %s: %w", ErrMalformedTrailer, fmt.Sprintf(format, args...), io.ErrUnexpectedEOF)
+}
+
+// validKey reports whether k is an HTTP field name, a token.
+func validKey(k string) bool {
+	if k == "" {
+		return false
+	}
+	for i := 0; i < len(k); i++ {
+		c := k[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`"(),/:;<=>?@[\]{}`, c) != -1 {
+			return false
+		}
+	}
+	return true
+}
+
+// validValue reports whether v has no control characters other than tabs.
+func validValue(v string) bool {
+	for i := 0; i < len(v); i++ {
+		if c := v[i]; (c < ' ' && c != '\t') || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
 func decodeBase64Value(v string) ([]byte, error) {
 	// Mostly copied from http_util.go in grpc/grpc-go.
 
@@ -2,13 +2,20 @@ package parser_test
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"testing/quick"
 
 	"github.com/google/go-cmp/cmp"
-	"github.com/pkg/errors"
 	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
@@ -41,13 +48,21 @@ func TestParseResponseHeader(t *testing.T) {
 			expectedContentLength: 72,
 			expectedHeaderType:    trailer,
 		},
+		"heartbeat": {
+			in:                 []byte{0x80, 0x00, 0x00, 0x00, 0x00},
+			expectedHeaderType: trailer,
+		},
 		"unexpected error": {
 			in:          []byte{0x80},
 			wantErr:     true,
 			expectedErr: io.ErrUnexpectedEOF,
 		},
-		"the length is zero": {
-			in:          []byte{0x00, 0x00, 0x00, 0x00, 0x00},
+		"empty message": {
+			in:                 []byte{0x00, 0x00, 0x00, 0x00, 0x00},
+			expectedHeaderType: message,
+		},
+		"EOF": {
+			in:          []byte{},
 			wantErr:     true,
 			expectedErr: io.EOF,
 		},
@@ -98,11 +113,9 @@ func TestParseLengthPrefixedMessage(t *testing.T) {
 			wantErr:     true,
 			expectedErr: io.ErrUnexpectedEOF,
 		},
-		"EOF": {
-			bytes:       []byte{},
-			length:      0,
-			wantErr:     true,
-			expectedErr: io.EOF,
+		"empty message": {
+			bytes:  []byte{},
+			length: 0,
 		},
 	}
 
@@ -126,9 +139,27 @@ func TestParseLengthPrefixedMessage(t *testing.T) {
 	}
 }
 
+func TestParseChunkedFrame(t *testing.T) {
+	frame := []byte{0x00, 0x00, 0x00, 0x00, 0x03, 0x01, 0x02, 0x03}
+	r := iotest.OneByteReader(bytes.NewReader(frame))
+
+	h, err := parser.ParseResponseHeader(r)
+	if err != nil {
+		t.Fatalf("ParseResponseHeader should not return an error, but got '%s'", err)
+	}
+	msg, err := parser.ParseLengthPrefixedMessage(r, h.ContentLength)
+	if err != nil {
+		t.Fatalf("ParseLengthPrefixedMessage should not return an error, but got '%s'", err)
+	}
+	if diff := cmp.Diff(frame[5:], msg); diff != "" {
+		t.Errorf("-want, +got\n%s", diff)
+	}
+}
+
 func TestParseStatusAndTrailer(t *testing.T) {
 	cases := map[string]struct {
 		fname           string
+		body            string
 		length          uint32
 		expectedStatus  *status.Status
 		expectedTrailer metadata.MD
@@ -191,37 +222,72 @@ func TestParseStatusAndTrailer(t *testing.T) {
 			fname:       "status_trailer_invalid_metadata.in",
 			expectedErr: io.ErrUnexpectedEOF,
 		},
+		"truncated": {
+			body:        "grpc-status: 0\r\n",
+			length:      100,
+			expectedErr: parser.ErrMalformedTrailer,
+		},
+		"too large": {
+			length:      parser.MaxTrailerSize + 1,
+			expectedErr: parser.ErrMalformedTrailer,
+		},
+		"too many lines": {
+			body:        strings.Repeat("k: v\r\n", parser.MaxTrailerLines+1),
+			expectedErr: parser.ErrMalformedTrailer,
+		},
+		"invalid key": {
+			body:        "grpc status: 0\r\n",
+			expectedErr: parser.ErrMalformedTrailer,
+		},
+		"control character": {
+			body:        "k: a\x00b\r\n",
+			expectedErr: parser.ErrMalformedTrailer,
+		},
+		"empty lines and bare line feeds": {
+			body:            "grpc-status: 5\n\r\nK: v\n",
+			expectedStatus:  status.New(codes.NotFound, ""),
+			expectedTrailer: metadata.Pairs("k", "v"),
+		},
+		"percent-encoded message": {
+			body:           "grpc-status: 3\r\ngrpc-message: caf%C3%A9 %E2%9C%93\r\n",
+			expectedStatus: status.New(codes.InvalidArgument, "café ✓"),
+		},
 		"invalid status": {
-			fname:          "status_trailer_invalid_status.in",
-			expectedStatus: status.New(codes.Unknown, ""),
-			expectedTrailer: metadata.New(map[string]string{
-				"trailer_key1": "trailer_val1",
-				"trailer_key2": "trailer_val2",
-			}),
+			fname:       "status_trailer_invalid_status.in",
+			expectedErr: parser.ErrMalformedTrailer,
 		},
 	}
 
 	for name, c := range cases {
 		c := c
 		t.Run(name, func(t *testing.T) {
-			fpath := filepath.Join("testdata", c.fname)
-			b, err := os.ReadFile(fpath)
-			if err != nil {
-				t.Fatalf("Open should not return an error, but got '%s'", err)
+			b := []byte(c.body)
+			if c.fname != "" {
+				var err error
+				b, err = os.ReadFile(filepath.Join("testdata", c.fname))
+				if err != nil {
+					t.Fatalf("Open should not return an error, but got '%s'", err)
+				}
 			}
 
 			in := bytes.NewReader(b)
 			if c.length == 0 {
 				c.length = uint32(in.Len())
 			}
-			status, trailer, err := parser.ParseStatusAndTrailer(in, c.length)
-			if err != c.expectedErr {
+			st, trailer, err := parser.ParseStatusAndTrailer(in, c.length)
+			if !errors.Is(err, c.expectedErr) {
 				t.Errorf("expected error: '%s', but got '%s'", c.expectedErr, err)
 				if err != nil {
 					return
 				}
 			}
-			if diff := cmp.Diff(c.expectedStatus.Proto(), status.Proto(), protocmp.Transform()); diff != "" {
+			if err != nil {
+				if code := status.Code(err); code != codes.Internal {
+					t.Errorf("expected code Internal, but got %s", code)
+				}
+				return
+			}
+			if diff := cmp.Diff(c.expectedStatus.Proto(), st.Proto(), protocmp.Transform()); diff != "" {
 				t.Errorf("-want, +got\n%s", diff)
 			}
 			if diff := cmp.Diff(c.expectedTrailer, trailer); diff != "" {
@@ -230,3 +296,180 @@ func TestParseStatusAndTrailer(t *testing.T) {
 		})
 	}
 }
+
+func TestDecodeMessage(t *testing.T) {
+	cases := map[string]struct {
+		in, expected string
+	}{
+		"plain":            {in: "not found", expected: "not found"},
+		"encoded":          {in: "%E3%81%AA%E3%81%AE%20%25", expected: "なの %"},
+		"malformed escape": {in: "100%zz", expected: "100%zz"},
+		"trailing percent": {in: "100%", expected: "100%"},
+		"truncated escape": {in: "a%4", expected: "a%4"},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := parser.DecodeMessage(c.in); got != c.expected {
+				t.Errorf("expected %q, but got %q", c.expected, got)
+			}
+		})
+	}
+}
+
+func FuzzParseStatusAndTrailer(f *testing.F) {
+	for _, name := range []string{"status_trailer.in", "status_trailer_error.in", "status_grpc_status_details_bin.in", "status_trailer_invalid_metadata.in", "status_trailer_invalid_status.in"} {
+		b, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			f.Fatalf("ReadFile should not return an error, but got '%s'", err)
+		}
+		f.Add(b, uint32(len(b)))
+	}
+	f.Add([]byte("grpc-status: 0\n\n\nk:\tv"), uint32(17))
+
+	f.Fuzz(func(t *testing.T, b []byte, length uint32) {
+		st, trailer, err := parser.ParseStatusAndTrailer(bytes.NewReader(b), length)
+		if err != nil {
+			if code := status.Code(err); code != codes.Internal {
+				t.Fatalf("expected a malformed trailer to resolve to Internal, but got %s: %s", code, err)
+			}
+			return
+		}
+		if st == nil {
+			t.Fatalf("expected a status")
+		}
+		for k := range trailer {
+			if k != strings.ToLower(k) || strings.ContainsAny(k, " :") {
+				t.Errorf("expected a valid lowercase key, but got %q", k)
+			}
+		}
+	})
+}
+
+// malformedTrailer is a trailer frame with a single malformed line or length,
+// generated by testing/quick.
+type malformedTrailer struct {
+	Kind   string
+	Body   string
+	Length uint32
+}
+
+func (malformedTrailer) Generate(r *rand.Rand, size int) reflect.Value {
+	var (
+		b    strings.Builder
+		kind string
+	)
+	n := r.Intn(size + 1)
+	bad := r.Intn(n + 1)
+	for i := 0; i <= n; i++ {
+		if i != bad {
+			fmt.Fprintf(&b, "k%d: v%d\r\n", r.Intn(100), r.Intn(100))
+			continue
+		}
+		switch r.Intn(6) {
+		case 0:
+			kind = "no colon"
+			fmt.Fprintf(&b, "k%d v%d\r\n", r.Intn(100), r.Intn(100))
+		case 1:
+			kind = "empty key"
+			fmt.Fprintf(&b, ": v%d\r\n", r.Intn(100))
+		case 2:
+			kind = "invalid key"
+			// Any byte but the line and field separators.
+			invalid := " \t\x00\x1f\x7f\x80\xff\"(),/;<=>?@[\\]{}"
+			fmt.Fprintf(&b, "k%cx: v\r\n", invalid[r.Intn(len(invalid))])
+		case 3:
+			kind = "control character"
+			c := byte(r.Intn(0x20))
+			for c == '\t' || c == '\n' || c == '\r' {
+				c = byte(r.Intn(0x20))
+			}
+			fmt.Fprintf(&b, "k: a%cb\r\n", c)
+		case 4:
+			kind = "too many lines"
+			b.WriteString(strings.Repeat("k: v\r\n", parser.MaxTrailerLines+1))
+		case 5:
+			kind = "invalid status"
+			invalid := []string{"", "a", "-1", "1.5", "0x1", "4294967296"}
+			fmt.Fprintf(&b, "grpc-status: %s\r\n", invalid[r.Intn(len(invalid))])
+		}
+	}
+	t := malformedTrailer{Kind: kind, Body: b.String(), Length: uint32(b.Len())}
+	switch r.Intn(4) {
+	case 0:
+		t.Kind = "truncated"
+		t.Length += 1 + uint32(r.Intn(size+1))
+	case 1:
+		t.Kind = "too large"
+		t.Length = parser.MaxTrailerSize + 1 + uint32(r.Intn(size+1))
+	}
+	return reflect.ValueOf(t)
+}
+
+func TestParseStatusAndTrailerMalformed(t *testing.T) {
+	resolvesToInternal := func(in malformedTrailer) bool {
+		_, _, err := parser.ParseStatusAndTrailer(strings.NewReader(in.Body), in.Length)
+		return status.Code(err) == codes.Internal &&
+			errors.Is(err, parser.ErrMalformedTrailer) && errors.Is(err, io.ErrUnexpectedEOF)
+	}
+	if err := quick.Check(resolvesToInternal, nil); err != nil {
+		t.Errorf("expected every malformed trailer to resolve to Internal, but %s", err)
+	}
+}
+
+func FuzzParseFrames(f *testing.F) {
+	frame := func(flag byte, payload []byte) []byte {
+		b := []byte{flag, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(len(payload)))
+		return append(b, payload...)
+	}
+	for _, name := range []string{"status_trailer.in", "status_trailer_error.in", "status_trailer_invalid_metadata.in", "status_trailer_invalid_status.in"} {
+		b, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			f.Fatalf("ReadFile should not return an error, but got '%s'", err)
+		}
+		f.Add(append(frame(0x00, []byte{0x0a, 0x01, 0x61}), frame(0x80, b)...))
+	}
+	f.Add(append(frame(0x01, nil), frame(0x80, nil)...))
+	f.Add(append(frame(0x02, []byte("unknown")), 0x80, 0x00))
+	f.Add([]byte{0x00, 0xff, 0xff, 0xff, 0xff, 0x01})
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		r := bytes.NewReader(b)
+		for {
+			h, err := parser.ParseResponseHeader(r)
+			if err != nil {
+				if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+					t.Fatalf("expected a header to be complete or cut short, but got '%s'", err)
+				}
+				return
+			}
+			if h.IsMessageHeader() && h.IsTrailerHeader() {
+				t.Fatalf("expected flag %#x to be either a message or a trailer", h.Flag())
+			}
+			if h.ContentLength > uint32(r.Len()) {
+				// The callers bound the length before reading the frame.
+				if _, err := parser.ParseLengthPrefixedMessage(r, uint32(r.Len())+1); !errors.Is(err, io.ErrUnexpectedEOF) {
+					t.Fatalf("expected a frame cut short to return io.ErrUnexpectedEOF, but got '%v'", err)
+				}
+				return
+			}
+			if !h.IsTrailerHeader() {
+				msg, err := parser.ParseLengthPrefixedMessage(r, h.ContentLength)
+				if err != nil {
+					t.Fatalf("ParseLengthPrefixedMessage should not return an error, but got '%s'", err)
+				}
+				if len(msg) != int(h.ContentLength) {
+					t.Fatalf("expected a payload of %d bytes, but got %d", h.ContentLength, len(msg))
+				}
+				continue
+			}
+			if _, _, err := parser.ParseStatusAndTrailer(r, h.ContentLength); err != nil {
+				if code := status.Code(err); code != codes.Internal {
+					t.Fatalf("expected a malformed trailer to resolve to Internal, but got %s: %s", code, err)
+				}
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package grpcweb
+
+import (
+	"fmt"
+	"path"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/errs"
+)
+
+// ErrMethodNotAllowed is returned, without any request being sent, for the
+// methods a MethodPolicy rejects.
+var ErrMethodNotAllowed = errs.WithCode(codes.PermissionDenied, nil, "method is not allowed")
+
+// MethodPolicy restricts the full method names a ClientConn may call. The
+// patterns follow the syntax of path.Match, e.g. "/pkg.Service/*".
+type MethodPolicy struct {
+	// Allow lists the allowed methods. All methods are allowed if it is
+	// empty.
+	Allow []string
+	// Deny lists the rejected methods. It takes precedence over Allow.
+	Deny []string
+}
+
+// Allows reports whether p allows method.
+func (p *MethodPolicy) Allows(method string) bool {
+	if matchAny(p.Deny, method) {
+		return false
+	}
+	return len(p.Allow) == 0 || matchAny(p.Allow, method)
+}
+
+func (p *MethodPolicy) validate() error {
+	for _, pattern := range append(p.Allow[:len(p.Allow):len(p.Allow)], p.Deny...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid method pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func matchAny(patterns []string, method string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, method); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// WithMethodPolicy makes the calls and streams of methods rejected by p fail
+// locally with ErrMethodNotAllowed, which resolves to codes.PermissionDenied.
+// NewClient returns an error if p has an invalid pattern.
+func WithMethodPolicy(p MethodPolicy) DialOption {
+	return func(opt *dialOptions) {
+		opt.methodPolicy = &p
+	}
+}
+
+// checkMethod returns an error if the method policy rejects method.
+func (o *dialOptions) checkMethod(method string) error {
+	if o.methodPolicy == nil || o.methodPolicy.Allows(method) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrMethodNotAllowed, method)
+}
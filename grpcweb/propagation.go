@@ -0,0 +1,94 @@
+package grpcweb
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// Propagation describes what a nested call inherits from the call it is made
+// for, e.g. a gateway handler forwarding a request to its backend.
+type Propagation struct {
+	// DeadlineMargin is subtracted from the deadline of the parent context,
+	// leaving the parent some time to handle the result of the nested call.
+	DeadlineMargin time.Duration
+	// Keys lists the metadata keys copied from the incoming metadata of the
+	// parent context to the outgoing metadata of the nested call, the other
+	// keys aren't. Keys are case insensitive, and a key ending with "*"
+	// matches the keys starting with the rest of it, such as "x-b3-*". Keys
+	// which are already set in the outgoing metadata are left as is.
+	Keys []string
+}
+
+// Context derives the context of a nested call from parent. The returned
+// cancel function must be called once the nested call is done.
+func (p Propagation) Context(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx := parent
+	if in, ok := metadata.FromIncomingContext(parent); ok && len(p.Keys) > 0 {
+		out, _ := metadata.FromOutgoingContext(parent)
+		out = out.Copy()
+		for _, k := range p.Keys {
+			k = strings.ToLower(k)
+			prefix, ok := strings.CutSuffix(k, "*")
+			if !ok {
+				propagateKey(in, out, k)
+				continue
+			}
+			for k := range in {
+				if strings.HasPrefix(k, prefix) {
+					propagateKey(in, out, k)
+				}
+			}
+		}
+		ctx = metadata.NewOutgoingContext(parent, out)
+	}
+
+	if deadline, ok := parent.Deadline(); ok && p.DeadlineMargin > 0 {
+		return context.WithDeadline(ctx, deadline.Add(-p.DeadlineMargin))
+	}
+	return context.WithCancel(ctx)
+}
+
+// propagateKey copies the values of k from in to out, unless out sets it.
+func propagateKey(in, out metadata.MD, k string) {
+	if len(out.Get(k)) > 0 {
+		return
+	}
+	if v := in.Get(k); len(v) > 0 {
+		out.Set(k, v...)
+	}
+}
+
+// WithPropagation applies p to the context of every call and stream, so that
+// calls made with the context of an incoming request inherit its deadline and
+// the allowed metadata.
+func WithPropagation(p Propagation) DialOption {
+	return func(opt *dialOptions) {
+		opt.propagation = &p
+	}
+}
+
+// WithPropagatedMetadata adds keys to the Keys of the propagation policy, so
+// that the calls made with the context of an incoming request, such as in a
+// server handler, carry these metadata of the request, e.g. "authorization"
+// and "x-request-id". The metadata keys which aren't listed aren't propagated.
+func WithPropagatedMetadata(keys ...string) DialOption {
+	return func(opt *dialOptions) {
+		var p Propagation
+		if opt.propagation != nil {
+			p = *opt.propagation
+		}
+		p.Keys = append(p.Keys[:len(p.Keys):len(p.Keys)], keys...)
+		opt.propagation = &p
+	}
+}
+
+// propagate applies the propagation policy, if any, to ctx.
+func (o *dialOptions) propagate(ctx context.Context) (context.Context, context.CancelFunc) {
+	if o.propagation == nil {
+		return ctx, func() {}
+	}
+	return o.propagation.Context(ctx)
+}
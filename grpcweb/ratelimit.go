@@ -0,0 +1,150 @@
+package grpcweb
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/errs"
+)
+
+// ErrRateLimitExceeded is returned by the calls whose context is done while
+// they wait for the budget advertised by the server, see
+// WithRateLimitThrottling.
+var ErrRateLimitExceeded = errs.WithCode(codes.ResourceExhausted, nil, "rate limit budget of the server exhausted")
+
+// RateLimit is the budget advertised by a gateway with the RateLimit-Limit,
+// RateLimit-Remaining and RateLimit-Reset response headers. It is reported by
+// CallStats.
+type RateLimit struct {
+	// Limit is the number of calls allowed per window, zero if it isn't
+	// advertised.
+	Limit int
+	// Remaining is the number of calls left in the current window.
+	Remaining int
+	// Reset is the time left until the window ends and the budget is
+	// restored, zero if it isn't advertised.
+	Reset time.Duration
+}
+
+// WithRateLimitThrottling holds the unary calls and server streams back
+// once the budget advertised by the RateLimit-Remaining header of the last
+// response is used up, until the time given by its RateLimit-Reset header,
+// so that the calls stay under the budget instead of being rejected by the
+// gateway. The budget is forgotten once reset, until the next response
+// advertises it again. The budgets without RateLimit-Reset are ignored.
+func WithRateLimitThrottling() DialOption {
+	return func(opt *dialOptions) {
+		opt.rateBudget = &rateBudget{}
+	}
+}
+
+// parseRateLimit returns the budget advertised by h, if any. RateLimit-Reset
+// is a number of seconds, or a Unix time if it is larger than a year, like
+// the X-RateLimit-Reset header of some gateways.
+func parseRateLimit(h http.Header, now time.Time) (*RateLimit, bool) {
+	remaining, ok := parseRateLimitField(h.Get("RateLimit-Remaining"))
+	if !ok {
+		return nil, false
+	}
+	rl := &RateLimit{Remaining: remaining}
+	rl.Limit, _ = parseRateLimitField(h.Get("RateLimit-Limit"))
+	if reset, ok := parseRateLimitField(h.Get("RateLimit-Reset")); ok {
+		if reset > 365*24*60*60 {
+			rl.Reset = max(time.Unix(int64(reset), 0).Sub(now), 0)
+		} else {
+			rl.Reset = time.Duration(reset) * time.Second
+		}
+	}
+	return rl, true
+}
+
+// parseRateLimitField parses the leading number of a field, ignoring the
+// quota policies which may follow it, as in "100, 100;w=60".
+func parseRateLimitField(v string) (int, bool) {
+	if i := strings.IndexAny(v, ",;"); i != -1 {
+		v = v[:i]
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// learnRateLimit records the budget advertised by the response headers h of
+// a call.
+func (o *dialOptions) learnRateLimit(h http.Header, co *callOptions) {
+	now := o.clock.Now()
+	rl, ok := parseRateLimit(h, now)
+	if !ok {
+		return
+	}
+	if co.stats != nil {
+		co.stats.RateLimit = rl
+	}
+	o.rateBudget.learn(rl, now)
+}
+
+// waitRateLimit waits for the budget to allow another call.
+func (o *dialOptions) waitRateLimit(ctx context.Context) error {
+	for {
+		d := o.rateBudget.take(o.clock.Now())
+		if d <= 0 {
+			return nil
+		}
+		timer := o.clock.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w: %w", ErrRateLimitExceeded, ctx.Err())
+		case <-timer.C():
+		}
+	}
+}
+
+// rateBudget is the budget learned from the responses, see
+// WithRateLimitThrottling.
+type rateBudget struct {
+	mu        sync.Mutex
+	known     bool
+	remaining int
+	reset     time.Time
+}
+
+func (b *rateBudget) learn(rl *RateLimit, now time.Time) {
+	if b == nil || rl.Reset <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.known, b.remaining, b.reset = true, rl.Remaining, now.Add(rl.Reset)
+}
+
+// take takes a call from the budget, and returns the time to wait for it
+// otherwise.
+func (b *rateBudget) take(now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.known {
+		return 0
+	}
+	if !now.Before(b.reset) {
+		b.known = false
+		return 0
+	}
+	if b.remaining > 0 {
+		b.remaining--
+		return 0
+	}
+	return b.reset.Sub(now)
+}
@@ -0,0 +1,92 @@
+package grpcweb
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/protoadapt"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/errs"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/transport"
+)
+
+// ErrRecvTimeout is returned by RecvMsg when no message arrives within the
+// receive timeout of the stream.
+var ErrRecvTimeout = errs.WithCode(codes.DeadlineExceeded, nil, "no message received within the receive timeout")
+
+// RecvTimeout makes RecvMsg return ErrRecvTimeout when no message arrives
+// within d of the call. Unlike InactivityTimeout, the stream is left intact:
+// the next RecvMsg keeps waiting for the same message, so that consumers
+// expecting regular heartbeats can react to a late one and carry on. The
+// messages must be pointers, and are decoded into a new one before being
+// copied to the one passed to RecvMsg.
+func RecvTimeout(d time.Duration) CallOption {
+	return newCallOption(func(opt *callOptions) {
+		opt.recvTimeout = d
+	})
+}
+
+type recvResult struct {
+	m   any
+	err error
+}
+
+// recvTimeoutStream bounds the wait of each RecvMsg. A receive which timed out
+// keeps running in the background and is picked up by the next RecvMsg.
+type recvTimeoutStream struct {
+	Stream
+	timeout time.Duration
+	clock   transport.Clock
+
+	// pending is the result of the running receive, nil if there is none.
+	pending chan recvResult
+}
+
+func newRecvTimeoutStream(s Stream, d time.Duration, clock transport.Clock) *recvTimeoutStream {
+	return &recvTimeoutStream{Stream: s, timeout: d, clock: clock}
+}
+
+func (s *recvTimeoutStream) RecvMsg(m any) error {
+	if s.pending == nil {
+		pending := make(chan recvResult, 1)
+		msg := reflect.New(reflect.TypeOf(m).Elem()).Interface()
+		go func() {
+			err := s.Stream.RecvMsg(msg)
+			pending <- recvResult{m: msg, err: err}
+		}()
+		s.pending = pending
+	}
+
+	timer := s.clock.NewTimer(s.timeout)
+	defer timer.Stop()
+	select {
+	case res := <-s.pending:
+		s.pending = nil
+		if res.err != nil {
+			return res.err
+		}
+		copyMessage(m, res.m)
+		return nil
+	case <-timer.C():
+		return classify(fmt.Errorf("%w after %s", ErrRecvTimeout, s.timeout))
+	}
+}
+
+// copyMessage replaces the content of dst with src, two pointers to values of
+// the same type.
+func copyMessage(dst, src any) {
+	if d, ok := dst.(proto.Message); ok {
+		proto.Reset(d)
+		proto.Merge(d, src.(proto.Message))
+		return
+	}
+	if d, ok := dst.(protoadapt.MessageV1); ok {
+		d.Reset()
+		proto.Merge(protoadapt.MessageV2Of(d), protoadapt.MessageV2Of(src.(protoadapt.MessageV1)))
+		return
+	}
+	reflect.ValueOf(dst).Elem().Set(reflect.ValueOf(src).Elem())
+}
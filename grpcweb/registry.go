@@ -0,0 +1,103 @@
+package grpcweb
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/transport"
+)
+
+// StreamInfo describes a stream which is currently open on a ClientConn.
+type StreamInfo struct {
+	// Method is the full method name of the stream.
+	Method string
+	// StartTime is the time the stream was created.
+	StartTime time.Time
+
+	clock transport.Clock
+}
+
+// Age returns how long the stream has been open, according to the clock of
+// the ClientConn.
+func (i StreamInfo) Age() time.Duration {
+	if i.clock == nil {
+		return time.Since(i.StartTime)
+	}
+	return i.clock.Now().Sub(i.StartTime)
+}
+
+type streamEntry struct {
+	info StreamInfo
+}
+
+// streamRegistry keeps track of the streams which haven't finished yet.
+type streamRegistry struct {
+	clock transport.Clock
+
+	mu      sync.Mutex
+	streams map[*streamEntry]struct{}
+}
+
+// add registers a new stream and returns a function which removes it from the
+// registry. The stream is also removed when ctx is done. The returned function
+// is safe to call multiple times.
+func (r *streamRegistry) add(ctx context.Context, method string) func() {
+	e := &streamEntry{info: StreamInfo{Method: method, StartTime: r.clock.Now(), clock: r.clock}}
+
+	r.mu.Lock()
+	if r.streams == nil {
+		r.streams = make(map[*streamEntry]struct{})
+	}
+	r.streams[e] = struct{}{}
+	r.mu.Unlock()
+
+	var once sync.Once
+	remove := func() {
+		once.Do(func() {
+			r.mu.Lock()
+			delete(r.streams, e)
+			r.mu.Unlock()
+		})
+	}
+	stop := context.AfterFunc(ctx, remove)
+
+	return func() {
+		stop()
+		remove()
+	}
+}
+
+func (r *streamRegistry) list() []StreamInfo {
+	r.mu.Lock()
+	infos := make([]StreamInfo, 0, len(r.streams))
+	for e := range r.streams {
+		infos = append(infos, e.info)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].StartTime.Before(infos[j].StartTime)
+	})
+	return infos
+}
+
+func (r *streamRegistry) len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.streams)
+}
+
+// ActiveStreams returns the streams which are currently open on the ClientConn,
+// oldest first. A stream is considered open until it has received its final
+// status or its context is done.
+func (c *ClientConn) ActiveStreams() []StreamInfo {
+	return c.streams.list()
+}
+
+// NumActiveStreams returns the number of streams which are currently open on
+// the ClientConn.
+func (c *ClientConn) NumActiveStreams() int {
+	return c.streams.len()
+}
@@ -0,0 +1,108 @@
+package grpcweb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/parser"
+)
+
+// WithFrameReordering makes unary calls and server streams tolerate the
+// buggy gateways which send the trailer frame before the last message frames,
+// or send it several times. Up to window frames following a trailer frame are
+// read ahead: their messages are delivered before the trailer and the
+// duplicate trailers are dropped. The anomalies are logged as warnings, even
+// in strict mode. Websocket streams aren't affected.
+func WithFrameReordering(window int) DialOption {
+	return func(opt *dialOptions) {
+		opt.reorderWindow = window
+	}
+}
+
+// reorderFrames returns body reordered as described by WithFrameReordering,
+// body itself if the option isn't set.
+func (o *dialOptions) reorderFrames(method string, body io.ReadCloser) io.ReadCloser {
+	if o.reorderWindow <= 0 {
+		return body
+	}
+	return &frameReorderer{ReadCloser: body, opts: o, method: method}
+}
+
+// frameReorderer holds the trailer frame of a response body back until the
+// frames following it in the window are read. The frames are read with the
+// frame parser of the client and passed on byte for byte.
+type frameReorderer struct {
+	io.ReadCloser
+	opts   *dialOptions
+	method string
+	buf    bytes.Buffer
+	// passthrough is set once the body can be read as is, after the trailer
+	// or an error.
+	passthrough bool
+}
+
+func (r *frameReorderer) Read(p []byte) (int, error) {
+	for r.buf.Len() == 0 {
+		if r.passthrough {
+			return r.ReadCloser.Read(p)
+		}
+		r.next()
+	}
+	return r.buf.Read(p)
+}
+
+// next moves the next frame to buf, along with the messages which follow it
+// if it is a trailer. A frame which can't be read is passed on as far as it
+// was read, for the caller to fail on it.
+func (r *frameReorderer) next() {
+	var trailer bytes.Buffer
+	h, err := r.readFrame(&trailer)
+	if err != nil || h.Flag() != trailerFlag || h.IsHeartbeat() {
+		r.buf.Write(trailer.Bytes())
+		r.passthrough = err != nil
+		return
+	}
+
+	for range r.opts.reorderWindow {
+		var frame bytes.Buffer
+		h, err := r.readFrame(&frame)
+		if err != nil {
+			// The body normally ends right after the trailer.
+			break
+		}
+		switch {
+		case h.IsHeartbeat():
+		case h.Flag() == trailerFlag:
+			r.anomaly("dropped a duplicate trailer frame")
+		default:
+			r.anomaly("received a frame with flag 0x%02x after the trailer frame", h.Flag())
+			r.buf.Write(frame.Bytes())
+		}
+	}
+	r.buf.Write(trailer.Bytes())
+	r.passthrough = true
+}
+
+// readFrame reads a whole frame, copying its bytes to w. The frames larger
+// than the buffer size limit aren't read beyond their header.
+func (r *frameReorderer) readFrame(w *bytes.Buffer) (*parser.Header, error) {
+	tee := io.TeeReader(r.ReadCloser, w)
+	h, err := r.opts.frameParser.ParseResponseHeader(tee)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkMessageSize(r.opts.maxBufferSize, h.ContentLength); err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, tee, int64(h.ContentLength)); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (r *frameReorderer) anomaly(format string, args ...any) {
+	if r.opts.logger != nil {
+		r.opts.logger.Warn("gRPC-Web frame ordering anomaly", "method", r.method, "detail", fmt.Sprintf(format, args...))
+	}
+}
@@ -0,0 +1,349 @@
+package grpcweb
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/errs"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/transport"
+)
+
+// Resolver resolves the host of the target to the addresses of its backends.
+type Resolver interface {
+	// Resolve returns the addresses serving host, of the form host[:port].
+	// The port of the target is used for the addresses without one.
+	Resolve(ctx context.Context, host string) ([]string, error)
+}
+
+// ResolverFunc adapts a function to a Resolver.
+type ResolverFunc func(ctx context.Context, host string) ([]string, error)
+
+func (f ResolverFunc) Resolve(ctx context.Context, host string) ([]string, error) {
+	return f(ctx, host)
+}
+
+// WithResolver spreads the calls and streams over the addresses r returns for
+// the target, according to the load balancing policy. The addresses are refreshed every refresh
+// interval, and immediately after a call fails to connect to one of them, so
+// that its retry goes to another address. A zero interval disables the
+// periodic refresh. The requests keep the Host of the target. With TLS, the
+// certificates are verified against the host of the target unless the
+// configuration sets a ServerName.
+func WithResolver(r Resolver, refresh time.Duration) DialOption {
+	return func(opt *dialOptions) {
+		opt.resolver = r
+		opt.resolveInterval = refresh
+	}
+}
+
+// addressSet keeps the resolved addresses of a target.
+type addressSet struct {
+	resolver Resolver
+	refresh  time.Duration
+	// timeout bounds the resolutions in the background.
+	timeout time.Duration
+	clock   transport.Clock
+	policy  LoadBalancingPolicy
+	// host and port are the hostname and port of the target, prefix and
+	// suffix its userinfo and path.
+	host, port     string
+	prefix, suffix string
+
+	mu    sync.Mutex
+	addrs []string
+	// pending counts the calls in flight by address.
+	pending map[string]int
+	// avoid is the address the next pick skips, after a connection failure.
+	avoid     string
+	outliers  *outlierDetector
+	resolved  time.Time
+	resolving bool
+}
+
+func newAddressSet(target string, opt *dialOptions) *addressSet {
+	s := &addressSet{
+		resolver: opt.resolver,
+		refresh:  opt.resolveInterval,
+		// The connect timeout, 20 seconds by default like grpc-go.
+		timeout: 20 * time.Second,
+		clock:   opt.clock,
+		policy:  opt.balancer,
+		pending: make(map[string]int),
+	}
+	if s.policy == nil {
+		s.policy = RoundRobin()
+	}
+	if p := opt.connectParams; p != nil && p.MinConnectTimeout > 0 {
+		s.timeout = p.MinConnectTimeout
+	}
+	if opt.outlierDetection != nil {
+		s.outliers = newOutlierDetector(*opt.outlierDetection, opt.clock.Now())
+	}
+	if i := strings.LastIndex(target, "@"); i != -1 {
+		s.prefix, target = target[:i+1], target[i+1:]
+	}
+	if i := strings.Index(target, "/"); i != -1 {
+		target, s.suffix = target[:i], target[i:]
+	}
+	// The target is normalized, it always has a port.
+	s.host, s.port, _ = net.SplitHostPort(target)
+	return s
+}
+
+// pick returns the target of the next call, resolving the addresses first if
+// needed. The calls with an affinity key are pinned by rendezvous hashing
+// instead of the policy. The returned use must be ended once the call is over.
+func (s *addressSet) pick(ctx context.Context, key string) (string, *addressUse, error) {
+	s.mu.Lock()
+	if s.addrs == nil {
+		s.mu.Unlock()
+		if err := s.resolve(ctx); err != nil {
+			return "", nil, err
+		}
+		s.mu.Lock()
+	} else if s.refresh > 0 && s.clock.Now().Sub(s.resolved) >= s.refresh {
+		s.resolveLocked()
+	}
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	if s.outliers != nil {
+		s.outliers.sweep(now, s.addrs)
+	}
+	addrs := s.filter(func(a string) bool {
+		return a != s.avoid && (s.outliers == nil || s.outliers.available(a, now))
+	})
+	s.avoid = ""
+	pending := make([]int, len(addrs))
+	for i, a := range addrs {
+		pending[i] = s.pending[a]
+	}
+	var i int
+	if key != "" {
+		i = rendezvous(key, addrs)
+	} else {
+		i = s.policy.Pick(addrs, pending)
+	}
+	if i < 0 || i >= len(addrs) {
+		i = 0
+	}
+
+	addr := addrs[i]
+	s.pending[addr]++
+	return s.prefix + addr + s.suffix, &addressUse{set: s, addr: addr, start: now}, nil
+}
+
+// filter returns the addresses accepted by f, or all of them if f accepts
+// none.
+func (s *addressSet) filter(f func(addr string) bool) []string {
+	addrs := make([]string, 0, len(s.addrs))
+	for _, a := range s.addrs {
+		if f(a) {
+			addrs = append(addrs, a)
+		}
+	}
+	if len(addrs) == 0 {
+		return s.addrs
+	}
+	return addrs
+}
+
+// addressUse is the use of an address by a call or stream.
+type addressUse struct {
+	set   *addressSet
+	addr  string
+	start time.Time
+	once  sync.Once
+}
+
+// end ends the use by a call which returned err, recording its outcome for
+// the outlier detection.
+func (u *addressUse) end(err error) {
+	if u == nil {
+		return
+	}
+	u.once.Do(func() {
+		s := u.set
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.releaseLocked(u.addr)
+		if s.outliers != nil {
+			now := s.clock.Now()
+			s.outliers.record(u.addr, err, now.Sub(u.start), now, s.addrs)
+		}
+	})
+}
+
+// release ends the use without recording an outcome.
+func (u *addressUse) release() {
+	if u == nil {
+		return
+	}
+	u.once.Do(func() {
+		u.set.mu.Lock()
+		defer u.set.mu.Unlock()
+		u.set.releaseLocked(u.addr)
+	})
+}
+
+func (s *addressSet) releaseLocked(addr string) {
+	if s.pending[addr]--; s.pending[addr] <= 0 {
+		delete(s.pending, addr)
+	}
+}
+
+// failed reports that a call couldn't connect to target. The addresses are
+// resolved again, and the next pick skips target.
+func (s *addressSet) failed(target string) {
+	addr := strings.TrimSuffix(strings.TrimPrefix(target, s.prefix), s.suffix)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.avoid = addr
+	s.resolveLocked()
+}
+
+// resolveLocked resolves the addresses in the background, unless a
+// resolution is already running. s.mu must be held.
+func (s *addressSet) resolveLocked() {
+	if s.resolving {
+		return
+	}
+	s.resolving = true
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		defer cancel()
+		// The current addresses are kept if the resolution fails.
+		_ = s.resolve(ctx)
+		s.mu.Lock()
+		s.resolving = false
+		s.mu.Unlock()
+	}()
+}
+
+func (s *addressSet) resolve(ctx context.Context) error {
+	addrs, err := s.resolver.Resolve(ctx, s.host)
+	if err != nil {
+		return errs.WithCode(codes.Unavailable, err, "failed to resolve the target")
+	}
+	if len(addrs) == 0 {
+		return errs.WithCode(codes.Unavailable, nil, "the resolver returned no addresses")
+	}
+
+	normalized := make([]string, len(addrs))
+	for i, a := range addrs {
+		if _, _, err := net.SplitHostPort(a); err != nil {
+			a = net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(a, "["), "]"), s.port)
+		}
+		normalized[i] = a
+	}
+
+	s.mu.Lock()
+	s.addrs = normalized
+	s.resolved = s.clock.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// resolverTLSConfig returns the TLS configuration of the connections to the
+// resolved addresses, verifying the certificates against host.
+func resolverTLSConfig(conf *tls.Config, host string) *tls.Config {
+	if conf == nil {
+		return &tls.Config{ServerName: host}
+	}
+	if conf.ServerName != "" {
+		return conf
+	}
+	conf = conf.Clone()
+	conf.ServerName = host
+	return conf
+}
+
+// isConnectionError reports whether err is a failure to reach the server, as
+// opposed to an error returned by the server.
+func isConnectionError(err error) bool {
+	var (
+		opErr  *net.OpError
+		dnsErr *net.DNSError
+	)
+	return errors.As(err, &opErr) || errors.As(err, &dnsErr) ||
+		errors.Is(err, transport.ErrConnectionReset) ||
+		errors.Is(err, transport.ErrDNSResolution) ||
+		errors.Is(err, transport.ErrTLSHandshake)
+}
+
+// pickTarget returns the target of a new call. The returned use, nil without
+// a resolver or with a Host override, must be ended once the call is over.
+func (c *ClientConn) pickTarget(ctx context.Context, callOptions *callOptions) (string, *addressUse, error) {
+	if callOptions.host != "" {
+		return callOptions.host, nil, nil
+	}
+	if c.addrs == nil {
+		return c.host, nil, nil
+	}
+	return c.addrs.pick(ctx, callOptions.affinityKey)
+}
+
+// targetConnectOptions returns the connect options of a call of method sent
+// to target, picked by use. The requests to a resolved address keep the Host
+// of the target.
+func (c *ClientConn) targetConnectOptions(method string, callOptions *callOptions, use *addressUse) []transport.ConnectOption {
+	opts := c.connectOptions(method, callOptions)
+	if use != nil {
+		opts = append(opts, transport.WithAuthority(net.JoinHostPort(c.addrs.host, c.addrs.port)))
+	}
+	return opts
+}
+
+// connectionFailed triggers the re-resolution of the addresses if err shows
+// that target, picked by use, couldn't be reached.
+func (c *ClientConn) connectionFailed(use *addressUse, target string, err error) {
+	if use != nil && isConnectionError(err) {
+		c.addrs.failed(target)
+	}
+}
+
+// reportingTransport reports the connection failures of the requests sent to
+// a resolved address. They resolve to codes.Unavailable, so that a retry
+// policy may send the call to another address.
+type reportingTransport struct {
+	transport.UnaryTransport
+	cc     *ClientConn
+	target string
+}
+
+func (t *reportingTransport) Send(ctx context.Context, endpoint, contentType string, body io.Reader) (http.Header, io.ReadCloser, error) {
+	h, r, err := t.UnaryTransport.Send(ctx, endpoint, contentType, body)
+	if err != nil && isConnectionError(err) {
+		t.cc.addrs.failed(t.target)
+		return nil, nil, errs.WithCode(codes.Unavailable, err, "")
+	}
+	return h, r, err
+}
+
+// newUnaryTransport returns the transport of a unary call or server stream,
+// and the use of the address it is sent to.
+func (c *ClientConn) newUnaryTransport(ctx context.Context, method string, callOptions *callOptions) (transport.UnaryTransport, *addressUse, error) {
+	target, use, err := c.pickTarget(ctx, callOptions)
+	if err != nil {
+		return nil, nil, err
+	}
+	tr, err := transport.NewUnary(target, c.targetConnectOptions(method, callOptions, use)...)
+	if err != nil {
+		use.end(err)
+		return nil, nil, err
+	}
+	if use != nil {
+		tr = &reportingTransport{UnaryTransport: tr, cc: c, target: target}
+	}
+	return tr, use, nil
+}
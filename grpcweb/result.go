@@ -0,0 +1,42 @@
+package grpcweb
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// CallResult describes a unary call made with InvokeFull.
+type CallResult struct {
+	// Header and Trailer are the metadata received from the server.
+	Header, Trailer metadata.MD
+	// Status is the status of the call, codes.OK if it succeeded.
+	Status *status.Status
+	// StartTime is the time the call started, Duration its overall duration,
+	// retries included.
+	StartTime time.Time
+	Duration  time.Duration
+	// Attempts is the number of attempts made, 1 without retries.
+	Attempts int
+	// RequestSize and ResponseSize are the lengths of the request and
+	// response messages on the wire, without their frame prefix.
+	RequestSize, ResponseSize int
+	// Stats holds the statistics of the last attempt, see Stats.
+	Stats CallStats
+}
+
+// InvokeFull is like Invoke, but returns all the details of the call at once
+// instead of through the Header, Trailer and Stats call options. The result is
+// returned even if the call fails.
+func (c *ClientConn) InvokeFull(ctx context.Context, method string, args, reply any, opts ...CallOption) (*CallResult, error) {
+	res := &CallResult{StartTime: c.dialOptions.clock.Now()}
+	opts = append(opts[:len(opts):len(opts)], Header(&res.Header), Trailer(&res.Trailer), Stats(&res.Stats), newCallOption(func(opt *callOptions) {
+		opt.result = res
+	}))
+	err := c.Invoke(ctx, method, args, reply, opts...)
+	res.Duration = c.dialOptions.clock.Now().Sub(res.StartTime)
+	res.Status = status.Convert(err)
+	return res, err
+}
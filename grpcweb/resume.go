@@ -0,0 +1,150 @@
+package grpcweb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	internalbackoff "github.com/heartandu/grpc-web-go-client/grpcweb/internal/backoff"
+)
+
+// ResumeConfig configures a resumable server stream, see
+// ClientConn.NewResumableStream.
+type ResumeConfig struct {
+	// Request returns the request of the stream, resuming the download after
+	// the given number of fully received messages. It is called with 0 for the
+	// initial request.
+	Request func(received int) any
+	// MaxAttempts is the maximum number of consecutive resumptions without
+	// any message received in between. It defaults to 3.
+	MaxAttempts int
+	// Backoff configures the delays between the resumptions. It defaults to
+	// the backoff set with WithConnectParams, then to backoff.DefaultConfig.
+	Backoff backoff.Config
+	// Retryable reports whether the stream may be resumed after err. By
+	// default transport failures are, i.e. errors with codes.Unavailable and
+	// truncated responses.
+	Retryable func(err error) bool
+}
+
+// NewResumableStream opens a server stream which is transparently rebuilt
+// after a transport failure. The new stream is sent the request returned by
+// rc.Request for the number of messages received so far, so that servers
+// supporting Range-style resumption continue where the download stopped. The
+// messages are sent by the stream itself, SendMsg must not be called.
+func (c *ClientConn) NewResumableStream(ctx context.Context, method string, rc ResumeConfig, opts ...CallOption) (Stream, error) {
+	if rc.MaxAttempts == 0 {
+		rc.MaxAttempts = 3
+	}
+	rc.Backoff = c.dialOptions.backoffConfig(rc.Backoff)
+	if rc.Retryable == nil {
+		rc.Retryable = isTransportFailure
+	}
+
+	s := &resumableStream{ctx: ctx, cc: c, method: method, config: rc, opts: opts}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func isTransportFailure(err error) bool {
+	return status.Code(err) == codes.Unavailable || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+type resumableStream struct {
+	ctx    context.Context
+	cc     *ClientConn
+	method string
+	config ResumeConfig
+	opts   []CallOption
+
+	stream   Stream
+	received int
+	attempts int
+}
+
+func (s *resumableStream) open() error {
+	stream, err := s.cc.newInterceptedStream(withAttempt(s.ctx, s.attempts+1), &grpc.StreamDesc{ServerStreams: true}, s.method, s.opts...)
+	if err != nil {
+		return err
+	}
+	if err := stream.SendMsg(s.config.Request(s.received)); err != nil {
+		return err
+	}
+	s.stream = stream
+	return nil
+}
+
+func (s *resumableStream) Header() (metadata.MD, error) {
+	return s.stream.Header()
+}
+
+func (s *resumableStream) Trailer() metadata.MD {
+	return s.stream.Trailer()
+}
+
+func (s *resumableStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *resumableStream) CloseSend() error {
+	return nil
+}
+
+func (s *resumableStream) SetCallMetadata(metadata.MD) error {
+	return ErrStreamStarted
+}
+
+func (s *resumableStream) SendMsg(any) error {
+	return errors.New("SendMsg must not be called on a resumable stream")
+}
+
+func (s *resumableStream) RecvMsg(m any) error {
+	for {
+		err := s.stream.RecvMsg(m)
+		if err == nil {
+			s.received++
+			s.attempts = 0
+			return nil
+		}
+		if err == io.EOF || !s.config.Retryable(err) || s.attempts >= s.config.MaxAttempts {
+			return err
+		}
+
+		if err := s.resume(err); err != nil {
+			return err
+		}
+	}
+}
+
+// resume waits for the backoff delay and rebuilds the stream which failed
+// with cause.
+func (s *resumableStream) resume(cause error) error {
+	for {
+		timer := s.cc.dialOptions.clock.NewTimer(internalbackoff.Delay(s.config.Backoff, s.attempts))
+		select {
+		case <-s.ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w (last error: %w)", s.ctx.Err(), cause)
+		case <-timer.C():
+		}
+		s.attempts++
+
+		err := s.open()
+		if err == nil {
+			return nil
+		}
+		if !s.config.Retryable(err) || s.attempts >= s.config.MaxAttempts {
+			return err
+		}
+		cause = err
+	}
+}
@@ -0,0 +1,135 @@
+package grpcweb
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// RetryPolicy configures the retries of unary calls, with the semantics of
+// the retryPolicy of the gRPC service config. Attempts following the first
+// one carry the grpc-previous-rpc-attempts header, and the server may steer
+// the retries with the grpc-retry-pushback-ms trailer.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the original
+	// one. Retries are disabled if it is less than 2.
+	MaxAttempts int
+	// InitialBackoff, MaxBackoff and BackoffMultiplier bound the randomized
+	// delay before the n-th retry to
+	// min(InitialBackoff*BackoffMultiplier^(n-1), MaxBackoff).
+	InitialBackoff    time.Duration
+	MaxBackoff        time.Duration
+	BackoffMultiplier float64
+	// RetryableStatusCodes lists the codes of the failures which are retried.
+	RetryableStatusCodes []codes.Code
+}
+
+// WithRetryPolicy retries the unary calls failing with one of the retryable
+// codes of p.
+func WithRetryPolicy(p RetryPolicy) DialOption {
+	return func(opt *dialOptions) {
+		opt.retryPolicy = &p
+	}
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	code := status.Code(err)
+	for _, c := range p.RetryableStatusCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the delay before the n-th retry, starting at 1.
+func (p *RetryPolicy) backoff(n int) time.Duration {
+	d := min(float64(p.InitialBackoff)*math.Pow(p.BackoffMultiplier, float64(n-1)), float64(p.MaxBackoff))
+	return time.Duration(rand.Float64() * d)
+}
+
+// attemptInfo collects what the retry logic needs to know about an attempt.
+type attemptInfo struct {
+	// pushback is the value of the grpc-retry-pushback-ms header or trailer
+	// received from the server, if any.
+	pushback []string
+}
+
+func (a *attemptInfo) record(md metadata.MD) {
+	if a == nil {
+		return
+	}
+	if v := md.Get("grpc-retry-pushback-ms"); len(v) > 0 {
+		a.pushback = v
+	}
+}
+
+// retryDelay returns the delay before the n-th retry of a call which failed
+// with err, or false if the call must not be retried. A pushback received
+// from the server overrides the backoff, and a negative or malformed one
+// stops the retries.
+func (p *RetryPolicy) retryDelay(n int, err error, info *attemptInfo) (time.Duration, bool) {
+	if !p.retryable(err) {
+		return 0, false
+	}
+	if info.pushback == nil {
+		return p.backoff(n), true
+	}
+	if len(info.pushback) != 1 {
+		return 0, false
+	}
+	ms, perr := strconv.Atoi(info.pushback[0])
+	if perr != nil || ms < 0 {
+		return 0, false
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+// invokeWithRetry performs the call, retrying it according to the retry
+// policy.
+func (c *ClientConn) invokeWithRetry(ctx context.Context, method string, args, reply any, opts ...CallOption) error {
+	opts = c.dialOptions.withIdempotencyKey(opts)
+	waitForReady := c.applyCallOptions(opts).waitForReady
+	p := c.dialOptions.retryPolicy
+	if p == nil || p.MaxAttempts < 2 {
+		return c.queue(ctx, waitForReady, func() error {
+			return c.invoke(ctx, method, args, reply, opts...)
+		})
+	}
+
+	n := 0
+	for attempt := 1; ; attempt++ {
+		info := &attemptInfo{}
+		attemptOpts := append(opts[:len(opts):len(opts)], newCallOption(func(o *callOptions) { o.attempt = info }))
+		err := c.queue(ctx, waitForReady, func() error {
+			return c.invoke(withCallAttempt(ctx, attempt), method, args, reply, attemptOpts...)
+		})
+		if err == nil || attempt >= p.MaxAttempts {
+			return err
+		}
+
+		n++
+		delay, ok := p.retryDelay(n, err, info)
+		if !ok {
+			return err
+		}
+		if info.pushback != nil {
+			// The backoff starts over after a pushback.
+			n = 0
+		}
+
+		timer := c.dialOptions.clock.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C():
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package grpcweb
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// RunBidiStream opens a bidirectional stream of method on cc, and runs send
+// and the reception of the responses concurrently. send is given the sending
+// half of the stream, whose sending side is closed once send returns nil.
+// recv is called with each response message, in order. It returns the first
+// error returned by send, recv or the stream, which cancels the stream, or
+// the trailer of the stream once the server ends it successfully.
+//
+// The responses are read through the receive pump, see WithReceivePump, so
+// that canceling the stream interrupts their reception. A send still running
+// when the server ends the stream sees the context of the stream canceled,
+// and its error is ignored.
+func RunBidiStream[Res any](ctx context.Context, cc ClientConnInterface, method string, send func(SendStream) error, recv func(*Res) error, opts ...CallOption) (metadata.MD, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var trailer metadata.MD
+	opts = append(opts[:len(opts):len(opts)], Trailer(&trailer), newCallOption(func(o *callOptions) {
+		o.receivePump = true
+	}))
+	s, err := cc.NewStream(ctx, &grpc.StreamDesc{ClientStreams: true, ServerStreams: true}, method, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		once     sync.Once
+		firstErr error
+	)
+	// end records the outcome of the stream, the first one wins.
+	end := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := send(sendHalf{s}); err != nil {
+			end(err)
+			return
+		}
+		if err := s.CloseSend(); err != nil {
+			end(err)
+		}
+	}()
+
+	for {
+		msg := new(Res)
+		if err := s.RecvMsg(msg); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			end(err)
+			break
+		}
+		if err := recv(msg); err != nil {
+			end(err)
+			break
+		}
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return trailer, nil
+}
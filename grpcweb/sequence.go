@@ -0,0 +1,92 @@
+package grpcweb
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/errs"
+)
+
+// ErrOutOfSequence is returned by the strict calls made with Sequence when a
+// received message is missing or duplicated.
+var ErrOutOfSequence = errs.WithCode(codes.DataLoss, nil, "message out of sequence")
+
+// Sequencer stamps the messages sent by a call with sequence numbers, and
+// reads the ones of the messages received, see Sequence.
+type Sequencer struct {
+	// Stamp sets seq on msg, a message about to be sent. The messages of a
+	// call are numbered from 1. It may be nil.
+	Stamp func(msg any, seq uint64)
+	// Sequence returns the sequence number of msg, a received message, or
+	// false if it doesn't carry any. It may be nil.
+	Sequence func(msg any) (uint64, bool)
+	// Strict fails the call with ErrOutOfSequence on the first missing or
+	// duplicated message, instead of only counting them.
+	Strict bool
+}
+
+// Sequence makes the call stamp its messages and verify the sequence numbers
+// of the ones it receives with s. Each received number is expected to follow
+// the previous one, the first one being taken as is so that resumed streams
+// may start anywhere. The messages skipped or received again are counted in
+// the CallStats set with Stats, which reconnection logic and tests may check
+// for messages lost or duplicated by the transport layers.
+func Sequence(s Sequencer) CallOption {
+	return newCallOption(func(opt *callOptions) {
+		opt.sequencer = &s
+	})
+}
+
+// sequence holds the sequence numbers of a call.
+type sequence struct {
+	sent uint64
+	// received is the last number received, valid once seen is set.
+	received uint64
+	seen     bool
+}
+
+// stamp counts a message about to be sent, and stamps it.
+func (o *callOptions) stamp(msg any) {
+	o.seq.sent++
+	if o.stats != nil {
+		o.stats.MessagesSent++
+	}
+	if o.sequencer != nil && o.sequencer.Stamp != nil {
+		o.sequencer.Stamp(msg, o.seq.sent)
+	}
+}
+
+// verify counts a received message, and checks its sequence number.
+func (o *callOptions) verify(msg any) error {
+	if o.stats != nil {
+		o.stats.MessagesReceived++
+	}
+	if o.sequencer == nil || o.sequencer.Sequence == nil {
+		return nil
+	}
+	n, ok := o.sequencer.Sequence(msg)
+	if !ok {
+		return nil
+	}
+	last, seen := o.seq.received, o.seq.seen
+	if !seen || n > last {
+		o.seq.received, o.seq.seen = n, true
+	}
+	switch {
+	case !seen || n == last+1:
+		return nil
+	case n > last:
+		if o.stats != nil {
+			o.stats.MissingMessages += int(n - last - 1)
+		}
+	default:
+		if o.stats != nil {
+			o.stats.DuplicateMessages++
+		}
+	}
+	if o.sequencer.Strict {
+		return fmt.Errorf("%w: received %d after %d", ErrOutOfSequence, n, last)
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package grpcweb
+
+// CallStats holds information about a call. Use the Stats call option to
+// obtain it.
+type CallStats struct {
+	// URL is the request URL after all rewriting, as sent to the server.
+	URL string
+	// Heartbeats is the number of heartbeat frames skipped while receiving
+	// the response.
+	Heartbeats int
+	// MessagesSent and MessagesReceived are the numbers of messages sent and
+	// received by the call.
+	MessagesSent, MessagesReceived int
+	// MissingMessages and DuplicateMessages are the numbers of messages
+	// skipped and received again according to their sequence numbers, see
+	// Sequence.
+	MissingMessages, DuplicateMessages int
+	// RateLimit is the budget advertised by the response headers of the
+	// unary call or server stream, nil if there is none.
+	RateLimit *RateLimit
+}
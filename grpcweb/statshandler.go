@@ -0,0 +1,140 @@
+package grpcweb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/transport"
+)
+
+// rpcStats reports the events of a single RPC to the stats handlers set with
+// WithStatsHandler. The methods of a nil *rpcStats are no-ops.
+type rpcStats struct {
+	ctx      context.Context
+	handlers []stats.Handler
+	clock    transport.Clock
+	begin    time.Time
+
+	mu      sync.Mutex
+	trailer metadata.MD
+	ended   bool
+}
+
+// beginRPC tags the RPC and reports its beginning. The returned context must
+// be used for the rest of the RPC.
+func (o *dialOptions) beginRPC(ctx context.Context, method string, clientStream, serverStream bool) (context.Context, *rpcStats) {
+	if len(o.statsHandlers) == 0 {
+		return ctx, nil
+	}
+
+	for _, h := range o.statsHandlers {
+		ctx = h.TagRPC(ctx, &stats.RPCTagInfo{FullMethodName: method})
+	}
+	s := &rpcStats{
+		ctx:      ctx,
+		handlers: o.statsHandlers,
+		clock:    o.clock,
+		begin:    o.clock.Now(),
+	}
+	s.handle(&stats.Begin{
+		Client:         true,
+		BeginTime:      s.begin,
+		IsClientStream: clientStream,
+		IsServerStream: serverStream,
+	})
+	return ctx, s
+}
+
+func (s *rpcStats) handle(rs stats.RPCStats) {
+	for _, h := range s.handlers {
+		h.HandleRPC(s.ctx, rs)
+	}
+}
+
+func (s *rpcStats) outHeader(method string, md metadata.MD) {
+	if s == nil {
+		return
+	}
+	s.handle(&stats.OutHeader{Client: true, Header: md.Copy(), FullMethod: method})
+}
+
+// outPayload reports a sent message. wireLength includes the frame header.
+func (s *rpcStats) outPayload(msg any, wireLength int) {
+	if s == nil {
+		return
+	}
+	s.handle(&stats.OutPayload{
+		Client:           true,
+		Payload:          msg,
+		Length:           wireLength - headerLen,
+		CompressedLength: wireLength - headerLen,
+		WireLength:       wireLength,
+		SentTime:         s.clock.Now(),
+	})
+}
+
+func (s *rpcStats) inHeader(md metadata.MD) {
+	if s == nil {
+		return
+	}
+	s.handle(&stats.InHeader{Client: true, Header: md})
+}
+
+// inPayload reports a received message of length bytes, without the frame
+// header.
+func (s *rpcStats) inPayload(msg any, length int) {
+	if s == nil {
+		return
+	}
+	s.handle(&stats.InPayload{
+		Client:           true,
+		Payload:          msg,
+		Length:           length,
+		CompressedLength: length,
+		WireLength:       length + headerLen,
+		RecvTime:         s.clock.Now(),
+	})
+}
+
+func (s *rpcStats) inTrailer(md metadata.MD, wireLength int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.trailer = md
+	s.mu.Unlock()
+	s.handle(&stats.InTrailer{Client: true, Trailer: md, WireLength: wireLength})
+}
+
+// end reports the end of the RPC. Only the first call has an effect. io.EOF
+// is treated as a successful end of stream.
+func (s *rpcStats) end(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	trailer := s.trailer
+	s.mu.Unlock()
+
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	s.handle(&stats.End{
+		Client:    true,
+		BeginTime: s.begin,
+		EndTime:   s.clock.Now(),
+		Trailer:   trailer,
+		Error:     err,
+	})
+}
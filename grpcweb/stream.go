@@ -1,26 +1,32 @@
 package grpcweb
 
 import (
+	"bytes"
 	"context"
-	"encoding/binary"
-	"fmt"
+	"errors"
 	"io"
 	"net/http"
-	"strconv"
+	"slices"
 	"sync"
 
-	"github.com/pkg/errors"
 	"go.uber.org/atomic"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/mem"
 	"google.golang.org/grpc/metadata"
-	"google.golang.org/grpc/status"
 
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/errs"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/statusresolver"
 	"github.com/heartandu/grpc-web-go-client/grpcweb/parser"
 	"github.com/heartandu/grpc-web-go-client/grpcweb/transport"
 )
 
 // Stream is an interface that represents a generic stream of messages.
+//
+// Like with grpc-go, a goroutine may call SendMsg while another goroutine
+// calls RecvMsg on the same stream, but neither method may be called from
+// several goroutines at the same time. Header, Trailer, Context and CloseSend
+// may be called concurrently with both.
 type Stream interface {
 	// Header returns the header metadata from the server, if there is any.
 	// It blocks if the metadata is not ready to read.
@@ -37,17 +43,45 @@ type Stream interface {
 	SendMsg(m any) error
 	// RecvMsg receives a message from the stream and returns any error that occurred.
 	RecvMsg(m any) error
+	// SetCallMetadata adds md to the request headers of the stream, along
+	// with the outgoing metadata of its context. It must be called before
+	// the first SendMsg, and returns ErrStreamStarted afterwards.
+	SetCallMetadata(md metadata.MD) error
 }
 
+// ErrRequestMetadataChanged is returned by SendMsg when the outgoing metadata
+// of the stream context was modified after the creation of the stream. The
+// request headers are sent once, so the change would be silently dropped.
+var ErrRequestMetadataChanged = errs.WithCode(codes.FailedPrecondition, nil, "the request metadata changed after the creation of the stream")
+
+// ErrStreamStarted is returned by SetCallMetadata once the request headers of
+// the stream were sent.
+var ErrStreamStarted = errs.WithCode(codes.FailedPrecondition, nil, "the stream already sent its request headers")
+
 type clientStream struct {
-	ctx         context.Context
-	endpoint    string
-	transport   transport.ClientStreamTransport
-	callOptions *callOptions
+	ctx      context.Context
+	endpoint string
+	// requestMD is the outgoing metadata sent with the request headers.
+	requestMD metadata.MD
+	// requestHeader is set to the transport by the first SendMsg or CloseSend.
+	requestHeader http.Header
+	headerSent    atomic.Bool
+	transport     transport.ClientStreamTransport
+	callOptions   *callOptions
+
+	dialOptions *dialOptions
+	stats       *rpcStats
+	inactivity  *inactivityTimer
+	// release removes the stream from the registry of active streams.
+	release func()
+	// res resolves the status of the response, it is used by RecvMsg only.
+	res *statusresolver.Resolver
 
 	trailersOnly, closed atomic.Bool
 	headerMu, trailerMu  sync.RWMutex
 	headerMD, trailerMD  metadata.MD
+	// rawHeaderMD is headerMD with the status headers.
+	rawHeaderMD metadata.MD
 }
 
 func (s *clientStream) Header() (metadata.MD, error) {
@@ -55,29 +89,35 @@ func (s *clientStream) Header() (metadata.MD, error) {
 		return nil, nil
 	}
 
-	h := s.header()
-	if h != nil {
-		return h, nil
+	md, _, err := s.headers()
+	return md, err
+}
+
+// headers returns the header metadata, and the raw one with the status
+// headers, receiving them first if needed.
+func (s *clientStream) headers() (md, raw metadata.MD, err error) {
+	s.headerMu.RLock()
+	md, raw = s.headerMD, s.rawHeaderMD
+	s.headerMu.RUnlock()
+	if raw != nil {
+		return md, raw, nil
 	}
 
-	md := metadata.New(nil)
 	headers, err := s.transport.Header()
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get headers")
-	}
-	for k, v := range headers {
-		md.Append(k, v...)
+		return nil, nil, errs.Wrap(err, "failed to get headers")
 	}
+	raw = metadata.New(nil)
+	appendMetadata(raw, headers)
+	md = withoutStatus(raw)
 	s.headerMu.Lock()
-	s.headerMD = md
+	s.headerMD, s.rawHeaderMD = md, raw
 	s.headerMu.Unlock()
-	return md, nil
-}
-
-func (s *clientStream) header() metadata.MD {
-	s.headerMu.RLock()
-	defer s.headerMu.RUnlock()
-	return s.headerMD
+	if s.callOptions.rawHeader != nil {
+		*s.callOptions.rawHeader = raw
+	}
+	s.stats.inHeader(md)
+	return md, raw, nil
 }
 
 func (s *clientStream) Trailer() metadata.MD {
@@ -97,9 +137,30 @@ func (s *clientStream) Context() context.Context {
 	return s.ctx
 }
 
+func (s *clientStream) SetCallMetadata(md metadata.MD) error {
+	if s.headerSent.Load() {
+		return ErrStreamStarted
+	}
+	for k, v := range md {
+		for _, vv := range v {
+			s.requestHeader.Add(k, vv)
+		}
+	}
+	return nil
+}
+
+// sendHeader sets the request headers to the transport, unless they were
+// already.
+func (s *clientStream) sendHeader() {
+	if !s.headerSent.Swap(true) {
+		s.transport.SetRequestHeader(s.requestHeader)
+	}
+}
+
 func (s *clientStream) CloseSend() error {
+	s.sendHeader()
 	if err := s.transport.CloseSend(); err != nil {
-		return fmt.Errorf("failed to close the send stream: %w", err)
+		return errs.Wrap(err, "failed to close the send stream")
 	}
 
 	s.closed.Store(true)
@@ -108,95 +169,200 @@ func (s *clientStream) CloseSend() error {
 }
 
 func (s *clientStream) SendMsg(req any) error {
-	r, err := encodeRequestBody(s.callOptions.codec, req)
+	if err := s.dialOptions.validate(req, false); err != nil {
+		return err
+	}
+	r, err := encodeRequestBody(s.dialOptions, s.callOptions, s.endpoint, req)
 	if err != nil {
-		return errors.Wrap(err, "failed to build the request")
+		return errs.Wrap(err, "failed to build the request")
 	}
 
-	h := make(http.Header)
-	md, ok := metadata.FromOutgoingContext(s.ctx)
-	if ok {
-		for k, v := range md {
-			for _, vv := range v {
-				h.Add(k, vv)
-			}
-		}
+	if md, _ := metadata.FromOutgoingContext(s.ctx); !equalMD(md, s.requestMD) {
+		return ErrRequestMetadataChanged
 	}
-	s.transport.SetRequestHeader(h)
+	s.sendHeader()
 
+	wireLength := r.Len()
 	if err := s.transport.Send(s.ctx, r); err != nil {
-		return errors.Wrap(err, "failed to send the request")
+		return errs.Wrap(err, "failed to send the request")
 	}
+	s.stats.outPayload(req, wireLength)
 	return nil
 }
 
-func (s *clientStream) RecvMsg(res any) error {
-	rawBody, err := s.transport.Receive(s.ctx)
-	if s.isTrailerOnly(err) {
-		// Parse headers as trailers.
-		trailer, err := s.Header()
-		if err != nil {
-			return errors.Wrap(err, "failed to get header instead of trailer")
+// equalMD reports whether a and b hold the same values.
+func equalMD(a, b metadata.MD) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if !slices.Equal(v, b[k]) {
+			return false
 		}
-		s.trailerMu.Lock()
-		s.trailerMD = trailer
-		s.trailersOnly.Store(true)
-		s.trailerMu.Unlock()
+	}
+	return true
+}
 
-		// Try to extract *status.Status from headers.
-		return statusFromHeader(trailer).Err()
+func (s *clientStream) RecvMsg(res any) (err error) {
+	// A client stream receives exactly one response.
+	defer func() {
+		err = s.inactivity.err(err)
+		err = classify(s.dialOptions.truncateStatus(s.endpoint, err))
+		s.release()
+		s.stats.end(err)
+	}()
+	defer s.inactivity.wait()()
+
+	rawBody, err := s.transport.Receive(s.ctx)
+	if s.isTrailerOnly(err) {
+		return s.resolveTrailersOnly()
 	}
 	if err != nil {
-		return errors.Wrap(err, "failed to receive the response")
+		return errs.Wrap(err, "failed to receive the response")
 	}
 
 	var closeOnce sync.Once
 	defer closeOnce.Do(func() { rawBody.Close() })
 
-	resHeader, err := parser.ParseResponseHeader(rawBody)
+	body, resHeader, err := s.nextFrame(rawBody)
 	if err != nil {
-		return errors.Wrap(err, "failed to parse response header")
+		return errs.Wrap(err, "failed to parse response header")
 	}
+	rawBody = body
 
 	if resHeader.IsMessageHeader() {
-		resBody, err := parser.ParseLengthPrefixedMessage(rawBody, resHeader.ContentLength)
+		if err := checkMessageSize(s.dialOptions.maxBufferSize, resHeader.ContentLength); err != nil {
+			return err
+		}
+		resBody, err := s.dialOptions.frameParser.ParseLengthPrefixedMessage(rawBody, resHeader.ContentLength)
 		if err != nil {
-			return errors.Wrap(err, "failed to parse the response body")
+			return errs.Wrap(err, "failed to parse the response body")
+		}
+		if resBody, err = s.decompress(resHeader, resBody); err != nil {
+			return err
+		}
+		if resBody, err = s.dialOptions.transformResponse(s.endpoint, resBody); err != nil {
+			return err
 		}
 		codec := s.callOptions.codec
 		if err := codec.Unmarshal([]mem.Buffer{mem.NewBuffer(&resBody, nil)}, res); err != nil {
-			return errors.Wrapf(err, "failed to unmarshal response body by codec %s", codec.Name())
+			return errs.Wrapf(err, "failed to unmarshal response body by codec %s", codec.Name())
+		}
+		if err := s.dialOptions.validate(res, true); err != nil {
+			return err
+		}
+		s.stats.inPayload(res, len(resBody))
+		if err := s.callOptions.verify(res); err != nil {
+			return err
+		}
+		if err := s.res.OnMessage(); err != nil {
+			return err
 		}
 
 		closeOnce.Do(func() { rawBody.Close() })
 
 		// improbable-eng/grpc-web returns the trailer in another message.
 		rawBody2, err := s.transport.Receive(s.ctx)
+		if errors.Is(err, io.EOF) {
+			return s.resolveEnd()
+		}
+		if err != nil {
+			return errs.Wrap(err, "failed to receive the response trailer")
+		}
+		rawBody2, resHeader, err = s.nextFrame(rawBody2)
 		if err != nil {
-			return errors.Wrap(err, "failed to receive the response trailer")
+			return errs.Wrap(err, "failed to parse response header2")
 		}
 		defer rawBody2.Close()
 		rawBody = rawBody2
-
-		resHeader, err = parser.ParseResponseHeader(rawBody2)
-		if err != nil {
-			return errors.Wrap(err, "failed to parse response header2")
+		if resHeader.IsMessageHeader() {
+			return errMultipleResponses
 		}
 	}
 	if !resHeader.IsTrailerHeader() {
-		return errors.New("unexpected header")
+		return errs.WithCode(codes.Internal, nil, "unexpected header")
 	}
 
-	status, trailer, err := parser.ParseStatusAndTrailer(rawBody, resHeader.ContentLength)
+	status, trailer, err := parseStatusAndTrailer(s.dialOptions, rawBody, resHeader.ContentLength)
 	if err != nil {
-		return errors.Wrap(err, "failed to parse status and trailer")
+		return errs.Wrap(err, "failed to parse status and trailer")
 	}
-	s.trailerMu.Lock()
-	defer s.trailerMu.Unlock()
-	s.trailerMD = trailer
+	if err := s.res.OnTrailer(status, trailer); err != nil {
+		return err
+	}
+	s.setTrailer(trailer, false)
+	s.stats.inTrailer(trailer, int(resHeader.ContentLength))
 	return status.Err()
 }
 
+// resolveTrailersOnly resolves the status of a response closed right after
+// its headers, which are its trailer.
+func (s *clientStream) resolveTrailersOnly() error {
+	_, raw, err := s.headers()
+	if err != nil {
+		return errs.Wrap(err, "failed to get header instead of trailer")
+	}
+	s.res.OnHeaders(raw)
+	st := s.res.OnTrailersOnly()
+	s.setTrailer(s.res.Trailer(), true)
+	s.stats.inTrailer(s.res.Trailer(), 0)
+	return st.Err()
+}
+
+// resolveEnd resolves the status of a response ended without a trailer
+// frame. It returns nil if the status is OK.
+func (s *clientStream) resolveEnd() error {
+	if _, raw, err := s.headers(); err == nil {
+		s.res.OnHeaders(raw)
+	}
+	err := s.dialOptions.resolveEnd(s.endpoint, s.res)
+	if s.res.TrailersOnly() {
+		s.setTrailer(s.res.Trailer(), true)
+	}
+	return err
+}
+
+func (s *clientStream) setTrailer(md metadata.MD, trailersOnly bool) {
+	s.trailerMu.Lock()
+	s.trailerMD = md
+	if trailersOnly {
+		s.trailersOnly.Store(true)
+	}
+	s.trailerMu.Unlock()
+	if s.callOptions.trailer != nil {
+		*s.callOptions.trailer = md
+	}
+}
+
+// nextFrame reads the header of the next frame from r. When r only holds
+// skipped frames, the next messages are received until one of them holds a
+// frame. The returned reader must be used to read the rest of the frame.
+func (s *clientStream) nextFrame(r io.ReadCloser) (io.ReadCloser, *parser.Header, error) {
+	for {
+		h, err := s.dialOptions.readFrameHeader(s.endpoint, r, s.callOptions)
+		if !errors.Is(err, errSkippedFrames) {
+			return r, h, err
+		}
+		r.Close()
+		if r, err = s.transport.Receive(s.ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// decompress returns the content of the message frame h, see
+// decompressMessage.
+func (s *clientStream) decompress(h *parser.Header, msg []byte) ([]byte, error) {
+	if h.Flag()&compressedFlag == 0 {
+		return msg, nil
+	}
+	md, err := s.Header()
+	if err != nil {
+		return nil, err
+	}
+	return decompressMessage(h, md, msg, s.dialOptions.maxBufferSize)
+}
+
 func (s *clientStream) isTrailerOnly(err error) bool {
 	return errors.Is(err, io.ErrUnexpectedEOF) && s.trailer().Len() == 0
 }
@@ -208,100 +374,231 @@ type serverStream struct {
 	resStream   io.ReadCloser
 	callOptions *callOptions
 
-	closed          bool
+	dialOptions *dialOptions
+	stats       *rpcStats
+	inactivity  *inactivityTimer
+	// release removes the stream from the registry of active streams.
+	release func()
+
+	// callMD is the metadata set with SetCallMetadata.
+	callMD metadata.MD
+	sent   atomic.Bool
+	// req is the request body encoded by SendMsg, and sent by start.
+	req      *bytes.Buffer
+	reqMsg   any
+	started  sync.Once
+	startErr error
+	// res resolves the status of the response, it is used by RecvMsg only.
+	res *statusresolver.Resolver
+
+	closed          atomic.Bool
+	mu              sync.RWMutex
 	header, trailer metadata.MD
 }
 
 func (s *serverStream) Header() (metadata.MD, error) {
+	if s.sent.Load() {
+		if err := s.start(); err != nil {
+			return nil, s.inactivity.err(err)
+		}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.header, nil
 }
 
 func (s *serverStream) Trailer() metadata.MD {
-	if !s.closed {
+	if !s.closed.Load() {
 		panic("Trailer must be called after stream.CloseAndReceive has been called")
 	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.trailer
 }
 
+func (s *serverStream) setTrailer(md metadata.MD) {
+	s.mu.Lock()
+	s.trailer = md
+	s.mu.Unlock()
+	if s.callOptions.trailer != nil {
+		*s.callOptions.trailer = md
+	}
+}
+
 func (s *serverStream) Context() context.Context {
 	return s.ctx
 }
 
+func (s *serverStream) SetCallMetadata(md metadata.MD) error {
+	if s.sent.Load() {
+		return ErrStreamStarted
+	}
+	s.callMD = metadata.Join(s.callMD, md)
+	return nil
+}
+
 func (s *serverStream) CloseSend() error {
 	return nil
 }
 
+// SendMsg encodes the request of the stream, which is sent along with the
+// request headers by the first RecvMsg or Header. Like with grpc-go, it
+// doesn't wait for the response.
 func (s *serverStream) SendMsg(req any) error {
-	codec := s.callOptions.codec
-
-	r, err := encodeRequestBody(codec, req)
+	if s.sent.Load() {
+		return errServerStreamResend
+	}
+	if err := s.dialOptions.validate(req, false); err != nil {
+		return err
+	}
+	r, err := encodeRequestBody(s.dialOptions, s.callOptions, s.endpoint, req)
 	if err != nil {
-		return errors.Wrap(err, "failed to build the request body")
+		return errs.Wrap(err, "failed to build the request body")
 	}
+	s.req, s.reqMsg = r, req
+	s.sent.Store(true)
+	return nil
+}
 
-	md, ok := metadata.FromOutgoingContext(s.ctx)
-	if ok {
-		for k, v := range md {
-			for _, vv := range v {
-				s.transport.Header().Add(k, vv)
-			}
+// start sends the request encoded by SendMsg and receives the response
+// headers, once.
+func (s *serverStream) start() error {
+	s.started.Do(func() {
+		s.startErr = s.send()
+	})
+	return s.startErr
+}
+
+func (s *serverStream) send() error {
+	codec := s.callOptions.codec
+	r := s.req
+	md, _ := metadata.FromOutgoingContext(s.ctx)
+	md = metadata.Join(md, s.callMD)
+	for k, v := range md {
+		for _, vv := range v {
+			s.transport.Header().Add(k, vv)
 		}
 	}
+	s.dialOptions.setRequestHeader(s.ctx, s.transport.Header())
+	s.callOptions.setCompressionHeader(s.transport.Header())
+	s.stats.outHeader(s.endpoint, md)
 
 	contentType := "application/grpc-web+" + codec.Name()
+	wireLength := r.Len()
+	if err := s.dialOptions.waitRateLimit(s.ctx); err != nil {
+		return err
+	}
+	disarm := s.inactivity.wait()
 	header, rawBody, err := s.transport.Send(s.ctx, s.endpoint, contentType, r)
+	disarm()
 	if err != nil {
-		return errors.Wrap(err, "failed to send the request")
+		return errs.Wrap(err, "failed to send the request")
 	}
-	s.header = toMetadata(header)
-	s.resStream = rawBody
+	s.stats.outPayload(s.reqMsg, wireLength)
+	s.dialOptions.affinity.learn(header)
+	s.dialOptions.learnRateLimit(header, s.callOptions)
+	if err := s.dialOptions.checkContentType(s.endpoint, header); err != nil {
+		rawBody.Close()
+		return err
+	}
+	raw := toMetadata(header)
+	if s.callOptions.rawHeader != nil {
+		*s.callOptions.rawHeader = raw
+	}
+	s.res.OnHeaders(raw)
+	md = withoutStatus(raw)
+	s.mu.Lock()
+	s.header = md
+	s.mu.Unlock()
+	s.stats.inHeader(md)
+	s.resStream = s.dialOptions.reorderFrames(s.endpoint, rawBody)
 	return nil
 }
 
 func (s *serverStream) RecvMsg(res any) (err error) {
-	if s.resStream == nil {
-		return errors.New("Receive must be call after calling Send")
+	if !s.sent.Load() {
+		return errs.New("Receive must be call after calling Send")
+	}
+	if s.closed.Load() {
+		return io.EOF
 	}
 	defer func() {
+		err = s.inactivity.err(err)
+		err = classify(s.dialOptions.truncateStatus(s.endpoint, err))
 		if err == io.EOF {
 			if rerr := s.transport.Close(); rerr != nil {
 				err = rerr
 			}
 			s.resStream.Close()
 		}
+		if err != nil {
+			s.release()
+			s.stats.end(err)
+		}
 	}()
 
-	var h [5]byte
-	n, err := s.resStream.Read(h[:])
-	if err != nil {
+	if err := s.start(); err != nil {
 		return err
 	}
-	if n != len(h) {
-		return io.ErrUnexpectedEOF
-	}
+	defer s.inactivity.wait()()
 
-	flag := h[0]
-	length := binary.BigEndian.Uint32(h[1:])
-	if length == 0 {
+	resHeader, err := s.dialOptions.readFrameHeader(s.endpoint, s.resStream, s.callOptions)
+	if errors.Is(err, io.EOF) {
+		// The headers of a trailers-only response carry the status.
+		err := s.dialOptions.resolveEnd(s.endpoint, s.res)
+		s.setTrailer(s.res.Trailer())
+		s.closed.Store(true)
+		if err != nil {
+			return err
+		}
 		return io.EOF
 	}
-	if flag == 0 || flag == 1 { // Message header.
-		msg, err := parser.ParseLengthPrefixedMessage(s.resStream, length)
+	if err != nil {
+		return errs.Wrap(err, "failed to parse response header")
+	}
+
+	length := resHeader.ContentLength
+	if resHeader.IsMessageHeader() {
+		if err := checkMessageSize(s.dialOptions.maxBufferSize, length); err != nil {
+			return err
+		}
+		msg, err := s.dialOptions.frameParser.ParseLengthPrefixedMessage(s.resStream, length)
 		if err != nil {
 			return err
 		}
+		md, _ := s.Header()
+		if msg, err = decompressMessage(resHeader, md, msg, s.dialOptions.maxBufferSize); err != nil {
+			return err
+		}
+		if msg, err = s.dialOptions.transformResponse(s.endpoint, msg); err != nil {
+			return err
+		}
 		if err := s.callOptions.codec.Unmarshal([]mem.Buffer{mem.NewBuffer(&msg, nil)}, res); err != nil {
-			return errors.Wrap(err, "failed to unmarshal response body")
+			return errs.Wrap(err, "failed to unmarshal response body")
 		}
-		return nil
+		if err := s.dialOptions.validate(res, true); err != nil {
+			return err
+		}
+		s.stats.inPayload(res, len(msg))
+		if err := s.callOptions.verify(res); err != nil {
+			return err
+		}
+		return s.res.OnMessage()
 	}
 
-	status, trailer, err := parser.ParseStatusAndTrailer(s.resStream, length)
+	status, trailer, err := parseStatusAndTrailer(s.dialOptions, s.resStream, length)
 	if err != nil {
-		return errors.Wrap(err, "failed to parse trailer")
+		return errs.Wrap(err, "failed to parse trailer")
+	}
+	if err := s.res.OnTrailer(status, trailer); err != nil {
+		return err
+	}
+	s.setTrailer(trailer)
+	s.closed.Store(true)
+	s.stats.inTrailer(trailer, int(length))
+	if err := s.checkEnd(); err != nil {
+		return err
 	}
-	s.closed = true
-	s.trailer = trailer
 	if status.Code() != codes.OK {
 		return status.Err()
 	}
@@ -319,74 +616,108 @@ var (
 	gRPCStatusBytes          = []byte("grpc-status: ")
 )
 
-func (s *bidiStream) RecvMsg(res any) error {
+func (s *bidiStream) RecvMsg(res any) (err error) {
 	if s.closed.Load() {
 		return io.EOF
 	}
-
-	rawBody, err := s.transport.Receive(s.ctx)
-	if s.isTrailerOnly(err) {
-		// Trailers-only responses, no message.
-
-		s.closed.Store(true)
-
-		// Parse headers as trailers.
-		trailer, err := s.Header()
+	defer func() {
+		err = s.inactivity.err(err)
+		err = classify(s.dialOptions.truncateStatus(s.endpoint, err))
 		if err != nil {
-			return errors.Wrap(err, "failed to get header instead of trailer")
+			s.release()
+			s.stats.end(err)
 		}
-
-		s.trailerMu.Lock()
-		s.trailerMD = trailer
-		s.trailersOnly.Store(true)
-		s.trailerMu.Unlock()
-
-		// Try to extract *status.Status from headers.
-		return statusFromHeader(trailer).Err()
-	}
-	if err != nil {
-		return errors.Wrap(err, "failed to receive the response")
+	}()
+	defer s.inactivity.wait()()
+
+	var (
+		rawBody   io.ReadCloser
+		resHeader *parser.Header
+	)
+	// The messages made of skipped frames only, e.g. heartbeats, are followed
+	// by the next one.
+	for {
+		rawBody, err = s.transport.Receive(s.ctx)
+		if s.isTrailerOnly(err) {
+			// Trailers-only responses, no message.
+			s.closed.Store(true)
+			return s.resolveTrailersOnly()
+		}
+		if errors.Is(err, io.EOF) {
+			s.closed.Store(true)
+			if err := s.resolveEnd(); err != nil {
+				return err
+			}
+			return io.EOF
+		}
+		if err != nil {
+			return errs.Wrap(err, "failed to receive the response")
+		}
+		resHeader, err = s.dialOptions.readFrameHeader(s.endpoint, rawBody, s.callOptions)
+		if !errors.Is(err, errSkippedFrames) {
+			break
+		}
+		rawBody.Close()
 	}
-
-	resHeader, err := parser.ParseResponseHeader(rawBody)
+	defer rawBody.Close()
 	if err != nil {
-		return errors.Wrap(err, "failed to parse response header")
+		return errs.Wrap(err, "failed to parse response header")
 	}
 
 	switch {
 	case resHeader.IsMessageHeader():
-		msg, err := parser.ParseLengthPrefixedMessage(rawBody, resHeader.ContentLength)
+		if err := checkMessageSize(s.dialOptions.maxBufferSize, resHeader.ContentLength); err != nil {
+			return err
+		}
+		msg, err := s.dialOptions.frameParser.ParseLengthPrefixedMessage(rawBody, resHeader.ContentLength)
 		if err != nil {
 			return err
 		}
+		if msg, err = s.decompress(resHeader, msg); err != nil {
+			return err
+		}
+		if msg, err = s.dialOptions.transformResponse(s.endpoint, msg); err != nil {
+			return err
+		}
 		if err := s.callOptions.codec.Unmarshal([]mem.Buffer{mem.NewBuffer(&msg, nil)}, res); err != nil {
-			return errors.Wrap(err, "failed to unmarshal response body")
+			return errs.Wrap(err, "failed to unmarshal response body")
 		}
-		return nil
+		if err := s.dialOptions.validate(res, true); err != nil {
+			return err
+		}
+		s.stats.inPayload(res, len(msg))
+		if err := s.callOptions.verify(res); err != nil {
+			return err
+		}
+		return s.res.OnMessage()
 	case resHeader.IsTrailerHeader():
 		s.closed.Store(true)
 
-		status, trailer, err := parser.ParseStatusAndTrailer(rawBody, resHeader.ContentLength)
+		status, trailer, err := parseStatusAndTrailer(s.dialOptions, rawBody, resHeader.ContentLength)
 		if err != nil {
-			return errors.Wrap(err, "failed to parse trailer")
+			return errs.Wrap(err, "failed to parse trailer")
+		}
+		if err := s.res.OnTrailer(status, trailer); err != nil {
+			return err
 		}
-		s.trailerMu.Lock()
-		s.trailerMD = trailer
-		s.trailerMu.Unlock()
+		s.setTrailer(trailer, false)
+		s.stats.inTrailer(trailer, int(resHeader.ContentLength))
 
 		if status.Code() != codes.OK {
 			return status.Err()
 		}
 		return io.EOF
 	default:
-		return errors.New("unexpected header")
+		return errs.WithCode(codes.Internal, nil, "unexpected header")
 	}
 }
 
 func (s *bidiStream) CloseSend() error {
+	s.sendHeader()
 	if err := s.transport.CloseSend(); err != nil {
-		return errors.Wrap(err, "failed to close the send stream")
+		return errs.Wrap(err, "failed to close the send stream")
 	}
+	// Unlike a client stream, closed is left to the end of the responses.
 	s.sentCloseSend.Store(true)
 	return nil
 }
@@ -395,14 +726,20 @@ func (s *bidiStream) isTrailerOnly(err error) bool {
 	return s.sentCloseSend.Load() && s.clientStream.isTrailerOnly(err)
 }
 
-func statusFromHeader(h metadata.MD) *status.Status {
-	msgs, codeStr := h.Get("grpc-message"), h.Get("grpc-status")
-	if len(codeStr) == 0 {
-		return status.New(codes.Unknown, "response closed without grpc-status (headers only)")
-	}
-	i, err := strconv.Atoi(codeStr[0])
-	if err != nil {
-		return status.New(codes.Unknown, err.Error())
+// Conn returns the connection underlying a stream created by a ClientConn,
+// see transport.Conn. It returns nil for other streams.
+//
+// Unstable: Conn is an escape hatch for platform specific tuning. The types
+// it returns may change in future versions.
+func Conn(s grpc.ClientStream) any {
+	switch s := s.(type) {
+	case *clientStream:
+		return transport.Conn(s.transport)
+	case *bidiStream:
+		return transport.Conn(s.transport)
+	case *serverStream:
+		return transport.Conn(s.transport)
+	default:
+		return nil
 	}
-	return status.New(codes.Code(i), msgs[0])
 }
@@ -0,0 +1,203 @@
+package grpcweb
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	internalbackoff "github.com/heartandu/grpc-web-go-client/grpcweb/internal/backoff"
+)
+
+// SubscribeConfig configures a subscription, see Subscribe.
+type SubscribeConfig[Res any] struct {
+	// Request returns the request of the server stream, subscribing from the
+	// resume token of the last message delivered. It is called with "" for
+	// the initial request.
+	Request func(token string) any
+	// Token returns the resume token of msg, such as the resource version of
+	// a watch event. The messages whose token was already delivered, which
+	// the server may send again after a resubscription, are skipped. The
+	// messages with an empty token are always delivered.
+	Token func(msg *Res) string
+	// Backoff configures the delays between the resubscriptions. It defaults
+	// to the backoff set with WithConnectParams, then to
+	// backoff.DefaultConfig.
+	Backoff backoff.Config
+	// MaxAttempts is the maximum number of consecutive resubscriptions
+	// without any message delivered in between. It defaults to no limit.
+	MaxAttempts int
+	// IdleTimeout resubscribes when no frame, heartbeats included, is
+	// received for this long, so that the connections silently dropped by a
+	// proxy are noticed. It is disabled by default.
+	IdleTimeout time.Duration
+	// Retryable reports whether to resubscribe after err. By default the
+	// streams ended by the server, the transport failures and the idle
+	// timeouts are.
+	Retryable func(err error) bool
+	// Buffer is the capacity of the channel the messages are delivered on.
+	Buffer int
+	// DedupeWindow is the number of most recent tokens remembered to skip
+	// the duplicates. It defaults to 128.
+	DedupeWindow int
+}
+
+// Subscription is a server stream maintained across disconnects by
+// Subscribe.
+type Subscription[Res any] struct {
+	// C delivers the messages of the subscription. It is closed once the
+	// subscription ends, see Err.
+	C <-chan *Res
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// Stop ends the subscription and waits for C to be closed.
+func (s *Subscription[Res]) Stop() {
+	s.cancel()
+	<-s.done
+}
+
+// Err returns the error which ended the subscription, once C is closed. It
+// is nil if the subscription was stopped or its context is done.
+func (s *Subscription[Res]) Err() error {
+	<-s.done
+	return s.err
+}
+
+// Subscribe opens a server stream of method, and delivers its messages on
+// the C channel of the returned Subscription. It is resubscribed with a
+// backoff when the stream ends or fails, with the request returned by
+// sc.Request for the resume token of the last message delivered, which suits
+// the watch APIs. The subscription ends with ctx, Stop, or an error which
+// isn't retryable.
+func Subscribe[Res any](ctx context.Context, cc *ClientConn, method string, sc SubscribeConfig[Res], opts ...CallOption) *Subscription[Res] {
+	sc.Backoff = cc.dialOptions.backoffConfig(sc.Backoff)
+	if sc.Retryable == nil {
+		sc.Retryable = isSubscriptionFailure
+	}
+	if sc.DedupeWindow == 0 {
+		sc.DedupeWindow = 128
+	}
+	if sc.IdleTimeout > 0 {
+		opts = append(opts[:len(opts):len(opts)], InactivityTimeout(sc.IdleTimeout))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	c := make(chan *Res, sc.Buffer)
+	s := &Subscription[Res]{C: c, cancel: cancel, done: make(chan struct{})}
+	r := &subscriber[Res]{cc: cc, method: method, config: sc, opts: opts, c: c, seen: make(map[string]struct{})}
+	go func() {
+		defer close(s.done)
+		defer close(c)
+		defer cancel()
+		s.err = r.run(ctx)
+	}()
+	return s
+}
+
+// isSubscriptionFailure reports whether a subscription may be resumed after
+// err.
+func isSubscriptionFailure(err error) bool {
+	return err == io.EOF || isTransportFailure(err) || status.Code(err) == codes.DeadlineExceeded
+}
+
+type subscriber[Res any] struct {
+	cc     *ClientConn
+	method string
+	config SubscribeConfig[Res]
+	opts   []CallOption
+	c      chan<- *Res
+
+	token string
+	// seen holds the tokens in recent, the most recently delivered ones.
+	seen   map[string]struct{}
+	recent []string
+}
+
+// run subscribes until ctx is done or an error isn't retryable.
+func (r *subscriber[Res]) run(ctx context.Context) error {
+	for attempts := 0; ; attempts++ {
+		delivered, err := r.subscribe(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if delivered {
+			attempts = 0
+		}
+		if !r.config.Retryable(err) || (r.config.MaxAttempts > 0 && attempts >= r.config.MaxAttempts) {
+			return err
+		}
+
+		timer := r.cc.dialOptions.clock.NewTimer(internalbackoff.Delay(r.config.Backoff, attempts))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C():
+		}
+	}
+}
+
+// subscribe runs a single server stream, and reports whether it delivered
+// any message.
+func (r *subscriber[Res]) subscribe(ctx context.Context) (bool, error) {
+	// Canceling the stream releases it when the subscription is stopped.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := r.cc.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, r.method, r.opts...)
+	if err != nil {
+		return false, err
+	}
+	if err := stream.SendMsg(r.config.Request(r.token)); err != nil {
+		return false, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return false, err
+	}
+
+	var delivered bool
+	for {
+		msg := new(Res)
+		if err := stream.RecvMsg(msg); err != nil {
+			return delivered, err
+		}
+		token := r.config.Token(msg)
+		if r.duplicate(token) {
+			continue
+		}
+		select {
+		case r.c <- msg:
+		case <-ctx.Done():
+			return delivered, ctx.Err()
+		}
+		delivered = true
+		if token != "" {
+			r.token = token
+		}
+	}
+}
+
+// duplicate reports whether token was already delivered, and remembers it
+// otherwise.
+func (r *subscriber[Res]) duplicate(token string) bool {
+	if token == "" {
+		return false
+	}
+	if _, ok := r.seen[token]; ok {
+		return true
+	}
+	if len(r.recent) >= r.config.DedupeWindow {
+		delete(r.seen, r.recent[0])
+		r.recent = r.recent[1:]
+	}
+	r.seen[token] = struct{}{}
+	r.recent = append(r.recent, token)
+	return false
+}
@@ -0,0 +1,76 @@
+package grpcweb
+
+import (
+	"context"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/ratelimit"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/transport"
+)
+
+// WithUploadRateLimit limits the upload bandwidth of the calls and streams of
+// the ClientConn, all together, to bytesPerSecond, so that background jobs
+// don't saturate constrained links. The request bodies of unary calls and
+// server streams, and the messages sent by websocket streams, are throttled.
+func WithUploadRateLimit(bytesPerSecond int) DialOption {
+	return func(opt *dialOptions) {
+		opt.uploadRate = bytesPerSecond
+	}
+}
+
+// UploadRateLimit limits the upload bandwidth of the call or stream to
+// bytesPerSecond, in addition to the limit set with WithUploadRateLimit.
+func UploadRateLimit(bytesPerSecond int) CallOption {
+	return newCallOption(func(opt *callOptions) {
+		opt.uploadRate = bytesPerSecond
+	})
+}
+
+// WithDownloadRateLimit limits the download bandwidth of the calls and
+// streams of the ClientConn, all together, to bytesPerSecond. The response
+// bodies of unary calls and server streams, and the messages received by
+// websocket streams, are throttled as they are read, so that the concurrent
+// streams share the bandwidth.
+func WithDownloadRateLimit(bytesPerSecond int) DialOption {
+	return func(opt *dialOptions) {
+		opt.downloadRate = bytesPerSecond
+	}
+}
+
+// DownloadRateLimit limits the download bandwidth of the call or stream to
+// bytesPerSecond, in addition to the limit set with WithDownloadRateLimit.
+func DownloadRateLimit(bytesPerSecond int) CallOption {
+	return newCallOption(func(opt *callOptions) {
+		opt.downloadRate = bytesPerSecond
+	})
+}
+
+// newLimiter returns the limiter of rate, nil if rate isn't positive.
+func (o *dialOptions) newLimiter(rate int) *ratelimit.Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	return ratelimit.New(rate, o.clock)
+}
+
+// throttle returns the function throttling a transfer with the limiter of the
+// ClientConn, conn, and a new one of the rate of the call, nil if there is
+// none of them.
+func (o *dialOptions) throttle(conn *ratelimit.Limiter, rate int) transport.ThrottleFunc {
+	var limiters []*ratelimit.Limiter
+	for _, l := range []*ratelimit.Limiter{conn, o.newLimiter(rate)} {
+		if l != nil {
+			limiters = append(limiters, l)
+		}
+	}
+	if len(limiters) == 0 {
+		return nil
+	}
+	return func(ctx context.Context, n int) error {
+		for _, l := range limiters {
+			if err := l.WaitN(ctx, n); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
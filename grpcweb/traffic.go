@@ -0,0 +1,67 @@
+package grpcweb
+
+import (
+	"sort"
+	"sync"
+
+	"go.uber.org/atomic"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/transport"
+)
+
+// MethodTraffic is the cumulative traffic of the calls and streams of a
+// method on a ClientConn.
+type MethodTraffic struct {
+	// Method is the full method name.
+	Method string
+	// SentBytes and ReceivedBytes count the bytes transferred on the wire,
+	// headers and frames included.
+	SentBytes, ReceivedBytes int64
+}
+
+// Traffic returns the cumulative traffic of the ClientConn per method, sorted
+// by method, e.g. for cost attribution in metered environments. The retries
+// and the failed attempts are included.
+func (c *ClientConn) Traffic() []MethodTraffic {
+	return c.traffic.list()
+}
+
+// trafficRegistry accounts the traffic of a ClientConn per method.
+type trafficRegistry struct {
+	// methods maps the method names to their *trafficCounters.
+	methods sync.Map
+}
+
+type trafficCounters struct {
+	sent, received atomic.Int64
+}
+
+// counter returns the counter of the transports of method. The eager
+// connection of WithBlock, with no method, isn't accounted.
+func (r *trafficRegistry) counter(method string) transport.TrafficCounter {
+	if method == "" {
+		return nil
+	}
+	v, ok := r.methods.Load(method)
+	if !ok {
+		v, _ = r.methods.LoadOrStore(method, new(trafficCounters))
+	}
+	c := v.(*trafficCounters)
+	return func(sent, received int) {
+		c.sent.Add(int64(sent))
+		c.received.Add(int64(received))
+	}
+}
+
+func (r *trafficRegistry) list() []MethodTraffic {
+	var traffic []MethodTraffic
+	r.methods.Range(func(k, v any) bool {
+		c := v.(*trafficCounters)
+		traffic = append(traffic, MethodTraffic{Method: k.(string), SentBytes: c.sent.Load(), ReceivedBytes: c.received.Load()})
+		return true
+	})
+	sort.Slice(traffic, func(i, j int) bool {
+		return traffic[i].Method < traffic[j].Method
+	})
+	return traffic
+}
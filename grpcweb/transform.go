@@ -0,0 +1,55 @@
+package grpcweb
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/mem"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/errs"
+)
+
+// MessageTransformer transforms the encoded messages between the codec and
+// the framing, e.g. to encrypt their payload or to wrap them in an envelope.
+type MessageTransformer interface {
+	// TransformRequest transforms an encoded request message of method
+	// before it is framed and compressed.
+	TransformRequest(method string, msg []byte) ([]byte, error)
+	// TransformResponse reverts the transformation of a response message of
+	// method before it is decoded.
+	TransformResponse(method string, msg []byte) ([]byte, error)
+}
+
+// WithMessageTransformers appends transformers to the pipeline applied to the
+// messages of every call and stream. Request messages go through the
+// transformers in order, response messages in reverse order. A failing
+// transformer fails the call with codes.Internal.
+func WithMessageTransformers(transformers ...MessageTransformer) DialOption {
+	return func(opt *dialOptions) {
+		opt.transformers = append(opt.transformers, transformers...)
+	}
+}
+
+// transformRequest runs the request message body through the pipeline.
+func (o *dialOptions) transformRequest(method string, body mem.BufferSlice) (mem.BufferSlice, error) {
+	if len(o.transformers) == 0 {
+		return body, nil
+	}
+	msg := body.Materialize()
+	for _, t := range o.transformers {
+		var err error
+		if msg, err = t.TransformRequest(method, msg); err != nil {
+			return nil, errs.WithCode(codes.Internal, err, "failed to transform the request message")
+		}
+	}
+	return mem.BufferSlice{mem.SliceBuffer(msg)}, nil
+}
+
+// transformResponse runs the response message msg through the pipeline.
+func (o *dialOptions) transformResponse(method string, msg []byte) ([]byte, error) {
+	for i := len(o.transformers) - 1; i >= 0; i-- {
+		var err error
+		if msg, err = o.transformers[i].TransformResponse(method, msg); err != nil {
+			return nil, errs.WithCode(codes.Internal, err, "failed to transform the response message")
+		}
+	}
+	return msg, nil
+}
@@ -0,0 +1,66 @@
+//go:build !js
+
+package transport
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBufferSizes(t *testing.T) {
+	var o connectOptions
+	for _, opt := range []ConnectOption{WithReadBufferSize(1024), WithWriteBufferSize(2048)} {
+		opt(&o)
+	}
+
+	t.Run("websocket dialer", func(t *testing.T) {
+		d := newWebSocketDialer(&o)
+		if d.ReadBufferSize != 1024 {
+			t.Errorf("expected the read buffer size 1024, but got %d", d.ReadBufferSize)
+		}
+		if d.WriteBufferSize != 2048 {
+			t.Errorf("expected the write buffer size 2048, but got %d", d.WriteBufferSize)
+		}
+	})
+
+	t.Run("response body", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte{0x80, 0, 0, 0, 0})
+		}))
+		defer srv.Close()
+
+		tr, err := NewUnary(strings.TrimPrefix(srv.URL, "http://"), WithInsecure(), WithReadBufferSize(1024))
+		if err != nil {
+			t.Fatalf("NewUnary should not return an error, but got '%s'", err)
+		}
+		_, body, err := tr.Send(context.Background(), "/service/Method", "application/grpc-web+proto", strings.NewReader(""))
+		if err != nil {
+			t.Fatalf("Send should not return an error, but got '%s'", err)
+		}
+		defer body.Close()
+		if n := readBufferSize(body); n != 1024 {
+			t.Errorf("expected the response body to be read through a buffer of 1024 bytes, but got %d", n)
+		}
+	})
+}
+
+// readBufferSize returns the size of the buffer r is read through, zero if
+// it is unbuffered.
+func readBufferSize(r io.ReadCloser) int {
+	for {
+		switch b := r.(type) {
+		case *contextReadCloser:
+			r = b.ReadCloser
+		case *trailerBody:
+			r = b.ReadCloser
+		case *bufferedBody:
+			return b.Size()
+		default:
+			return 0
+		}
+	}
+}
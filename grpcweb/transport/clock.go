@@ -0,0 +1,54 @@
+package transport
+
+import "time"
+
+// Clock tells the time and creates the timers of the client, so that its
+// timing behavior, such as retries, backoffs and deadlines, can be tested with
+// a fake clock like transporttest.Clock.
+type Clock interface {
+	Now() time.Time
+	// NewTimer returns a timer sending the current time on its channel
+	// after d.
+	NewTimer(d time.Duration) Timer
+	// AfterFunc returns a timer calling f in its own goroutine after d. Its
+	// channel is nil.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a timer created by a Clock.
+type Timer interface {
+	C() <-chan time.Time
+	// Stop prevents the timer from firing. It returns false if the timer
+	// already fired or was stopped.
+	Stop() bool
+}
+
+// SystemClock is the Clock of the time package.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{t: time.NewTimer(d)}
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return systemTimer{t: time.AfterFunc(d, f)}
+}
+
+type systemTimer struct {
+	t *time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time { return t.t.C }
+func (t systemTimer) Stop() bool          { return t.t.Stop() }
+
+// WithClock sets the clock of the dial backoff, the connect timeouts and the
+// keepalive pings. It defaults to SystemClock.
+func WithClock(c Clock) ConnectOption {
+	return func(opt *connectOptions) {
+		opt.clock = c
+	}
+}
@@ -0,0 +1,32 @@
+package transport
+
+// Conn returns the connection underlying tr: the *websocket.Conn of a stream
+// transport, or its browser WebSocket js.Value on js/wasm, or the
+// *http.Response of a unary transport once its request has been sent. Wrapping transports are looked through if they have an Unwrap
+// method returning the wrapped transport. It returns nil if tr doesn't expose
+// a connection.
+//
+// Unstable: Conn is an escape hatch for platform specific tuning, e.g. of
+// socket options. The types it returns may change in future versions.
+func Conn(tr any) any {
+	for {
+		switch t := tr.(type) {
+		case interface{ underlyingConn() any }:
+			return t.underlyingConn()
+		case interface{ Unwrap() UnaryTransport }:
+			tr = t.Unwrap()
+		case interface{ Unwrap() ClientStreamTransport }:
+			tr = t.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+func (t *httpTransport) underlyingConn() any {
+	res := t.res.Load()
+	if res == nil {
+		return nil
+	}
+	return res
+}
@@ -0,0 +1,147 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/backoff"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/errs"
+)
+
+// wsConn is the websocket connection of a stream transport. It is the subset
+// of *websocket.Conn used by the transport, implemented with the browser
+// WebSocket on js/wasm.
+type wsConn interface {
+	NextReader() (int, io.Reader, error)
+	ReadMessage() (int, []byte, error)
+	WriteMessage(messageType int, data []byte) error
+	SetReadLimit(limit int64)
+	Close() error
+}
+
+// dialFunc dials a websocket once. The response is the one of a rejected
+// handshake, if available.
+type dialFunc func(ctx context.Context) (wsConn, *http.Response, error)
+
+// dialWebSocket dials the websocket endpoint, retrying transient failures as
+// configured by WithDialRetry. Each attempt is bounded as configured by
+// WithMinConnectTimeout.
+func dialWebSocket(ctx context.Context, d dialFunc, u *url.URL, method string, o *connectOptions) (wsConn, error) {
+	for retries := 0; ; retries++ {
+		conn, res, err := dialAttempt(ctx, d, o, retries)
+		if err == nil {
+			o.events.emit(Event{Type: EventConnectionEstablished, Method: method, Target: u.Host})
+			return conn, nil
+		}
+
+		temporary, err := classifyDialError(err, res, u.Host)
+		if errors.Is(err, errConnectTimeout) {
+			temporary = true
+		}
+		if !temporary || retries+1 >= o.dialAttempts || ctx.Err() != nil {
+			return nil, err
+		}
+		o.events.emit(Event{Type: EventReconnect, Method: method, Target: u.Host, Attempt: retries + 1, Err: err})
+
+		timer := o.timer(backoff.Delay(o.dialBackoff, retries))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("%w (last error: %w)", ctx.Err(), err)
+		case <-timer.C():
+		}
+	}
+}
+
+// errConnectTimeout is returned by an attempt which exceeded its connect
+// timeout.
+var errConnectTimeout = errs.WithCode(codes.Unavailable, nil, "connect timeout")
+
+// dialAttempt dials once. Like in grpc-go, the attempt is given the larger of
+// the minimum connect timeout and the backoff delay, if a minimum is set.
+func dialAttempt(ctx context.Context, d dialFunc, o *connectOptions, retries int) (wsConn, *http.Response, error) {
+	if o.minConnectTimeout <= 0 {
+		return d(ctx)
+	}
+
+	timeout := max(o.minConnectTimeout, backoff.Delay(o.dialBackoff, retries))
+	attemptCtx, stop := o.withConnectTimeout(ctx, timeout)
+	defer stop()
+	conn, res, err := d(attemptCtx)
+	if err != nil {
+		err = connectTimeoutError(ctx, attemptCtx, timeout, err)
+	}
+	return conn, res, err
+}
+
+// withConnectTimeout returns a copy of ctx canceled after timeout, according
+// to the clock of the options unlike a context deadline, and the function
+// releasing it.
+func (o *connectOptions) withConnectTimeout(ctx context.Context, timeout time.Duration) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	timer := o.getClock().AfterFunc(timeout, func() { cancel(errConnectTimeout) })
+	return ctx, func() {
+		timer.Stop()
+		cancel(nil)
+	}
+}
+
+// connectTimeoutError wraps err, the failure of a dial bounded by
+// withConnectTimeout, into errConnectTimeout if the timeout caused it.
+func connectTimeoutError(parent, ctx context.Context, timeout time.Duration, err error) error {
+	if parent.Err() == nil && errors.Is(context.Cause(ctx), errConnectTimeout) {
+		return fmt.Errorf("%w after %s: %w", errConnectTimeout, timeout, err)
+	}
+	return err
+}
+
+// classifyDialError reports whether dialing addr again may succeed after
+// err, and wraps err into a *DialError, with the sentinel describing its
+// cause. Context errors are returned as is.
+func classifyDialError(err error, res *http.Response, addr string) (bool, error) {
+	var (
+		dnsErr     *net.DNSError
+		opErr      *net.OpError
+		recordErr  tls.RecordHeaderError
+		alertErr   tls.AlertError
+		verifyErr  *tls.CertificateVerificationError
+		authErr    x509.UnknownAuthorityError
+		hostErr    x509.HostnameError
+		invalidErr x509.CertificateInvalidError
+	)
+	switch {
+	case res != nil && errors.Is(err, websocket.ErrBadHandshake):
+		// The response is an *HTTPError unless it carries a gRPC status.
+		cause := responseError(res)
+		code := status.Code(cause)
+		err = errs.WithCode(code, fmt.Errorf("%w: %w", ErrHandshakeRejected, cause), "")
+		return code == codes.Unavailable, &DialError{Stage: DialStageUpgrade, Addr: addr, Err: err}
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false, err
+	case errors.As(err, &recordErr), errors.As(err, &alertErr), errors.As(err, &verifyErr),
+		errors.As(err, &authErr), errors.As(err, &hostErr), errors.As(err, &invalidErr):
+		return false, &DialError{Stage: DialStageTLS, Addr: addr, Err: fmt.Errorf("%w: %w", ErrTLSHandshake, err)}
+	case errors.As(err, &opErr) && opErr.Op == "proxyconnect":
+		return true, &DialError{Stage: DialStageProxy, Addr: addr, Err: errs.WithCode(codes.Unavailable, err, "")}
+	case errors.As(err, &dnsErr):
+		return dnsErr.IsTemporary || dnsErr.IsTimeout, &DialError{Stage: DialStageDNS, Addr: addr, Err: fmt.Errorf("%w: %w", ErrDNSResolution, err)}
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return true, &DialError{Stage: DialStageTCP, Addr: addr, Err: errs.WithCode(codes.Unavailable, err, "")}
+	case errors.Is(err, websocket.ErrBadHandshake):
+		return true, &DialError{Stage: DialStageUpgrade, Addr: addr, Err: errs.WithCode(codes.Unavailable, err, "")}
+	default:
+		return true, &DialError{Stage: DialStageUnknown, Addr: addr, Err: errs.WithCode(codes.Unavailable, err, "")}
+	}
+}
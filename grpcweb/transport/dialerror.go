@@ -0,0 +1,108 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/errs"
+)
+
+// DialStage is the step of the connection setup at which a dial failed.
+type DialStage int
+
+const (
+	DialStageUnknown DialStage = iota
+	DialStageDNS
+	DialStageTCP
+	DialStageTLS
+	DialStageProxy
+	DialStageUpgrade
+)
+
+func (s DialStage) String() string {
+	switch s {
+	case DialStageDNS:
+		return "dns"
+	case DialStageTCP:
+		return "tcp"
+	case DialStageTLS:
+		return "tls"
+	case DialStageProxy:
+		return "proxy"
+	case DialStageUpgrade:
+		return "http upgrade"
+	default:
+		return "unknown"
+	}
+}
+
+// DialError is returned when a connection to the server can't be set up. Err
+// keeps the cause visible to errors.Is and errors.As, such as
+// ErrDNSResolution and the *net.DNSError it wraps, and the gRPC code it
+// resolves to.
+type DialError struct {
+	// Stage is the step which failed.
+	Stage DialStage
+	// Addr is the address dialed, as host:port.
+	Addr string
+	Err  error
+}
+
+func (e *DialError) Error() string {
+	return fmt.Sprintf("dial %s: %s stage failed: %s", e.Addr, e.Stage, e.Err)
+}
+
+func (e *DialError) Unwrap() error {
+	return e.Err
+}
+
+// Dial eagerly sets up a connection to host like the unary transports do,
+// and keeps it for them, so that connection failures surface before the
+// first call. It sends an OPTIONS request, whose response is discarded. It
+// returns a *DialError if the connection can't be set up.
+func Dial(ctx context.Context, host string, opts ...ConnectOption) error {
+	o := new(connectOptions)
+	for _, f := range opts {
+		f(o)
+	}
+
+	scheme := "https"
+	if o.insecure {
+		scheme = "http"
+	}
+	u, err := url.Parse(fmt.Sprintf("%s://%s", scheme, host))
+	if err != nil {
+		return errs.Wrap(err, "failed to parse host into url")
+	}
+	applyTemplateURL(o.template, u, false)
+	client := http.DefaultClient
+	if o.tlsConf != nil {
+		client = tlsClient(o.tlsConf)
+	}
+
+	reqCtx := ctx
+	if o.minConnectTimeout > 0 {
+		var stop func()
+		reqCtx, stop = o.withConnectTimeout(ctx, o.minConnectTimeout)
+		defer stop()
+	}
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodOptions, u.String(), nil)
+	if err != nil {
+		return errs.Wrap(err, "failed to build the request")
+	}
+	if host := requestHost(o.template, o.authority); host != "" {
+		req.Host = host
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		_, err = classifyDialError(connectTimeoutError(ctx, reqCtx, o.minConnectTimeout, err), nil, u.Host)
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(res.Body, 4096))
+	res.Body.Close()
+	o.events.emit(Event{Type: EventConnectionEstablished, Target: u.Host})
+	return nil
+}
@@ -0,0 +1,88 @@
+package transport
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"time"
+)
+
+// EventType identifies the kind of an Event.
+type EventType int
+
+const (
+	// EventConnectionEstablished is emitted when a new connection to the
+	// server is set up. Reused HTTP connections don't emit it.
+	EventConnectionEstablished EventType = iota + 1
+	// EventReconnect is emitted before dialing again after a failed attempt.
+	EventReconnect
+	// EventProtocolDowngrade is emitted when a TLS connection doesn't
+	// negotiate HTTP/2 and falls back to HTTP/1.1.
+	EventProtocolDowngrade
+	// EventPingTimeout is emitted when a keepalive ping isn't answered in
+	// time, see WithKeepalive.
+	EventPingTimeout
+	// EventStreamReset is emitted when the connection of a stream is reset by
+	// the peer.
+	EventStreamReset
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventConnectionEstablished:
+		return "connection established"
+	case EventReconnect:
+		return "reconnect"
+	case EventProtocolDowngrade:
+		return "protocol downgrade"
+	case EventPingTimeout:
+		return "ping timeout"
+	case EventStreamReset:
+		return "stream reset"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes something that happened to the connection of a transport.
+type Event struct {
+	Type EventType
+	Time time.Time
+	// Method is the full method name of the call or stream.
+	Method string
+	// Target is the address of the server.
+	Target string
+	// Attempt is the number of the dial attempt for EventReconnect, starting
+	// at 1 for the first retry.
+	Attempt int
+	// Err is the error which caused the event, if any.
+	Err error
+}
+
+// EventListener receives transport events. It is called synchronously from
+// the transports, so it must not block.
+type EventListener func(Event)
+
+// emit fills in the time of e and passes it to l. l may be nil.
+func (l EventListener) emit(e Event) {
+	if l == nil {
+		return
+	}
+	e.Time = time.Now()
+	l(e)
+}
+
+// trace returns a ClientTrace reporting new HTTP connections and protocol
+// downgrades of the requests to the given target.
+func (l EventListener) trace(method, target string) *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				return
+			}
+			l.emit(Event{Type: EventConnectionEstablished, Method: method, Target: target})
+			if c, ok := info.Conn.(*tls.Conn); ok && c.ConnectionState().NegotiatedProtocol != "h2" {
+				l.emit(Event{Type: EventProtocolDowngrade, Method: method, Target: target})
+			}
+		},
+	}
+}
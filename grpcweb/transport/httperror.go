@@ -0,0 +1,119 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/parser"
+)
+
+// maxErrorBodySize bounds the part of a non-200 response body which is read
+// to build the returned error.
+const maxErrorBodySize = 4 << 10
+
+// HTTPError is returned when the server answers a request or a websocket
+// upgrade with an unexpected HTTP status code and neither the headers nor the
+// body carry a gRPC status. It matches ErrInvalidResponseCode with errors.Is.
+type HTTPError struct {
+	StatusCode int
+	Header     http.Header
+	// Body holds the beginning of the response body, up to 4 KiB. Websocket
+	// handshake responses are truncated to 1 KiB.
+	Body []byte
+}
+
+func (e *HTTPError) Error() string {
+	msg := fmt.Sprintf("%s: %d", ErrInvalidResponseCode, e.StatusCode)
+	if b := bytes.TrimSpace(e.Body); len(b) > 0 {
+		msg += ": " + string(b)
+	}
+	return msg
+}
+
+func (e *HTTPError) Unwrap() error {
+	return ErrInvalidResponseCode
+}
+
+// GRPCStatus maps the HTTP status code to a gRPC one the same way as
+// grpc-go does.
+func (e *HTTPError) GRPCStatus() *status.Status {
+	return status.New(httpStatusCode(e.StatusCode), e.Error())
+}
+
+func httpStatusCode(code int) codes.Code {
+	switch code {
+	case http.StatusBadRequest:
+		return codes.Internal
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.Unimplemented
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return codes.Unavailable
+	default:
+		return codes.Unknown
+	}
+}
+
+// responseError builds the error for a response with a non-200 status code.
+// A gRPC status in the headers takes precedence over a JSON encoded
+// google.rpc.Status in the body. Otherwise an *HTTPError is returned, also when
+// the status is OK or can't be parsed: the response is an error in any case.
+func responseError(res *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorBodySize))
+	res.Body.Close()
+
+	if v := res.Header.Get("grpc-status"); v != "" {
+		code, err := strconv.ParseUint(v, 10, 32)
+		if err == nil && codes.Code(code) != codes.OK {
+			return status.Error(codes.Code(code), parser.DecodeMessage(res.Header.Get("grpc-message")))
+		}
+	}
+
+	if st, ok := jsonStatus(body); ok {
+		return st.Err()
+	}
+
+	return &HTTPError{
+		StatusCode: res.StatusCode,
+		Header:     res.Header,
+		Body:       body,
+	}
+}
+
+func jsonStatus(body []byte) (*status.Status, bool) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '{' {
+		return nil, false
+	}
+
+	var s spb.Status
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(body, &s); err == nil {
+		if s.GetCode() == 0 {
+			return nil, false
+		}
+		return status.FromProto(&s), true
+	}
+
+	// Details of unknown types can't be resolved by protojson, keep the code
+	// and message at least.
+	var v struct {
+		Code    int32  `json:"code"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &v); err != nil || v.Code == 0 {
+		return nil, false
+	}
+	return status.New(codes.Code(v.Code), v.Message), true
+}
@@ -1,10 +1,61 @@
 package transport
 
-import "crypto/tls"
+import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"time"
+
+	"google.golang.org/grpc/backoff"
+)
 
 type connectOptions struct {
-	insecure bool
-	tlsConf  *tls.Config
+	insecure      bool
+	tlsConf       *tls.Config
+	maxBufferSize int
+	urlHook       func(*url.URL) error
+	lowercase     bool
+
+	maxHeaderListSize uint32
+	maxHeaderCount    int
+
+	dialAttempts      int
+	dialBackoff       backoff.Config
+	minConnectTimeout time.Duration
+
+	events EventListener
+
+	receivePump   bool
+	receiveBuffer int
+
+	keepaliveTime, keepaliveTimeout time.Duration
+
+	readBufferSize  int
+	writeBufferSize int
+	readLimit       int64
+
+	clock         Clock
+	contentLength bool
+
+	uploadThrottle   ThrottleFunc
+	downloadThrottle ThrottleFunc
+	traffic          TrafficCounter
+	template         *http.Request
+	handshakeHeader  http.Header
+	authority        string
+}
+
+// getClock returns the clock of the options.
+func (o *connectOptions) getClock() Clock {
+	if o.clock == nil {
+		return SystemClock
+	}
+	return o.clock
+}
+
+// timer returns a timer of the clock of the options.
+func (o *connectOptions) timer(d time.Duration) Timer {
+	return o.getClock().NewTimer(d)
 }
 
 type ConnectOption func(*connectOptions)
@@ -20,3 +71,126 @@ func WithTLSConfig(conf *tls.Config) ConnectOption {
 		opt.tlsConf = conf
 	}
 }
+
+// WithMaxBufferSize limits the number of bytes a stream transport buffers for
+// a single response message. Zero means no limit.
+func WithMaxBufferSize(n int) ConnectOption {
+	return func(opt *connectOptions) {
+		opt.maxBufferSize = n
+	}
+}
+
+// WithURLHook sets a function which is called with the fully resolved request
+// URL right before it is used. The function may modify the URL in place.
+func WithURLHook(f func(*url.URL) error) ConnectOption {
+	return func(opt *connectOptions) {
+		opt.urlHook = f
+	}
+}
+
+// WithLowercaseHeaders makes the transports send header keys in lowercase
+// instead of the canonical form produced by http.Header.
+func WithLowercaseHeaders() ConnectOption {
+	return func(opt *connectOptions) {
+		opt.lowercase = true
+	}
+}
+
+// WithHeaderLimits limits the total size in bytes of the response header keys
+// and values, and the number of key/value pairs. Zero means no limit.
+func WithHeaderLimits(maxSize uint32, maxCount int) ConnectOption {
+	return func(opt *connectOptions) {
+		opt.maxHeaderListSize = maxSize
+		opt.maxHeaderCount = maxCount
+	}
+}
+
+// WithDialRetry makes stream transports dial up to attempts times, waiting
+// according to c between the attempts. Only failures which may be transient
+// are retried.
+func WithDialRetry(attempts int, c backoff.Config) ConnectOption {
+	return func(opt *connectOptions) {
+		opt.dialAttempts = attempts
+		opt.dialBackoff = c
+	}
+}
+
+// WithMinConnectTimeout bounds each websocket dial attempt of stream
+// transports to the larger of d and the backoff delay of the attempt. An
+// attempt timing out is retried like other transient failures.
+func WithMinConnectTimeout(d time.Duration) ConnectOption {
+	return func(opt *connectOptions) {
+		opt.minConnectTimeout = d
+	}
+}
+
+// WithEventListener sets a listener which is notified of the connection
+// events of the transport.
+func WithEventListener(l EventListener) ConnectOption {
+	return func(opt *connectOptions) {
+		opt.events = l
+	}
+}
+
+// WithReceivePump makes stream transports read the websocket in a dedicated
+// goroutine, which hands the response messages over to Receive. Up to buffer
+// messages are read ahead of the Receive calls, each of them being at most as
+// large as allowed by WithMaxBufferSize. Receive then also honors its context.
+func WithReceivePump(buffer int) ConnectOption {
+	return func(opt *connectOptions) {
+		opt.receivePump = true
+		opt.receiveBuffer = buffer
+	}
+}
+
+// WithKeepalive makes stream transports send a websocket ping after every
+// interval, and close the connection when the pong doesn't arrive within
+// timeout, emitting EventPingTimeout. The stream then fails with
+// ErrPingTimeout. It detects the connections silently dropped by proxies.
+// The pongs are read along with the response messages, so the stream must
+// keep receiving them, e.g. with WithReceivePump. It has no effect in the
+// browser, whose WebSocket API doesn't expose the pings.
+func WithKeepalive(interval, timeout time.Duration) ConnectOption {
+	return func(opt *connectOptions) {
+		opt.keepaliveTime = interval
+		opt.keepaliveTimeout = timeout
+	}
+}
+
+// WithReadBufferSize sets the size of the buffer frames are read through.
+// Unary and server streaming transports buffer the response body with it,
+// which is unbuffered if n is zero. Stream transports use it as the read
+// buffer of the websocket, which defaults to 4 KiB if n is zero.
+func WithReadBufferSize(n int) ConnectOption {
+	return func(opt *connectOptions) {
+		opt.readBufferSize = n
+	}
+}
+
+// WithWriteBufferSize sets the size of the websocket write buffer of stream
+// transports. It defaults to 4 KiB if n is zero.
+func WithWriteBufferSize(n int) ConnectOption {
+	return func(opt *connectOptions) {
+		opt.writeBufferSize = n
+	}
+}
+
+// WithReadLimit limits the size of the websocket messages received by stream
+// transports. A larger message fails the stream with ErrReadLimitExceeded,
+// which carries the size of the message when the frame prefix announced it.
+func WithReadLimit(n int64) ConnectOption {
+	return func(opt *connectOptions) {
+		opt.readLimit = n
+	}
+}
+
+// WithContentLength makes unary and server streaming transports buffer the
+// request bodies which aren't in memory already and send them with a
+// Content-Length, never chunked, for the WAFs rejecting chunked requests. The
+// Expect header is removed from the requests too. It defeats the streaming of
+// large request bodies.
+func WithContentLength() ConnectOption {
+	return func(opt *connectOptions) {
+		opt.contentLength = true
+	}
+}
@@ -0,0 +1,89 @@
+package transport
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// WithRequestTemplate makes the requests, and the websocket handshakes of
+// stream transports, start from tmpl: the scheme, host and query values of
+// its URL, its Host and its header apply to every request, unless the call
+// sets the same header. The websocket scheme is derived from the HTTP one.
+// The URL hook sees the URL with the template applied. The browser
+// websockets of js/wasm don't carry the header. tmpl must not be modified
+// afterwards.
+func WithRequestTemplate(tmpl *http.Request) ConnectOption {
+	return func(opt *connectOptions) {
+		opt.template = tmpl
+	}
+}
+
+// WithHandshakeHeader adds h to the websocket handshakes of stream
+// transports, which the gateways route on unlike the headers of the
+// gRPC-Web header frame, e.g. to keep a stream on the backend of a session.
+// It takes precedence over the header of the request template. The browser
+// websockets of js/wasm don't carry it. h must not be modified afterwards.
+func WithHandshakeHeader(h http.Header) ConnectOption {
+	return func(opt *connectOptions) {
+		opt.handshakeHeader = h
+	}
+}
+
+// WithAuthority sets the Host of the requests and of the websocket
+// handshakes, e.g. the host of the target when host is an address resolved
+// for it, so that the gateways routing on the Host keep working. The Host of
+// the request template takes precedence.
+func WithAuthority(host string) ConnectOption {
+	return func(opt *connectOptions) {
+		opt.authority = host
+	}
+}
+
+// requestHost returns the Host of the requests, empty for the host of their
+// URL.
+func requestHost(tmpl *http.Request, authority string) string {
+	if tmpl != nil && tmpl.Host != "" {
+		return tmpl.Host
+	}
+	return authority
+}
+
+// applyTemplateURL applies the URL of tmpl to u, the URL of a request.
+func applyTemplateURL(tmpl *http.Request, u *url.URL, websocket bool) {
+	if tmpl == nil || tmpl.URL == nil {
+		return
+	}
+	if s := tmpl.URL.Scheme; s != "" {
+		if websocket {
+			switch s {
+			case "http":
+				s = "ws"
+			case "https":
+				s = "wss"
+			}
+		}
+		u.Scheme = s
+	}
+	if tmpl.URL.Host != "" {
+		u.Host = tmpl.URL.Host
+	}
+	if tmpl.URL.RawQuery != "" {
+		q := u.Query()
+		for k, v := range tmpl.URL.Query() {
+			q[k] = append(q[k], v...)
+		}
+		u.RawQuery = q.Encode()
+	}
+}
+
+// applyTemplateHeader adds the header of tmpl to the keys h doesn't set.
+func applyTemplateHeader(tmpl *http.Request, h http.Header) {
+	if tmpl == nil {
+		return
+	}
+	for k, v := range tmpl.Header {
+		if _, ok := h[k]; !ok {
+			h[k] = append([]string(nil), v...)
+		}
+	}
+}
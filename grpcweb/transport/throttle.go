@@ -0,0 +1,62 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// ThrottleFunc is called with the number of bytes about to be transferred by
+// a transport, and blocks until they may be. An error fails the transfer.
+type ThrottleFunc func(ctx context.Context, n int) error
+
+// WithUploadThrottle throttles the request bodies of unary and server
+// streaming transports, and the messages sent by stream transports, with f.
+func WithUploadThrottle(f ThrottleFunc) ConnectOption {
+	return func(opt *connectOptions) {
+		opt.uploadThrottle = f
+	}
+}
+
+// WithDownloadThrottle throttles the response bodies of unary and server
+// streaming transports, and the messages received by stream transports, with
+// f as they are read.
+func WithDownloadThrottle(f ThrottleFunc) ConnectOption {
+	return func(opt *connectOptions) {
+		opt.downloadThrottle = f
+	}
+}
+
+// throttledBody calls throttle with the number of bytes of each read, before
+// returning them.
+type throttledBody struct {
+	io.ReadCloser
+	ctx      context.Context
+	throttle ThrottleFunc
+}
+
+func (b *throttledBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if terr := b.throttle(b.ctx, n); terr != nil {
+			return 0, terr
+		}
+	}
+	return n, err
+}
+
+// throttleBody returns r throttled with f, r itself if f is nil.
+func throttleBody(ctx context.Context, r io.ReadCloser, f ThrottleFunc) io.ReadCloser {
+	if f == nil {
+		return r
+	}
+	return &throttledBody{ReadCloser: r, ctx: ctx, throttle: f}
+}
+
+// throttleRequest throttles the upload of the body of req with f, if any.
+func throttleRequest(ctx context.Context, req *http.Request, f ThrottleFunc) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return
+	}
+	req.Body = throttleBody(ctx, req.Body, f)
+}
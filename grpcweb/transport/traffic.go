@@ -0,0 +1,71 @@
+package transport
+
+import (
+	"io"
+	"net/http"
+)
+
+// TrafficCounter is called by the transports with the numbers of bytes sent
+// and received on the wire, headers included, as they are transferred. It is
+// called concurrently by the transports of concurrent calls.
+type TrafficCounter func(sent, received int)
+
+// WithTrafficCounter makes the transports report their traffic to c. The
+// HTTP headers of the websocket handshakes aren't counted.
+func WithTrafficCounter(c TrafficCounter) ConnectOption {
+	return func(opt *connectOptions) {
+		opt.traffic = c
+	}
+}
+
+func (c TrafficCounter) sent(n int) {
+	if c != nil && n > 0 {
+		c(n, 0)
+	}
+}
+
+func (c TrafficCounter) received(n int) {
+	if c != nil && n > 0 {
+		c(0, n)
+	}
+}
+
+// countSent returns r reporting the bytes read from it as sent, r itself if c
+// is nil.
+func (c TrafficCounter) countSent(r io.ReadCloser) io.ReadCloser {
+	if c == nil {
+		return r
+	}
+	return &countedBody{ReadCloser: r, count: c.sent}
+}
+
+// countReceived returns r reporting the bytes read from it as received, r
+// itself if c is nil.
+func (c TrafficCounter) countReceived(r io.ReadCloser) io.ReadCloser {
+	if c == nil {
+		return r
+	}
+	return &countedBody{ReadCloser: r, count: c.received}
+}
+
+type countedBody struct {
+	io.ReadCloser
+	count func(n int)
+}
+
+func (b *countedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.count(n)
+	return n, err
+}
+
+// headerSize returns the size of h written as HTTP/1.1 header lines.
+func headerSize(h http.Header) int {
+	n := 0
+	for k, vs := range h {
+		for _, v := range vs {
+			n += len(k) + len(v) + len(": \r\n")
+		}
+	}
+	return n
+}
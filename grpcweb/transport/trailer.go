@@ -0,0 +1,68 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// trailerBody appends the HTTP trailers of a response to its body as a
+// gRPC-Web trailer frame. Some HTTP/2 gateways send the status as HTTP
+// trailers instead of a trailer frame, the frame lets the callers handle both
+// the same way.
+type trailerBody struct {
+	io.ReadCloser
+	res *http.Response
+
+	// frame holds the trailer frame once the body is exhausted.
+	frame *bytes.Reader
+}
+
+func (b *trailerBody) Read(p []byte) (int, error) {
+	if b.frame != nil {
+		return b.frame.Read(p)
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	if err != io.EOF {
+		return n, err
+	}
+	// The trailers are only available once the body has been read.
+	f := trailerFrame(b.res.Trailer)
+	if f == nil {
+		return n, err
+	}
+	b.frame = bytes.NewReader(f)
+	if n > 0 {
+		return n, nil
+	}
+	return b.frame.Read(p)
+}
+
+// trailerFrame encodes h as a trailer frame. It returns nil if h holds no
+// value.
+func trailerFrame(h http.Header) []byte {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b bytes.Buffer
+	b.Write([]byte{0x80, 0, 0, 0, 0})
+	for _, k := range keys {
+		for _, v := range h[k] {
+			b.WriteString(strings.ToLower(k) + ": " + v + "\r\n")
+		}
+	}
+	if b.Len() == 5 {
+		return nil
+	}
+
+	f := b.Bytes()
+	binary.BigEndian.PutUint32(f[1:5], uint32(len(f)-5))
+	return f
+}
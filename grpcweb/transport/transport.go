@@ -4,20 +4,40 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"strings"
 	"sync"
-	"time"
+	"syscall"
 
 	"github.com/gorilla/websocket"
-	"github.com/pkg/errors"
+	"go.uber.org/atomic"
+	"google.golang.org/grpc/codes"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/errs"
 )
 
-var ErrInvalidResponseCode = errors.New("received invalid response code")
+// Sentinel errors returned by the transports. They resolve to gRPC codes, so
+// callers can branch on them with errors.Is or status.Code.
+var (
+	ErrInvalidResponseCode = errs.WithCode(codes.Unavailable, nil, "received invalid response code")
+	ErrBufferLimitExceeded = errs.WithCode(codes.ResourceExhausted, nil, "buffered response exceeds the limit")
+	ErrConnectionReset     = errs.WithCode(codes.Unavailable, nil, "connection reset")
+	ErrInvalidMethod       = errs.WithCode(codes.Internal, nil, "invalid method name")
+	ErrHeaderLimitExceeded = errs.WithCode(codes.ResourceExhausted, nil, "header list exceeds the limit")
+	ErrDNSResolution       = errs.WithCode(codes.Unavailable, nil, "dns resolution failed")
+	ErrTLSHandshake        = errs.WithCode(codes.Unavailable, nil, "tls handshake failed")
+	ErrHandshakeRejected   = errs.WithCode(codes.Unavailable, nil, "websocket handshake rejected")
+	ErrReadLimitExceeded   = errs.WithCode(codes.ResourceExhausted, nil, "websocket message exceeds the read limit")
+	ErrPingTimeout         = errs.WithCode(codes.Unavailable, nil, "keepalive ping timed out")
+)
 
 type UnaryTransport interface {
 	Header() http.Header
@@ -26,12 +46,26 @@ type UnaryTransport interface {
 }
 
 type httpTransport struct {
-	url    *url.URL
-	client *http.Client
+	url          *url.URL
+	urlHook      func(*url.URL) error
+	lowercase    bool
+	headerLimits headerLimits
+	events       EventListener
+	client       *http.Client
+	bufferSize   int
+	// contentLength forces the requests to carry a Content-Length.
+	contentLength    bool
+	uploadThrottle   ThrottleFunc
+	downloadThrottle ThrottleFunc
+	traffic          TrafficCounter
+	template         *http.Request
+	authority        string
 
 	header http.Header
+	// res is the response to the request, once it has been sent.
+	res atomic.Pointer[http.Response]
 
-	sent bool
+	sent atomic.Bool
 }
 
 func (t *httpTransport) Header() http.Header {
@@ -43,36 +77,156 @@ func (t *httpTransport) Send(
 	endpoint, contentType string,
 	body io.Reader,
 ) (http.Header, io.ReadCloser, error) {
-	if t.sent {
-		return nil, nil, errors.New("Send must be called only one time per one Request")
+	if t.sent.Swap(true) {
+		return nil, nil, errs.New("Send must be called only one time per one Request")
 	}
-	defer func() {
-		t.sent = true
-	}()
 
 	u := *t.url
-	u.Path += endpoint
+	if err := joinMethod(&u, endpoint); err != nil {
+		return nil, nil, err
+	}
+	applyTemplateURL(t.template, &u, false)
+	if t.urlHook != nil {
+		if err := t.urlHook(&u); err != nil {
+			return nil, nil, errs.Wrap(err, "failed to apply the URL hook")
+		}
+	}
+
+	if t.contentLength {
+		b, err := sizedBody(body)
+		if err != nil {
+			return nil, nil, errs.Wrap(err, "failed to buffer the request body")
+		}
+		body = b
+	}
 
 	url := u.String()
-	req, err := http.NewRequest(http.MethodPost, url, body)
+	reqCtx := ctx
+	if t.events != nil {
+		reqCtx = httptrace.WithClientTrace(ctx, t.events.trace(endpoint, u.Host))
+	}
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, url, body)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "failed to build the API request")
+		return nil, nil, errs.Wrap(err, "failed to build the API request")
 	}
+	throttleRequest(ctx, req, t.uploadThrottle)
 
 	req.Header = t.Header()
+	applyTemplateHeader(t.template, req.Header)
+	if host := requestHost(t.template, t.authority); host != "" {
+		req.Host = host
+	}
+	// Hop-by-hop headers are forbidden in HTTP/2 requests, which the client
+	// uses when the server supports it.
+	for _, k := range hopByHopHeaders {
+		req.Header.Del(k)
+	}
 	req.Header.Add("content-type", contentType)
 	req.Header.Add("x-grpc-web", "1")
+	// Gateways may send the status as HTTP trailers, see trailerBody.
+	req.Header.Set("te", "trailers")
+	if t.contentLength {
+		// Some WAFs reject the requests waiting for 100 Continue too.
+		req.Header.Del("Expect")
+	}
+	if t.lowercase {
+		req.Header = lowercaseHeader(req.Header)
+	}
+	t.traffic.sent(headerSize(req.Header))
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = t.traffic.countSent(req.Body)
+	}
 
 	res, err := t.client.Do(req)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "failed to send the API")
+		return nil, nil, errs.Wrap(err, "failed to send the API")
 	}
+	t.res.Store(res)
+	t.traffic.received(headerSize(res.Header))
 
 	if res.StatusCode != http.StatusOK {
-		return nil, nil, fmt.Errorf("%w: %d", ErrInvalidResponseCode, res.StatusCode)
+		return nil, nil, responseError(res)
+	}
+
+	if err := t.headerLimits.check(res.Header); err != nil {
+		res.Body.Close()
+		return nil, nil, err
+	}
+
+	resBody := res.Body
+	if t.bufferSize > 0 {
+		resBody = &bufferedBody{Reader: bufio.NewReaderSize(res.Body, t.bufferSize), Closer: res.Body}
+	}
+	resBody = throttleBody(ctx, resBody, t.downloadThrottle)
+	resBody = t.traffic.countReceived(resBody)
+	resBody = &trailerBody{ReadCloser: resBody, res: res}
+	return res.Header, &contextReadCloser{ctx: ctx, ReadCloser: resBody}, nil
+}
+
+// sizedBody returns body as a reader whose size http.NewRequest knows, so
+// that the request isn't sent chunked. Other readers are read fully.
+func sizedBody(body io.Reader) (io.Reader, error) {
+	switch body.(type) {
+	case *bytes.Buffer, *bytes.Reader, *strings.Reader:
+		return body, nil
+	}
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(b), nil
+}
+
+// hopByHopHeaders are the connection specific headers of HTTP/1.1.
+var hopByHopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Transfer-Encoding", "Upgrade"}
+
+// tlsClients caches the clients of tlsClient by TLS configuration, so that
+// the calls made with the same configuration share their connections.
+var tlsClients sync.Map
+
+// tlsClient returns a client using conf, based on http.DefaultTransport.
+// HTTP/2 is negotiated with the servers supporting it.
+func tlsClient(conf *tls.Config) *http.Client {
+	if c, ok := tlsClients.Load(conf); ok {
+		return c.(*http.Client)
 	}
 
-	return res.Header, res.Body, nil
+	var rt http.RoundTripper = http.DefaultTransport
+	if def, ok := http.DefaultTransport.(*http.Transport); ok {
+		tr := def.Clone()
+		tr.TLSClientConfig = conf
+		tr.ForceAttemptHTTP2 = true
+		rt = tr
+	}
+	c, _ := tlsClients.LoadOrStore(conf, &http.Client{Transport: rt})
+	return c.(*http.Client)
+}
+
+// bufferedBody reads a response body through a buffer.
+type bufferedBody struct {
+	*bufio.Reader
+	io.Closer
+}
+
+// contextReadCloser reports the context error instead of the one returned by
+// the underlying body when the body read fails due to the call context being
+// done.
+type contextReadCloser struct {
+	ctx context.Context
+	io.ReadCloser
+}
+
+func (r *contextReadCloser) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		if cerr := r.ctx.Err(); cerr != nil {
+			return n, cerr
+		}
+	}
+	return n, err
 }
 
 func (t *httpTransport) Close() error {
@@ -93,25 +247,35 @@ var NewUnary = func(host string, opts ...ConnectOption) (UnaryTransport, error)
 
 	u, err := url.Parse(fmt.Sprintf("%s://%s", scheme, host))
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to parse host into url")
+		return nil, errs.Wrap(err, "failed to parse host into url")
 	}
 
 	client := http.DefaultClient
 	if o.tlsConf != nil {
-		if defTransport, ok := http.DefaultTransport.(*http.Transport); ok {
-			defTransport.TLSClientConfig = o.tlsConf
-			client.Transport = defTransport
-		}
+		client = tlsClient(o.tlsConf)
 	}
 
 	return &httpTransport{
-		url:    u,
-		client: client,
-		header: make(http.Header),
+		url:              u,
+		urlHook:          o.urlHook,
+		lowercase:        o.lowercase,
+		headerLimits:     headerLimits{maxSize: o.maxHeaderListSize, maxCount: o.maxHeaderCount},
+		events:           o.events,
+		client:           client,
+		bufferSize:       o.readBufferSize,
+		contentLength:    o.contentLength,
+		uploadThrottle:   o.uploadThrottle,
+		downloadThrottle: o.downloadThrottle,
+		traffic:          o.traffic,
+		template:         o.template,
+		authority:        o.authority,
+		header:           make(http.Header),
 	}, nil
 }
 
 type ClientStreamTransport interface {
+	// Header returns the response headers, waiting for the server to send
+	// them if needed.
 	Header() (http.Header, error)
 	Trailer() http.Header
 
@@ -138,43 +302,86 @@ type webSocketTransport struct {
 	host     string
 	endpoint string
 
-	conn *websocket.Conn
+	conn wsConn
 
 	once    sync.Once
 	resOnce sync.Once
 
-	closed bool
+	closed atomic.Bool
+	// pingTimeout is set once the connection was closed because a keepalive
+	// ping wasn't answered, see WithKeepalive.
+	pingTimeout atomic.Bool
+
+	// maxBufferSize limits the bytes buffered for a single response message.
+	maxBufferSize int
+	// readLimit is the read limit of the websocket, if any.
+	readLimit    int64
+	lowercase    bool
+	headerLimits headerLimits
+	events       EventListener
+	// uploadThrottle and downloadThrottle throttle the messages sent and
+	// received, if set.
+	uploadThrottle, downloadThrottle ThrottleFunc
+	traffic                          TrafficCounter
 
 	writeMu sync.Mutex
 
+	// frames is fed by the receive pump if it is enabled.
+	frames    chan frame
+	done      chan struct{}
+	closeOnce sync.Once
+	// lastErr is the error which stopped the receive pump.
+	lastErr atomic.Error
+
+	headerMu                   sync.RWMutex
 	reqHeader, header, trailer http.Header
+	// headerErr is the error which failed the read of the response headers.
+	headerErr error
+}
+
+// frame is a response message or error read by the receive pump.
+type frame struct {
+	r   io.ReadCloser
+	err error
 }
 
 func (t *webSocketTransport) Header() (http.Header, error) {
-	return t.header, nil
+	t.resOnce.Do(t.readHeader)
+	t.headerMu.RLock()
+	defer t.headerMu.RUnlock()
+	return t.header, t.headerErr
 }
 
 func (t *webSocketTransport) Trailer() http.Header {
+	t.headerMu.RLock()
+	defer t.headerMu.RUnlock()
 	return t.trailer
 }
 
 func (t *webSocketTransport) SetRequestHeader(h http.Header) {
+	t.headerMu.Lock()
 	t.reqHeader = h
+	t.headerMu.Unlock()
 }
 
 func (t *webSocketTransport) Send(ctx context.Context, body io.Reader) error {
-	if t.closed {
+	if t.closed.Load() {
 		return io.EOF
 	}
 
 	var err error
 	t.once.Do(func() {
-		h := t.reqHeader
+		t.headerMu.RLock()
+		h := t.reqHeader.Clone()
+		t.headerMu.RUnlock()
 		if h == nil {
 			h = make(http.Header)
 		}
 		h.Set("content-type", "application/grpc-web+proto")
 		h.Set("x-grpc-web", "1")
+		if t.lowercase {
+			h = lowercaseHeader(h)
+		}
 		var b bytes.Buffer
 		_ = h.Write(&b)
 
@@ -188,54 +395,152 @@ func (t *webSocketTransport) Send(ctx context.Context, body io.Reader) error {
 	b.Write([]byte{0x00})
 	_, err = io.Copy(&b, body)
 	if err != nil {
-		return errors.Wrap(err, "failed to read request body")
+		return errs.Wrap(err, "failed to read request body")
+	}
+	if t.uploadThrottle != nil {
+		if err := t.uploadThrottle(ctx, b.Len()); err != nil {
+			return err
+		}
 	}
 
 	return t.writeMessage(websocket.BinaryMessage, b.Bytes())
 }
 
-func (t *webSocketTransport) Receive(context.Context) (_ io.ReadCloser, err error) {
-	if t.closed {
+func (t *webSocketTransport) Receive(ctx context.Context) (io.ReadCloser, error) {
+	r, err := t.nextMessage(ctx)
+	if err != nil {
+		return r, err
+	}
+	return throttleBody(ctx, r, t.downloadThrottle), nil
+}
+
+// nextMessage returns the next response message, from the receive pump if it
+// is enabled.
+func (t *webSocketTransport) nextMessage(ctx context.Context) (io.ReadCloser, error) {
+	if t.closed.Load() {
 		return nil, io.EOF
 	}
+	if t.frames == nil {
+		return t.receive()
+	}
 
-	defer func() {
-		if err == nil {
-			return
+	select {
+	case f, ok := <-t.frames:
+		if !ok {
+			if err := t.lastErr.Load(); err != nil {
+				return nil, err
+			}
+			// The pump was stopped by Close.
+			return nil, io.EOF
 		}
-
-		if berr, ok := errors.Cause(err).(*net.OpError); ok && !berr.Temporary() {
-			err = io.EOF
+		if f.err != nil {
+			t.lastErr.Store(f.err)
 		}
-	}()
+		return f.r, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
 
-	// skip response header
-	t.resOnce.Do(func() {
-		_, _, err = t.conn.NextReader()
+// pump reads the response messages in the background until the connection
+// fails or is closed, so that reading the websocket doesn't depend on the
+// pace of the Receive calls.
+func (t *webSocketTransport) pump() {
+	defer close(t.frames)
+	for {
+		r, err := t.receive()
+		select {
+		case t.frames <- frame{r: r, err: err}:
+		case <-t.done:
+			return
+		}
 		if err != nil {
-			err = errors.Wrap(err, "failed to read response header")
 			return
 		}
+	}
+}
 
-		_, msg, err := t.conn.NextReader()
-		if err != nil {
-			err = errors.Wrap(err, "failed to read response header")
+// readHeader reads the response headers, which the server sends first.
+func (t *webSocketTransport) readHeader() {
+	h, err := t.receiveHeader()
+	t.headerMu.Lock()
+	t.header, t.headerErr = h, err
+	t.headerMu.Unlock()
+}
+
+func (t *webSocketTransport) receiveHeader() (http.Header, error) {
+	if _, _, err := t.conn.NextReader(); err != nil {
+		return nil, errs.Wrap(err, "failed to read response header")
+	}
+
+	_, msg, err := t.conn.NextReader()
+	if err != nil {
+		return nil, errs.Wrap(err, "failed to read response header")
+	}
+
+	msg = t.traffic.countReceived(io.NopCloser(msg))
+	var lr *io.LimitedReader
+	if t.headerLimits.maxSize > 0 {
+		// Line separators are not counted by the limit, so allow some slack
+		// before giving up on reading the frame.
+		lr = &io.LimitedReader{R: msg, N: 2*int64(t.headerLimits.maxSize) + 1}
+		msg = lr
+	}
+
+	h := make(http.Header)
+	s := bufio.NewScanner(msg)
+	for s.Scan() {
+		line := s.Text()
+		i := strings.Index(line, ": ")
+		if i == -1 {
+			continue
+		}
+		k := strings.ToLower(line[:i])
+		h.Add(k, line[i+2:])
+	}
+	if lr != nil && lr.N == 0 {
+		return nil, fmt.Errorf("%w: the limit is %d bytes", ErrHeaderLimitExceeded, t.headerLimits.maxSize)
+	}
+	if err := t.headerLimits.check(h); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (t *webSocketTransport) receive() (_ io.ReadCloser, err error) {
+	// size is the length of the message being read, if known.
+	size := -1
+	defer func() {
+		if err == nil {
 			return
 		}
 
-		h := make(http.Header)
-		s := bufio.NewScanner(msg)
-		for s.Scan() {
-			t := s.Text()
-			i := strings.Index(t, ": ")
-			if i == -1 {
-				continue
+		var oerr *net.OpError
+		switch {
+		case t.pingTimeout.Load():
+			err = fmt.Errorf("%w: %w", ErrPingTimeout, err)
+		case errors.Is(err, websocket.ErrReadLimit):
+			if size >= 0 {
+				err = fmt.Errorf("%w: message of %d bytes, the limit is %d", ErrReadLimitExceeded, size, t.readLimit)
+			} else {
+				err = fmt.Errorf("%w: more than %d bytes", ErrReadLimitExceeded, t.readLimit)
 			}
-			k := strings.ToLower(t[:i])
-			h.Add(k, t[i+2:])
+		case errors.Is(err, syscall.ECONNRESET):
+			err = fmt.Errorf("%w: %w", ErrConnectionReset, err)
+			t.events.emit(Event{Type: EventStreamReset, Method: t.endpoint, Target: t.host, Err: err})
+		case errors.As(err, &oerr) && !oerr.Temporary():
+			err = io.EOF
 		}
-		t.header = h
-	})
+	}()
+
+	// The headers are read once, by the first of Receive and Header.
+	t.resOnce.Do(t.readHeader)
+	t.headerMu.RLock()
+	err = t.headerErr
+	t.headerMu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
 
 	var buf bytes.Buffer
 	var b []byte
@@ -250,10 +555,17 @@ func (t *webSocketTransport) Receive(context.Context) (_ io.ReadCloser, err erro
 				return nil, io.ErrUnexpectedEOF
 			}
 		}
-		err = errors.Wrap(err, "failed to read response body")
+		err = errs.Wrap(err, "failed to read response body")
+		return
+	}
+	if err = t.checkBufferSize(len(b)); err != nil {
 		return
 	}
 	buf.Write(b)
+	if len(b) == 5 {
+		// b is the prefix of the frame whose payload follows.
+		size = int(binary.BigEndian.Uint32(b[1:]))
+	}
 
 	var r io.Reader
 	_, r, err = t.conn.NextReader()
@@ -261,46 +573,68 @@ func (t *webSocketTransport) Receive(context.Context) (_ io.ReadCloser, err erro
 		return
 	}
 
-	res := io.NopCloser(io.MultiReader(&buf, r))
-
-	by, err := io.ReadAll(res)
-	if err != nil {
-		panic(err)
+	if t.maxBufferSize > 0 {
+		// Read one byte past the remaining budget to detect oversized messages
+		// without buffering all of them.
+		r = io.LimitReader(r, int64(t.maxBufferSize-buf.Len())+1)
+	}
+	if _, err = buf.ReadFrom(r); err != nil {
+		err = errs.Wrap(err, "failed to read response body")
+		return
+	}
+	if err = t.checkBufferSize(buf.Len()); err != nil {
+		return
 	}
+	t.traffic.received(buf.Len())
 
-	res = io.NopCloser(bytes.NewReader(by))
+	return io.NopCloser(&buf), nil
+}
 
-	return res, nil
+func (t *webSocketTransport) checkBufferSize(n int) error {
+	if t.maxBufferSize > 0 && n > t.maxBufferSize {
+		return fmt.Errorf("%w: more than %d bytes", ErrBufferLimitExceeded, t.maxBufferSize)
+	}
+	return nil
 }
 
 func (t *webSocketTransport) CloseSend() error {
 	// 0x01 means the finish send frame.
 	// ref. transports/websocket/websocket.ts
 	if err := t.writeMessage(websocket.BinaryMessage, []byte{0x01}); err != nil {
-		return fmt.Errorf("failed to write message to a websocket: %w", err)
+		return errs.Wrap(err, "failed to write message to a websocket")
 	}
 
 	return nil
 }
 
 func (t *webSocketTransport) Close() error {
-	// Send the close message.
+	// Send the close message. The connection is closed and the receive pump
+	// stopped even if it fails, e.g. because the server is already gone.
 	err := t.writeMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
-	if err != nil {
-		return err
-	}
-	t.closed = true
+	t.closed.Store(true)
+	t.closeOnce.Do(func() { close(t.done) })
 	// Close the WebSocket connection.
-	return t.conn.Close()
+	return errors.Join(err, t.conn.Close())
 }
 
 func (t *webSocketTransport) writeMessage(msg int, b []byte) error {
 	t.writeMu.Lock()
 	defer t.writeMu.Unlock()
-	return t.conn.WriteMessage(msg, b)
+	if err := t.conn.WriteMessage(msg, b); err != nil {
+		return err
+	}
+	t.traffic.sent(len(b))
+	return nil
 }
 
+// NewClientStream is NewClientStreamContext with a background context.
 var NewClientStream = func(host, endpoint string, opts ...ConnectOption) (ClientStreamTransport, error) {
+	return NewClientStreamContext(context.Background(), host, endpoint, opts...)
+}
+
+// NewClientStreamContext dials the websocket of the stream of endpoint on
+// host. ctx bounds the dial, its retries included, see WithDialRetry.
+var NewClientStreamContext = func(ctx context.Context, host, endpoint string, opts ...ConnectOption) (ClientStreamTransport, error) {
 	o := new(connectOptions)
 	for _, f := range opts {
 		f(o)
@@ -311,31 +645,125 @@ var NewClientStream = func(host, endpoint string, opts ...ConnectOption) (Client
 		scheme = "ws"
 	}
 
-	u, err := url.Parse(fmt.Sprintf("%s://%s%s", scheme, host, endpoint))
+	u, err := url.Parse(fmt.Sprintf("%s://%s", scheme, host))
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to parse url")
+		return nil, errs.Wrap(err, "failed to parse url")
+	}
+	if err := joinMethod(u, endpoint); err != nil {
+		return nil, err
+	}
+	applyTemplateURL(o.template, u, true)
+	if o.urlHook != nil {
+		if err := o.urlHook(u); err != nil {
+			return nil, errs.Wrap(err, "failed to apply the URL hook")
+		}
 	}
 
-	wsDialer := &websocket.Dialer{
-		Proxy:            http.ProxyFromEnvironment,
-		HandshakeTimeout: 45 * time.Second,
+	conn, err := dialWebSocket(ctx, webSocketDialer(u, o), u, endpoint, o)
+	if err != nil {
+		return nil, errs.Wrapf(err, "failed to dial to '%s'", u.String())
 	}
+	return newWebSocketTransport(conn, host, endpoint, o), nil
+}
 
-	if o.tlsConf != nil {
-		wsDialer.TLSClientConfig = o.tlsConf
+// NewClientStreamFromConn returns a stream transport on top of conn, a
+// websocket already opened to the URL of the method, e.g. through an SSH port
+// forward or a libp2p stream, with the "grpc-websockets" subprotocol. The
+// dial options of opts don't apply, and the events report the remote address
+// of conn as target. Closing the transport closes conn.
+func NewClientStreamFromConn(conn *websocket.Conn, opts ...ConnectOption) (ClientStreamTransport, error) {
+	if conn == nil {
+		return nil, errs.New("no websocket connection")
 	}
+	o := new(connectOptions)
+	for _, f := range opts {
+		f(o)
+	}
+	return newWebSocketTransport(conn, conn.RemoteAddr().String(), "", o), nil
+}
 
-	h := http.Header{}
-	h.Set("Sec-WebSocket-Protocol", "grpc-websockets")
-	var conn *websocket.Conn
-	conn, _, err = wsDialer.Dial(u.String(), h)
-	if err != nil {
-		return nil, errors.Wrapf(err, "failed to dial to '%s'", u.String())
+// newWebSocketTransport returns the stream transport of endpoint on conn,
+// connected to host.
+func newWebSocketTransport(conn wsConn, host, endpoint string, o *connectOptions) *webSocketTransport {
+	if o.readLimit > 0 {
+		conn.SetReadLimit(o.readLimit)
 	}
 
-	return &webSocketTransport{
-		host:     host,
-		endpoint: endpoint,
-		conn:     conn,
-	}, nil
+	t := &webSocketTransport{
+		host:             host,
+		endpoint:         endpoint,
+		conn:             conn,
+		maxBufferSize:    o.maxBufferSize,
+		readLimit:        o.readLimit,
+		lowercase:        o.lowercase,
+		headerLimits:     headerLimits{maxSize: o.maxHeaderListSize, maxCount: o.maxHeaderCount},
+		events:           o.events,
+		uploadThrottle:   o.uploadThrottle,
+		downloadThrottle: o.downloadThrottle,
+		traffic:          o.traffic,
+		done:             make(chan struct{}),
+	}
+	if o.keepaliveTime > 0 {
+		t.startKeepalive(o)
+	}
+	if o.receivePump {
+		t.frames = make(chan frame, o.receiveBuffer)
+		go t.pump()
+	}
+	return t
+}
+
+// joinMethod appends the path of a full method name to u, keeping any path u
+// already has. Both "/pkg.Service/Method" and "pkg.Service/Method" forms are
+// accepted, and each path segment is escaped.
+func joinMethod(u *url.URL, method string) error {
+	service, name, ok := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	if !ok || service == "" || name == "" || strings.Contains(name, "/") {
+		return fmt.Errorf("%w: %q", ErrInvalidMethod, method)
+	}
+
+	base, rawBase := strings.TrimSuffix(u.Path, "/"), strings.TrimSuffix(u.EscapedPath(), "/")
+	u.Path = base + "/" + service + "/" + name
+	u.RawPath = rawBase + "/" + url.PathEscape(service) + "/" + url.PathEscape(name)
+	return nil
+}
+
+// lowercaseHeader returns a copy of h with lowercase keys. The keys are stored
+// directly in the map, bypassing canonicalization, so they are written to the
+// wire as is.
+func lowercaseHeader(h http.Header) http.Header {
+	lh := make(http.Header, len(h))
+	for k, v := range h {
+		lk := strings.ToLower(k)
+		lh[lk] = append(lh[lk], v...)
+	}
+	return lh
+}
+
+type headerLimits struct {
+	maxSize  uint32
+	maxCount int
+}
+
+// check returns ErrHeaderLimitExceeded if h is larger than the limits.
+func (l headerLimits) check(h http.Header) error {
+	var (
+		size  uint64
+		count int
+	)
+	for k, v := range h {
+		for _, vv := range v {
+			size += uint64(len(k) + len(vv))
+			count++
+		}
+	}
+
+	switch {
+	case l.maxSize > 0 && size > uint64(l.maxSize):
+		return fmt.Errorf("%w: %d bytes, the limit is %d", ErrHeaderLimitExceeded, size, l.maxSize)
+	case l.maxCount > 0 && count > l.maxCount:
+		return fmt.Errorf("%w: %d entries, the limit is %d", ErrHeaderLimitExceeded, count, l.maxCount)
+	default:
+		return nil
+	}
 }
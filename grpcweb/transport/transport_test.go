@@ -0,0 +1,1313 @@
+package transport_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/transport"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/transport/transporttest"
+)
+
+func TestUnarySendHonorsContext(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	tr, err := transport.NewUnary(strings.TrimPrefix(srv.URL, "http://"), transport.WithInsecure())
+	if err != nil {
+		t.Fatalf("NewUnary should not return an error, but got '%s'", err)
+	}
+	defer tr.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, _, err = tr.Send(ctx, "/service/Method", "application/grpc-web+proto", bytes.NewReader(nil))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to abort the request, but got '%v'", err)
+	}
+	if code := status.Code(err); code != codes.DeadlineExceeded {
+		t.Errorf("expected status code %s, but got %s", codes.DeadlineExceeded, code)
+	}
+}
+
+var errStop = errors.New("stop")
+
+func TestUnaryRequestURL(t *testing.T) {
+	cases := map[string]struct {
+		host, method string
+		expectedURL  string
+		expectedErr  error
+	}{
+		"full method": {
+			host:        "example.com",
+			method:      "/pkg.Service/Method",
+			expectedURL: "http://example.com/pkg.Service/Method",
+		},
+		"method without leading slash": {
+			host:        "example.com",
+			method:      "pkg.Service/Method",
+			expectedURL: "http://example.com/pkg.Service/Method",
+		},
+		"host with path prefix": {
+			host:        "example.com/api/",
+			method:      "/pkg.Service/Method",
+			expectedURL: "http://example.com/api/pkg.Service/Method",
+		},
+		"special characters": {
+			host:        "example.com",
+			method:      "/pkg.Service/Me?th od",
+			expectedURL: "http://example.com/pkg.Service/Me%3Fth%20od",
+		},
+		"missing method": {
+			host:        "example.com",
+			method:      "/pkg.Service/",
+			expectedErr: transport.ErrInvalidMethod,
+		},
+		"too many segments": {
+			host:        "example.com",
+			method:      "/pkg.Service/Method/Extra",
+			expectedErr: transport.ErrInvalidMethod,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			var got string
+			tr, err := transport.NewUnary(c.host, transport.WithInsecure(), transport.WithURLHook(func(u *url.URL) error {
+				got = u.String()
+				return errStop
+			}))
+			if err != nil {
+				t.Fatalf("NewUnary should not return an error, but got '%s'", err)
+			}
+
+			_, _, err = tr.Send(context.Background(), c.method, "application/grpc-web+proto", bytes.NewReader(nil))
+			if c.expectedErr != nil {
+				if !errors.Is(err, c.expectedErr) {
+					t.Fatalf("expected error '%v', but got '%v'", c.expectedErr, err)
+				}
+				return
+			}
+			if !errors.Is(err, errStop) {
+				t.Fatalf("expected the URL hook to be called, but got '%v'", err)
+			}
+			if got != c.expectedURL {
+				t.Errorf("expected URL '%s', but got '%s'", c.expectedURL, got)
+			}
+		})
+	}
+}
+
+func TestUnaryHTTP2(t *testing.T) {
+	var (
+		proto      int
+		te, upgrade string
+	)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proto, te, upgrade = r.ProtoMajor, r.Header.Get("Te"), r.Header.Get("Upgrade")
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	tr, err := transport.NewUnary(strings.TrimPrefix(srv.URL, "https://"), transport.WithTLSConfig(&tls.Config{RootCAs: pool}))
+	if err != nil {
+		t.Fatalf("NewUnary should not return an error, but got '%s'", err)
+	}
+	defer tr.Close()
+	if def := http.DefaultTransport.(*http.Transport); def.TLSClientConfig != nil && def.TLSClientConfig.RootCAs == pool {
+		t.Errorf("NewUnary should not modify http.DefaultTransport")
+	}
+
+	// Hop-by-hop headers set from metadata make HTTP/2 requests fail.
+	tr.Header().Set("Upgrade", "websocket")
+	_, body, err := tr.Send(context.Background(), "/service/Method", "application/grpc-web+proto", strings.NewReader(""))
+	if err != nil {
+		t.Fatalf("Send should not return an error, but got '%s'", err)
+	}
+	body.Close()
+
+	if proto != 2 {
+		t.Errorf("expected HTTP/2 to be negotiated, but got HTTP/%d", proto)
+	}
+	if te != "trailers" {
+		t.Errorf("expected te: trailers, but got %q", te)
+	}
+	if upgrade != "" {
+		t.Errorf("expected no upgrade header, but got %q", upgrade)
+	}
+}
+
+func TestUnaryHeaderLimits(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("key1", "value1")
+		w.Header().Add("key2", "value2")
+		w.Header().Add("key2", "value3")
+	}))
+	defer srv.Close()
+
+	cases := map[string]struct {
+		maxSize  uint32
+		maxCount int
+		wantErr  bool
+	}{
+		"within limits":  {maxSize: 1024, maxCount: 10},
+		"too large":      {maxSize: 16, wantErr: true},
+		"too many pairs": {maxCount: 3, wantErr: true},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			tr, err := transport.NewUnary(
+				strings.TrimPrefix(srv.URL, "http://"),
+				transport.WithInsecure(),
+				transport.WithHeaderLimits(c.maxSize, c.maxCount),
+			)
+			if err != nil {
+				t.Fatalf("NewUnary should not return an error, but got '%s'", err)
+			}
+
+			_, _, err = tr.Send(context.Background(), "/service/Method", "application/grpc-web+proto", bytes.NewReader(nil))
+			if !c.wantErr {
+				if err != nil {
+					t.Fatalf("should not return an error, but got '%s'", err)
+				}
+				return
+			}
+			if !errors.Is(err, transport.ErrHeaderLimitExceeded) {
+				t.Fatalf("expected ErrHeaderLimitExceeded, but got '%v'", err)
+			}
+			if code := status.Code(err); code != codes.ResourceExhausted {
+				t.Errorf("expected status code %s, but got %s", codes.ResourceExhausted, code)
+			}
+		})
+	}
+}
+
+func TestUnaryContentLength(t *testing.T) {
+	var (
+		length  int64
+		chunked bool
+		expect  string
+		body    []byte
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		length = r.ContentLength
+		chunked = slices.Contains(r.TransferEncoding, "chunked")
+		expect = r.Header.Get("Expect")
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	cases := map[string]struct {
+		opts            []transport.ConnectOption
+		expectedLength  int64
+		expectedChunked bool
+		expectedExpect  string
+	}{
+		"content length": {
+			opts:           []transport.ConnectOption{transport.WithContentLength()},
+			expectedLength: 5,
+		},
+		"chunked": {
+			expectedLength:  -1,
+			expectedChunked: true,
+			expectedExpect:  "100-continue",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			tr, err := transport.NewUnary(
+				strings.TrimPrefix(srv.URL, "http://"),
+				append(c.opts, transport.WithInsecure())...,
+			)
+			if err != nil {
+				t.Fatalf("NewUnary should not return an error, but got '%s'", err)
+			}
+			tr.Header().Set("Expect", "100-continue")
+
+			// A MultiReader hides the size of the body.
+			req := io.MultiReader(strings.NewReader("nano"), strings.NewReader("!"))
+			_, res, err := tr.Send(context.Background(), "/service/Method", "application/grpc-web+proto", req)
+			if err != nil {
+				t.Fatalf("should not return an error, but got '%s'", err)
+			}
+			res.Close()
+
+			if length != c.expectedLength {
+				t.Errorf("expected Content-Length %d, but got %d", c.expectedLength, length)
+			}
+			if chunked != c.expectedChunked {
+				t.Errorf("expected chunked %t, but got %t", c.expectedChunked, chunked)
+			}
+			if expect != c.expectedExpect {
+				t.Errorf("expected Expect %q, but got %q", c.expectedExpect, expect)
+			}
+			if string(body) != "nano!" {
+				t.Errorf("expected the body %q, but got %q", "nano!", body)
+			}
+		})
+	}
+}
+
+func TestRequestTemplate(t *testing.T) {
+	var requests []*http.Request
+	upgrader := websocket.Upgrader{Subprotocols: []string{"grpc-websockets"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		if websocket.IsWebSocketUpgrade(r) {
+			if conn, err := upgrader.Upgrade(w, r, nil); err == nil {
+				conn.Close()
+			}
+		}
+	}))
+	defer srv.Close()
+
+	tmpl, err := http.NewRequest(http.MethodPost, srv.URL+"?tenant=a", nil)
+	if err != nil {
+		t.Fatalf("NewRequest should not return an error, but got '%s'", err)
+	}
+	tmpl.Host = "api.example.com"
+	tmpl.Header.Set("X-Gateway", "template")
+	tmpl.Header.Set("X-Override", "template")
+	// The target is replaced by the host of the template.
+	opts := []transport.ConnectOption{transport.WithRequestTemplate(tmpl)}
+
+	tr, err := transport.NewUnary("target.invalid", opts...)
+	if err != nil {
+		t.Fatalf("NewUnary should not return an error, but got '%s'", err)
+	}
+	tr.Header().Set("X-Override", "call")
+	_, res, err := tr.Send(context.Background(), "/service/Method", "application/grpc-web+proto", strings.NewReader(""))
+	if err != nil {
+		t.Fatalf("Send should not return an error, but got '%s'", err)
+	}
+	res.Close()
+
+	stream, err := transport.NewClientStreamContext(context.Background(), "target.invalid", "/service/Method", opts...)
+	if err != nil {
+		t.Fatalf("NewClientStreamContext should not return an error, but got '%s'", err)
+	}
+	stream.Close()
+
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 requests, but got %d", len(requests))
+	}
+	for i, expectedOverride := range []string{"call", "template"} {
+		r := requests[i]
+		if r.URL.Path != "/service/Method" || r.URL.Query().Get("tenant") != "a" {
+			t.Errorf("expected the template query on the method path, but got %s", r.URL)
+		}
+		if r.Host != "api.example.com" {
+			t.Errorf("expected the Host of the template, but got %s", r.Host)
+		}
+		if got := r.Header.Get("X-Gateway"); got != "template" {
+			t.Errorf("expected the header of the template, but got %q", got)
+		}
+		if got := r.Header.Get("X-Override"); got != expectedOverride {
+			t.Errorf("expected X-Override %q, but got %q", expectedOverride, got)
+		}
+	}
+}
+
+func TestUnaryInvalidResponseCode(t *testing.T) {
+	cases := map[string]struct {
+		statusCode      int
+		header          http.Header
+		body            string
+		expectedCode    codes.Code
+		expectedMessage string
+		httpError       bool
+	}{
+		"grpc-status header": {
+			statusCode:      http.StatusBadGateway,
+			header:          http.Header{"Grpc-Status": {"7"}, "Grpc-Message": {"denied"}},
+			body:            `{"code": 5, "message": "not found"}`,
+			expectedCode:    codes.PermissionDenied,
+			expectedMessage: "denied",
+		},
+		"JSON status": {
+			statusCode:      http.StatusBadRequest,
+			body:            `{"code": 3, "message": "bad argument", "details": [{"@type": "type.example.com/Unknown"}]}`,
+			expectedCode:    codes.InvalidArgument,
+			expectedMessage: "bad argument",
+		},
+		"plain body": {
+			statusCode:      http.StatusForbidden,
+			body:            "forbidden\n",
+			expectedCode:    codes.PermissionDenied,
+			expectedMessage: "received invalid response code: 403: forbidden",
+			httpError:       true,
+		},
+		"OK grpc-status header": {
+			statusCode:      http.StatusBadGateway,
+			header:          http.Header{"Grpc-Status": {"0"}},
+			expectedCode:    codes.Unavailable,
+			expectedMessage: "received invalid response code: 502",
+			httpError:       true,
+		},
+		"invalid grpc-status header": {
+			statusCode:      http.StatusBadGateway,
+			header:          http.Header{"Grpc-Status": {"ok"}},
+			expectedCode:    codes.Unavailable,
+			expectedMessage: "received invalid response code: 502",
+			httpError:       true,
+		},
+		"OK JSON status": {
+			statusCode:      http.StatusInternalServerError,
+			body:            `{"code": 0, "message": "ok"}`,
+			expectedCode:    codes.Unknown,
+			expectedMessage: `received invalid response code: 500: {"code": 0, "message": "ok"}`,
+			httpError:       true,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for k, v := range c.header {
+					w.Header()[k] = v
+				}
+				w.WriteHeader(c.statusCode)
+				w.Write([]byte(c.body))
+			}))
+			defer srv.Close()
+
+			tr, err := transport.NewUnary(strings.TrimPrefix(srv.URL, "http://"), transport.WithInsecure())
+			if err != nil {
+				t.Fatalf("NewUnary should not return an error, but got '%s'", err)
+			}
+
+			_, _, err = tr.Send(context.Background(), "/service/Method", "application/grpc-web+proto", bytes.NewReader(nil))
+			stat := status.Convert(err)
+			if stat.Code() != c.expectedCode {
+				t.Errorf("expected status code %s, but got %s", c.expectedCode, stat.Code())
+			}
+			if stat.Message() != c.expectedMessage {
+				t.Errorf("expected status message %q, but got %q", c.expectedMessage, stat.Message())
+			}
+
+			var httpErr *transport.HTTPError
+			if errors.As(err, &httpErr) != c.httpError {
+				t.Errorf("expected HTTPError: %t, but got '%v'", c.httpError, err)
+			}
+			if c.httpError && !errors.Is(err, transport.ErrInvalidResponseCode) {
+				t.Errorf("expected the error to match ErrInvalidResponseCode")
+			}
+		})
+	}
+}
+
+func TestClientStreamDialRetry(t *testing.T) {
+	cases := map[string]struct {
+		rejectStatus     int
+		rejections       int
+		attempts         int
+		expectedAttempts int
+		expectedCode     codes.Code
+	}{
+		"retried until accepted": {
+			rejectStatus:     http.StatusServiceUnavailable,
+			rejections:       2,
+			attempts:         3,
+			expectedAttempts: 3,
+			expectedCode:     codes.OK,
+		},
+		"attempts exhausted": {
+			rejectStatus:     http.StatusServiceUnavailable,
+			rejections:       5,
+			attempts:         2,
+			expectedAttempts: 2,
+			expectedCode:     codes.Unavailable,
+		},
+		"permanent rejection": {
+			rejectStatus:     http.StatusForbidden,
+			rejections:       1,
+			attempts:         3,
+			expectedAttempts: 1,
+			expectedCode:     codes.PermissionDenied,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			var n int
+			upgrader := websocket.Upgrader{Subprotocols: []string{"grpc-websockets"}}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n++
+				if n <= c.rejections {
+					w.Header().Set("Www-Authenticate", "Bearer")
+					w.WriteHeader(c.rejectStatus)
+					w.Write([]byte("rejected"))
+					return
+				}
+				conn, err := upgrader.Upgrade(w, r, nil)
+				if err != nil {
+					return
+				}
+				conn.Close()
+			}))
+			defer srv.Close()
+
+			tr, err := transport.NewClientStreamContext(
+				context.Background(),
+				strings.TrimPrefix(srv.URL, "http://"),
+				"/service/Method",
+				transport.WithInsecure(),
+				transport.WithDialRetry(c.attempts, backoff.Config{BaseDelay: time.Millisecond, Multiplier: 1, MaxDelay: time.Millisecond}),
+			)
+			if err == nil {
+				tr.Close()
+			}
+			if code := status.Code(err); code != c.expectedCode {
+				t.Errorf("expected status code %s, but got %s (%v)", c.expectedCode, code, err)
+			}
+			if err != nil {
+				if !errors.Is(err, transport.ErrHandshakeRejected) {
+					t.Errorf("expected ErrHandshakeRejected, but got '%v'", err)
+				}
+				var dialErr *transport.DialError
+				if !errors.As(err, &dialErr) || dialErr.Stage != transport.DialStageUpgrade {
+					t.Errorf("expected a DialError of the upgrade stage, but got '%v'", err)
+				}
+				var httpErr *transport.HTTPError
+				if !errors.As(err, &httpErr) {
+					t.Fatalf("expected HTTPError, but got '%v'", err)
+				}
+				if httpErr.StatusCode != c.rejectStatus || string(httpErr.Body) != "rejected" || httpErr.Header.Get("Www-Authenticate") != "Bearer" {
+					t.Errorf("the handshake response isn't captured: %d %q %v", httpErr.StatusCode, httpErr.Body, httpErr.Header)
+				}
+			}
+			if n != c.expectedAttempts {
+				t.Errorf("expected %d dial attempts, but got %d", c.expectedAttempts, n)
+			}
+		})
+	}
+}
+
+func TestClientStreamMinConnectTimeout(t *testing.T) {
+	var n atomic.Int32
+	done := make(chan struct{})
+	upgrader := websocket.Upgrader{Subprotocols: []string{"grpc-websockets"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n.Add(1) == 1 {
+			// Stall the first handshake.
+			select {
+			case <-r.Context().Done():
+			case <-done:
+			}
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	tr, err := transport.NewClientStreamContext(
+		context.Background(),
+		strings.TrimPrefix(srv.URL, "http://"),
+		"/service/Method",
+		transport.WithInsecure(),
+		transport.WithDialRetry(2, backoff.Config{BaseDelay: time.Millisecond, Multiplier: 1, MaxDelay: time.Millisecond}),
+		transport.WithMinConnectTimeout(50*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("NewClientStreamContext should not return an error, but got '%s'", err)
+	}
+	tr.Close()
+	if got := n.Load(); got != 2 {
+		t.Errorf("expected 2 dial attempts, but got %d", got)
+	}
+}
+
+func TestClientStreamMinConnectTimeoutClock(t *testing.T) {
+	var n atomic.Int32
+	stalled, done := make(chan struct{}), make(chan struct{})
+	upgrader := websocket.Upgrader{Subprotocols: []string{"grpc-websockets"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n.Add(1) == 1 {
+			// Stall the first handshake.
+			close(stalled)
+			select {
+			case <-r.Context().Done():
+			case <-done:
+			}
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	clock := transporttest.NewClock(time.Now())
+	errc := make(chan error, 1)
+	go func() {
+		tr, err := transport.NewClientStreamContext(
+			context.Background(),
+			strings.TrimPrefix(srv.URL, "http://"),
+			"/service/Method",
+			transport.WithInsecure(),
+			transport.WithClock(clock),
+			transport.WithDialRetry(2, backoff.Config{BaseDelay: time.Millisecond, Multiplier: 1, MaxDelay: time.Millisecond}),
+			transport.WithMinConnectTimeout(time.Hour),
+		)
+		if err == nil {
+			tr.Close()
+		}
+		errc <- err
+	}()
+
+	// The connect timeout of the first attempt, then the backoff.
+	<-stalled
+	clock.BlockUntil(1)
+	clock.Advance(time.Hour)
+	clock.BlockUntil(1)
+	clock.Advance(time.Millisecond)
+	if err := <-errc; err != nil {
+		t.Fatalf("NewClientStreamContext should not return an error, but got '%s'", err)
+	}
+	if got := n.Load(); got != 2 {
+		t.Errorf("expected 2 dial attempts, but got %d", got)
+	}
+}
+
+func TestClientStreamDialDNSError(t *testing.T) {
+	_, err := transport.NewClientStreamContext(context.Background(), "nonexistent.invalid:80", "/service/Method", transport.WithInsecure())
+	if !errors.Is(err, transport.ErrDNSResolution) {
+		t.Errorf("expected ErrDNSResolution, but got '%v'", err)
+	}
+	if code := status.Code(err); code != codes.Unavailable {
+		t.Errorf("expected status code %s, but got %s", codes.Unavailable, code)
+	}
+}
+
+func TestDial(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	tlsSrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer tlsSrv.Close()
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	closed.Close()
+
+	var (
+		dnsErr    *net.DNSError
+		opErr     *net.OpError
+		verifyErr *tls.CertificateVerificationError
+	)
+	cases := map[string]struct {
+		host          string
+		opts          []transport.ConnectOption
+		expectedStage transport.DialStage
+		expectedCause any
+	}{
+		"connected": {
+			host: strings.TrimPrefix(srv.URL, "http://"),
+			opts: []transport.ConnectOption{transport.WithInsecure()},
+		},
+		"dns": {
+			host:          "nonexistent.invalid:80",
+			opts:          []transport.ConnectOption{transport.WithInsecure()},
+			expectedStage: transport.DialStageDNS,
+			expectedCause: &dnsErr,
+		},
+		"tcp": {
+			host:          strings.TrimPrefix(closed.URL, "http://"),
+			opts:          []transport.ConnectOption{transport.WithInsecure()},
+			expectedStage: transport.DialStageTCP,
+			expectedCause: &opErr,
+		},
+		"tls": {
+			host:          strings.TrimPrefix(tlsSrv.URL, "https://"),
+			opts:          []transport.ConnectOption{transport.WithTLSConfig(&tls.Config{})},
+			expectedStage: transport.DialStageTLS,
+			expectedCause: &verifyErr,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := transport.Dial(context.Background(), c.host, c.opts...)
+			if c.expectedCause == nil {
+				if err != nil {
+					t.Fatalf("Dial should not return an error, but got '%s'", err)
+				}
+				return
+			}
+
+			var dialErr *transport.DialError
+			if !errors.As(err, &dialErr) {
+				t.Fatalf("expected a DialError, but got '%v'", err)
+			}
+			if dialErr.Stage != c.expectedStage {
+				t.Errorf("expected the %s stage, but got %s", c.expectedStage, dialErr.Stage)
+			}
+			if dialErr.Addr != c.host {
+				t.Errorf("expected the address %s, but got %s", c.host, dialErr.Addr)
+			}
+			if !errors.As(err, c.expectedCause) {
+				t.Errorf("expected the cause to be preserved, but got '%v'", err)
+			}
+			if code := status.Code(err); code != codes.Unavailable {
+				t.Errorf("expected status code %s, but got %s", codes.Unavailable, code)
+			}
+		})
+	}
+}
+
+func TestEventListener(t *testing.T) {
+	var n int
+	upgrader := websocket.Upgrader{Subprotocols: []string{"grpc-websockets"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") == "" {
+			return
+		}
+		n++
+		if n == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}))
+	defer srv.Close()
+
+	var events []transport.EventType
+	listener := transport.WithEventListener(func(e transport.Event) {
+		events = append(events, e.Type)
+	})
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	tr, err := transport.NewUnary(host, transport.WithInsecure(), listener)
+	if err != nil {
+		t.Fatalf("NewUnary should not return an error, but got '%s'", err)
+	}
+	_, body, err := tr.Send(context.Background(), "/service/Method", "application/grpc-web+proto", bytes.NewReader(nil))
+	if err != nil {
+		t.Fatalf("Send should not return an error, but got '%s'", err)
+	}
+	body.Close()
+	tr.Close()
+
+	stream, err := transport.NewClientStreamContext(
+		context.Background(),
+		host,
+		"/service/Method",
+		transport.WithInsecure(),
+		transport.WithDialRetry(2, backoff.Config{BaseDelay: time.Millisecond, Multiplier: 1, MaxDelay: time.Millisecond}),
+		listener,
+	)
+	if err != nil {
+		t.Fatalf("NewClientStreamContext should not return an error, but got '%s'", err)
+	}
+	stream.Close()
+
+	expected := []transport.EventType{
+		transport.EventConnectionEstablished,
+		transport.EventReconnect,
+		transport.EventConnectionEstablished,
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected events %v, but got %v", expected, events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("expected events %v, but got %v", expected, events)
+		}
+	}
+}
+
+func TestClientStreamReceivePump(t *testing.T) {
+	upgrader := websocket.Upgrader{Subprotocols: []string{"grpc-websockets"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for _, msg := range [][]byte{
+			{0x00},
+			[]byte("content-type: application/grpc-web+proto\r\n"),
+			{0x00, 0x00, 0x00, 0x00, 0x03},
+			[]byte("abc"),
+		} {
+			if err := conn.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+				return
+			}
+		}
+		// Keep the stream open until the client goes away.
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	tr, err := transport.NewClientStream(
+		strings.TrimPrefix(srv.URL, "http://"),
+		"/service/Method",
+		transport.WithInsecure(),
+		transport.WithReceivePump(1),
+	)
+	if err != nil {
+		t.Fatalf("NewClientStream should not return an error, but got '%s'", err)
+	}
+	defer tr.Close()
+
+	r, err := tr.Receive(context.Background())
+	if err != nil {
+		t.Fatalf("Receive should not return an error, but got '%s'", err)
+	}
+	var b bytes.Buffer
+	b.ReadFrom(r)
+	if expected := "\x00\x00\x00\x00\x03abc"; b.String() != expected {
+		t.Errorf("expected %q, but got %q", expected, b.String())
+	}
+	if h, _ := tr.Header(); h.Get("content-type") != "application/grpc-web+proto" {
+		t.Errorf("unexpected response header %v", h)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := tr.Receive(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the context to interrupt Receive, but got '%v'", err)
+	}
+}
+
+func TestClientStreamKeepalive(t *testing.T) {
+	cases := map[string]struct {
+		answer      bool
+		expectedErr error
+	}{
+		"answered":   {answer: true, expectedErr: context.DeadlineExceeded},
+		"unanswered": {expectedErr: transport.ErrPingTimeout},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			pings := make(chan struct{}, 10)
+			upgrader := websocket.Upgrader{Subprotocols: []string{"grpc-websockets"}}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				conn, err := upgrader.Upgrade(w, r, nil)
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				conn.SetPingHandler(func(data string) error {
+					select {
+					case pings <- struct{}{}:
+					default:
+					}
+					if !c.answer {
+						return nil
+					}
+					return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+				})
+				for _, msg := range [][]byte{{0x00}, []byte("content-type: application/grpc-web+proto\r\n")} {
+					if err := conn.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+						return
+					}
+				}
+				for {
+					if _, _, err := conn.ReadMessage(); err != nil {
+						return
+					}
+				}
+			}))
+			defer srv.Close()
+
+			events := make(chan transport.Event, 10)
+			tr, err := transport.NewClientStreamContext(
+				context.Background(),
+				strings.TrimPrefix(srv.URL, "http://"),
+				"/service/Method",
+				transport.WithInsecure(),
+				transport.WithReceivePump(1),
+				transport.WithKeepalive(10*time.Millisecond, 200*time.Millisecond),
+				transport.WithEventListener(func(e transport.Event) { events <- e }),
+			)
+			if err != nil {
+				t.Fatalf("NewClientStreamContext should not return an error, but got '%s'", err)
+			}
+			defer tr.Close()
+			<-events // connection established
+
+			if c.answer {
+				// The pongs keep the stream alive past the timeout.
+				for range 30 {
+					<-pings
+				}
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if c.answer {
+				ctx, cancel = context.WithTimeout(ctx, 50*time.Millisecond)
+				defer cancel()
+			}
+			_, err = tr.Receive(ctx)
+			if !errors.Is(err, c.expectedErr) {
+				t.Fatalf("expected Receive to return '%v', but got '%v'", c.expectedErr, err)
+			}
+			if c.answer {
+				return
+			}
+			if code := status.Code(err); code != codes.Unavailable {
+				t.Errorf("expected code Unavailable, but got %s", code)
+			}
+			select {
+			case e := <-events:
+				if e.Type != transport.EventPingTimeout || !errors.Is(e.Err, transport.ErrPingTimeout) {
+					t.Errorf("expected a ping timeout event, but got %+v", e)
+				}
+			default:
+				t.Error("expected a ping timeout event")
+			}
+		})
+	}
+}
+
+func TestClientStreamCloseAfterDisconnect(t *testing.T) {
+	upgrader := websocket.Upgrader{Subprotocols: []string{"grpc-websockets"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// Fill the buffer of the receive pump, so that it blocks.
+		for _, msg := range [][]byte{
+			{0x00},
+			[]byte("content-type: application/grpc-web+proto\r\n"),
+			{0x00, 0x00, 0x00, 0x00, 0x01},
+			[]byte("a"),
+			{0x00, 0x00, 0x00, 0x00, 0x01},
+			[]byte("b"),
+		} {
+			if err := conn.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+				return
+			}
+		}
+		conn.ReadMessage()
+	}))
+	defer srv.Close()
+
+	d := websocket.Dialer{Subprotocols: []string{"grpc-websockets"}}
+	conn, _, err := d.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/service/Method", nil)
+	if err != nil {
+		t.Fatalf("Dial should not return an error, but got '%s'", err)
+	}
+	tr, err := transport.NewClientStreamFromConn(conn, transport.WithReceivePump(1))
+	if err != nil {
+		t.Fatalf("NewClientStreamFromConn should not return an error, but got '%s'", err)
+	}
+
+	// pumpState returns the state of the goroutine of the receive pump, "" if
+	// it exited.
+	pumpState := func() string {
+		buf := make([]byte, 1<<20)
+		for _, g := range strings.Split(string(buf[:runtime.Stack(buf, true)]), "\n\n") {
+			if strings.Contains(g, "(*webSocketTransport).pump") {
+				_, state, _ := strings.Cut(g, "[")
+				state, _, _ = strings.Cut(state, "]")
+				return state
+			}
+		}
+		return ""
+	}
+	waitFor := func(expected, msg string) {
+		deadline := time.Now().Add(5 * time.Second)
+		for pumpState() != expected {
+			if time.Now().After(deadline) {
+				t.Fatal(msg)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitFor("select", "expected the receive pump to block on its full buffer")
+
+	// The close message can't be written once the connection is gone.
+	conn.UnderlyingConn().Close()
+	if err := tr.Close(); err == nil {
+		t.Errorf("Close should return the error of the close message")
+	}
+	waitFor("", "expected the receive pump to exit once the transport is closed")
+}
+
+func TestClientStreamReadLimit(t *testing.T) {
+	upgrader := websocket.Upgrader{Subprotocols: []string{"grpc-websockets"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for _, msg := range [][]byte{
+			{0x00},
+			[]byte("content-type: application/grpc-web+proto\r\n"),
+			{0x00, 0x00, 0x00, 0x00, 0x40},
+			bytes.Repeat([]byte("a"), 64),
+		} {
+			if err := conn.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+				return
+			}
+		}
+		conn.ReadMessage()
+	}))
+	defer srv.Close()
+
+	tr, err := transport.NewClientStreamContext(
+		context.Background(),
+		strings.TrimPrefix(srv.URL, "http://"),
+		"/service/Method",
+		transport.WithInsecure(),
+		transport.WithReadLimit(50),
+	)
+	if err != nil {
+		t.Fatalf("NewClientStreamContext should not return an error, but got '%s'", err)
+	}
+	defer tr.Close()
+
+	_, err = tr.Receive(context.Background())
+	if !errors.Is(err, transport.ErrReadLimitExceeded) {
+		t.Fatalf("expected ErrReadLimitExceeded, but got '%v'", err)
+	}
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected code %s, but got %s", codes.ResourceExhausted, status.Code(err))
+	}
+	if !strings.Contains(err.Error(), "64 bytes") {
+		t.Errorf("expected the error to carry the size of the message, but got '%s'", err)
+	}
+}
+
+func TestClientStreamMaxBufferSize(t *testing.T) {
+	upgrader := websocket.Upgrader{Subprotocols: []string{"grpc-websockets"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for _, msg := range [][]byte{
+			{0x00},
+			[]byte("content-type: application/grpc-web+proto\r\n"),
+			{0x00, 0x00, 0x00, 0x00, 0x04},
+			[]byte("abcd"),
+			{0x00, 0x00, 0x00, 0x00, 0x40},
+			bytes.Repeat([]byte("a"), 64),
+		} {
+			if err := conn.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+				return
+			}
+		}
+		conn.ReadMessage()
+	}))
+	defer srv.Close()
+
+	tr, err := transport.NewClientStreamContext(
+		context.Background(),
+		strings.TrimPrefix(srv.URL, "http://"),
+		"/service/Method",
+		transport.WithInsecure(),
+		transport.WithMaxBufferSize(32),
+	)
+	if err != nil {
+		t.Fatalf("NewClientStreamContext should not return an error, but got '%s'", err)
+	}
+	defer tr.Close()
+
+	r, err := tr.Receive(context.Background())
+	if err != nil {
+		t.Fatalf("Receive should not return an error for a message within the limit, but got '%s'", err)
+	}
+	r.Close()
+	_, err = tr.Receive(context.Background())
+	if !errors.Is(err, transport.ErrBufferLimitExceeded) {
+		t.Fatalf("expected ErrBufferLimitExceeded, but got '%v'", err)
+	}
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected code %s, but got %s", codes.ResourceExhausted, status.Code(err))
+	}
+}
+
+// TestClientStreamConcurrentUse is meant to be run with the race detector.
+func TestClientStreamConcurrentUse(t *testing.T) {
+	cases := map[string]struct {
+		opts []transport.ConnectOption
+	}{
+		"without pump": {},
+		"with pump":    {opts: []transport.ConnectOption{transport.WithReceivePump(1)}},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			upgrader := websocket.Upgrader{Subprotocols: []string{"grpc-websockets"}}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				conn, err := upgrader.Upgrade(w, r, nil)
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				// Skip the request header, then answer every request message.
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+				conn.WriteMessage(websocket.BinaryMessage, []byte{0x00})
+				conn.WriteMessage(websocket.BinaryMessage, []byte("content-type: application/grpc-web+proto\r\n"))
+				for {
+					if _, _, err := conn.ReadMessage(); err != nil {
+						return
+					}
+					conn.WriteMessage(websocket.BinaryMessage, []byte{0x00, 0x00, 0x00, 0x00, 0x01})
+					conn.WriteMessage(websocket.BinaryMessage, []byte("a"))
+				}
+			}))
+			defer srv.Close()
+
+			opts := append([]transport.ConnectOption{transport.WithInsecure()}, c.opts...)
+			tr, err := transport.NewClientStreamContext(context.Background(), strings.TrimPrefix(srv.URL, "http://"), "/service/Method", opts...)
+			if err != nil {
+				t.Fatalf("NewClientStreamContext should not return an error, but got '%s'", err)
+			}
+
+			var wg sync.WaitGroup
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 10; i++ {
+					tr.SetRequestHeader(http.Header{"X-Request": []string{strconv.Itoa(i)}})
+					if err := tr.Send(context.Background(), strings.NewReader("a")); err != nil {
+						return
+					}
+				}
+			}()
+			received := make(chan struct{})
+			go func() {
+				defer wg.Done()
+				for i := 0; ; i++ {
+					if i == 5 {
+						close(received)
+					}
+					r, err := tr.Receive(context.Background())
+					if err != nil {
+						return
+					}
+					r.Close()
+				}
+			}()
+
+			for {
+				select {
+				case <-received:
+					tr.Close()
+					wg.Wait()
+					return
+				default:
+					tr.Header()
+					tr.Trailer()
+				}
+			}
+		})
+	}
+}
+
+func TestClientStreamHeader(t *testing.T) {
+	upgrader := websocket.Upgrader{Subprotocols: []string{"grpc-websockets"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// Send the headers right away, and the response message once the
+		// request message is received.
+		conn.WriteMessage(websocket.BinaryMessage, []byte{0x00})
+		conn.WriteMessage(websocket.BinaryMessage, []byte("content-type: application/grpc-web+proto\r\nx-shard: 3\r\n"))
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if len(msg) > 0 && msg[0] == 0x00 {
+				break
+			}
+		}
+		conn.WriteMessage(websocket.BinaryMessage, []byte{0x00, 0x00, 0x00, 0x00, 0x01})
+		conn.WriteMessage(websocket.BinaryMessage, []byte("a"))
+	}))
+	defer srv.Close()
+
+	tr, err := transport.NewClientStreamContext(context.Background(), strings.TrimPrefix(srv.URL, "http://"), "/service/Method", transport.WithInsecure())
+	if err != nil {
+		t.Fatalf("NewClientStreamContext should not return an error, but got '%s'", err)
+	}
+	defer tr.Close()
+
+	h, err := tr.Header()
+	if err != nil {
+		t.Fatalf("Header should not return an error, but got '%s'", err)
+	}
+	if got := h.Get("x-shard"); got != "3" {
+		t.Errorf("expected the header x-shard to be 3, but got %q", got)
+	}
+
+	if err := tr.Send(context.Background(), strings.NewReader("a")); err != nil {
+		t.Fatalf("Send should not return an error, but got '%s'", err)
+	}
+	r, err := tr.Receive(context.Background())
+	if err != nil {
+		t.Fatalf("Receive should not return an error, but got '%s'", err)
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("failed to read the response: %s", err)
+	}
+	if !bytes.Equal(b, []byte{0x00, 0x00, 0x00, 0x00, 0x01, 'a'}) {
+		t.Errorf("expected the response message, but got %v", b)
+	}
+}
+
+func TestClientStreamFromConn(t *testing.T) {
+	var path string
+	upgrader := websocket.Upgrader{Subprotocols: []string{"grpc-websockets"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// Skip the request header, then echo the request message.
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		conn.WriteMessage(websocket.BinaryMessage, []byte{0x00})
+		conn.WriteMessage(websocket.BinaryMessage, []byte("content-type: application/grpc-web+proto\r\n"))
+		_, msg, err := conn.ReadMessage()
+		if err != nil || len(msg) < 6 {
+			return
+		}
+		conn.WriteMessage(websocket.BinaryMessage, msg[1:6])
+		conn.WriteMessage(websocket.BinaryMessage, msg[6:])
+	}))
+	defer srv.Close()
+
+	d := websocket.Dialer{Subprotocols: []string{"grpc-websockets"}}
+	conn, _, err := d.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/service/Method", nil)
+	if err != nil {
+		t.Fatalf("Dial should not return an error, but got '%s'", err)
+	}
+	tr, err := transport.NewClientStreamFromConn(conn)
+	if err != nil {
+		t.Fatalf("NewClientStreamFromConn should not return an error, but got '%s'", err)
+	}
+	defer tr.Close()
+	if c := transport.Conn(tr); c != conn {
+		t.Errorf("expected the given connection, but got %v", c)
+	}
+
+	req := []byte{0x00, 0x00, 0x00, 0x00, 0x01, 'a'}
+	if err := tr.Send(context.Background(), bytes.NewReader(req)); err != nil {
+		t.Fatalf("Send should not return an error, but got '%s'", err)
+	}
+	r, err := tr.Receive(context.Background())
+	if err != nil {
+		t.Fatalf("Receive should not return an error, but got '%s'", err)
+	}
+	defer r.Close()
+	res, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("failed to read the response: %s", err)
+	}
+	if !bytes.Equal(res, req) {
+		t.Errorf("expected the echoed message %v, but got %v", req, res)
+	}
+	if path != "/service/Method" {
+		t.Errorf("expected the path /service/Method, but got %s", path)
+	}
+}
+
+func TestConn(t *testing.T) {
+	upgrader := websocket.Upgrader{Subprotocols: []string{"grpc-websockets"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !websocket.IsWebSocketUpgrade(r) {
+			w.Header().Set("content-type", "application/grpc-web+proto")
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	unary, err := transport.NewUnary(host, transport.WithInsecure())
+	if err != nil {
+		t.Fatalf("NewUnary should not return an error, but got '%s'", err)
+	}
+	defer unary.Close()
+	if c := transport.Conn(unary); c != nil {
+		t.Errorf("expected no connection before Send, but got %T", c)
+	}
+	_, body, err := unary.Send(context.Background(), "/service/Method", "application/grpc-web+proto", bytes.NewReader(nil))
+	if err != nil {
+		t.Fatalf("Send should not return an error, but got '%s'", err)
+	}
+	body.Close()
+	if _, ok := transport.Conn(unary).(*http.Response); !ok {
+		t.Errorf("expected an *http.Response, but got %T", transport.Conn(unary))
+	}
+
+	stream, err := transport.NewClientStreamContext(context.Background(), host, "/service/Method", transport.WithInsecure())
+	if err != nil {
+		t.Fatalf("NewClientStreamContext should not return an error, but got '%s'", err)
+	}
+	defer stream.Close()
+	if _, ok := transport.Conn(stream).(*websocket.Conn); !ok {
+		t.Errorf("expected a *websocket.Conn, but got %T", transport.Conn(stream))
+	}
+}
@@ -0,0 +1,115 @@
+// Package transporttest provides helpers for the tests of code using the
+// client, such as a fake clock.
+package transporttest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/transport"
+)
+
+// Clock is a fake transport.Clock whose time only moves forward with Advance.
+// Set it with grpcweb.WithClock to test the retries, backoffs and timeouts of
+// the client without waiting for them.
+type Clock struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	now    time.Time
+	timers []*timer
+}
+
+var _ transport.Clock = (*Clock)(nil)
+
+// NewClock returns a Clock set to now.
+func NewClock(now time.Time) *Clock {
+	c := &Clock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *Clock) NewTimer(d time.Duration) transport.Timer {
+	return c.add(d, make(chan time.Time, 1), nil)
+}
+
+func (c *Clock) AfterFunc(d time.Duration, f func()) transport.Timer {
+	return c.add(d, nil, f)
+}
+
+func (c *Clock) add(d time.Duration, ch chan time.Time, f func()) *timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &timer{c: c, at: c.now.Add(d), ch: ch, f: f}
+	if d <= 0 {
+		t.fire(c.now)
+		return t
+	}
+	c.timers = append(c.timers, t)
+	c.cond.Broadcast()
+	return t
+}
+
+// Advance moves the time forward by d, firing the timers which expire in
+// order.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	end := c.now.Add(d)
+	sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].at.Before(c.timers[j].at) })
+	for len(c.timers) > 0 && !c.timers[0].at.After(end) {
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		c.now = t.at
+		t.fire(t.at)
+	}
+	c.now = end
+	c.cond.Broadcast()
+}
+
+// BlockUntil blocks until at least n timers are pending, e.g. until the
+// goroutine under test waits for a backoff before calling Advance.
+func (c *Clock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.timers) < n {
+		c.cond.Wait()
+	}
+}
+
+func (c *Clock) remove(t *timer) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, tt := range c.timers {
+		if tt == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			c.cond.Broadcast()
+			return true
+		}
+	}
+	return false
+}
+
+type timer struct {
+	c  *Clock
+	at time.Time
+	ch chan time.Time
+	f  func()
+}
+
+func (t *timer) fire(now time.Time) {
+	if t.f != nil {
+		go t.f()
+		return
+	}
+	t.ch <- now
+}
+
+func (t *timer) C() <-chan time.Time { return t.ch }
+func (t *timer) Stop() bool          { return t.c.remove(t) }
@@ -0,0 +1,48 @@
+package transporttest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewClock(start)
+
+	short, long, stopped := c.NewTimer(time.Second), c.NewTimer(time.Minute), c.NewTimer(time.Second)
+	called := make(chan time.Time, 1)
+	c.AfterFunc(2*time.Second, func() { called <- c.Now() })
+	if !stopped.Stop() {
+		t.Errorf("Stop should return true for a pending timer")
+	}
+
+	c.Advance(5 * time.Second)
+	if now := c.Now(); !now.Equal(start.Add(5 * time.Second)) {
+		t.Errorf("expected the time to be %s, but got %s", start.Add(5*time.Second), now)
+	}
+	select {
+	case at := <-short.C():
+		if !at.Equal(start.Add(time.Second)) {
+			t.Errorf("expected the timer to fire at %s, but got %s", start.Add(time.Second), at)
+		}
+	default:
+		t.Errorf("the expired timer should have fired")
+	}
+	select {
+	case <-long.C():
+		t.Errorf("the pending timer should not have fired")
+	case <-stopped.C():
+		t.Errorf("the stopped timer should not have fired")
+	default:
+	}
+	if at := <-called; !at.After(start) {
+		t.Errorf("expected the function to be called after the start, but got %s", at)
+	}
+	if short.Stop() {
+		t.Errorf("Stop should return false for a fired timer")
+	}
+
+	c.BlockUntil(1)
+	c.Advance(time.Minute)
+	<-long.C()
+}
@@ -0,0 +1,132 @@
+//go:build !js
+
+package transport
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newWebSocketDialer returns the dialer of the websockets of stream
+// transports.
+func newWebSocketDialer(o *connectOptions) *websocket.Dialer {
+	d := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 45 * time.Second,
+		ReadBufferSize:   o.readBufferSize,
+		WriteBufferSize:  o.writeBufferSize,
+	}
+
+	if o.tlsConf != nil {
+		d.TLSClientConfig = o.tlsConf
+	}
+	return d
+}
+
+// webSocketDialer returns the function dialing the websocket of u.
+func webSocketDialer(u *url.URL, o *connectOptions) dialFunc {
+	d := newWebSocketDialer(o)
+	h := http.Header{}
+	h.Set("Sec-WebSocket-Protocol", "grpc-websockets")
+	for k, v := range o.handshakeHeader {
+		h[k] = append([]string(nil), v...)
+	}
+	applyTemplateHeader(o.template, h)
+	if host := requestHost(o.template, o.authority); host != "" {
+		h.Set("Host", host)
+	}
+	return func(ctx context.Context) (wsConn, *http.Response, error) {
+		// The dialer only bounds the handshake by the deadline of ctx, so its
+		// cancellation, e.g. by the connect timeout, interrupts the handshake
+		// by closing the connection.
+		var stop func() bool
+		d := *d
+		d.NetDialContext = func(dialCtx context.Context, network, addr string) (net.Conn, error) {
+			c, err := new(net.Dialer).DialContext(dialCtx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			stop = context.AfterFunc(ctx, func() { c.Close() })
+			return c, nil
+		}
+		conn, res, err := d.DialContext(ctx, u.String(), h)
+		if stop != nil && !stop() && err == nil {
+			conn.Close()
+			return nil, nil, context.Cause(ctx)
+		}
+		if err != nil {
+			// Like net/http, report the failures to go through a proxy as
+			// such, the dialer doesn't.
+			if res == nil {
+				if p, _ := d.Proxy(&http.Request{URL: u}); p != nil {
+					err = &net.OpError{Op: "proxyconnect", Net: "tcp", Err: err}
+				}
+			}
+			return nil, res, err
+		}
+		return conn, res, nil
+	}
+}
+
+// underlyingConn returns the *websocket.Conn of the stream.
+func (t *webSocketTransport) underlyingConn() any {
+	return t.conn
+}
+
+// startKeepalive pings the server as configured by WithKeepalive, until the
+// transport is closed. It must be called before the connection is read.
+func (t *webSocketTransport) startKeepalive(o *connectOptions) {
+	conn, ok := t.conn.(*websocket.Conn)
+	if !ok {
+		return
+	}
+	pongs := make(chan struct{}, 1)
+	conn.SetPongHandler(func(string) error {
+		select {
+		case pongs <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+	go t.keepalive(conn, pongs, o)
+}
+
+func (t *webSocketTransport) keepalive(conn *websocket.Conn, pongs <-chan struct{}, o *connectOptions) {
+	for {
+		timer := o.timer(o.keepaliveTime)
+		select {
+		case <-t.done:
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+
+		// A pong answering a previous ping doesn't count.
+		select {
+		case <-pongs:
+		default:
+		}
+		if err := conn.WriteControl(websocket.PingMessage, nil, o.getClock().Now().Add(o.keepaliveTimeout)); err != nil {
+			return
+		}
+		timer = o.timer(o.keepaliveTimeout)
+		select {
+		case <-t.done:
+			timer.Stop()
+			return
+		case <-pongs:
+			timer.Stop()
+		case <-timer.C():
+			t.pingTimeout.Store(true)
+			t.events.emit(Event{Type: EventPingTimeout, Method: t.endpoint, Target: t.host, Err: ErrPingTimeout})
+			// Closing the connection fails the reads in progress.
+			conn.Close()
+			return
+		}
+	}
+}
@@ -0,0 +1,194 @@
+//go:build js && wasm
+
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"syscall/js"
+
+	"github.com/gorilla/websocket"
+)
+
+// webSocketDialer returns the function dialing the websocket of u with the
+// WebSocket API of the browser. The browser owns the connection: the TLS
+// configuration, the proxy and the buffer sizes are not applied, and the
+// response of a rejected handshake is not available.
+func webSocketDialer(u *url.URL, _ *connectOptions) dialFunc {
+	return func(ctx context.Context) (wsConn, *http.Response, error) {
+		conn, err := dialBrowser(ctx, u.String())
+		if err != nil {
+			return nil, nil, err
+		}
+		return conn, nil, nil
+	}
+}
+
+// underlyingConn returns the js.Value of the browser WebSocket of the stream.
+func (t *webSocketTransport) underlyingConn() any {
+	if c, ok := t.conn.(*browserConn); ok {
+		return c.ws
+	}
+	return t.conn
+}
+
+// startKeepalive does nothing, the WebSocket API of the browser doesn't expose
+// the pings.
+func (t *webSocketTransport) startKeepalive(*connectOptions) {}
+
+var errBrowserDial = errors.New("failed to open the websocket")
+
+// browserConn is a wsConn on top of the browser WebSocket. The messages are
+// queued by the event handlers, which must not block, and read from the queue.
+type browserConn struct {
+	ws    js.Value
+	funcs []js.Func
+
+	mu        sync.Mutex
+	messages  [][]byte
+	readLimit int64
+	// closeErr is set once the websocket is closed, and returned once the
+	// queued messages are read.
+	closeErr error
+	// signal is notified of every new message and of the closing.
+	signal chan struct{}
+}
+
+func dialBrowser(ctx context.Context, u string) (*browserConn, error) {
+	c := &browserConn{signal: make(chan struct{}, 1)}
+	c.ws = js.Global().Get("WebSocket").New(u, "grpc-websockets")
+	c.ws.Set("binaryType", "arraybuffer")
+
+	opened := make(chan struct{})
+	var once sync.Once
+	c.handle("open", func(js.Value) {
+		once.Do(func() { close(opened) })
+	})
+	c.handle("message", func(ev js.Value) {
+		arr := js.Global().Get("Uint8Array").New(ev.Get("data"))
+		b := make([]byte, arr.Get("length").Int())
+		js.CopyBytesToGo(b, arr)
+		c.mu.Lock()
+		c.messages = append(c.messages, b)
+		c.mu.Unlock()
+		c.notify()
+	})
+	c.handle("close", func(ev js.Value) {
+		c.mu.Lock()
+		if c.closeErr == nil {
+			c.closeErr = &websocket.CloseError{Code: ev.Get("code").Int(), Text: ev.Get("reason").String()}
+		}
+		c.mu.Unlock()
+		c.notify()
+		once.Do(func() { close(opened) })
+		c.release()
+	})
+
+	select {
+	case <-opened:
+	case <-ctx.Done():
+		c.ws.Call("close")
+		return nil, ctx.Err()
+	}
+	c.mu.Lock()
+	err := c.closeErr
+	c.mu.Unlock()
+	if err != nil {
+		// The browser doesn't tell why the websocket failed to open.
+		return nil, errBrowserDial
+	}
+	return c, nil
+}
+
+// handle registers f as the handler of the event of the websocket.
+func (c *browserConn) handle(event string, f func(ev js.Value)) {
+	fn := js.FuncOf(func(_ js.Value, args []js.Value) any {
+		f(args[0])
+		return nil
+	})
+	c.funcs = append(c.funcs, fn)
+	c.ws.Set("on"+event, fn)
+}
+
+func (c *browserConn) release() {
+	// The handlers may no longer be called once the websocket is closed, but
+	// the one running must not be released under its feet.
+	go func() {
+		for _, fn := range c.funcs {
+			fn.Release()
+		}
+	}()
+}
+
+func (c *browserConn) notify() {
+	select {
+	case c.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (c *browserConn) NextReader() (int, io.Reader, error) {
+	typ, b, err := c.ReadMessage()
+	if err != nil {
+		return typ, nil, err
+	}
+	return typ, bytes.NewReader(b), nil
+}
+
+func (c *browserConn) ReadMessage() (int, []byte, error) {
+	for {
+		c.mu.Lock()
+		if len(c.messages) > 0 {
+			b := c.messages[0]
+			c.messages = c.messages[1:]
+			limit := c.readLimit
+			c.mu.Unlock()
+			if limit > 0 && int64(len(b)) > limit {
+				c.Close()
+				return websocket.BinaryMessage, nil, websocket.ErrReadLimit
+			}
+			return websocket.BinaryMessage, b, nil
+		}
+		err := c.closeErr
+		c.mu.Unlock()
+		if err != nil {
+			return -1, nil, err
+		}
+		<-c.signal
+	}
+}
+
+func (c *browserConn) WriteMessage(messageType int, data []byte) error {
+	if c.ws.Get("readyState").Int() != 1 {
+		return websocket.ErrCloseSent
+	}
+	if messageType == websocket.CloseMessage {
+		code := websocket.CloseNormalClosure
+		if len(data) >= 2 {
+			code = int(binary.BigEndian.Uint16(data))
+		}
+		c.ws.Call("close", code)
+		return nil
+	}
+	arr := js.Global().Get("Uint8Array").New(len(data))
+	js.CopyBytesToJS(arr, data)
+	c.ws.Call("send", arr)
+	return nil
+}
+
+func (c *browserConn) SetReadLimit(limit int64) {
+	c.mu.Lock()
+	c.readLimit = limit
+	c.mu.Unlock()
+}
+
+func (c *browserConn) Close() error {
+	c.ws.Call("close")
+	return nil
+}
@@ -0,0 +1,52 @@
+package grpcweb
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// WithMaxStatusSize truncates the messages of the statuses returned by calls
+// and streams to n bytes, and drops their details if they are larger than n
+// bytes once encoded, so that large payloads from servers don't blow up the
+// logs. The code is preserved. The full statuses are logged through the
+// logger at debug level. Zero means no limit.
+func WithMaxStatusSize(n int) DialOption {
+	return func(opt *dialOptions) {
+		opt.maxStatusSize = n
+	}
+}
+
+// truncateStatus returns err with its status truncated as described by
+// WithMaxStatusSize. A truncated status is returned as a plain status error.
+func (o *dialOptions) truncateStatus(method string, err error) error {
+	if o.maxStatusSize <= 0 || err == nil {
+		return err
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	p := st.Proto()
+	detailsSize := 0
+	for _, d := range p.GetDetails() {
+		detailsSize += proto.Size(d)
+	}
+	if len(p.GetMessage()) <= o.maxStatusSize && detailsSize <= o.maxStatusSize {
+		return err
+	}
+
+	if o.logger != nil {
+		o.logger.Debug("truncated status", "method", method, "code", st.Code(), "message", p.GetMessage(), "details", st.Details())
+	}
+	if n := len(p.GetMessage()) - o.maxStatusSize; n > 0 {
+		// The cut may split a rune, whose bytes are dropped.
+		p.Message = fmt.Sprintf("%s... (%d bytes truncated)", strings.ToValidUTF8(p.Message[:o.maxStatusSize], ""), n)
+	}
+	if detailsSize > o.maxStatusSize {
+		p.Details = nil
+	}
+	return status.FromProto(p).Err()
+}
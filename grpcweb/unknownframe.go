@@ -0,0 +1,91 @@
+package grpcweb
+
+import (
+	"fmt"
+	"io"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/errs"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/parser"
+)
+
+// trailerFlag is the flag byte of trailer frames.
+const trailerFlag = 0x80
+
+// UnknownFrameAction is the treatment of the response frames whose flag is
+// unknown: neither 0x00 nor 0x01 for messages, nor 0x80 for the trailer.
+type UnknownFrameAction int
+
+const (
+	// SkipUnknownFrames skips the unknown frames, logging a warning.
+	SkipUnknownFrames UnknownFrameAction = iota + 1
+	// RejectUnknownFrames fails the calls receiving an unknown frame with
+	// codes.Internal.
+	RejectUnknownFrames
+)
+
+// WithUnknownFrames sets the treatment of the response frames whose flag is
+// unknown, to future-proof the client against extensions of the protocol. By
+// default they are protocol deviations: they are skipped with a warning, and
+// fail the call in strict mode. Empty frames are heartbeats whatever their
+// flag.
+func WithUnknownFrames(a UnknownFrameAction) DialOption {
+	return func(opt *dialOptions) {
+		opt.unknownFrames = a
+		opt.unknownFrameHandler = nil
+	}
+}
+
+// UnknownFrameHandler is handed the flag and the payload of a response frame
+// whose flag is unknown. It returns nil to skip the frame, or an error which
+// fails the call.
+type UnknownFrameHandler func(method string, flag byte, payload []byte) error
+
+// WithUnknownFrameHandler passes the response frames whose flag is unknown to
+// h, e.g. to implement an extension of the protocol. The payloads are subject
+// to the limit of WithMaxBufferSize.
+func WithUnknownFrameHandler(h UnknownFrameHandler) DialOption {
+	return func(opt *dialOptions) {
+		opt.unknownFrames = 0
+		opt.unknownFrameHandler = h
+	}
+}
+
+// isKnownFrame reports whether h is a message or a trailer frame.
+func isKnownFrame(h *parser.Header) bool {
+	return h.IsMessageHeader() || h.Flag() == trailerFlag
+}
+
+// unknownFrame handles the unknown frame h, whose payload is read from r. It
+// returns nil if the frame is skipped.
+func (o *dialOptions) unknownFrame(method string, r io.Reader, h *parser.Header) error {
+	if o.unknownFrameHandler != nil {
+		if err := checkMessageSize(o.maxBufferSize, h.ContentLength); err != nil {
+			return err
+		}
+		payload, err := o.frameParser.ParseLengthPrefixedMessage(r, h.ContentLength)
+		if err != nil {
+			return errs.Wrap(err, "failed to read the unknown frame")
+		}
+		return o.unknownFrameHandler(method, h.Flag(), payload)
+	}
+
+	detail := fmt.Sprintf("unexpected frame with flag 0x%02x", h.Flag())
+	switch o.unknownFrames {
+	case SkipUnknownFrames:
+		if o.logger != nil {
+			o.logger.Warn("gRPC-Web unknown frame skipped", "method", method, "flag", h.Flag(), "length", h.ContentLength)
+		}
+	case RejectUnknownFrames:
+		return errs.WithCode(codes.Internal, nil, "protocol violation: "+detail)
+	default:
+		if err := o.deviation(method, "%s", detail); err != nil {
+			return err
+		}
+	}
+	if _, err := io.CopyN(io.Discard, r, int64(h.ContentLength)); err != nil {
+		return errs.Wrap(err, "failed to skip the unexpected frame")
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package grpcweb
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/protoadapt"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/internal/errs"
+)
+
+// Validator checks a message, e.g. against its protovalidate constraints.
+type Validator interface {
+	Validate(msg proto.Message) error
+}
+
+// WithValidator runs v on every request before it is sent and on every
+// response once it is received. Invalid messages fail the call locally with
+// codes.InvalidArgument. Messages of the legacy protobuf API are converted
+// with protoadapt, other non-protobuf messages are not validated.
+func WithValidator(v Validator) DialOption {
+	return func(opt *dialOptions) {
+		opt.validator = v
+	}
+}
+
+// validate runs the validator, if any, on msg.
+func (o *dialOptions) validate(msg any, response bool) error {
+	if o.validator == nil {
+		return nil
+	}
+	var m proto.Message
+	switch msg := msg.(type) {
+	case proto.Message:
+		m = msg
+	case protoadapt.MessageV1:
+		m = protoadapt.MessageV2Of(msg)
+	default:
+		return nil
+	}
+	if err := o.validator.Validate(m); err != nil {
+		if response {
+			return errs.WithCode(codes.InvalidArgument, err, "invalid response")
+		}
+		return errs.WithCode(codes.InvalidArgument, err, "invalid request")
+	}
+	return nil
+}
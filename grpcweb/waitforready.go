@@ -0,0 +1,117 @@
+package grpcweb
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/backoff"
+
+	internalbackoff "github.com/heartandu/grpc-web-go-client/grpcweb/internal/backoff"
+	"github.com/heartandu/grpc-web-go-client/grpcweb/transport"
+)
+
+// WaitForReady queues the unary calls and the client and bidirectional
+// streams which can't reach the server while it is unreachable, instead of
+// failing them at once. They are attempted again with the backoff set with
+// WithConnectParams, and as soon as another call reaches the server, until
+// their context is done or the queue timeout set with WithQueueTimeout
+// elapses. Server streams connect at their first RecvMsg or Header call, with
+// a request which is sent once, and are never queued.
+func WaitForReady(wait bool) CallOption {
+	return newCallOption(func(opt *callOptions) {
+		opt.waitForReady = wait
+	})
+}
+
+// WithQueueTimeout bounds the time the calls made with WaitForReady are
+// queued. They are only bounded by their context by default.
+func WithQueueTimeout(d time.Duration) DialOption {
+	return func(opt *dialOptions) {
+		opt.queueTimeout = d
+	}
+}
+
+// readiness tracks whether the server is reachable, for the queued calls.
+type readiness struct {
+	mu      sync.Mutex
+	failing bool
+	// ready is closed once the server is reachable again.
+	ready chan struct{}
+}
+
+func (r *readiness) fail() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.failing {
+		r.failing = true
+		r.ready = make(chan struct{})
+	}
+}
+
+func (r *readiness) succeed() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failing {
+		r.failing = false
+		close(r.ready)
+	}
+}
+
+// wait waits for d, or until the server is reachable again or ctx is done.
+func (r *readiness) wait(ctx context.Context, clock transport.Clock, d time.Duration) error {
+	r.mu.Lock()
+	ready := r.ready
+	if !r.failing {
+		ready = nil
+	}
+	r.mu.Unlock()
+
+	timer := clock.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-ready:
+		return nil
+	case <-timer.C():
+		return nil
+	}
+}
+
+// queue runs call, and runs it again while it can't reach the server if
+// waitForReady is set.
+func (c *ClientConn) queue(ctx context.Context, waitForReady bool, call func() error) error {
+	var deadline time.Time
+	if d := c.dialOptions.queueTimeout; d > 0 {
+		deadline = c.dialOptions.clock.Now().Add(d)
+	}
+	config := c.dialOptions.backoffConfig(backoff.Config{})
+
+	for retries := 0; ; retries++ {
+		err := call()
+		if !isConnectionError(err) {
+			c.readiness.succeed()
+			return err
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		c.readiness.fail()
+
+		if !waitForReady {
+			return err
+		}
+		delay := internalbackoff.Delay(config, retries)
+		if !deadline.IsZero() {
+			left := deadline.Sub(c.dialOptions.clock.Now())
+			if left <= 0 {
+				return err
+			}
+			delay = min(delay, left)
+		}
+		if werr := c.readiness.wait(ctx, c.dialOptions.clock, delay); werr != nil {
+			return err
+		}
+	}
+}
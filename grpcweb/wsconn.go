@@ -0,0 +1,38 @@
+package grpcweb
+
+import (
+	"context"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/heartandu/grpc-web-go-client/grpcweb/transport"
+)
+
+// WebSocketConnFunc returns a websocket opened to the URL of method on target,
+// such as "wss://target/pkg.Service/Method", with the "grpc-websockets"
+// subprotocol. The stream owns the returned connection.
+type WebSocketConnFunc func(ctx context.Context, target, method string) (*websocket.Conn, error)
+
+// WithWebSocketConn makes the streams use the websockets f returns instead of
+// dialing them, e.g. to go through custom tunnels such as SSH port forwards
+// or libp2p. The dial retries, the TLS configuration and the URL rewriter
+// don't apply to them.
+func WithWebSocketConn(f WebSocketConnFunc) DialOption {
+	return func(opt *dialOptions) {
+		opt.webSocketConn = f
+	}
+}
+
+// newStreamTransport returns the transport of a stream of method on target,
+// picked by use.
+func (c *ClientConn) newStreamTransport(ctx context.Context, target, method string, callOptions *callOptions, use *addressUse) (transport.ClientStreamTransport, error) {
+	opts := c.targetConnectOptions(method, callOptions, use)
+	if c.dialOptions.webSocketConn == nil {
+		return transport.NewClientStreamContext(ctx, target, method, opts...)
+	}
+	conn, err := c.dialOptions.webSocketConn(ctx, target, method)
+	if err != nil {
+		return nil, err
+	}
+	return transport.NewClientStreamFromConn(conn, opts...)
+}